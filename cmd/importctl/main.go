@@ -0,0 +1,372 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// importctl renders the klusterlet manifests to import a managed cluster without the import controller. The
+// manifests are rendered with a bootstrap kubeconfig requested from the hub, or a static bootstrap kubeconfig
+// when the hub is not accessible.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	ocinfrav1 "github.com/openshift/api/config/v1"
+	ocoperatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	routeclient "github.com/openshift/client-go/route/clientset/versioned"
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/bootstrap"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/controller/agentregistration"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers/imageregistry"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8sscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/tools/clientcmd"
+	utilflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/logs"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	outputYAML       = "yaml"
+	outputJSON       = "json"
+	outputHelmValues = "helm-values"
+
+	// offlinePullSecretName is the name of the default image pull secret loaded from the pull secret file
+	// in the offline mode.
+	offlinePullSecretName = "importctl-pull-secret"
+)
+
+var scheme = k8sruntime.NewScheme()
+
+func init() {
+	utilruntime.Must(k8sscheme.AddToScheme(scheme))
+	utilruntime.Must(ocinfrav1.AddToScheme(scheme))
+	utilruntime.Must(ocoperatorv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(klusterletconfigv1alpha1.AddToScheme(scheme))
+}
+
+type options struct {
+	kubeconfig          string
+	bootstrapKubeconfig string
+	clusterName         string
+	mode                string
+	klusterletConfig    string
+	output              string
+	namespace           string
+	serviceAccount      string
+	duration            time.Duration
+	pullSecret          string
+	imagePullSecret     string
+	deployOnOCP         bool
+
+	registrationOperatorImage string
+	registrationImage         string
+	workImage                 string
+}
+
+func main() {
+	o := &options{}
+	o.addFlags(pflag.CommandLine)
+	pflag.CommandLine.SetNormalizeFunc(utilflag.WordSepNormalizeFunc)
+	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+
+	logs.AddFlags(pflag.CommandLine)
+	pflag.Parse()
+
+	logs.InitLogs()
+	defer logs.FlushLogs()
+
+	if err := o.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := o.run(context.Background(), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// addFlags adds the flags of the options to the flag set, the images and the default image pull secret are
+// defaulted from the same environment variables as the import controller.
+func (o *options) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "",
+		"the kubeconfig of the hub cluster to request the bootstrap token and discover the hub kube apiserver")
+	fs.StringVar(&o.bootstrapKubeconfig, "bootstrap-kubeconfig", "",
+		"the static bootstrap hub kubeconfig file, the hub cluster is not accessed if it is set")
+	fs.StringVar(&o.clusterName, "cluster-name", "", "the name of the managed cluster")
+	fs.StringVar(&o.mode, "mode", string(operatorv1.InstallModeDefault),
+		"the install mode of the klusterlet: Default, Singleton, Hosted or SingletonHosted")
+	fs.StringVar(&o.klusterletConfig, "klusterletconfig", "", "the KlusterletConfig yaml file")
+	fs.StringVarP(&o.output, "output", "o", outputYAML, "the output format: yaml, json or helm-values")
+	fs.StringVar(&o.namespace, "namespace", "multicluster-engine",
+		"the namespace of the bootstrap service account and the default image pull secret on the hub cluster")
+	fs.StringVar(&o.serviceAccount, "service-account", agentregistration.AgentRegistrationDefaultBootstrapSAName,
+		"the bootstrap service account on the hub cluster")
+	fs.DurationVar(&o.duration, "duration", 0,
+		"the expiration duration of the bootstrap token, the default expiration is used if it is not set")
+	fs.StringVar(&o.pullSecret, "pull-secret", "",
+		"the dockerconfigjson file of the image pull secret, only used with the bootstrap-kubeconfig")
+	fs.StringVar(&o.imagePullSecret, "image-pull-secret", os.Getenv(constants.DefaultImagePullSecretEnvVarName),
+		"the name of the default image pull secret in the namespace on the hub cluster, the pull-secret is used "+
+			"instead with the bootstrap-kubeconfig")
+	fs.StringVar(&o.registrationOperatorImage, "registration-operator-image",
+		os.Getenv(constants.RegistrationOperatorImageEnvVarName), "the image of the klusterlet operator")
+	fs.StringVar(&o.registrationImage, "registration-image",
+		os.Getenv(constants.RegistrationImageEnvVarName), "the image of the registration agent")
+	fs.StringVar(&o.workImage, "work-image", os.Getenv(constants.WorkImageEnvVarName),
+		"the image of the work agent")
+	fs.BoolVar(&o.deployOnOCP, "deploy-on-ocp", true, "the hub cluster is OCP or not, the hub kube apiserver "+
+		"of a non-OCP hub is discovered in the namespace of the POD_NAMESPACE environment variable")
+}
+
+func (o *options) validate() error {
+	if len(o.clusterName) == 0 {
+		return fmt.Errorf("the cluster-name is required")
+	}
+	if len(o.kubeconfig) == 0 && len(o.bootstrapKubeconfig) == 0 {
+		return fmt.Errorf("one of the kubeconfig and the bootstrap-kubeconfig is required")
+	}
+	if len(o.kubeconfig) > 0 && len(o.bootstrapKubeconfig) > 0 {
+		return fmt.Errorf("the kubeconfig and the bootstrap-kubeconfig cannot be set simultaneously")
+	}
+	if len(o.pullSecret) > 0 && len(o.bootstrapKubeconfig) == 0 {
+		return fmt.Errorf("the pull-secret is only supported with the bootstrap-kubeconfig")
+	}
+
+	switch operatorv1.InstallMode(o.mode) {
+	case operatorv1.InstallModeDefault, operatorv1.InstallModeSingleton,
+		operatorv1.InstallModeHosted, operatorv1.InstallModeSingletonHosted:
+	default:
+		return fmt.Errorf("invalid install mode %q", o.mode)
+	}
+
+	switch o.output {
+	case outputYAML, outputJSON, outputHelmValues:
+	default:
+		return fmt.Errorf("invalid output format %q", o.output)
+	}
+
+	images := []struct {
+		flag  string
+		env   string
+		image string
+	}{
+		{"registration-operator-image", constants.RegistrationOperatorImageEnvVarName, o.registrationOperatorImage},
+		{"registration-image", constants.RegistrationImageEnvVarName, o.registrationImage},
+		{"work-image", constants.WorkImageEnvVarName, o.workImage},
+	}
+	for _, i := range images {
+		if len(i.image) == 0 {
+			return fmt.Errorf("the %s is required, set it with the flag or the environment variable %s",
+				i.flag, i.env)
+		}
+	}
+
+	return nil
+}
+
+func (o *options) run(ctx context.Context, out io.Writer) error {
+	helpers.DeployOnOCP = o.deployOnOCP
+
+	klusterletConfig, err := loadKlusterletConfig(o.klusterletConfig)
+	if err != nil {
+		return err
+	}
+
+	defaults := &bootstrap.KlusterletManifestsDefaults{
+		Namespace:                 o.namespace,
+		ImagePullSecret:           o.imagePullSecret,
+		RegistrationOperatorImage: o.registrationOperatorImage,
+		RegistrationImage:         o.registrationImage,
+		WorkImage:                 o.workImage,
+	}
+
+	var clientHolder *helpers.ClientHolder
+	var bootstrapKubeconfig []byte
+	if len(o.bootstrapKubeconfig) > 0 {
+		bootstrapKubeconfig, err = os.ReadFile(o.bootstrapKubeconfig)
+		if err != nil {
+			return err
+		}
+		clientHolder, defaults.ImagePullSecret, err = o.newOfflineClientHolder()
+		if err != nil {
+			return err
+		}
+	} else {
+		clientHolder, err = o.newClientHolder()
+		if err != nil {
+			return err
+		}
+		bootstrapKubeconfig, err = o.requestBootstrapKubeconfig(ctx, clientHolder, klusterletConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifests, crds, values, err := bootstrap.NewKlusterletManifestsConfig(
+		operatorv1.InstallMode(o.mode),
+		o.clusterName,
+		bootstrapKubeconfig).
+		WithKlusterletConfig(klusterletConfig).
+		WithDefaults(defaults).
+		Generate(ctx, clientHolder)
+	if err != nil {
+		return err
+	}
+
+	return printManifests(out, o.output, manifests, crds, values)
+}
+
+func loadKlusterletConfig(file string) (*klusterletconfigv1alpha1.KlusterletConfig, error) {
+	if len(file) == 0 {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	klusterletConfig := &klusterletconfigv1alpha1.KlusterletConfig{}
+	if err := yaml.UnmarshalStrict(data, klusterletConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse the klusterletconfig %s: %v", file, err)
+	}
+	return klusterletConfig, nil
+}
+
+// newClientHolder creates the clients of the hub cluster with the kubeconfig.
+func (o *options) newClientHolder() (*helpers.ClientHolder, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", o.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	runtimeClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	routeClient, err := routeclient.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helpers.ClientHolder{
+		KubeClient:          kubeClient,
+		RuntimeClient:       runtimeClient,
+		RuntimeAPIReader:    runtimeClient,
+		ImageRegistryClient: imageregistry.NewClient(kubeClient),
+		RouteV1Client:       routeClient,
+	}, nil
+}
+
+// newOfflineClientHolder creates the in-memory clients to render the manifests without the hub cluster, the
+// image pull secret is loaded from the pull secret file into them. It returns the name of the loaded image
+// pull secret, which is empty if there is no pull secret file.
+func (o *options) newOfflineClientHolder() (*helpers.ClientHolder, string, error) {
+	objs := []k8sruntime.Object{}
+	imagePullSecret := ""
+	if len(o.pullSecret) > 0 {
+		data, err := os.ReadFile(o.pullSecret)
+		if err != nil {
+			return nil, "", err
+		}
+		objs = append(objs, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: offlinePullSecretName, Namespace: o.namespace},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: data},
+		})
+		imagePullSecret = offlinePullSecretName
+	}
+
+	kubeClient := kubefake.NewSimpleClientset(objs...)
+	runtimeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	return &helpers.ClientHolder{
+		KubeClient:          kubeClient,
+		RuntimeClient:       runtimeClient,
+		RuntimeAPIReader:    runtimeClient,
+		ImageRegistryClient: imageregistry.NewClient(kubeClient),
+	}, imagePullSecret, nil
+}
+
+// requestBootstrapKubeconfig requests a token of the bootstrap service account and builds the bootstrap
+// kubeconfig with the hub kube apiserver, in the same way as the agent-registration server.
+func (o *options) requestBootstrapKubeconfig(ctx context.Context, clientHolder *helpers.ClientHolder,
+	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) ([]byte, error) {
+	var token []byte
+	var err error
+	if o.duration == 0 {
+		token, _, _, err = bootstrap.GetBootstrapToken(ctx, clientHolder.KubeClient, o.serviceAccount, o.namespace,
+			constants.DefaultSecretTokenExpirationSecond)
+	} else {
+		token, _, _, err = bootstrap.RequestSAToken(ctx, clientHolder.KubeClient, o.serviceAccount, o.namespace,
+			int64(o.duration.Seconds()))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kubeAPIServer, proxyURL, ca, caData, err := bootstrap.GetKubeAPIServerConfig(
+		ctx, clientHolder, o.namespace, klusterletConfig, false)
+	if err != nil {
+		return nil, err
+	}
+	ctxClusterName, err := bootstrap.GetKubeconfigClusterName(ctx, clientHolder.RuntimeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return bootstrap.CreateBootstrapKubeConfig(ctxClusterName, kubeAPIServer, proxyURL, ca, caData, token)
+}
+
+// printManifests prints the crds followed by the import manifests in the yaml format, or a List of them in the
+// json format, or the values of the klusterlet chart.
+func printManifests(out io.Writer, output string, manifests, crds, values []byte) error {
+	switch output {
+	case outputHelmValues:
+		_, err := out.Write(values)
+		return err
+	case outputJSON:
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}}
+		for _, data := range helpers.SplitYamls(append(crds, manifests...)) {
+			obj := unstructured.Unstructured{}
+			if err := yaml.Unmarshal(data, &obj.Object); err != nil {
+				return err
+			}
+			if len(obj.Object) == 0 {
+				continue
+			}
+			list.Items = append(list.Items, obj)
+		}
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	default:
+		_, err := fmt.Fprintf(out, "%s%s\n", crds, manifests)
+		return err
+	}
+}
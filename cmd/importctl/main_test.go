@@ -0,0 +1,286 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	testBootstrapKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://api.hub.example.com:6443
+  name: hub
+contexts:
+- context:
+    cluster: hub
+    user: bootstrap
+  name: bootstrap
+current-context: bootstrap
+users:
+- name: bootstrap
+  user:
+    token: test-token
+`
+
+	testKlusterletConfig = `apiVersion: config.open-cluster-management.io/v1alpha1
+kind: KlusterletConfig
+metadata:
+  name: test
+spec:
+  nodePlacement:
+    nodeSelector:
+      kubernetes.io/os: linux
+`
+)
+
+func TestFlags(t *testing.T) {
+	t.Setenv(constants.RegistrationOperatorImageEnvVarName, "quay.io/stolostron/registration-operator:env")
+	t.Setenv(constants.RegistrationImageEnvVarName, "quay.io/stolostron/registration:env")
+	t.Setenv(constants.WorkImageEnvVarName, "quay.io/stolostron/work:env")
+	t.Setenv(constants.DefaultImagePullSecretEnvVarName, "")
+
+	cases := []struct {
+		name        string
+		args        []string
+		unsetImages bool
+		expectedErr string
+		validate    func(t *testing.T, o *options)
+	}{
+		{
+			name: "defaults",
+			args: []string{"--cluster-name=cluster1", "--kubeconfig=hub.kubeconfig"},
+			validate: func(t *testing.T, o *options) {
+				if o.mode != "Default" || o.output != outputYAML || o.namespace != "multicluster-engine" ||
+					!o.deployOnOCP || o.duration != 0 {
+					t.Errorf("unexpected defaults %+v", o)
+				}
+				if o.registrationOperatorImage != "quay.io/stolostron/registration-operator:env" ||
+					o.registrationImage != "quay.io/stolostron/registration:env" ||
+					o.workImage != "quay.io/stolostron/work:env" {
+					t.Errorf("expected the images from the environment variables, but got %+v", o)
+				}
+			},
+		},
+		{
+			name: "all flags",
+			args: []string{"--cluster-name=cluster1", "--bootstrap-kubeconfig=bootstrap.kubeconfig",
+				"--mode=Hosted", "--klusterletconfig=kc.yaml", "-o", "json", "--namespace=ns1",
+				"--service-account=sa1", "--duration=1h", "--pull-secret=pull-secret.json",
+				"--registration-operator-image=operator:flag", "--registration-image=registration:flag",
+				"--work-image=work:flag", "--deploy-on-ocp=false"},
+			validate: func(t *testing.T, o *options) {
+				if o.bootstrapKubeconfig != "bootstrap.kubeconfig" || o.mode != "Hosted" ||
+					o.klusterletConfig != "kc.yaml" || o.output != outputJSON || o.namespace != "ns1" ||
+					o.serviceAccount != "sa1" || o.duration != time.Hour || o.pullSecret != "pull-secret.json" ||
+					o.deployOnOCP {
+					t.Errorf("unexpected options %+v", o)
+				}
+				if o.registrationOperatorImage != "operator:flag" || o.registrationImage != "registration:flag" ||
+					o.workImage != "work:flag" {
+					t.Errorf("expected the images from the flags, but got %+v", o)
+				}
+			},
+		},
+		{
+			name:        "no cluster name",
+			args:        []string{"--kubeconfig=hub.kubeconfig"},
+			expectedErr: "the cluster-name is required",
+		},
+		{
+			name:        "no kubeconfig",
+			args:        []string{"--cluster-name=cluster1"},
+			expectedErr: "one of the kubeconfig and the bootstrap-kubeconfig is required",
+		},
+		{
+			name: "both kubeconfigs",
+			args: []string{"--cluster-name=cluster1", "--kubeconfig=hub.kubeconfig",
+				"--bootstrap-kubeconfig=bootstrap.kubeconfig"},
+			expectedErr: "the kubeconfig and the bootstrap-kubeconfig cannot be set simultaneously",
+		},
+		{
+			name: "pull secret with kubeconfig",
+			args: []string{"--cluster-name=cluster1", "--kubeconfig=hub.kubeconfig",
+				"--pull-secret=pull-secret.json"},
+			expectedErr: "the pull-secret is only supported with the bootstrap-kubeconfig",
+		},
+		{
+			name:        "invalid mode",
+			args:        []string{"--cluster-name=cluster1", "--kubeconfig=hub.kubeconfig", "--mode=Detached"},
+			expectedErr: `invalid install mode "Detached"`,
+		},
+		{
+			name:        "invalid output",
+			args:        []string{"--cluster-name=cluster1", "--kubeconfig=hub.kubeconfig", "-o", "table"},
+			expectedErr: `invalid output format "table"`,
+		},
+		{
+			name:        "no images",
+			args:        []string{"--cluster-name=cluster1", "--kubeconfig=hub.kubeconfig"},
+			unsetImages: true,
+			expectedErr: "the registration-operator-image is required",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.unsetImages {
+				t.Setenv(constants.RegistrationOperatorImageEnvVarName, "")
+			}
+
+			o := &options{}
+			fs := pflag.NewFlagSet("importctl", pflag.ContinueOnError)
+			o.addFlags(fs)
+			if err := fs.Parse(c.args); err != nil {
+				t.Fatalf("failed to parse flags: %v", err)
+			}
+
+			err := o.validate()
+			switch {
+			case len(c.expectedErr) == 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case len(c.expectedErr) > 0 && (err == nil || !strings.Contains(err.Error(), c.expectedErr)):
+				t.Fatalf("expected error %q, but got %v", c.expectedErr, err)
+			}
+			if c.validate != nil {
+				c.validate(t, o)
+			}
+		})
+	}
+}
+
+func TestRunOffline(t *testing.T) {
+	dir := t.TempDir()
+	bootstrapKubeconfigFile := writeFile(t, dir, "bootstrap.kubeconfig", testBootstrapKubeconfig)
+	klusterletConfigFile := writeFile(t, dir, "kc.yaml", testKlusterletConfig)
+	pullSecretFile := writeFile(t, dir, "pull-secret.json", `{"auths":{"quay.io":{"auth":"dGVzdA=="}}}`)
+	invalidKlusterletConfigFile := writeFile(t, dir, "invalid.yaml", "spec:\n  unknown: true\n")
+
+	// the rendering must not depend on the environment of the import controller
+	t.Setenv(constants.PodNamespaceEnvVarName, "")
+	t.Setenv(constants.DefaultImagePullSecretEnvVarName, "")
+	t.Setenv(constants.RegistrationOperatorImageEnvVarName, "")
+	t.Setenv(constants.RegistrationImageEnvVarName, "")
+	t.Setenv(constants.WorkImageEnvVarName, "")
+
+	cases := []struct {
+		name        string
+		options     func(o *options)
+		expectedErr bool
+		validate    func(t *testing.T, output string)
+	}{
+		{
+			name: "yaml",
+			validate: func(t *testing.T, output string) {
+				for _, expected := range []string{
+					"kind: CustomResourceDefinition",
+					"kind: Klusterlet",
+					`clusterName: "cluster1"`,
+					"quay.io/stolostron/registration-operator:test",
+					"name: bootstrap-hub-kubeconfig",
+				} {
+					if !strings.Contains(output, expected) {
+						t.Errorf("expected %q in the output", expected)
+					}
+				}
+			},
+		},
+		{
+			name: "json with klusterletconfig and pull secret",
+			options: func(o *options) {
+				o.output = outputJSON
+				o.klusterletConfig = klusterletConfigFile
+				o.pullSecret = pullSecretFile
+			},
+			validate: func(t *testing.T, output string) {
+				list := &unstructured.UnstructuredList{}
+				if err := json.Unmarshal([]byte(output), &list.Object); err != nil {
+					t.Fatalf("failed to parse the output: %v", err)
+				}
+				if list.Object["kind"] != "List" {
+					t.Errorf("expected a List, but got %v", list.Object["kind"])
+				}
+				if !strings.Contains(output, "kubernetes.io/os") {
+					t.Errorf("expected the nodeSelector of the klusterletconfig in the output")
+				}
+				if !strings.Contains(output, "kubernetes.io/dockerconfigjson") {
+					t.Errorf("expected the image pull secret in the output")
+				}
+			},
+		},
+		{
+			name:    "helm values",
+			options: func(o *options) { o.output = outputHelmValues },
+			validate: func(t *testing.T, output string) {
+				if strings.Contains(output, "kind: Klusterlet") {
+					t.Errorf("expected the values only, but got the manifests")
+				}
+				if !strings.Contains(output, "clusterName: cluster1") {
+					t.Errorf("expected the cluster name in the values")
+				}
+			},
+		},
+		{
+			name:        "invalid klusterletconfig",
+			options:     func(o *options) { o.klusterletConfig = invalidKlusterletConfigFile },
+			expectedErr: true,
+		},
+		{
+			name:        "bootstrap kubeconfig not found",
+			options:     func(o *options) { o.bootstrapKubeconfig = filepath.Join(dir, "not-found") },
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			o := &options{
+				bootstrapKubeconfig:       bootstrapKubeconfigFile,
+				clusterName:               "cluster1",
+				mode:                      "Default",
+				output:                    outputYAML,
+				namespace:                 "multicluster-engine",
+				deployOnOCP:               true,
+				registrationOperatorImage: "quay.io/stolostron/registration-operator:test",
+				registrationImage:         "quay.io/stolostron/registration:test",
+				workImage:                 "quay.io/stolostron/work:test",
+			}
+			if c.options != nil {
+				c.options(o)
+			}
+			if err := o.validate(); err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+
+			out := &bytes.Buffer{}
+			err := o.run(context.TODO(), out)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if c.validate != nil {
+				c.validate(t, out.String())
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+	return file
+}
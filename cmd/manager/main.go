@@ -23,6 +23,7 @@ import (
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
 	"github.com/stolostron/managedcluster-import-controller/pkg/controller"
 	"github.com/stolostron/managedcluster-import-controller/pkg/controller/agentregistration"
+	"github.com/stolostron/managedcluster-import-controller/pkg/controller/csr"
 	"github.com/stolostron/managedcluster-import-controller/pkg/controller/flightctl"
 	"github.com/stolostron/managedcluster-import-controller/pkg/controller/importconfig"
 	"github.com/stolostron/managedcluster-import-controller/pkg/features"
@@ -34,6 +35,7 @@ import (
 	"k8s.io/client-go/tools/cache"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
+	ocoperatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
 	asv1beta1 "github.com/openshift/assisted-service/api/v1beta1"
 	hivev1 "github.com/openshift/hive/apis/hive/v1"
 	hyperv1beta1 "github.com/openshift/hypershift/api/hypershift/v1beta1"
@@ -86,6 +88,7 @@ var (
 func init() {
 	utilruntime.Must(k8sscheme.AddToScheme(scheme))
 	utilruntime.Must(ocinfrav1.AddToScheme(scheme))
+	utilruntime.Must(ocoperatorv1alpha1.AddToScheme(scheme))
 	utilruntime.Must(hivev1.AddToScheme(scheme))
 	utilruntime.Must(clusterv1.AddToScheme(scheme))
 	utilruntime.Must(asv1beta1.AddToScheme(scheme))
@@ -128,6 +131,10 @@ func main() {
 	pflag.BoolVar(&helpers.DeployOnOCP, "deploy-on-ocp", true, "used to deploy the controller on OCP or not")
 	pflag.Float32Var(&QPS, "kube-api-qps", 50, "QPS indicates the maximum QPS to the master from this client")
 	pflag.IntVar(&Burst, "kube-api-burst", 100, "Burst indicates the maximum burst for throttle")
+	agentRegistrationOptions := agentregistration.NewServerOptions()
+	agentRegistrationOptions.AddFlags(pflag.CommandLine)
+	csrApprovalWebhookOptions := csr.NewWebhookOptions()
+	csrApprovalWebhookOptions.AddFlags(pflag.CommandLine)
 	pflag.CommandLine.SetNormalizeFunc(utilflag.WordSepNormalizeFunc)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	features.DefaultMutableFeatureGate.AddFlag(pflag.CommandLine)
@@ -281,12 +288,55 @@ func main() {
 		},
 	)
 
+	effectiveKlusterletConfigInformerF := informers.NewFilteredSharedInformerFactory(
+		kubeClient,
+		10*time.Minute,
+		metav1.NamespaceAll, func(listOptions *metav1.ListOptions) {
+			listOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name",
+				constants.EffectiveKlusterletConfigConfigMapName).String()
+		},
+	)
+
+	flightctlDiscoveryInformerF := informers.NewFilteredSharedInformerFactory(
+		kubeClient,
+		10*time.Minute,
+		componentNamespace, func(listOptions *metav1.ListOptions) {
+			listOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name", flightctl.FlightCtlDiscoveryConfigMap).String()
+		})
+
+	// the agent registration ServiceAccount and ClusterRoleBinding of FlightCtl have the same name, the namespace is
+	// ignored by the ClusterRoleBinding informer
+	flightctlAgentRegistrationInformerF := informers.NewFilteredSharedInformerFactory(
+		kubeClient,
+		10*time.Minute,
+		componentNamespace, func(listOptions *metav1.ListOptions) {
+			listOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name", flightctl.AgentRegistrationServiceAccount).String()
+		})
+
+	// the per-cluster bootstrap ServiceAccounts and ClusterRoleBindings of the agent-registration clusters
+	agentRegistrationIdentityInformerF := informers.NewFilteredSharedInformerFactory(
+		kubeClient,
+		10*time.Minute,
+		metav1.NamespaceAll, func(listOptions *metav1.ListOptions) {
+			selector := &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      constants.AgentRegistrationClusterLabel,
+						Operator: metav1.LabelSelectorOpExists,
+					},
+				},
+			}
+			listOptions.LabelSelector = metav1.FormatLabelSelector(selector)
+		},
+	)
+
 	klusterletconfigInformerF := klusterletconfiginformer.NewSharedInformerFactory(klusterletconfigClient, 10*time.Minute)
 	klusterletconfigInformer := klusterletconfigInformerF.Config().V1alpha1().KlusterletConfigs().Informer()
 	if err := klusterletconfigInformer.AddIndexers(
 		cache.Indexers{
 			importconfig.KlusterletConfigBootstrapKubeConfigSecretsIndexKey: importconfig.IndexKlusterletConfigByBootstrapKubeConfigSecrets(),
 			importconfig.KlusterletConfigCustomizedCAConfigmapsIndexKey:     importconfig.IndexKlusterletConfigByCustomizedCAConfigmaps(),
+			helpers.KlusterletConfigSelectorIndexKey:                        helpers.IndexKlusterletConfigBySelector,
 		},
 	); err != nil {
 		setupLog.Error(err, "failed to add indexers to klusterletconfig informer")
@@ -296,9 +346,11 @@ func main() {
 	klusterletconfigLister := klusterletconfigInformerF.Config().V1alpha1().KlusterletConfigs().Lister()
 
 	// managedclusterInformer has an index on the klusterletconfig annotation, so we can get all managed clusters
-	// affected by a klusterletconfig change.
+	// referencing a klusterletconfig.
 	managedclusterInformerF := informerscluster.NewSharedInformerFactory(managedclusterClient, 10*time.Minute)
 	managedclusterInformer := managedclusterInformerF.Cluster().V1().ManagedClusters().Informer()
+	managedclustersetInformer := managedclusterInformerF.Cluster().V1beta2().ManagedClusterSets().Informer()
+	managedclustersetLister := managedclusterInformerF.Cluster().V1beta2().ManagedClusterSets().Lister()
 	if err := managedclusterInformer.AddIndexers(
 		cache.Indexers{
 			importconfig.ManagedClusterKlusterletConfigAnnotationIndexKey: importconfig.IndexManagedClusterByKlusterletconfigAnnotation,
@@ -341,7 +393,8 @@ func main() {
 	mcRecorder := helpers.NewManagedClusterEventRecorder(ctx, clientHolder.KubeClient)
 
 	// Init flightctlManager
-	flightctlManager := flightctl.NewFlightCtlManager(clientHolder, clusterIngressDomain)
+	flightctlManager := flightctl.NewFlightCtlManager(clientHolder,
+		flightctlDiscoveryInformerF.Core().V1().ConfigMaps().Lister(), clusterIngressDomain)
 
 	setupLog.Info("Registering Controllers")
 	if err := controller.AddToManager(
@@ -361,10 +414,26 @@ func main() {
 			KlusterletConfigLister:   klusterletconfigLister,
 			ControllerConfigInformer: controllerConfigInformerF.Core().V1().ConfigMaps().Informer(),
 			ControllerConfigLister:   controllerConfigInformerF.Core().V1().ConfigMaps().Lister(),
-			ManagedClusterInformer:   managedclusterInformer,
+
+			EffectiveKlusterletConfigInformer: effectiveKlusterletConfigInformerF.Core().V1().ConfigMaps().Informer(),
+			EffectiveKlusterletConfigLister:   effectiveKlusterletConfigInformerF.Core().V1().ConfigMaps().Lister(),
+			ManagedClusterInformer:            managedclusterInformer,
+
+			ManagedClusterSetInformer: managedclustersetInformer,
+			ManagedClusterSetLister:   managedclustersetLister,
+
+			FlightCtlDiscoveryConfigMapInformer: flightctlDiscoveryInformerF.Core().V1().ConfigMaps().Informer(),
+
+			FlightCtlServiceAccountInformer:     flightctlAgentRegistrationInformerF.Core().V1().ServiceAccounts().Informer(),
+			FlightCtlClusterRoleBindingInformer: flightctlAgentRegistrationInformerF.Rbac().V1().ClusterRoleBindings().Informer(),
+
+			AgentRegistrationServiceAccountLister: agentRegistrationIdentityInformerF.Core().V1().ServiceAccounts().Lister(),
+			AgentRegistrationClusterRoleBindingLister: agentRegistrationIdentityInformerF.Rbac().V1().
+				ClusterRoleBindings().Lister(),
 		},
 		componentNamespace,
 		flightctlManager,
+		csrApprovalWebhookOptions,
 		mcRecorder,
 	); err != nil {
 		setupLog.Error(err, "failed to register controller")
@@ -379,18 +448,25 @@ func main() {
 	hostedWorksInformerF.Start(ctx.Done())
 	klusterletconfigInformerF.Start(ctx.Done())
 	managedclusterInformerF.Start(ctx.Done())
+	effectiveKlusterletConfigInformerF.Start(ctx.Done())
+	flightctlDiscoveryInformerF.Start(ctx.Done())
+	flightctlAgentRegistrationInformerF.Start(ctx.Done())
+	agentRegistrationIdentityInformerF.Start(ctx.Done())
 	importSecertInformerF.WaitForCacheSync(ctx.Done())
 	autoimportSecretInformerF.WaitForCacheSync(ctx.Done())
 	klusterletWorksInformerF.WaitForCacheSync(ctx.Done())
 	hostedWorksInformerF.WaitForCacheSync(ctx.Done())
 	klusterletconfigInformerF.WaitForCacheSync(ctx.Done())
 	managedclusterInformerF.WaitForCacheSync(ctx.Done())
-	go flightctlManager.StartReconcileFlightCtlResources(ctx)
+	effectiveKlusterletConfigInformerF.WaitForCacheSync(ctx.Done())
+	flightctlDiscoveryInformerF.WaitForCacheSync(ctx.Done())
+	flightctlAgentRegistrationInformerF.WaitForCacheSync(ctx.Done())
+	agentRegistrationIdentityInformerF.WaitForCacheSync(ctx.Done())
 
 	// Start the agent-registratioin server
 	if features.DefaultMutableFeatureGate.Enabled(features.AgentRegistration) {
 		go func() {
-			if err := agentregistration.RunAgentRegistrationServer(ctx, 9091, clientHolder,
+			if err := agentregistration.RunAgentRegistrationServer(ctx, agentRegistrationOptions, clientHolder,
 				klusterletconfigLister); err != nil {
 				setupLog.Error(err, "failed to start agent registration server")
 			}
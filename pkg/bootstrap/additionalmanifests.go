@@ -0,0 +1,126 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// getKlusterletConfigAdditionalManifests returns the ConfigMaps and Secrets that hold the additional manifests
+// in the KlusterletConfigAdditionalManifestsAnnotation of the klusterletConfig.
+func getKlusterletConfigAdditionalManifests(
+	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) ([]corev1.ObjectReference, error) {
+	if klusterletConfig == nil {
+		return nil, nil
+	}
+
+	var refs []corev1.ObjectReference
+	value := klusterletConfig.Annotations[constants.KlusterletConfigAdditionalManifestsAnnotation]
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		segs := strings.Split(item, "/")
+		if len(segs) != 3 || len(segs[1]) == 0 || len(segs[2]) == 0 {
+			return nil, fmt.Errorf("wrong additional manifests format %q in the annotation %s, "+
+				"kind/namespace/name is required", item, constants.KlusterletConfigAdditionalManifestsAnnotation)
+		}
+
+		var kind string
+		switch {
+		case strings.EqualFold(segs[0], "ConfigMap"):
+			kind = "ConfigMap"
+		case strings.EqualFold(segs[0], "Secret"):
+			kind = "Secret"
+		default:
+			return nil, fmt.Errorf("unsupported kind %q in the annotation %s, only ConfigMap and Secret are supported",
+				segs[0], constants.KlusterletConfigAdditionalManifestsAnnotation)
+		}
+		refs = append(refs, corev1.ObjectReference{Kind: kind, Namespace: segs[1], Name: segs[2]})
+	}
+
+	return refs, nil
+}
+
+// renderAdditionalManifests renders the templated manifests in the referenced ConfigMaps and Secrets with the
+// config. The ConfigMaps and Secrets are rendered in order, and the data items of each of them are rendered in
+// the order of the keys. Every rendered manifest must be a kubernetes object.
+func renderAdditionalManifests(ctx context.Context, kubeClient kubernetes.Interface,
+	refs []corev1.ObjectReference, config interface{}) ([]byte, error) {
+	manifests := new(bytes.Buffer)
+	for _, ref := range refs {
+		data := map[string][]byte{}
+		switch ref.Kind {
+		case "ConfigMap":
+			cm, err := kubeClient.CoreV1().ConfigMaps(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the additional manifests configmap %s/%s: %w",
+					ref.Namespace, ref.Name, err)
+			}
+			for key, value := range cm.Data {
+				data[key] = []byte(value)
+			}
+		case "Secret":
+			secret, err := kubeClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the additional manifests secret %s/%s: %w",
+					ref.Namespace, ref.Name, err)
+			}
+			data = secret.Data
+		}
+
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			name := fmt.Sprintf("%s/%s/%s/%s", ref.Kind, ref.Namespace, ref.Name, key)
+			rendered, err := helpers.CreateAssetFromTemplate(name, data[key], config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render the additional manifests %s: %w", name, err)
+			}
+
+			// the leading document separator is optional
+			rendered = bytes.TrimPrefix(rendered, []byte("---\n"))
+			for _, manifest := range strings.Split(string(rendered), constants.YamlSperator) {
+				if len(strings.TrimSpace(manifest)) == 0 {
+					continue
+				}
+				obj := &unstructured.Unstructured{}
+				if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+					return nil, fmt.Errorf("invalid additional manifest in %s: %w", name, err)
+				}
+				if len(obj.Object) == 0 {
+					// the manifest has comments only
+					continue
+				}
+				if len(obj.GetAPIVersion()) == 0 || len(obj.GetKind()) == 0 || len(obj.GetName()) == 0 {
+					return nil, fmt.Errorf("invalid additional manifest in %s: apiVersion, kind and name are required", name)
+				}
+				// the manifests in the import.yaml are decoded before they are applied
+				if err := helpers.ValidateApplicableManifest([]byte(manifest)); err != nil {
+					return nil, fmt.Errorf("invalid additional manifest %s %s in %s: %w",
+						obj.GetKind(), obj.GetName(), name, err)
+				}
+				fmt.Fprintf(manifests, "%s%s", constants.YamlSperator, manifest)
+			}
+		}
+	}
+	return manifests.Bytes(), nil
+}
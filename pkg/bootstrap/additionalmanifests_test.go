@@ -0,0 +1,202 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+func TestGetKlusterletConfigAdditionalManifests(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotation  string
+		expected    []corev1.ObjectReference
+		expectedErr bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "configmaps and secrets",
+			annotation: "ConfigMap/ns1/policies, secret/ns2/ca",
+			expected: []corev1.ObjectReference{
+				{Kind: "ConfigMap", Namespace: "ns1", Name: "policies"},
+				{Kind: "Secret", Namespace: "ns2", Name: "ca"},
+			},
+		},
+		{
+			name:        "wrong format",
+			annotation:  "ns1/policies",
+			expectedErr: true,
+		},
+		{
+			name:        "unsupported kind",
+			annotation:  "Deployment/ns1/policies",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kc := &klusterletconfigv1alpha1.KlusterletConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{constants.KlusterletConfigAdditionalManifestsAnnotation: c.annotation},
+				},
+			}
+			refs, err := getKlusterletConfigAdditionalManifests(kc)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if !reflect.DeepEqual(refs, c.expected) {
+				t.Errorf("expected %v, but got %v", c.expected, refs)
+			}
+		})
+	}
+}
+
+func TestRenderAdditionalManifests(t *testing.T) {
+	policies := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "policies", Namespace: "ns1"},
+		Data: map[string]string{
+			"b.yaml": `apiVersion: v1
+kind: LimitRange
+metadata:
+  name: limits
+  namespace: {{ .Klusterlet.Namespace }}`,
+			"a.yaml": `---
+# network policies
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: deny-all
+  namespace: {{ .Klusterlet.Namespace }}
+---
+# nothing here`,
+		},
+	}
+	ca := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "ns2"},
+		Data: map[string][]byte{
+			"ca.yaml": []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: corporate-ca
+  namespace: {{ .Klusterlet.Namespace }}`),
+		},
+	}
+	invalid := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "ns1"},
+		Data:       map[string]string{"invalid.yaml": `{{ .Klusterlet.Namespace`},
+	}
+	noKind := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "nokind", Namespace: "ns1"},
+		Data:       map[string]string{"nokind.yaml": "metadata:\n  name: test"},
+	}
+	scc := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "scc", Namespace: "ns1"},
+		Data: map[string]string{
+			"scc.yaml": "apiVersion: security.openshift.io/v1\nkind: SecurityContextConstraints\nmetadata:\n  name: scc",
+		},
+	}
+	invalidField := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalidfield", Namespace: "ns1"},
+		Data: map[string]string{
+			"limits.yaml": "apiVersion: v1\nkind: LimitRange\nmetadata:\n  name: limits\nspec:\n  limits: invalid",
+		},
+	}
+
+	cases := []struct {
+		name        string
+		objs        []runtime.Object
+		refs        []corev1.ObjectReference
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "no refs",
+		},
+		{
+			name: "render in order",
+			objs: []runtime.Object{policies, ca},
+			refs: []corev1.ObjectReference{
+				{Kind: "Secret", Namespace: "ns2", Name: "ca"},
+				{Kind: "ConfigMap", Namespace: "ns1", Name: "policies"},
+			},
+			expected: `
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: corporate-ca
+  namespace: open-cluster-management-agent
+---
+# network policies
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: deny-all
+  namespace: open-cluster-management-agent
+---
+apiVersion: v1
+kind: LimitRange
+metadata:
+  name: limits
+  namespace: open-cluster-management-agent`,
+		},
+		{
+			name:        "not found",
+			refs:        []corev1.ObjectReference{{Kind: "ConfigMap", Namespace: "ns1", Name: "policies"}},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid template",
+			objs:        []runtime.Object{invalid},
+			refs:        []corev1.ObjectReference{{Kind: "ConfigMap", Namespace: "ns1", Name: "invalid"}},
+			expectedErr: true,
+		},
+		{
+			name:        "no kind",
+			objs:        []runtime.Object{noKind},
+			refs:        []corev1.ObjectReference{{Kind: "ConfigMap", Namespace: "ns1", Name: "nokind"}},
+			expectedErr: true,
+		},
+		{
+			name:     "kind not registered",
+			objs:     []runtime.Object{scc},
+			refs:     []corev1.ObjectReference{{Kind: "ConfigMap", Namespace: "ns1", Name: "scc"}},
+			expected: "\n---\napiVersion: security.openshift.io/v1\nkind: SecurityContextConstraints\nmetadata:\n  name: scc",
+		},
+		{
+			name:        "manifest cannot be decoded",
+			objs:        []runtime.Object{invalidField},
+			refs:        []corev1.ObjectReference{{Kind: "ConfigMap", Namespace: "ns1", Name: "invalidfield"}},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := newKlusterletChartConfig(operatorv1.InstallModeDefault, "cluster1", nil)
+			config.Klusterlet.Namespace = "open-cluster-management-agent"
+
+			manifests, err := renderAdditionalManifests(context.TODO(), kubefake.NewSimpleClientset(c.objs...),
+				c.refs, config)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if string(manifests) != c.expected {
+				t.Errorf("expected %q, but got %q", c.expected, string(manifests))
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -83,33 +84,103 @@ func CreateBootstrapKubeConfig(ctxClusterName string,
 	return boostrapConfigData, err
 }
 
+// KubeAPIServerEndpoint is a hub kube apiserver endpoint in the bootstrap kubeconfig, it includes the
+// apiserver url, proxy url, ca file and ca data.
+type KubeAPIServerEndpoint struct {
+	URL      string
+	ProxyURL string
+	CA       string
+	CAData   []byte
+}
+
 // GetKubeAPIServerConfig returns the expected apiserver url, proxy url, ca file and ca data
-// for cluster registration.
+// for cluster registration. If there are multiple hub kube apiserver URLs, the first one is used.
 func GetKubeAPIServerConfig(ctx context.Context, clientHolder *helpers.ClientHolder, ns string,
 	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig, selfManaged bool) (string, string,
 	string, []byte, error) {
+	endpoints, err := GetKubeAPIServerEndpoints(ctx, clientHolder, ns, klusterletConfig, selfManaged)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+
+	return endpoints[0].URL, endpoints[0].ProxyURL, endpoints[0].CA, endpoints[0].CAData, nil
+}
+
+// GetKubeAPIServerEndpoints returns the expected hub kube apiserver endpoints for cluster registration in
+// order, there is at least one endpoint returned if no error occurs.
+func GetKubeAPIServerEndpoints(ctx context.Context, clientHolder *helpers.ClientHolder, ns string,
+	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig, selfManaged bool) ([]KubeAPIServerEndpoint, error) {
 	// the proxy settings in the klusterletConfig will be ignored when the internal endpoint
 	// is used for the self managed cluster
 	if selfManaged && !hasCustomServerURLOrStrategy(klusterletConfig) {
-		return apiServerInternalEndpoint, "", apiServerInternalEndpointCA, nil, nil
+		return []KubeAPIServerEndpoint{{URL: apiServerInternalEndpoint, CA: apiServerInternalEndpointCA}}, nil
 	}
 
 	// get the proxy settings
 	proxy, _ := GetProxySettings(klusterletConfig)
 
-	// get the apiserver address
-	url, err := GetKubeAPIServerAddress(ctx, clientHolder.RuntimeClient, klusterletConfig)
+	// get the apiserver addresses
+	urls, err := GetKubeAPIServerURLs(klusterletConfig)
 	if err != nil {
-		return "", "", "", nil, err
+		return nil, err
+	}
+	if len(urls) == 0 {
+		url, err := GetKubeAPIServerAddress(ctx, clientHolder, klusterletConfig)
+		if err != nil {
+			return nil, err
+		}
+		urls = []string{url}
 	}
 
-	// get the ca data
-	caData, err := GetBootstrapCAData(ctx, clientHolder, url, ns, klusterletConfig)
-	if err != nil {
-		return "", "", "", nil, err
+	endpoints := []KubeAPIServerEndpoint{}
+	for _, url := range urls {
+		// get the ca data, the auto detected ca may be different for each apiserver address
+		caData, err := GetBootstrapCAData(ctx, clientHolder, url, ns, klusterletConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints = append(endpoints, KubeAPIServerEndpoint{URL: url, ProxyURL: proxy, CAData: caData})
+	}
+
+	return endpoints, nil
+}
+
+// GetKubeAPIServerURLs returns the ordered hub kube apiserver URLs from the klusterletConfig annotation,
+// the duplicated URLs are removed. It returns nil if the annotation is not set.
+func GetKubeAPIServerURLs(klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) ([]string, error) {
+	if klusterletConfig == nil {
+		return nil, nil
+	}
+
+	value := klusterletConfig.Annotations[constants.KlusterletConfigHubKubeAPIServerURLsAnnotation]
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	var urls []string
+	for _, u := range strings.Split(value, ",") {
+		u = strings.TrimSpace(u)
+		if len(u) == 0 {
+			continue
+		}
+
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hub kube apiserver url %q in annotation %s: %v",
+				u, constants.KlusterletConfigHubKubeAPIServerURLsAnnotation, err)
+		}
+		if parsed.Scheme != "https" || len(parsed.Host) == 0 {
+			return nil, fmt.Errorf("invalid hub kube apiserver url %q in annotation %s: an https url is required",
+				u, constants.KlusterletConfigHubKubeAPIServerURLsAnnotation)
+		}
+
+		if !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
 	}
 
-	return url, proxy, "", caData, err
+	return urls, nil
 }
 
 // Return true if the managed cluster has a custom URL or its server verification strategy
@@ -119,6 +190,10 @@ func hasCustomServerURLOrStrategy(klusterletConfig *klusterletconfigv1alpha1.Klu
 		return false
 	}
 
+	if len(klusterletConfig.Annotations[constants.KlusterletConfigHubKubeAPIServerURLsAnnotation]) > 0 {
+		return true
+	}
+
 	if klusterletConfig.Spec.HubKubeAPIServerConfig != nil {
 		if len(klusterletConfig.Spec.HubKubeAPIServerConfig.URL) > 0 {
 			return true
@@ -226,7 +301,10 @@ func GetBootstrapToken(ctx context.Context, kubeClient kubernetes.Interface,
 	return RequestSAToken(ctx, kubeClient, saName, secretNamespace, tokenExpirationSeconds)
 }
 
-func GetKubeAPIServerAddress(ctx context.Context, client client.Client,
+// GetKubeAPIServerAddress returns the hub kube apiserver address. The address in the klusterletConfig is used
+// if it is specified, otherwise the address is got from the Infrastructure on the OCP hub cluster, or discovered
+// by the kubeAPIServerDiscoverers on the non-OCP hub cluster.
+func GetKubeAPIServerAddress(ctx context.Context, clientHolder *helpers.ClientHolder,
 	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) (string, error) {
 
 	if klusterletConfig != nil && klusterletConfig.Spec.HubKubeAPIServerConfig != nil &&
@@ -242,11 +320,16 @@ func GetKubeAPIServerAddress(ctx context.Context, client client.Client,
 	}
 
 	if !helpers.DeployOnOCP {
-		return "", fmt.Errorf("failed get Hub kube apiserver on non-OCP cluster")
+		discovered := discoverKubeAPIServer(ctx, clientHolder.KubeClient)
+		if discovered == nil {
+			return "", fmt.Errorf("failed get Hub kube apiserver on non-OCP cluster, please use " +
+				"klusterletConfig to set the hub kubeAPIServer URL")
+		}
+		return discovered.url, nil
 	}
 
 	infraConfig := &ocinfrav1.Infrastructure{}
-	err := client.Get(ctx, types.NamespacedName{Name: "cluster"}, infraConfig)
+	err := clientHolder.RuntimeClient.Get(ctx, types.NamespacedName{Name: "cluster"}, infraConfig)
 	if err == nil {
 		return infraConfig.Status.APIServerURL, nil
 	}
@@ -371,8 +454,12 @@ func getCustomCAData(ctx context.Context, clientHolder *helpers.ClientHolder,
 
 func autoDetectCAData(ctx context.Context, clientHolder *helpers.ClientHolder, kubeAPIServer string,
 	caNamespace string) ([]byte, error) {
-	// get caBundle from the kube-root-ca.crt configmap in the pod namespace for non-ocp case.
+	// get caBundle from the source that the kube apiserver is discovered from, and fallback to the
+	// kube-root-ca.crt configmap in the pod namespace for non-ocp case.
 	if !helpers.DeployOnOCP {
+		if caData := discoverKubeAPIServerCAData(ctx, clientHolder.KubeClient, kubeAPIServer); len(caData) > 0 {
+			return caData, nil
+		}
 		return getKubeRootCABundle(ctx, clientHolder, caNamespace)
 	}
 
@@ -446,7 +533,8 @@ func getCABundleFromConfigmap(ctx context.Context, clientHolder *helpers.ClientH
 }
 
 // getKubeAPIServerSecretName iterate through all named certificates from apiserver
-// returns the first one which has a name matches the given dnsName
+// returns the first one which has a name matches the given dnsName, a wildcard name
+// matches the dnsName in its subdomain, like the named certificates of the apiserver
 func getKubeAPIServerSecretName(ctx context.Context, client client.Client, dnsName string) (string, error) {
 	apiserver := &ocinfrav1.APIServer{}
 	if err := client.Get(ctx, types.NamespacedName{Name: "cluster"}, apiserver); err != nil {
@@ -460,7 +548,7 @@ func getKubeAPIServerSecretName(ctx context.Context, client client.Client, dnsNa
 	// iterate through all namedcertificates
 	for _, namedCert := range apiserver.Spec.ServingCerts.NamedCertificates {
 		for _, name := range namedCert.Names {
-			if strings.EqualFold(name, dnsName) {
+			if matchesCertificateName(name, dnsName) {
 				return namedCert.ServingCertificate.Name, nil
 			}
 		}
@@ -468,6 +556,21 @@ func getKubeAPIServerSecretName(ctx context.Context, client client.Client, dnsNa
 	return "", nil
 }
 
+// matchesCertificateName returns true if the dnsName matches the certificate name, the wildcard in the name
+// only matches a single label.
+func matchesCertificateName(name, dnsName string) bool {
+	if strings.EqualFold(name, dnsName) {
+		return true
+	}
+
+	suffix, ok := strings.CutPrefix(name, "*.")
+	if !ok {
+		return false
+	}
+	label, domain, ok := strings.Cut(dnsName, ".")
+	return ok && len(label) > 0 && strings.EqualFold(domain, suffix)
+}
+
 // checkIsIBMCloud detects if the current cloud vendor is ibm or not
 // we know we are on OCP already, so if it's also ibm cloud, it's roks
 func checkIsIBMCloud(ctx context.Context, client client.Client) (bool, error) {
@@ -631,42 +734,58 @@ func mergeCertificateData(caBundles ...[]byte) ([]byte, error) {
 }
 
 // ValidateBootstrapKubeconfig validates the bootstrap kubeconfig data by checking for changes in:
-//   - the kube apiserver address
-//   - the CA file path
-//   - the CA data
-//   - the proxy url
+//   - the number and the order of the kube apiserver endpoints
+//   - the kube apiserver address of each endpoint
+//   - the CA file path of each endpoint
+//   - the CA data of each endpoint
+//   - the proxy url of each endpoint
 //   - the context cluster name
 func ValidateBootstrapKubeconfig(clusterName string,
-	kubeAPIServer, proxyURL, ca string, caData []byte, ctxClusterName string,
-	requiredKubeAPIServer, requiredProxyURL, requiredCA string, requiredCAData []byte,
-	requiredCtxClusterName string) bool {
+	endpoints []KubeAPIServerEndpoint, ctxClusterName string,
+	requiredEndpoints []KubeAPIServerEndpoint, requiredCtxClusterName string) bool {
+	// validate the kube api server endpoints
+	if len(endpoints) != len(requiredEndpoints) {
+		klog.Infof("KubeAPIServer endpoints changed for the managed cluster %s: %d", clusterName, len(endpoints))
+		return false
+	}
+
+	for i := range requiredEndpoints {
+		if !validateKubeAPIServerEndpoint(clusterName, endpoints[i], requiredEndpoints[i]) {
+			return false
+		}
+	}
+
+	// validate context cluster name
+	if ctxClusterName != requiredCtxClusterName {
+		klog.Infof("Context cluster name is invalid for the managed cluster %s: %s", clusterName, ctxClusterName)
+		return false
+	}
+
+	return true
+}
+
+func validateKubeAPIServerEndpoint(clusterName string, endpoint, requiredEndpoint KubeAPIServerEndpoint) bool {
 	// validate kube api server endpoint
-	if kubeAPIServer != requiredKubeAPIServer {
-		klog.Infof("KubeAPIServer invalid for the managed cluster %s: %s", clusterName, kubeAPIServer)
+	if endpoint.URL != requiredEndpoint.URL {
+		klog.Infof("KubeAPIServer invalid for the managed cluster %s: %s", clusterName, endpoint.URL)
 		return false
 	}
 
 	// validate kube api server CA file path
-	if ca != requiredCA {
-		klog.Infof("CA is invalid for the managed cluster %s: %s", clusterName, ca)
+	if endpoint.CA != requiredEndpoint.CA {
+		klog.Infof("CA is invalid for the managed cluster %s: %s", clusterName, endpoint.CA)
 		return false
 	}
 
 	// validate kube api server CA data
-	if !bytes.Equal(caData, requiredCAData) {
+	if !bytes.Equal(endpoint.CAData, requiredEndpoint.CAData) {
 		klog.Infof("CAdata is invalid for the managed cluster %s", clusterName)
 		return false
 	}
 
 	// validate proxy server url
-	if proxyURL != requiredProxyURL {
-		klog.Infof("Proxy config is invalid for the managed cluster %s: %s", clusterName, proxyURL)
-		return false
-	}
-
-	// validate context cluster name
-	if ctxClusterName != requiredCtxClusterName {
-		klog.Infof("Context cluster name is invalid for the managed cluster %s: %s", clusterName, ctxClusterName)
+	if endpoint.ProxyURL != requiredEndpoint.ProxyURL {
+		klog.Infof("Proxy config is invalid for the managed cluster %s: %s", clusterName, endpoint.ProxyURL)
 		return false
 	}
 
@@ -743,7 +743,10 @@ func TestGetKubeAPIServerAddress(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := GetKubeAPIServerAddress(context.Background(), tt.args.client, tt.args.klusterletConfig)
+			got, err := GetKubeAPIServerAddress(context.Background(), &helpers.ClientHolder{
+				KubeClient:    kubefake.NewSimpleClientset(),
+				RuntimeClient: tt.args.client,
+			}, tt.args.klusterletConfig)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getKubeAPIServerAddress() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -810,6 +813,10 @@ func TestGetKubeAPIServerSecretName(t *testing.T) {
 						Names:              []string{"my-dns-name.com"},
 						ServingCertificate: ocinfrav1.SecretNameReference{Name: "my-secret-name"},
 					},
+					{
+						Names:              []string{"*.apps.example.com"},
+						ServingCertificate: ocinfrav1.SecretNameReference{Name: "my-wildcard-secret-name"},
+					},
 				},
 			},
 		},
@@ -852,6 +859,24 @@ func TestGetKubeAPIServerSecretName(t *testing.T) {
 			want:    "my-secret-name",
 			wantErr: false,
 		},
+		{
+			name: "wildcard name matches",
+			args: args{
+				client: fake.NewClientBuilder().WithScheme(testscheme).WithObjects(apiserverConfig).Build(),
+				name:   "api.apps.example.com",
+			},
+			want:    "my-wildcard-secret-name",
+			wantErr: false,
+		},
+		{
+			name: "wildcard name only matches a single label",
+			args: args{
+				client: fake.NewClientBuilder().WithScheme(testscheme).WithObjects(apiserverConfig).Build(),
+				name:   "api.hub.apps.example.com",
+			},
+			want:    "",
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1399,6 +1424,9 @@ func TestValidateBootstrapKubeconfig(t *testing.T) {
 		requiredCAData         []byte
 		requiredCtxClusterName string
 
+		additionalEndpoints         []KubeAPIServerEndpoint
+		requiredAdditionalEndpoints []KubeAPIServerEndpoint
+
 		valid bool
 	}{
 		{
@@ -1447,6 +1475,37 @@ func TestValidateBootstrapKubeconfig(t *testing.T) {
 			ca:         "/etc/ca.crt",
 			requiredCA: "/etc/new-ca.crt",
 		},
+		{
+			name:                  "additional endpoint added",
+			kubeAPIServer:         "https://api.my-cluster.example.com:6443",
+			requiredKubeAPIServer: "https://api.my-cluster.example.com:6443",
+			requiredAdditionalEndpoints: []KubeAPIServerEndpoint{
+				{URL: "https://api2.my-cluster.example.com:6443"},
+			},
+		},
+		{
+			name:                  "additional endpoint changed",
+			kubeAPIServer:         "https://api.my-cluster.example.com:6443",
+			requiredKubeAPIServer: "https://api.my-cluster.example.com:6443",
+			additionalEndpoints: []KubeAPIServerEndpoint{
+				{URL: "https://api2.my-cluster.example.com:6443", CAData: certData1},
+			},
+			requiredAdditionalEndpoints: []KubeAPIServerEndpoint{
+				{URL: "https://api2.my-cluster.example.com:6443", CAData: certData2},
+			},
+		},
+		{
+			name:                  "all endpoints valid",
+			kubeAPIServer:         "https://api.my-cluster.example.com:6443",
+			requiredKubeAPIServer: "https://api.my-cluster.example.com:6443",
+			additionalEndpoints: []KubeAPIServerEndpoint{
+				{URL: "https://api2.my-cluster.example.com:6443", CAData: certData1},
+			},
+			requiredAdditionalEndpoints: []KubeAPIServerEndpoint{
+				{URL: "https://api2.my-cluster.example.com:6443", CAData: certData1},
+			},
+			valid: true,
+		},
 		{
 			name:                   "all valid",
 			kubeAPIServer:          "https://api.my-cluster.example.com:6443",
@@ -1462,8 +1521,16 @@ func TestValidateBootstrapKubeconfig(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
 			t.Logf("Test name: %s", c.name)
-			valid := ValidateBootstrapKubeconfig("cluster1", c.kubeAPIServer, c.proxyURL, c.ca, c.caData, c.ctxClusterName,
-				c.requiredKubeAPIServer, c.requiredProxyURL, c.requiredCA, c.requiredCAData, c.requiredCtxClusterName)
+			endpoints := []KubeAPIServerEndpoint{
+				{URL: c.kubeAPIServer, ProxyURL: c.proxyURL, CA: c.ca, CAData: c.caData},
+			}
+			endpoints = append(endpoints, c.additionalEndpoints...)
+			requiredEndpoints := []KubeAPIServerEndpoint{
+				{URL: c.requiredKubeAPIServer, ProxyURL: c.requiredProxyURL, CA: c.requiredCA, CAData: c.requiredCAData},
+			}
+			requiredEndpoints = append(requiredEndpoints, c.requiredAdditionalEndpoints...)
+			valid := ValidateBootstrapKubeconfig("cluster1", endpoints, c.ctxClusterName,
+				requiredEndpoints, c.requiredCtxClusterName)
 			if valid != c.valid {
 				t.Errorf("expected %v, but got %v", c.valid, valid)
 			}
@@ -1475,13 +1542,13 @@ func TestGetBootstrapToken(t *testing.T) {
 	ctx := context.Background()
 
 	tests := []struct {
-		name                     string
-		saName                   string
-		secretNamespace          string
-		tokenExpirationSeconds   int64
-		secrets                  []runtime.Object
-		expectTokenRequest       bool
-		expectedTokenFromSecret  string
+		name                    string
+		saName                  string
+		secretNamespace         string
+		tokenExpirationSeconds  int64
+		secrets                 []runtime.Object
+		expectTokenRequest      bool
+		expectedTokenFromSecret string
 	}{
 		{
 			name:                   "legacy token exists and valid",
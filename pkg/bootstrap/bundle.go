@@ -0,0 +1,100 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ImportBundleImportYamlKey          = "import.yaml"
+	ImportBundleCRDsYamlKey            = "crds.yaml"
+	ImportBundleImagesKey              = "images.txt"
+	ImportBundleBootstrapCredentialKey = "bootstrap-credential.yaml"
+)
+
+// BootstrapCredential describes the bootstrap token in the bootstrap hub kubeconfig of an import bundle, so the
+// operator can tell when the bundle must be applied before. The timestamps are empty if the token does not expire.
+type BootstrapCredential struct {
+	ClusterName         string `json:"clusterName"`
+	ServiceAccount      string `json:"serviceAccount"`
+	Namespace           string `json:"namespace"`
+	CreationTimestamp   string `json:"creationTimestamp,omitempty"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+// GenerateImportBundle returns a gzipped tar archive to import a cluster that has no access to the hub
+// registries. It contains the rendered import.yaml and crds.yaml, the klusterlet images resolved with the image
+// mirror overrides in images.txt, and the bootstrap credential expiry in bootstrap-credential.yaml.
+func (c *KlusterletManifestsConfig) GenerateImportBundle(ctx context.Context, clientHolder *helpers.ClientHolder,
+	credential BootstrapCredential) ([]byte, error) {
+	importYaml, crdsYaml, _, err := c.Generate(ctx, clientHolder)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialYaml, err := yaml.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{name: ImportBundleImportYamlKey, data: importYaml},
+		{name: ImportBundleCRDsYamlKey, data: crdsYaml},
+		{name: ImportBundleImagesKey, data: []byte(strings.Join(c.getAgentImageList(), "\n") + "\n")},
+		{name: ImportBundleBootstrapCredentialKey, data: credentialYaml},
+	}
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	modTime := time.Now()
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    0600,
+			Size:    int64(len(f.data)),
+			ModTime: modTime,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to write %s to the import bundle: %w", f.name, err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, fmt.Errorf("failed to write %s to the import bundle: %w", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// getAgentImageList returns the sorted and deduplicated klusterlet agent images resolved by the last Generate.
+func (c *KlusterletManifestsConfig) getAgentImageList() []string {
+	images := []string{}
+	for _, image := range c.agentImages {
+		if len(image) == 0 {
+			continue
+		}
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return slices.Compact(images)
+}
@@ -0,0 +1,92 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers/imageregistry"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGenerateImportBundle(t *testing.T) {
+	t.Setenv(constants.DefaultImagePullSecretEnvVarName, "")
+
+	kubeClient := kubefake.NewSimpleClientset()
+	clientHolder := &helpers.ClientHolder{
+		KubeClient:          kubeClient,
+		RuntimeClient:       fake.NewClientBuilder().WithScheme(testscheme).Build(),
+		ImageRegistryClient: imageregistry.NewClient(kubeClient),
+	}
+
+	config := NewKlusterletManifestsConfig(operatorv1.InstallModeDefault, "cluster1", []byte("bootstrap kubeconfig")).
+		WithKlusterletConfig(&klusterletconfigv1alpha1.KlusterletConfig{
+			Spec: klusterletconfigv1alpha1.KlusterletConfigSpec{
+				Registries: []klusterletconfigv1alpha1.Registries{
+					{Source: "quay.io/open-cluster-management", Mirror: "mirror.io/ocm"},
+				},
+			},
+		})
+
+	bundle, err := config.GenerateImportBundle(context.TODO(), clientHolder, BootstrapCredential{
+		ClusterName:         "cluster1",
+		ServiceAccount:      "agent-registration-bootstrap",
+		Namespace:           "multicluster-engine",
+		ExpirationTimestamp: "2026-01-01T00:00:00Z",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		files[header.Name] = string(data)
+	}
+
+	if len(files) != 4 {
+		t.Errorf("expected 4 files, but got %d", len(files))
+	}
+	if !strings.Contains(files[ImportBundleImportYamlKey], "kind: Klusterlet") {
+		t.Errorf("expected the klusterlet in import.yaml, but got %s", files[ImportBundleImportYamlKey])
+	}
+	if !strings.Contains(files[ImportBundleCRDsYamlKey], "kind: CustomResourceDefinition") {
+		t.Errorf("expected the crds in crds.yaml, but got %s", files[ImportBundleCRDsYamlKey])
+	}
+
+	expectedImages := "mirror.io/ocm/registration-operator:latest\n" +
+		"mirror.io/ocm/registration:latest\n" +
+		"mirror.io/ocm/work:latest\n"
+	if files[ImportBundleImagesKey] != expectedImages {
+		t.Errorf("expected images %q, but got %q", expectedImages, files[ImportBundleImagesKey])
+	}
+	if !strings.Contains(files[ImportBundleBootstrapCredentialKey], "expirationTimestamp: \"2026-01-01T00:00:00Z\"") {
+		t.Errorf("expected the expiration in the credential, but got %s", files[ImportBundleBootstrapCredentialKey])
+	}
+}
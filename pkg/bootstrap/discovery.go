@@ -0,0 +1,384 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	clusterInfoNamespace     = "kube-public"
+	clusterInfoConfigMapName = "cluster-info"
+	clusterInfoKubeconfigKey = "kubeconfig"
+
+	kubeadmConfigNamespace         = "kube-system"
+	kubeadmConfigConfigMapName     = "kubeadm-config"
+	kubeadmClusterConfigurationKey = "ClusterConfiguration"
+)
+
+// discoveredKubeAPIServer is the hub kube apiserver address and ca found on a non-OCP hub cluster, the ca
+// data is empty if the source does not provide it.
+type discoveredKubeAPIServer struct {
+	url    string
+	caData []byte
+	source string
+}
+
+type kubeAPIServerDiscoverer func(ctx context.Context, kubeClient kubernetes.Interface) (*discoveredKubeAPIServer, error)
+
+// kubeAPIServerDiscoverers are used to discover the hub kube apiserver on a non-OCP hub cluster in order.
+// The Service and Ingress are labeled by the administrator explicitly, so they take precedence over the
+// cluster-info and kubeadm-config that are created by the cluster bootstrap tools.
+var kubeAPIServerDiscoverers = []kubeAPIServerDiscoverer{
+	discoverFromService,
+	discoverFromIngress,
+	discoverFromClusterInfo,
+	discoverFromKubeadmConfig,
+}
+
+// discoveryCacheTTL is how long the discovered hub kube apiservers are cached. The discovery reads several
+// resources of the hub cluster, and it is required by the import secret of every cluster.
+const discoveryCacheTTL = 5 * time.Minute
+
+// discoveryFailureCacheTTL is how long the discovered hub kube apiservers are cached when any of the
+// kubeAPIServerDiscoverers fails, so the failed discoverer is retried soon without reading the resources of
+// the hub cluster for every cluster.
+const discoveryFailureCacheTTL = 30 * time.Second
+
+// discoveryCache caches the hub kube apiservers found by the kubeAPIServerDiscoverers with a kube client.
+type discoveryCache struct {
+	mutex      sync.Mutex
+	kubeClient kubernetes.Interface
+	discovered []*discoveredKubeAPIServer
+	expiry     time.Time
+}
+
+var kubeAPIServerDiscoveryCache = &discoveryCache{}
+
+// get returns the hub kube apiservers found by each of the kubeAPIServerDiscoverers in order, the result is
+// reused until it expires. A discoverer that fails is logged and skipped, so the hub kube apiserver is found
+// by the next discoverers, and the result is cached for a shorter time.
+func (c *discoveryCache) get(ctx context.Context, kubeClient kubernetes.Interface) []*discoveredKubeAPIServer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.kubeClient == kubeClient && time.Now().Before(c.expiry) {
+		return c.discovered
+	}
+
+	ttl := discoveryCacheTTL
+	discovered := []*discoveredKubeAPIServer{}
+	for _, discover := range kubeAPIServerDiscoverers {
+		d, err := discover(ctx, kubeClient)
+		if err != nil {
+			klog.Warningf("failed to discover the hub kube apiserver, try the next source: %v", err)
+			ttl = discoveryFailureCacheTTL
+			continue
+		}
+		if d != nil && len(d.url) > 0 {
+			discovered = append(discovered, d)
+		}
+	}
+
+	c.kubeClient = kubeClient
+	c.discovered = discovered
+	c.expiry = time.Now().Add(ttl)
+	return discovered
+}
+
+// discoverKubeAPIServer returns the first hub kube apiserver found by the kubeAPIServerDiscoverers, it
+// returns nil if the hub kube apiserver cannot be found.
+func discoverKubeAPIServer(ctx context.Context, kubeClient kubernetes.Interface) *discoveredKubeAPIServer {
+	discovered := kubeAPIServerDiscoveryCache.get(ctx, kubeClient)
+	if len(discovered) == 0 {
+		return nil
+	}
+
+	klog.V(4).Infof("Discovered the hub kube apiserver %s from %s", discovered[0].url, discovered[0].source)
+	return discovered[0]
+}
+
+// discoverKubeAPIServerCAData returns the ca data provided by the source that the given hub kube apiserver
+// is discovered from, it returns nil if no source provides the ca data of the kube apiserver.
+func discoverKubeAPIServerCAData(ctx context.Context, kubeClient kubernetes.Interface,
+	kubeAPIServer string) []byte {
+	for _, d := range kubeAPIServerDiscoveryCache.get(ctx, kubeClient) {
+		if isSameKubeAPIServer(d.url, kubeAPIServer) && len(d.caData) > 0 {
+			klog.V(4).Infof("Using the ca of the hub kube apiserver %s from %s", kubeAPIServer, d.source)
+			return d.caData
+		}
+	}
+
+	return nil
+}
+
+// isSameKubeAPIServer compares the hosts and ports of the kube apiserver urls, the default https port is
+// used if the port is not specified.
+func isSameKubeAPIServer(a, b string) bool {
+	urlA, err := url.Parse(a)
+	if err != nil {
+		return a == b
+	}
+	urlB, err := url.Parse(b)
+	if err != nil {
+		return a == b
+	}
+
+	portA, portB := urlA.Port(), urlB.Port()
+	if len(portA) == 0 {
+		portA = "443"
+	}
+	if len(portB) == 0 {
+		portB = "443"
+	}
+	return strings.EqualFold(urlA.Hostname(), urlB.Hostname()) && portA == portB
+}
+
+// discoverFromClusterInfo reads the kubeconfig in the kube-public/cluster-info configmap, which is created
+// by kubeadm, kind and the other kubeadm based installers.
+func discoverFromClusterInfo(ctx context.Context, kubeClient kubernetes.Interface) (*discoveredKubeAPIServer, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(clusterInfoNamespace).Get(ctx, clusterInfoConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfigData, ok := cm.Data[clusterInfoKubeconfigKey]
+	if !ok {
+		return nil, nil
+	}
+
+	kubeconfig, err := clientcmd.Load([]byte(kubeconfigData))
+	if err != nil {
+		klog.Warningf("failed to load the kubeconfig in %s/%s: %v", clusterInfoNamespace, clusterInfoConfigMapName, err)
+		return nil, nil
+	}
+
+	// the cluster-info has only one cluster, sort the names to be deterministic anyway
+	names := make([]string, 0, len(kubeconfig.Clusters))
+	for name := range kubeconfig.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cluster := kubeconfig.Clusters[name]
+		if cluster == nil || len(cluster.Server) == 0 {
+			continue
+		}
+		return &discoveredKubeAPIServer{
+			url:    cluster.Server,
+			caData: cluster.CertificateAuthorityData,
+			source: fmt.Sprintf("configmap %s/%s", clusterInfoNamespace, clusterInfoConfigMapName),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// discoverFromKubeadmConfig reads the controlPlaneEndpoint of the ClusterConfiguration in the
+// kube-system/kubeadm-config configmap.
+func discoverFromKubeadmConfig(ctx context.Context, kubeClient kubernetes.Interface) (*discoveredKubeAPIServer, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(kubeadmConfigNamespace).Get(ctx, kubeadmConfigConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	clusterConfiguration := struct {
+		ControlPlaneEndpoint string `json:"controlPlaneEndpoint"`
+	}{}
+	if err := yaml.Unmarshal([]byte(cm.Data[kubeadmClusterConfigurationKey]), &clusterConfiguration); err != nil {
+		klog.Warningf("failed to parse the %s in %s/%s: %v",
+			kubeadmClusterConfigurationKey, kubeadmConfigNamespace, kubeadmConfigConfigMapName, err)
+		return nil, nil
+	}
+
+	endpoint := clusterConfiguration.ControlPlaneEndpoint
+	if len(endpoint) == 0 {
+		return nil, nil
+	}
+	// the controlPlaneEndpoint is host with an optional port, and the default port of kubeadm is 6443
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		endpoint = net.JoinHostPort(endpoint, "6443")
+	}
+
+	return &discoveredKubeAPIServer{
+		url:    (&url.URL{Scheme: "https", Host: endpoint}).String(),
+		source: fmt.Sprintf("configmap %s/%s", kubeadmConfigNamespace, kubeadmConfigConfigMapName),
+	}, nil
+}
+
+// discoverFromService reads the load balancer address of the Service that has the HubKubeAPIServerLabel in
+// the pod namespace. The ca data is read from the HubKubeAPIServerCAConfigMapAnnotation of the Service.
+func discoverFromService(ctx context.Context, kubeClient kubernetes.Interface) (*discoveredKubeAPIServer, error) {
+	ns := os.Getenv(constants.PodNamespaceEnvVarName)
+	services, err := kubeClient.CoreV1().Services(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: constants.HubKubeAPIServerLabel + "=true",
+	})
+	if apierrors.IsForbidden(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(services.Items, func(i, j int) bool { return services.Items[i].Name < services.Items[j].Name })
+	for _, svc := range services.Items {
+		if len(svc.Spec.Ports) == 0 {
+			continue
+		}
+
+		var host string
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if len(ingress.Hostname) > 0 {
+				host = ingress.Hostname
+				break
+			}
+			if len(ingress.IP) > 0 {
+				host = ingress.IP
+				break
+			}
+		}
+		if len(host) == 0 {
+			continue
+		}
+
+		caData, err := getDiscoveryCAData(ctx, kubeClient, ns, svc.Annotations)
+		if err != nil {
+			return nil, err
+		}
+
+		return &discoveredKubeAPIServer{
+			url: (&url.URL{
+				Scheme: "https",
+				Host:   net.JoinHostPort(host, strconv.Itoa(int(getServicePort(svc)))),
+			}).String(),
+			caData: caData,
+			source: fmt.Sprintf("service %s/%s", ns, svc.Name),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// getServicePort returns the port named https of the Service, or the first port if it is not found.
+func getServicePort(svc corev1.Service) int32 {
+	for _, port := range svc.Spec.Ports {
+		if port.Name == "https" {
+			return port.Port
+		}
+	}
+	return svc.Spec.Ports[0].Port
+}
+
+// discoverFromIngress reads the host of the Ingress that has the HubKubeAPIServerLabel in the pod namespace.
+// The ca data is read from the HubKubeAPIServerCAConfigMapAnnotation of the Ingress, or the ca.crt of the
+// tls secret of the host.
+func discoverFromIngress(ctx context.Context, kubeClient kubernetes.Interface) (*discoveredKubeAPIServer, error) {
+	ns := os.Getenv(constants.PodNamespaceEnvVarName)
+	ingresses, err := kubeClient.NetworkingV1().Ingresses(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: constants.HubKubeAPIServerLabel + "=true",
+	})
+	if apierrors.IsForbidden(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ingresses.Items, func(i, j int) bool { return ingresses.Items[i].Name < ingresses.Items[j].Name })
+	for _, ingress := range ingresses.Items {
+		for _, rule := range ingress.Spec.Rules {
+			if len(rule.Host) == 0 {
+				continue
+			}
+
+			caData, err := getDiscoveryCAData(ctx, kubeClient, ns, ingress.Annotations)
+			if err != nil {
+				return nil, err
+			}
+			if len(caData) == 0 {
+				caData, err = getIngressTLSCAData(ctx, kubeClient, ingress, rule.Host)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return &discoveredKubeAPIServer{
+				url:    (&url.URL{Scheme: "https", Host: rule.Host}).String(),
+				caData: caData,
+				source: fmt.Sprintf("ingress %s/%s", ns, ingress.Name),
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func getIngressTLSCAData(ctx context.Context, kubeClient kubernetes.Interface,
+	ingress networkingv1.Ingress, host string) ([]byte, error) {
+	for _, tls := range ingress.Spec.TLS {
+		if len(tls.SecretName) == 0 {
+			continue
+		}
+		for _, h := range tls.Hosts {
+			if h != host {
+				continue
+			}
+			secret, err := kubeClient.CoreV1().Secrets(ingress.Namespace).Get(ctx, tls.SecretName, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return secret.Data["ca.crt"], nil
+		}
+	}
+	return nil, nil
+}
+
+// getDiscoveryCAData returns the ca data in the configmap specified by the HubKubeAPIServerCAConfigMapAnnotation.
+func getDiscoveryCAData(ctx context.Context, kubeClient kubernetes.Interface, ns string,
+	annotations map[string]string) ([]byte, error) {
+	name := annotations[constants.HubKubeAPIServerCAConfigMapAnnotation]
+	if len(name) == 0 {
+		return nil, nil
+	}
+
+	cm, err := kubeClient.CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"ca-bundle.crt", "ca.crt", "tls.crt"} {
+		if data, ok := cm.Data[key]; ok {
+			return []byte(data), nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to find ca data in configmap %s/%s", ns, name)
+}
@@ -0,0 +1,234 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	testinghelpers "github.com/stolostron/managedcluster-import-controller/pkg/helpers/testing"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDiscoverKubeAPIServer(t *testing.T) {
+	certData1, _, _ := testinghelpers.NewRootCA("test ca1")
+	certData2, _, _ := testinghelpers.NewRootCA("test ca2")
+
+	t.Setenv(constants.PodNamespaceEnvVarName, "open-cluster-management")
+	defer func(deployOnOCP bool) { helpers.DeployOnOCP = deployOnOCP }(helpers.DeployOnOCP)
+	helpers.DeployOnOCP = false
+
+	clusterInfo := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-info", Namespace: "kube-public"},
+		Data: map[string]string{
+			"kubeconfig": `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority-data: ` + base64.StdEncoding.EncodeToString(certData1) + `
+    server: https://kind-control-plane:6443
+  name: ""
+`,
+		},
+	}
+	kubeadmConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeadm-config", Namespace: "kube-system"},
+		Data: map[string]string{
+			"ClusterConfiguration": "apiVersion: kubeadm.k8s.io/v1beta3\ncontrolPlaneEndpoint: api.example.com\n",
+		},
+	}
+	kubeRootCA := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "cluster1"},
+		Data:       map[string]string{"ca.crt": string(certData2)},
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hub-apiserver",
+			Namespace: "open-cluster-management",
+			Labels:    map[string]string{constants.HubKubeAPIServerLabel: "true"},
+			Annotations: map[string]string{
+				constants.HubKubeAPIServerCAConfigMapAnnotation: "hub-apiserver-ca",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "metrics", Port: 8443}, {Name: "https", Port: 443}},
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}},
+			},
+		},
+	}
+	serviceCA := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "hub-apiserver-ca", Namespace: "open-cluster-management"},
+		Data:       map[string]string{"ca.crt": string(certData2)},
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hub-apiserver",
+			Namespace: "open-cluster-management",
+			Labels:    map[string]string{constants.HubKubeAPIServerLabel: "true"},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{Host: "api.hub.example.com"}},
+			TLS:   []networkingv1.IngressTLS{{Hosts: []string{"api.hub.example.com"}, SecretName: "hub-apiserver-tls"}},
+		},
+	}
+	ingressTLS := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hub-apiserver-tls", Namespace: "open-cluster-management"},
+		Data:       map[string][]byte{"ca.crt": certData1},
+	}
+
+	cases := []struct {
+		name       string
+		objs       []runtime.Object
+		wantURL    string
+		wantCAData []byte
+		wantErr    bool
+	}{
+		{
+			name:    "nothing found",
+			objs:    []runtime.Object{kubeRootCA},
+			wantErr: true,
+		},
+		{
+			name:       "cluster-info",
+			objs:       []runtime.Object{clusterInfo, kubeadmConfig, kubeRootCA},
+			wantURL:    "https://kind-control-plane:6443",
+			wantCAData: certData1,
+		},
+		{
+			name:       "kubeadm-config",
+			objs:       []runtime.Object{kubeadmConfig, kubeRootCA},
+			wantURL:    "https://api.example.com:6443",
+			wantCAData: certData2,
+		},
+		{
+			name:       "service",
+			objs:       []runtime.Object{clusterInfo, service, serviceCA, kubeRootCA},
+			wantURL:    "https://lb.example.com:443",
+			wantCAData: certData2,
+		},
+		{
+			name:       "service ca configmap missing",
+			objs:       []runtime.Object{clusterInfo, service, kubeRootCA},
+			wantURL:    "https://kind-control-plane:6443",
+			wantCAData: certData1,
+		},
+		{
+			name:       "ingress",
+			objs:       []runtime.Object{clusterInfo, ingress, ingressTLS, kubeRootCA},
+			wantURL:    "https://api.hub.example.com",
+			wantCAData: certData1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientHolder := &helpers.ClientHolder{
+				KubeClient:    kubefake.NewSimpleClientset(c.objs...),
+				RuntimeClient: fake.NewClientBuilder().WithScheme(testscheme).Build(),
+			}
+
+			url, err := GetKubeAPIServerAddress(context.TODO(), clientHolder, nil)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("expected error %v, but got %v", c.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if url != c.wantURL {
+				t.Errorf("expected url %s, but got %s", c.wantURL, url)
+			}
+
+			caData, err := GetBootstrapCAData(context.TODO(), clientHolder, url, "cluster1", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(caData, c.wantCAData) {
+				t.Errorf("expected ca data %s, but got %s", string(c.wantCAData), string(caData))
+			}
+		})
+	}
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	t.Setenv(constants.PodNamespaceEnvVarName, "open-cluster-management")
+
+	kubeadmConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeadm-config", Namespace: "kube-system"},
+		Data: map[string]string{
+			"ClusterConfiguration": "controlPlaneEndpoint: api.example.com\n",
+		},
+	}
+	kubeClient := kubefake.NewSimpleClientset(kubeadmConfig)
+
+	discovered := discoverKubeAPIServer(context.TODO(), kubeClient)
+	if discovered == nil || discovered.url != "https://api.example.com:6443" {
+		t.Fatalf("unexpected discovered kube apiserver %v", discovered)
+	}
+
+	// the discovered kube apiserver is cached, the resources are not read again
+	kubeClient.ClearActions()
+	discoverKubeAPIServer(context.TODO(), kubeClient)
+	discoverKubeAPIServerCAData(context.TODO(), kubeClient, discovered.url)
+	if actions := kubeClient.Actions(); len(actions) != 0 {
+		t.Errorf("expected no actions, but got %v", actions)
+	}
+
+	// the cache is not shared with another client
+	if discovered := discoverKubeAPIServer(context.TODO(), kubefake.NewSimpleClientset()); discovered != nil {
+		t.Errorf("expected nothing discovered, but got %v", discovered)
+	}
+	if ttl := time.Until(kubeAPIServerDiscoveryCache.expiry); ttl <= discoveryFailureCacheTTL {
+		t.Errorf("expected the result to be cached for %v, but got %v", discoveryCacheTTL, ttl)
+	}
+
+	// a failed discoverer is skipped and the result is cached for a shorter time
+	failedClient := kubefake.NewSimpleClientset(kubeadmConfig)
+	failedClient.PrependReactor("list", "services", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("internal error")
+	})
+	discovered = discoverKubeAPIServer(context.TODO(), failedClient)
+	if discovered == nil || discovered.url != "https://api.example.com:6443" {
+		t.Fatalf("unexpected discovered kube apiserver %v", discovered)
+	}
+	if ttl := time.Until(kubeAPIServerDiscoveryCache.expiry); ttl > discoveryFailureCacheTTL {
+		t.Errorf("expected the result to be cached for %v, but got %v", discoveryFailureCacheTTL, ttl)
+	}
+	failedClient.ClearActions()
+	discoverKubeAPIServer(context.TODO(), failedClient)
+	if actions := failedClient.Actions(); len(actions) != 0 {
+		t.Errorf("expected no actions, but got %v", actions)
+	}
+}
+
+func TestIsSameKubeAPIServer(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{a: "https://api.example.com", b: "https://api.example.com:443", expected: true},
+		{a: "https://API.example.com:6443", b: "https://api.example.com:6443", expected: true},
+		{a: "https://api.example.com:6443", b: "https://api.example.com", expected: false},
+		{a: "https://api.example.com", b: "https://lb.example.com", expected: false},
+	}
+
+	for _, c := range cases {
+		if actual := isSameKubeAPIServer(c.a, c.b); actual != c.expected {
+			t.Errorf("expected %v for %s and %s, but got %v", c.expected, c.a, c.b, actual)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	ocinfrav1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers/imageregistry"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// deriveImageMirrorsEnabled returns true if the klusterletConfig enables to derive the image mirrors of the
+// klusterlet agent images from the ImageDigestMirrorSets and ImageContentSourcePolicies on the hub.
+func deriveImageMirrorsEnabled(klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) bool {
+	if klusterletConfig == nil {
+		return false
+	}
+	return strings.EqualFold(klusterletConfig.Annotations[constants.KlusterletConfigDeriveImageMirrorsAnnotation], "true")
+}
+
+// hasAnnotationRegistries returns true if the registries are set in the image-registries annotation of the
+// managed cluster.
+func hasAnnotationRegistries(clusterAnnotations map[string]string) bool {
+	value, ok := clusterAnnotations[imageregistry.ClusterImageRegistriesAnnotation]
+	if !ok {
+		return false
+	}
+
+	imageRegistries := imageregistry.ImageRegistries{}
+	if err := json.Unmarshal([]byte(value), &imageRegistries); err != nil {
+		// the invalid annotation is reported when the images are overridden by it
+		return true
+	}
+	return len(imageRegistries.Registries) > 0
+}
+
+// getHubImageMirrors derives the registries from the ImageDigestMirrorSets and ImageContentSourcePolicies on
+// the hub, the first mirror of a source is used. If a source is defined more than once, the one in the object
+// with the smaller name wins and the ImageDigestMirrorSets take precedence over the ImageContentSourcePolicies.
+// The registries are sorted by the length of the source, so the most specific source wins when overriding.
+func getHubImageMirrors(ctx context.Context, runtimeClient client.Client) ([]klusterletconfigv1alpha1.Registries, error) {
+	mirrors := map[string]string{}
+
+	idmsList := &ocinfrav1.ImageDigestMirrorSetList{}
+	if err := runtimeClient.List(ctx, idmsList); err != nil && !isAPINotAvailable(err) {
+		return nil, err
+	}
+	sort.Slice(idmsList.Items, func(i, j int) bool { return idmsList.Items[i].Name < idmsList.Items[j].Name })
+	for _, idms := range idmsList.Items {
+		for _, m := range idms.Spec.ImageDigestMirrors {
+			if _, ok := mirrors[m.Source]; ok || len(m.Mirrors) == 0 {
+				continue
+			}
+			mirrors[m.Source] = string(m.Mirrors[0])
+		}
+	}
+
+	icspList := &operatorv1alpha1.ImageContentSourcePolicyList{}
+	if err := runtimeClient.List(ctx, icspList); err != nil && !isAPINotAvailable(err) {
+		return nil, err
+	}
+	sort.Slice(icspList.Items, func(i, j int) bool { return icspList.Items[i].Name < icspList.Items[j].Name })
+	for _, icsp := range icspList.Items {
+		for _, m := range icsp.Spec.RepositoryDigestMirrors {
+			if _, ok := mirrors[m.Source]; ok || len(m.Mirrors) == 0 {
+				continue
+			}
+			mirrors[m.Source] = m.Mirrors[0]
+		}
+	}
+
+	registries := []klusterletconfigv1alpha1.Registries{}
+	for source, mirror := range mirrors {
+		if len(source) == 0 {
+			// an empty source replaces all registries, it is not a valid mirror on the hub
+			continue
+		}
+		registries = append(registries, klusterletconfigv1alpha1.Registries{Source: source, Mirror: mirror})
+	}
+	sort.Slice(registries, func(i, j int) bool {
+		if len(registries[i].Source) != len(registries[j].Source) {
+			return len(registries[i].Source) < len(registries[j].Source)
+		}
+		return registries[i].Source < registries[j].Source
+	})
+
+	klog.V(4).Infof("Derived the image mirrors from the hub: %v", registries)
+	return registries, nil
+}
+
+// isAPINotAvailable returns true if the api of the image mirrors is not installed on the hub, e.g. on the
+// non-OCP hub cluster.
+func isAPINotAvailable(err error) bool {
+	return meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err)
+}
@@ -0,0 +1,141 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	ocinfrav1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers/imageregistry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetHubImageMirrors(t *testing.T) {
+	mirrorScheme := runtime.NewScheme()
+	if err := ocinfrav1.AddToScheme(mirrorScheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := operatorv1alpha1.AddToScheme(mirrorScheme); err != nil {
+		t.Fatal(err)
+	}
+
+	idms1 := &ocinfrav1.ImageDigestMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "a"},
+		Spec: ocinfrav1.ImageDigestMirrorSetSpec{
+			ImageDigestMirrors: []ocinfrav1.ImageDigestMirrors{
+				{Source: "quay.io/stolostron", Mirrors: []ocinfrav1.ImageMirror{"mirror.io/stolostron", "backup.io/stolostron"}},
+				{Source: "quay.io", Mirrors: []ocinfrav1.ImageMirror{"mirror.io"}},
+				{Source: "registry.redhat.io"},
+			},
+		},
+	}
+	idms2 := &ocinfrav1.ImageDigestMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "b"},
+		Spec: ocinfrav1.ImageDigestMirrorSetSpec{
+			ImageDigestMirrors: []ocinfrav1.ImageDigestMirrors{
+				{Source: "quay.io/stolostron", Mirrors: []ocinfrav1.ImageMirror{"other.io/stolostron"}},
+			},
+		},
+	}
+	icsp := &operatorv1alpha1.ImageContentSourcePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "a"},
+		Spec: operatorv1alpha1.ImageContentSourcePolicySpec{
+			RepositoryDigestMirrors: []operatorv1alpha1.RepositoryDigestMirrors{
+				{Source: "quay.io", Mirrors: []string{"icsp.io"}},
+				{Source: "registry.redhat.io/rhacm2", Mirrors: []string{"mirror.io/rhacm2"}},
+			},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		client   client.Client
+		expected []klusterletconfigv1alpha1.Registries
+	}{
+		{
+			name:     "api is not available",
+			client:   fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build(),
+			expected: []klusterletconfigv1alpha1.Registries{},
+		},
+		{
+			name:   "mirrors on the hub",
+			client: fake.NewClientBuilder().WithScheme(mirrorScheme).WithObjects(idms2, idms1, icsp).Build(),
+			expected: []klusterletconfigv1alpha1.Registries{
+				{Source: "quay.io", Mirror: "mirror.io"},
+				{Source: "quay.io/stolostron", Mirror: "mirror.io/stolostron"},
+				{Source: "registry.redhat.io/rhacm2", Mirror: "mirror.io/rhacm2"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			registries, err := getHubImageMirrors(context.TODO(), c.client)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(registries, c.expected) {
+				t.Errorf("expected %v, but got %v", c.expected, registries)
+			}
+
+			// the most specific source wins
+			images, err := getKlusterletAgentImagesWithDefaults(t, registries)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.expected) > 0 && images[constants.RegistrationOperatorImageEnvVarName] != "mirror.io/stolostron/registration-operator:latest" {
+				t.Errorf("unexpected image %s", images[constants.RegistrationOperatorImageEnvVarName])
+			}
+		})
+	}
+}
+
+func getKlusterletAgentImagesWithDefaults(t *testing.T,
+	registries []klusterletconfigv1alpha1.Registries) (map[string]string, error) {
+	return getKlusterletAgentImages(&KlusterletManifestsDefaults{
+		RegistrationOperatorImage: "quay.io/stolostron/registration-operator:latest",
+		RegistrationImage:         "quay.io/stolostron/registration:latest",
+		WorkImage:                 "quay.io/stolostron/work:latest",
+	}, registries, nil)
+}
+
+func TestHasAnnotationRegistries(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name: "pull secret only",
+			annotations: map[string]string{
+				imageregistry.ClusterImageRegistriesAnnotation: `{"pullSecret":"ns.secret"}`,
+			},
+		},
+		{
+			name: "registries",
+			annotations: map[string]string{
+				imageregistry.ClusterImageRegistriesAnnotation: `{"registries":[{"source":"quay.io","mirror":"mirror.io"}]}`,
+			},
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := hasAnnotationRegistries(c.annotations); actual != c.expected {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
@@ -5,45 +5,177 @@ package bootstrap
 
 import (
 	"context"
-	"os"
+	"encoding/json"
+	"fmt"
+	"strings"
 
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 )
 
 const EmptyImagePullSecret = "empty-image-pull-secret"
 
-// getImagePullSecret get image pull secret from env
+// MergedImagePullSecret is the name of the image pull secret merged from multiple image pull secrets
+const MergedImagePullSecret = "merged-image-pull-secret"
+
+// getImagePullSecret returns the image pull secret for the klusterlet. By default, the pullSecret of the
+// klusterletConfig is used if it is set, otherwise the pullSecret in the image-registries annotation of the
+// managed cluster is used if it is set, otherwise the default image pull secret is used.
+// If the klusterletConfig has additional image pull secrets in the KlusterletConfigPullSecretsAnnotation, the
+// default image pull secret, the pullSecret in the image-registries annotation, the pullSecret of the
+// klusterletConfig and the additional image pull secrets are merged into one instead, and the latter one
+// overrides the former ones for the same registry.
 func getImagePullSecret(ctx context.Context, clientHolder *helpers.ClientHolder,
-	kcImagePullSecret corev1.ObjectReference, clusterAnnotations map[string]string) (*corev1.Secret, error) {
-	if kcImagePullSecret.Name != "" {
-		secret, err := clientHolder.KubeClient.CoreV1().Secrets(kcImagePullSecret.Namespace).Get(ctx, kcImagePullSecret.Name, metav1.GetOptions{})
+	defaults *KlusterletManifestsDefaults, kcImagePullSecret corev1.ObjectReference, kcAdditionalImagePullSecrets []corev1.ObjectReference,
+	clusterAnnotations map[string]string) (*corev1.Secret, error) {
+	if len(kcAdditionalImagePullSecrets) == 0 {
+		if kcImagePullSecret.Name != "" {
+			return clientHolder.KubeClient.CoreV1().Secrets(kcImagePullSecret.Namespace).Get(ctx,
+				kcImagePullSecret.Name, metav1.GetOptions{})
+		}
+
+		secret, err := clientHolder.ImageRegistryClient.Cluster(clusterAnnotations).PullSecret()
 		if err != nil {
 			return nil, err
 		}
-		return secret, nil
+		if secret != nil {
+			return secret, nil
+		}
+
+		return getDefaultImagePullSecret(ctx, clientHolder, defaults)
 	}
 
-	secret, err := clientHolder.ImageRegistryClient.Cluster(clusterAnnotations).PullSecret()
+	var secrets []*corev1.Secret
+
+	annotationSecret, err := clientHolder.ImageRegistryClient.Cluster(clusterAnnotations).PullSecret()
 	if err != nil {
 		return nil, err
 	}
-	if secret != nil {
-		return secret, nil
+
+	// the default image pull secret is the base of the others, it is optional when merging
+	defaultSecret, err := getDefaultImagePullSecret(ctx, clientHolder, defaults)
+	switch {
+	case errors.IsNotFound(err):
+		klog.Warningf("the default image pull secret is not found, ignore it: %v", err)
+	case err != nil:
+		return nil, err
+	case defaultSecret.Name != EmptyImagePullSecret:
+		secrets = append(secrets, defaultSecret)
+	}
+
+	if annotationSecret != nil {
+		secrets = append(secrets, annotationSecret)
+	}
+
+	refs := kcAdditionalImagePullSecrets
+	if kcImagePullSecret.Name != "" {
+		refs = append([]corev1.ObjectReference{kcImagePullSecret}, refs...)
+	}
+	for _, ref := range refs {
+		secret, err := clientHolder.KubeClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	if len(secrets) == 1 {
+		return secrets[0], nil
+	}
+
+	return mergeImagePullSecrets(secrets...)
+}
+
+// getKlusterletConfigImagePullSecrets returns the additional image pull secrets in the
+// KlusterletConfigPullSecretsAnnotation of the klusterletConfig in order.
+func getKlusterletConfigImagePullSecrets(
+	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) ([]corev1.ObjectReference, error) {
+	if klusterletConfig == nil {
+		return nil, nil
+	}
+
+	var refs []corev1.ObjectReference
+	value := klusterletConfig.Annotations[constants.KlusterletConfigPullSecretsAnnotation]
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		segs := strings.Split(item, "/")
+		if len(segs) != 2 || len(segs[0]) == 0 || len(segs[1]) == 0 {
+			return nil, fmt.Errorf("wrong pull secret format %q in the annotation %s, namespace/name is required",
+				item, constants.KlusterletConfigPullSecretsAnnotation)
+		}
+		refs = append(refs, corev1.ObjectReference{Namespace: segs[0], Name: segs[1]})
+	}
+
+	return refs, nil
+}
+
+// mergeImagePullSecrets merges the auths of the image pull secrets into one dockerconfigjson secret, the
+// latter secret overrides the former ones for the same registry. Both the dockerconfigjson and the legacy
+// dockercfg secrets are supported.
+func mergeImagePullSecrets(secrets ...*corev1.Secret) (*corev1.Secret, error) {
+	auths := map[string]json.RawMessage{}
+	for _, secret := range secrets {
+		secretAuths := map[string]json.RawMessage{}
+		if data, ok := secret.Data[corev1.DockerConfigJsonKey]; ok {
+			dockerConfigJSON := struct {
+				Auths map[string]json.RawMessage `json:"auths"`
+			}{}
+			if err := json.Unmarshal(data, &dockerConfigJSON); err != nil {
+				return nil, fmt.Errorf("failed to parse the image pull secret %s/%s: %v",
+					secret.Namespace, secret.Name, err)
+			}
+			secretAuths = dockerConfigJSON.Auths
+		} else if data, ok := secret.Data[corev1.DockerConfigKey]; ok {
+			if err := json.Unmarshal(data, &secretAuths); err != nil {
+				return nil, fmt.Errorf("failed to parse the image pull secret %s/%s: %v",
+					secret.Namespace, secret.Name, err)
+			}
+		} else {
+			return nil, fmt.Errorf("no docker config found in the image pull secret %s/%s",
+				secret.Namespace, secret.Name)
+		}
+
+		for registry, auth := range secretAuths {
+			if _, ok := auths[registry]; ok {
+				klog.V(4).Infof("the auth of registry %s is overridden by the image pull secret %s/%s",
+					registry, secret.Namespace, secret.Name)
+			}
+			auths[registry] = auth
+		}
+	}
+
+	// the keys of a map are sorted when marshaling, so the data is deterministic
+	data, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err != nil {
+		return nil, err
 	}
 
-	return getDefaultImagePullSecret(ctx, clientHolder)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: MergedImagePullSecret,
+		},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: data,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}, nil
 }
 
-func getDefaultImagePullSecret(ctx context.Context, clientHolder *helpers.ClientHolder) (*corev1.Secret, error) {
+func getDefaultImagePullSecret(ctx context.Context, clientHolder *helpers.ClientHolder,
+	defaults *KlusterletManifestsDefaults) (*corev1.Secret, error) {
 	var err error
 	var secret *corev1.Secret
 
-	defaultSecretName := os.Getenv(constants.DefaultImagePullSecretEnvVarName)
-	if defaultSecretName == "" {
+	if defaults.ImagePullSecret == "" {
 		// If default secret can't be found from env DEFAULT_IMAGE_PULL_SECRET, create an empty image pull secret
 		secret = &corev1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
@@ -55,8 +187,8 @@ func getDefaultImagePullSecret(ctx context.Context, clientHolder *helpers.Client
 			Type: corev1.SecretTypeDockerConfigJson,
 		}
 	} else {
-		ns := os.Getenv(constants.PodNamespaceEnvVarName)
-		secret, err = clientHolder.KubeClient.CoreV1().Secrets(ns).Get(ctx, defaultSecretName, metav1.GetOptions{})
+		secret, err = clientHolder.KubeClient.CoreV1().Secrets(defaults.Namespace).Get(ctx, defaults.ImagePullSecret,
+			metav1.GetOptions{})
 		if err != nil {
 			return nil, err
 		}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"testing"
 
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers/imageregistry"
@@ -150,7 +151,9 @@ func TestGetImagePullSecret(t *testing.T) {
 				ImageRegistryClient: imageregistry.NewClient(kubeClient),
 			}
 
-			secret, err := getImagePullSecret(context.Background(), clientHolder, c.klusterletconfigImagePullSecret, c.managedCluster.Annotations)
+			secret, err := getImagePullSecret(context.Background(), clientHolder,
+				KlusterletManifestsDefaultsFromEnv(), c.klusterletconfigImagePullSecret, nil,
+				c.managedCluster.Annotations)
 			if err != nil {
 				t.Errorf("unexpected error: %v", err)
 			}
@@ -160,3 +163,98 @@ func TestGetImagePullSecret(t *testing.T) {
 		})
 	}
 }
+
+func TestGetMergedImagePullSecret(t *testing.T) {
+	defaultSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-pull-secret", Namespace: "open-cluster-management"},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"auth":"default"},"registry.io":{"auth":"default"}}}`),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+	annotationSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "annotation-pull-secret", Namespace: "ns1"},
+		Data: map[string][]byte{
+			corev1.DockerConfigKey: []byte(`{"quay.io":{"auth":"annotation"}}`),
+		},
+		Type: corev1.SecretTypeDockercfg,
+	}
+	kcSecret1 := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kc-pull-secret1", Namespace: "ns2"},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"mirror.io":{"auth":"kc1"},"registry.io":{"auth":"kc1"}}}`),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+	kcSecret2 := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kc-pull-secret2", Namespace: "ns2"},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"mirror.io":{"auth":"kc2"}}}`),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+
+	t.Setenv(constants.PodNamespaceEnvVarName, "open-cluster-management")
+	t.Setenv(constants.DefaultImagePullSecretEnvVarName, "default-pull-secret")
+
+	kubeClient := kubefake.NewSimpleClientset(defaultSecret, annotationSecret, kcSecret1, kcSecret2)
+	clientHolder := &helpers.ClientHolder{
+		KubeClient:          kubeClient,
+		ImageRegistryClient: imageregistry.NewClient(kubeClient),
+	}
+
+	klusterletConfig := &klusterletconfigv1alpha1.KlusterletConfig{
+		Spec: klusterletconfigv1alpha1.KlusterletConfigSpec{
+			PullSecret: corev1.ObjectReference{Namespace: "ns2", Name: "kc-pull-secret1"},
+		},
+	}
+	annotations := map[string]string{
+		imageregistry.ClusterImageRegistriesAnnotation: `{"pullSecret":"ns1.annotation-pull-secret"}`,
+	}
+
+	// without the pull-secrets annotation, the pullSecret of the klusterletConfig replaces the others
+	kcImagePullSecrets, err := getKlusterletConfigImagePullSecrets(klusterletConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret, err := getImagePullSecret(context.Background(), clientHolder, KlusterletManifestsDefaultsFromEnv(),
+		klusterletConfig.Spec.PullSecret, kcImagePullSecrets, annotations)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret.Name != kcSecret1.Name {
+		t.Errorf("expected secret %s, but got %s", kcSecret1.Name, secret.Name)
+	}
+
+	klusterletConfig.Annotations = map[string]string{
+		constants.KlusterletConfigPullSecretsAnnotation: "ns2/kc-pull-secret2",
+	}
+	kcImagePullSecrets, err = getKlusterletConfigImagePullSecrets(klusterletConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret, err = getImagePullSecret(context.Background(), clientHolder, KlusterletManifestsDefaultsFromEnv(),
+		klusterletConfig.Spec.PullSecret, kcImagePullSecrets, annotations)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if secret.Name != MergedImagePullSecret {
+		t.Errorf("expected secret %s, but got %s", MergedImagePullSecret, secret.Name)
+	}
+	expected := `{"auths":{"mirror.io":{"auth":"kc2"},"quay.io":{"auth":"annotation"},"registry.io":{"auth":"kc1"}}}`
+	if string(secret.Data[corev1.DockerConfigJsonKey]) != expected {
+		t.Errorf("expected %s, but got %s", expected, string(secret.Data[corev1.DockerConfigJsonKey]))
+	}
+
+	if _, err := getKlusterletConfigImagePullSecrets(&klusterletconfigv1alpha1.KlusterletConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				constants.KlusterletConfigPullSecretsAnnotation: "ns2.kc-pull-secret2",
+			},
+		},
+	}); err == nil {
+		t.Errorf("expected error for the wrong format")
+	}
+}
@@ -44,6 +44,11 @@ var hubFiles = []string{
 	"manifests/hub/managedcluster-clusterrolebinding.yaml",
 }
 
+var agentRegistrationHubFiles = []string{
+	"manifests/hub/agentregistration-service-account.yaml",
+	"manifests/hub/agentregistration-clusterrolebinding.yaml",
+}
+
 var additionalClusterRoleFiles = []string{
 	"manifests/klusterlet/clusterrole_bootstrap.yaml",
 	"manifests/klusterlet/clusterrole_aggregate.yaml",
@@ -64,10 +69,44 @@ type BootstrapKubeConfigSecret struct {
 	KubeConfig string
 }
 
+// KlusterletManifestsDefaults is the hub configuration that the klusterlet manifests are rendered with when
+// they are not overridden by the klusterletConfig or the managed cluster.
+type KlusterletManifestsDefaults struct {
+	// Namespace is the namespace of the default image pull secret and the bootstrap kubeconfig secrets of the
+	// klusterletConfig.
+	Namespace string
+	// ImagePullSecret is the name of the default image pull secret, an empty image pull secret is used if it is
+	// not set.
+	ImagePullSecret string
+
+	RegistrationOperatorImage string
+	RegistrationImage         string
+	WorkImage                 string
+}
+
+// KlusterletManifestsDefaultsFromEnv returns the KlusterletManifestsDefaults from the environment variables of
+// the import controller.
+func KlusterletManifestsDefaultsFromEnv() *KlusterletManifestsDefaults {
+	return &KlusterletManifestsDefaults{
+		Namespace:                 os.Getenv(constants.PodNamespaceEnvVarName),
+		ImagePullSecret:           os.Getenv(constants.DefaultImagePullSecretEnvVarName),
+		RegistrationOperatorImage: os.Getenv(constants.RegistrationOperatorImageEnvVarName),
+		RegistrationImage:         os.Getenv(constants.RegistrationImageEnvVarName),
+		WorkImage:                 os.Getenv(constants.WorkImageEnvVarName),
+	}
+}
+
 type KlusterletManifestsConfig struct {
-	chartConfig      *chart.KlusterletChartConfig
-	managedCluster   *clusterv1.ManagedCluster
-	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig
+	chartConfig                *chart.KlusterletChartConfig
+	managedCluster             *clusterv1.ManagedCluster
+	klusterletConfig           *klusterletconfigv1alpha1.KlusterletConfig
+	bootstrapKubeConfigSecrets []BootstrapKubeConfigSecret
+
+	// defaults is read from the environment variables by Generate if it is not set
+	defaults *KlusterletManifestsDefaults
+
+	// agentImages is the klusterlet agent images resolved by the last Generate
+	agentImages map[string]string
 }
 
 func NewKlusterletManifestsConfig(installMode operatorv1.InstallMode,
@@ -156,28 +195,59 @@ func (c *KlusterletManifestsConfig) WithKlusterletConfig(klusterletConfig *klust
 	return c
 }
 
+// WithBootstrapKubeConfigSecrets sets the ordered bootstrap kubeconfig secrets for the hub kube apiserver
+// endpoints, the klusterlet fails over among them with the MultipleHubs feature. It is ignored when the
+// MultipleHubsConfig is set in the klusterletConfig or there are less than two secrets.
+func (c *KlusterletManifestsConfig) WithBootstrapKubeConfigSecrets(
+	secrets ...BootstrapKubeConfigSecret) *KlusterletManifestsConfig {
+	c.bootstrapKubeConfigSecrets = secrets
+	return c
+}
+
 func (c *KlusterletManifestsConfig) WithoutImagePullSecretGenerate() *KlusterletManifestsConfig {
 	c.chartConfig.Images.ImageCredentials.CreateImageCredentials = false
 	return c
 }
 
+// WithDefaults sets the hub configuration to render the manifests with instead of the environment variables.
+func (c *KlusterletManifestsConfig) WithDefaults(defaults *KlusterletManifestsDefaults) *KlusterletManifestsConfig {
+	c.defaults = defaults
+	return c
+}
+
 func (c *KlusterletManifestsConfig) WithPriorityClassName(priorityClassName string) *KlusterletManifestsConfig {
 	c.chartConfig.PriorityClassName = priorityClassName
 	return c
 }
 
+// KlusterletNamespaceName returns the name and namespace of the klusterlet, they are resolved by Generate.
+func (c *KlusterletManifestsConfig) KlusterletNamespaceName() (string, string) {
+	return c.chartConfig.Klusterlet.Name, c.chartConfig.Klusterlet.Namespace
+}
+
+// NoOperator returns true if the klusterlet agents are installed without the operator, it is resolved by Generate.
+func (c *KlusterletManifestsConfig) NoOperator() bool {
+	return c.chartConfig.NoOperator
+}
+
 // Generate returns the rendered klusterlet manifests in bytes. return manifests, crd, values, error.
 func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 	clientHolder *helpers.ClientHolder) ([]byte, []byte, []byte, error) {
 	installMode := c.chartConfig.Klusterlet.Mode
 	clusterName := c.chartConfig.Klusterlet.ClusterName
+	defaults := c.defaults
+	if defaults == nil {
+		defaults = KlusterletManifestsDefaultsFromEnv()
+	}
 
 	// For image, image pull secret, nodePlacement, we use configurations in klusterletConfig over
 	// configurations in managed cluster annotations.
 	var kcRegistries []klusterletconfigv1alpha1.Registries
 	var kcNodePlacement *operatorv1.NodePlacement
 	var kcImagePullSecret corev1.ObjectReference
+	var kcAdditionalImagePullSecrets []corev1.ObjectReference
 	var appliedManifestWorkEvictionGracePeriod string
+	var err error
 
 	switch installMode {
 	case operatorv1.InstallModeHosted, operatorv1.InstallModeSingletonHosted:
@@ -187,6 +257,10 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 			kcRegistries = c.klusterletConfig.Spec.Registries
 			kcNodePlacement = c.klusterletConfig.Spec.NodePlacement
 			kcImagePullSecret = c.klusterletConfig.Spec.PullSecret
+			kcAdditionalImagePullSecrets, err = getKlusterletConfigImagePullSecrets(c.klusterletConfig)
+			if err != nil {
+				return nil, nil, nil, err
+			}
 			appliedManifestWorkEvictionGracePeriod = c.klusterletConfig.Spec.AppliedManifestWorkEvictionGracePeriod
 		}
 	default:
@@ -200,14 +274,27 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 		managedClusterAnnotations = c.managedCluster.GetAnnotations()
 	}
 
+	// The registries in the klusterletConfig and the managed cluster annotation take precedence over the
+	// image mirrors derived from the hub.
+	if len(kcRegistries) == 0 && installMode != operatorv1.InstallModeHosted &&
+		installMode != operatorv1.InstallModeSingletonHosted &&
+		deriveImageMirrorsEnabled(c.klusterletConfig) && !hasAnnotationRegistries(managedClusterAnnotations) {
+		hubImageMirrors, err := getHubImageMirrors(ctx, clientHolder.RuntimeClient)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to derive the image mirrors from the hub: %w", err)
+		}
+		kcRegistries = hubImageMirrors
+	}
+
 	// Images override
-	klusterletAgentImages, err := getKlusterletAgentImages(kcRegistries, managedClusterAnnotations)
+	klusterletAgentImages, err := getKlusterletAgentImages(defaults, kcRegistries, managedClusterAnnotations)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	c.chartConfig.Images.Overrides.OperatorImage = klusterletAgentImages[constants.RegistrationOperatorImageEnvVarName]
 	c.chartConfig.Images.Overrides.RegistrationImage = klusterletAgentImages[constants.RegistrationImageEnvVarName]
 	c.chartConfig.Images.Overrides.WorkImage = klusterletAgentImages[constants.WorkImageEnvVarName]
+	c.agentImages = klusterletAgentImages
 
 	// NodeSelector
 	var nodeSelector map[string]string
@@ -241,6 +328,11 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 	c.chartConfig.Tolerations = tolerations
 	c.chartConfig.Klusterlet.NodePlacement.Tolerations = tolerations
 
+	// Replicas and resources
+	if err := setKlusterletResources(c.chartConfig, c.klusterletConfig, managedClusterAnnotations); err != nil {
+		return nil, nil, nil, fmt.Errorf("get resources for cluster %s failed: %v", clusterName, err)
+	}
+
 	c.chartConfig.Klusterlet.Name, c.chartConfig.Klusterlet.Namespace = getKlusterletNamespaceName(
 		c.klusterletConfig, clusterName, managedClusterAnnotations, installMode)
 
@@ -263,7 +355,8 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 
 	// need to generate imagePullSecret
 	if c.chartConfig.Images.ImageCredentials.CreateImageCredentials {
-		imagePullSecret, err := getImagePullSecret(ctx, clientHolder, kcImagePullSecret, managedClusterAnnotations)
+		imagePullSecret, err := getImagePullSecret(ctx, clientHolder, defaults, kcImagePullSecret, kcAdditionalImagePullSecrets,
+			managedClusterAnnotations)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -307,21 +400,7 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 			return nil, nil, nil, fmt.Errorf("local secrets should be set")
 		}
 
-		// If MultipleHubs feature is not set in featureGates field, set it to enable.
-		var multipleHubFeatureSet bool
-		for _, f := range c.chartConfig.Klusterlet.RegistrationConfiguration.FeatureGates {
-			if f.Feature == string(apifeature.MultipleHubs) {
-				multipleHubFeatureSet = true
-			}
-		}
-
-		if !multipleHubFeatureSet {
-			c.chartConfig.Klusterlet.RegistrationConfiguration.FeatureGates = append(c.chartConfig.Klusterlet.RegistrationConfiguration.FeatureGates,
-				operatorv1.FeatureGate{
-					Feature: string(apifeature.MultipleHubs),
-					Mode:    operatorv1.FeatureGateModeTypeEnable,
-				})
-		}
+		enableMultipleHubsFeatureGate(c.chartConfig)
 		c.chartConfig.Klusterlet.RegistrationConfiguration.BootstrapKubeConfigs = *c.klusterletConfig.Spec.MultipleHubsConfig.BootstrapKubeConfigs.DeepCopy()
 
 		// Only append the current hub KubeConfigSecret if the strategy is IncludeCurrentHub
@@ -333,7 +412,7 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 				})
 		}
 
-		bootstrapKubeConfigSecrets, err := convertKubeConfigSecrets(ctx,
+		bootstrapKubeConfigSecrets, err := convertKubeConfigSecrets(ctx, defaults.Namespace,
 			c.klusterletConfig.Spec.MultipleHubsConfig.BootstrapKubeConfigs.LocalSecrets.KubeConfigSecrets, clientHolder.KubeClient)
 		if err != nil {
 			return nil, nil, nil, err
@@ -348,6 +427,25 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 			})
 		}
 		c.chartConfig.MultiHubBootstrapHubKubeConfigs = bootstrapKubeConfigSecrets
+	} else if !localCluster && len(c.bootstrapKubeConfigSecrets) > 1 {
+		// One bootstrap kubeconfig secret for each hub kube apiserver endpoint, the klusterlet connects to
+		// the hub with them in order.
+		enableMultipleHubsFeatureGate(c.chartConfig)
+		localSecrets := &operatorv1.LocalSecretsConfig{}
+		for _, s := range c.bootstrapKubeConfigSecrets {
+			localSecrets.KubeConfigSecrets = append(localSecrets.KubeConfigSecrets, operatorv1.KubeConfigSecret{
+				Name: s.Name,
+			})
+			c.chartConfig.MultiHubBootstrapHubKubeConfigs = append(c.chartConfig.MultiHubBootstrapHubKubeConfigs,
+				chart.BootStrapKubeConfig{
+					Name:       s.Name,
+					KubeConfig: s.KubeConfig,
+				})
+		}
+		c.chartConfig.Klusterlet.RegistrationConfiguration.BootstrapKubeConfigs = operatorv1.BootstrapKubeConfigs{
+			Type:         operatorv1.LocalSecrets,
+			LocalSecrets: localSecrets,
+		}
 	}
 
 	// Set MCE reserved clusterclaims
@@ -394,7 +492,20 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 	crdBytes := AggregateObjects(crds)
 	manifestsBytes := AggregateObjects(objects)
 
+	// The additional manifests in the klusterletConfig are rendered with the same config as the
+	// additionalClusterRoleFiles and appended to the end of the manifests.
+	additionalManifestRefs, err := getKlusterletConfigAdditionalManifests(c.klusterletConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	userManifestsBytes, err := renderAdditionalManifests(ctx, clientHolder.KubeClient,
+		additionalManifestRefs, c.chartConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	if c.chartConfig.NoOperator {
+		manifestsBytes = append(manifestsBytes, userManifestsBytes...)
 		return manifestsBytes, nil, valuesBytes, nil
 	}
 
@@ -403,9 +514,31 @@ func (c *KlusterletManifestsConfig) Generate(ctx context.Context,
 		return nil, nil, nil, err
 	}
 	manifestsBytes = append(manifestsBytes, additionalManifestsBytes...)
+	manifestsBytes = append(manifestsBytes, userManifestsBytes...)
 	return manifestsBytes, crdBytes, valuesBytes, nil
 }
 
+// enableMultipleHubsFeatureGate enables the MultipleHubs feature if it is not set in the featureGates field.
+func enableMultipleHubsFeatureGate(cc *chart.KlusterletChartConfig) {
+	for _, f := range cc.Klusterlet.RegistrationConfiguration.FeatureGates {
+		if f.Feature == string(apifeature.MultipleHubs) {
+			return
+		}
+	}
+
+	cc.Klusterlet.RegistrationConfiguration.FeatureGates = append(cc.Klusterlet.RegistrationConfiguration.FeatureGates,
+		operatorv1.FeatureGate{
+			Feature: string(apifeature.MultipleHubs),
+			Mode:    operatorv1.FeatureGateModeTypeEnable,
+		})
+}
+
+// GetEndpointBootstrapKubeConfigSecretName returns the name of the bootstrap kubeconfig secret for the hub kube
+// apiserver endpoint with the given index.
+func GetEndpointBootstrapKubeConfigSecretName(index int) string {
+	return fmt.Sprintf("%s-endpoint-%d", constants.DefaultBootstrapHubKubeConfigSecretName, index)
+}
+
 func setClusterClaimConfiguation(cc *chart.KlusterletChartConfig, kc *klusterletconfigv1alpha1.KlusterletConfig) {
 	defaultConfiguation := &operatorv1.ClusterClaimConfiguration{
 		ReservedClusterClaimSuffixes: reservedClusterClaimSuffixes,
@@ -418,11 +551,10 @@ func setClusterClaimConfiguation(cc *chart.KlusterletChartConfig, kc *klusterlet
 	cc.Klusterlet.RegistrationConfiguration.ClusterClaimConfiguration = defaultConfiguation
 }
 
-func convertKubeConfigSecrets(ctx context.Context,
+func convertKubeConfigSecrets(ctx context.Context, ns string,
 	kcs []operatorv1.KubeConfigSecret, kubeClient kubernetes.Interface) ([]chart.BootStrapKubeConfig, error) {
 	var bootstrapKubeConfigSecrets []chart.BootStrapKubeConfig
 	for _, s := range kcs {
-		ns := os.Getenv(constants.PodNamespaceEnvVarName)
 		secret, err := kubeClient.CoreV1().Secrets(ns).Get(ctx, s.Name, metav1.GetOptions{})
 		if err != nil {
 			return nil, err
@@ -454,6 +586,21 @@ func GenerateHubBootstrapRBACObjects(managedClusterName string) ([]runtime.Objec
 	}, &ManifestFiles)
 }
 
+// GenerateAgentRegistrationBootstrapRBACObjects returns the per-cluster bootstrap service account of an
+// agent-registration cluster in the given namespace, and the cluster role binding that grants it the
+// agent-registration bootstrap permissions.
+func GenerateAgentRegistrationBootstrapRBACObjects(managedClusterName, namespace string) ([]runtime.Object, error) {
+	return helpers.FilesToObjects(agentRegistrationHubFiles, struct {
+		ManagedClusterName               string
+		BootstrapServiceAccountName      string
+		BootstrapServiceAccountNamespace string
+	}{
+		ManagedClusterName:               managedClusterName,
+		BootstrapServiceAccountName:      helpers.GetAgentRegistrationBootstrapSAName(managedClusterName),
+		BootstrapServiceAccountNamespace: namespace,
+	}, &ManifestFiles)
+}
+
 func filesToTemplateBytes(files []string, config interface{}) ([]byte, error) {
 	manifests := new(bytes.Buffer)
 	for _, file := range files {
@@ -561,12 +708,12 @@ func getKlusterletNamespaceName(
 	return klusterletName, klusterletNamespace
 }
 
-func getKlusterletAgentImages(kcRegistries []klusterletconfigv1alpha1.Registries,
+func getKlusterletAgentImages(defaults *KlusterletManifestsDefaults, kcRegistries []klusterletconfigv1alpha1.Registries,
 	clusterAnnotations map[string]string) (map[string]string, error) {
 	agentImageNames := map[string]string{
-		constants.RegistrationOperatorImageEnvVarName: os.Getenv(constants.RegistrationOperatorImageEnvVarName),
-		constants.RegistrationImageEnvVarName:         os.Getenv(constants.RegistrationImageEnvVarName),
-		constants.WorkImageEnvVarName:                 os.Getenv(constants.WorkImageEnvVarName)}
+		constants.RegistrationOperatorImageEnvVarName: defaults.RegistrationOperatorImage,
+		constants.RegistrationImageEnvVarName:         defaults.RegistrationImage,
+		constants.WorkImageEnvVarName:                 defaults.WorkImage}
 
 	agentImageEnvNames := []string{constants.RegistrationOperatorImageEnvVarName,
 		constants.RegistrationImageEnvVarName, constants.WorkImageEnvVarName}
@@ -805,6 +805,38 @@ func TestKlusterletConfigGenerate(t *testing.T) {
 					constants.DefaultKlusterletNamespace, "bootstrap kubeconfig")
 			},
 		},
+		{
+			name:                   "with bootstrap kubeconfig secrets of multiple endpoints",
+			defaultImagePullSecret: "",
+			config: NewKlusterletManifestsConfig(
+				operatorv1.InstallModeDefault,
+				"test", // cluster name
+				[]byte("bootstrap kubeconfig"),
+			).WithBootstrapKubeConfigSecrets(
+				BootstrapKubeConfigSecret{Name: GetEndpointBootstrapKubeConfigSecretName(0), KubeConfig: "kubeconfig0"},
+				BootstrapKubeConfigSecret{Name: GetEndpointBootstrapKubeConfigSecretName(1), KubeConfig: "kubeconfig1"},
+			),
+			validateFunc: func(t *testing.T, objs, crds []runtime.Object) {
+				testinghelpers.ValidateObjectCount(t, objs, 11)
+				testinghelpers.ValidateKlusterlet(t, objs[8], operatorv1.InstallModeDefault,
+					"klusterlet", "test", constants.DefaultKlusterletNamespace)
+				klusterlet, _ := objs[8].(*operatorv1.Klusterlet)
+				bootstrapKubeConfigs := klusterlet.Spec.RegistrationConfiguration.BootstrapKubeConfigs
+				if bootstrapKubeConfigs.Type != operatorv1.LocalSecrets {
+					t.Errorf("the klusterlet bootstrap kubeconfig type is not %s", operatorv1.LocalSecrets)
+				}
+				if len(bootstrapKubeConfigs.LocalSecrets.KubeConfigSecrets) != 2 ||
+					bootstrapKubeConfigs.LocalSecrets.KubeConfigSecrets[0].Name != "bootstrap-hub-kubeconfig-endpoint-0" ||
+					bootstrapKubeConfigs.LocalSecrets.KubeConfigSecrets[1].Name != "bootstrap-hub-kubeconfig-endpoint-1" {
+					t.Errorf("unexpected bootstrap kubeconfig secrets %v", bootstrapKubeConfigs.LocalSecrets.KubeConfigSecrets)
+				}
+
+				testinghelpers.ValidateBoostrapSecret(t, objs[3], "bootstrap-hub-kubeconfig-endpoint-0",
+					constants.DefaultKlusterletNamespace, "kubeconfig0")
+				testinghelpers.ValidateBoostrapSecret(t, objs[4], "bootstrap-hub-kubeconfig-endpoint-1",
+					constants.DefaultKlusterletNamespace, "kubeconfig1")
+			},
+		},
 		{
 			name:                   "default cluster claim configuration",
 			defaultImagePullSecret: "",
@@ -0,0 +1,94 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+	"open-cluster-management.io/ocm/pkg/operator/helpers/chart"
+)
+
+// setKlusterletResources overrides the replica count and the resource requirements of the klusterlet operator,
+// and the resource requirements of the registration and work agents in the chart config. The annotations on the
+// klusterletConfig take precedence over the annotations on the managed cluster.
+func setKlusterletResources(cc *chart.KlusterletChartConfig,
+	kc *klusterletconfigv1alpha1.KlusterletConfig, clusterAnnotations map[string]string) error {
+	var kcAnnotations map[string]string
+	if kc != nil {
+		kcAnnotations = kc.Annotations
+	}
+
+	if value, ok := getResourcesAnnotation(constants.KlusterletOperatorReplicasAnnotation,
+		kcAnnotations, clusterAnnotations); ok {
+		replicas, err := strconv.Atoi(value)
+		if err != nil || replicas < 1 {
+			return fmt.Errorf("invalid %s annotation %q, it must be a positive integer",
+				constants.KlusterletOperatorReplicasAnnotation, value)
+		}
+		cc.ReplicaCount = replicas
+	}
+
+	if value, ok := getResourcesAnnotation(constants.KlusterletOperatorResourcesAnnotation,
+		kcAnnotations, clusterAnnotations); ok {
+		resources, err := parseResourceRequirements(constants.KlusterletOperatorResourcesAnnotation, value)
+		if err != nil {
+			return err
+		}
+		cc.Resources = *resources
+	}
+
+	if value, ok := getResourcesAnnotation(constants.KlusterletAgentResourcesAnnotation,
+		kcAnnotations, clusterAnnotations); ok {
+		resources, err := parseResourceRequirements(constants.KlusterletAgentResourcesAnnotation, value)
+		if err != nil {
+			return err
+		}
+		cc.Klusterlet.ResourceRequirement = &operatorv1.ResourceRequirement{
+			Type:                 operatorv1.ResourceQosClassResourceRequirement,
+			ResourceRequirements: resources,
+		}
+	}
+
+	return nil
+}
+
+func getResourcesAnnotation(key string, kcAnnotations, clusterAnnotations map[string]string) (string, bool) {
+	if value, ok := kcAnnotations[key]; ok {
+		return value, true
+	}
+	value, ok := clusterAnnotations[key]
+	return value, ok
+}
+
+// parseResourceRequirements parses the resource requirements in json format, the requests must be less than or
+// equal to the limits.
+func parseResourceRequirements(key, value string) (*corev1.ResourceRequirements, error) {
+	resources := &corev1.ResourceRequirements{}
+	if err := json.Unmarshal([]byte(value), resources); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", key, err)
+	}
+
+	for name, request := range resources.Requests {
+		if request.Sign() < 0 {
+			return nil, fmt.Errorf("invalid %s annotation: the request of %s must not be negative", key, name)
+		}
+		limit, ok := resources.Limits[name]
+		if ok && request.Cmp(limit) > 0 {
+			return nil, fmt.Errorf("invalid %s annotation: the request of %s %s exceeds the limit %s",
+				key, name, request.String(), limit.String())
+		}
+	}
+	for name, limit := range resources.Limits {
+		if limit.Sign() < 0 {
+			return nil, fmt.Errorf("invalid %s annotation: the limit of %s must not be negative", key, name)
+		}
+	}
+
+	return resources, nil
+}
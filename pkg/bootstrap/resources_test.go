@@ -0,0 +1,113 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package bootstrap
+
+import (
+	"testing"
+
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+func TestSetKlusterletResources(t *testing.T) {
+	cases := []struct {
+		name               string
+		kcAnnotations      map[string]string
+		clusterAnnotations map[string]string
+		expectedReplicas   int
+		expectedResources  corev1.ResourceRequirements
+		expectedAgent      *operatorv1.ResourceRequirement
+		expectedErr        bool
+	}{
+		{
+			name:             "no annotations",
+			expectedReplicas: 1,
+			expectedResources: corev1.ResourceRequirements{
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi"), corev1.ResourceCPU: resource.MustParse("50m")},
+			},
+		},
+		{
+			name: "cluster annotations",
+			clusterAnnotations: map[string]string{
+				constants.KlusterletOperatorReplicasAnnotation:  "2",
+				constants.KlusterletOperatorResourcesAnnotation: `{"requests":{"cpu":"10m","memory":"32Mi"}}`,
+				constants.KlusterletAgentResourcesAnnotation:    `{"limits":{"memory":"8Gi"}}`,
+			},
+			expectedReplicas: 2,
+			expectedResources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("32Mi"), corev1.ResourceCPU: resource.MustParse("10m")},
+			},
+			expectedAgent: &operatorv1.ResourceRequirement{
+				Type: operatorv1.ResourceQosClassResourceRequirement,
+				ResourceRequirements: &corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+				},
+			},
+		},
+		{
+			name: "klusterletconfig annotations take precedence",
+			kcAnnotations: map[string]string{
+				constants.KlusterletOperatorReplicasAnnotation:  "3",
+				constants.KlusterletOperatorResourcesAnnotation: `{"limits":{"memory":"4Gi"}}`,
+			},
+			clusterAnnotations: map[string]string{
+				constants.KlusterletOperatorReplicasAnnotation:  "2",
+				constants.KlusterletOperatorResourcesAnnotation: `{"limits":{"memory":"1Gi"}}`,
+			},
+			expectedReplicas: 3,
+			expectedResources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+			},
+		},
+		{
+			name:               "invalid replicas",
+			clusterAnnotations: map[string]string{constants.KlusterletOperatorReplicasAnnotation: "0"},
+			expectedErr:        true,
+		},
+		{
+			name:               "invalid resources",
+			clusterAnnotations: map[string]string{constants.KlusterletAgentResourcesAnnotation: `{"limits":`},
+			expectedErr:        true,
+		},
+		{
+			name: "requests exceed limits",
+			kcAnnotations: map[string]string{
+				constants.KlusterletOperatorResourcesAnnotation: `{"requests":{"memory":"4Gi"},"limits":{"memory":"2Gi"}}`,
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cc := newKlusterletChartConfig(operatorv1.InstallModeDefault, "cluster1", nil)
+			kc := &klusterletconfigv1alpha1.KlusterletConfig{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.kcAnnotations},
+			}
+
+			err := setKlusterletResources(cc, kc, c.clusterAnnotations)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if cc.ReplicaCount != c.expectedReplicas {
+				t.Errorf("expected replicas %d, but got %d", c.expectedReplicas, cc.ReplicaCount)
+			}
+			if !equality.Semantic.DeepEqual(cc.Resources, c.expectedResources) {
+				t.Errorf("expected resources %v, but got %v", c.expectedResources, cc.Resources)
+			}
+			if !equality.Semantic.DeepEqual(cc.Klusterlet.ResourceRequirement, c.expectedAgent) {
+				t.Errorf("expected agent resources %v, but got %v", c.expectedAgent, cc.Klusterlet.ResourceRequirement)
+			}
+		})
+	}
+}
@@ -42,6 +42,27 @@ const (
 	// ClusterImportConfig is to enable to generate the cluster import config secret for CAPI cluster
 	// importing when the value is true, otherwise do not generate the secret.
 	ClusterImportConfig = "clusterImportConfig"
+
+	// EffectiveKlusterletConfig is to enable to generate the effective klusterletconfig configmap in the managed
+	// cluster namespace when the value is true, otherwise do not generate the configmap.
+	EffectiveKlusterletConfig = "effectiveKlusterletConfig"
+
+	// CSRApprovalPolicyKey is the data key in the import-controller-config ConfigMap used to specify the CSR
+	// approval policy, the policy rules are evaluated in addition to the built-in CSR approval conditions.
+	CSRApprovalPolicyKey = "csrApprovalPolicy"
+
+	// CSRRateLimitKey is the data key in the import-controller-config ConfigMap used to specify the maximum CSRs
+	// that a managed cluster can request in a time window, the CSR rate limit is disabled if it is not specified.
+	CSRRateLimitKey = "csrRateLimit"
+
+	// FlightCtlDeviceLabelsKey is the data key in the import-controller-config ConfigMap used to specify the
+	// comma-separated keys of the FlightCtl device labels that are synced to the labels of the managed clusters.
+	FlightCtlDeviceLabelsKey = "flightctlDeviceLabels"
+
+	// FlightCtlDeviceRemovalActionKey is the data key in the import-controller-config ConfigMap used to specify how
+	// a managed cluster is handled when its FlightCtl device is deleted or decommissioned, the value can be
+	// MarkUnavailable or Detach.
+	FlightCtlDeviceRemovalActionKey = "flightctlDeviceRemovalAction"
 )
 
 /* #nosec */
@@ -70,11 +91,12 @@ const (
 )
 
 const (
-	CreatedViaAnnotation = "open-cluster-management/created-via"
-	CreatedViaAI         = "assisted-installer"
-	CreatedViaHive       = "hive"
-	CreatedViaDiscovery  = "discovery"
-	CreatedViaHypershift = "hypershift"
+	CreatedViaAnnotation        = "open-cluster-management/created-via"
+	CreatedViaAI                = "assisted-installer"
+	CreatedViaHive              = "hive"
+	CreatedViaDiscovery         = "discovery"
+	CreatedViaHypershift        = "hypershift"
+	CreatedViaAgentRegistration = "agent-registration"
 )
 
 // NOSONAR-START
@@ -154,6 +176,8 @@ const (
 
 	EventReasonManagedClusterDetaching      = "Detaching"
 	EventReasonManagedClusterForceDetaching = "ForceDetaching"
+
+	EventReasonManagedClusterCSRRateLimitExceeded = "CSRRateLimitExceeded"
 )
 
 /* #nosec */
@@ -189,6 +213,81 @@ const (
 	GlobalKlusterletConfigName = "global"
 )
 
+const (
+	// KlusterletConfigClusterSelectorAnnotation is the annotation on a KlusterletConfig to select managed clusters
+	// by labels, the value is a JSON encoded LabelSelector.
+	KlusterletConfigClusterSelectorAnnotation = "import.open-cluster-management.io/cluster-selector"
+
+	// KlusterletConfigClusterClaimSelectorAnnotation is the annotation on a KlusterletConfig to select managed
+	// clusters by the cluster claims in the cluster status, the value is a JSON encoded LabelSelector which
+	// matches against the claim names and values.
+	KlusterletConfigClusterClaimSelectorAnnotation = "import.open-cluster-management.io/clusterclaim-selector"
+
+	// KlusterletConfigClusterSetAnnotation is the annotation on a KlusterletConfig to select the managed clusters
+	// in a ManagedClusterSet, the value is the name of the ManagedClusterSet.
+	KlusterletConfigClusterSetAnnotation = "import.open-cluster-management.io/clusterset"
+
+	// KlusterletConfigPriorityAnnotation is the annotation on a KlusterletConfig to specify its priority when more
+	// than one KlusterletConfig selects a managed cluster, the one with the higher priority is used. The default
+	// value is 0.
+	KlusterletConfigPriorityAnnotation = "import.open-cluster-management.io/priority"
+
+	// KlusterletConfigHubKubeAPIServerURLsAnnotation is the annotation on a KlusterletConfig to specify an ordered,
+	// comma separated list of the hub kube apiserver URLs. When more than one URL is specified, one bootstrap
+	// kubeconfig is generated for each URL and the klusterlet fails over to the next one in order when the hub
+	// is unreachable from the current one. It takes precedence over the URL in the HubKubeAPIServerConfig.
+	KlusterletConfigHubKubeAPIServerURLsAnnotation = "import.open-cluster-management.io/hub-kube-apiserver-urls"
+
+	// KlusterletConfigDeriveImageMirrorsAnnotation is the annotation on a KlusterletConfig to derive the image
+	// mirrors of the klusterlet agent images from the ImageDigestMirrorSets and ImageContentSourcePolicies on the
+	// hub when the value is "true". It is ignored if the registries are set in the KlusterletConfig or the
+	// image-registries annotation of the managed cluster.
+	KlusterletConfigDeriveImageMirrorsAnnotation = "import.open-cluster-management.io/derive-image-mirrors"
+
+	// KlusterletConfigPullSecretsAnnotation is the annotation on a KlusterletConfig to specify an ordered, comma
+	// separated list of image pull secrets in the format of namespace/name. They are merged with the pullSecret of
+	// the KlusterletConfig, the default image pull secret and the pullSecret in the image-registries annotation of
+	// the managed cluster, the latter one overrides the former ones for the same registry. Without the annotation,
+	// the pullSecret of the KlusterletConfig replaces the others.
+	KlusterletConfigPullSecretsAnnotation = "import.open-cluster-management.io/pull-secrets"
+
+	// KlusterletOperatorReplicasAnnotation is the annotation on a KlusterletConfig or a ManagedCluster to specify
+	// the replica count of the klusterlet operator deployment, the value on the KlusterletConfig takes precedence.
+	KlusterletOperatorReplicasAnnotation = "import.open-cluster-management.io/klusterlet-operator-replicas"
+
+	// KlusterletOperatorResourcesAnnotation is the annotation on a KlusterletConfig or a ManagedCluster to specify
+	// the resource requests and limits of the klusterlet operator in the json format of ResourceRequirements, the
+	// value on the KlusterletConfig takes precedence.
+	KlusterletOperatorResourcesAnnotation = "import.open-cluster-management.io/klusterlet-operator-resources"
+
+	// KlusterletAgentResourcesAnnotation is the annotation on a KlusterletConfig or a ManagedCluster to specify
+	// the resource requests and limits of the registration and work agents in the json format of
+	// ResourceRequirements, the value on the KlusterletConfig takes precedence.
+	KlusterletAgentResourcesAnnotation = "import.open-cluster-management.io/klusterlet-agent-resources"
+
+	// KlusterletConfigAdditionalManifestsAnnotation is the annotation on a KlusterletConfig to specify an ordered,
+	// comma separated list of ConfigMaps or Secrets on the hub in the format of kind/namespace/name, e.g.
+	// ConfigMap/ns1/network-policies. Every data item of them is a templated manifest that is rendered with the
+	// klusterlet chart config and appended to the import.yaml in the order of the keys.
+	KlusterletConfigAdditionalManifestsAnnotation = "import.open-cluster-management.io/additional-manifests"
+
+	// KlusterletConfigAnnotationPrefix is the prefix of the KlusterletConfig annotations that are merged into
+	// the merged KlusterletConfig of a managed cluster.
+	KlusterletConfigAnnotationPrefix = "import.open-cluster-management.io/"
+)
+
+const (
+	// HubKubeAPIServerLabel is the label on a Service or an Ingress in the controller namespace to expose the hub
+	// kube apiserver on the non-OCP hub cluster, the value is "true". The load balancer address of the Service or
+	// the host of the Ingress is used as the hub kube apiserver address.
+	HubKubeAPIServerLabel = "import.open-cluster-management.io/hub-kube-apiserver"
+
+	// HubKubeAPIServerCAConfigMapAnnotation is the annotation on the Service or the Ingress with the
+	// HubKubeAPIServerLabel to specify the configmap in the controller namespace that contains the ca of the
+	// hub kube apiserver.
+	HubKubeAPIServerCAConfigMapAnnotation = "import.open-cluster-management.io/hub-kube-apiserver-ca-configmap"
+)
+
 const (
 	ComponentName = "managedcluster-import-controller"
 )
@@ -203,6 +302,10 @@ const (
 
 	// If a managed cluster is from the agent-registration, the username of the CSR will be this
 	AgentRegistrationBootstrapUser = "system:serviceaccount:multicluster-engine:agent-registration-bootstrap"
+
+	// AgentRegistrationClusterLabel is the label of the cluster id on the per-cluster bootstrap service account
+	// and cluster role binding created by the agent-registration server.
+	AgentRegistrationClusterLabel = "import.open-cluster-management.io/agent-registration-cluster"
 )
 
 const (
@@ -213,6 +316,21 @@ const (
 	ValuesYamlKey = "values.yaml"
 )
 
+const (
+	// EffectiveKlusterletConfigConfigMapName is the configmap name of the effective klusterletconfig in the managed
+	// cluster namespace, it includes the merged klusterletconfig spec and annotations and the sources of them.
+	EffectiveKlusterletConfigConfigMapName = "effective-klusterletconfig"
+	// EffectiveKlusterletConfigNameKey is the key of the name of the klusterletconfig used by the managed cluster
+	EffectiveKlusterletConfigNameKey = "klusterletconfig"
+	// EffectiveKlusterletConfigSpecKey is the key of the merged klusterletconfig spec
+	EffectiveKlusterletConfigSpecKey = "spec.yaml"
+	// EffectiveKlusterletConfigAnnotationsKey is the key of the merged klusterletconfig annotations
+	EffectiveKlusterletConfigAnnotationsKey = "annotations.yaml"
+	// EffectiveKlusterletConfigSourcesKey is the key of the sources of the fields in the merged klusterletconfig spec
+	// and of the merged klusterletconfig annotations
+	EffectiveKlusterletConfigSourcesKey = "sources.yaml"
+)
+
 const (
 	// LegacyTokenInvalidSince is the label key used by Kubernetes to mark legacy service account tokens as invalid.
 	// The label value is a timestamp in RFC3339 format indicating when the token became invalid.
@@ -0,0 +1,136 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// agentRegistrationPath is the root path of the unversioned routes, they are kept as the aliases of the v1
+	// routes for compatibility and their errors are in plain text.
+	agentRegistrationPath = "/agent-registration"
+	// agentRegistrationV1Path is the root path of the v1 API, the errors are returned as the Error objects.
+	agentRegistrationV1Path = "/agent-registration/v1"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// ErrorCode is the machine-readable code of an agent-registration API error.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidRequest          ErrorCode = "InvalidRequest"
+	ErrorCodeUnauthenticated         ErrorCode = "Unauthenticated"
+	ErrorCodeUnauthorized            ErrorCode = "Unauthorized"
+	ErrorCodeEnrollmentTokenRejected ErrorCode = "EnrollmentTokenRejected"
+	ErrorCodeRateLimited             ErrorCode = "RateLimited"
+	ErrorCodeNotFound                ErrorCode = "NotFound"
+	ErrorCodeClusterAlreadyExists    ErrorCode = "ClusterAlreadyExists"
+	ErrorCodeInternalError           ErrorCode = "InternalError"
+)
+
+// Error is the body of the error responses of the v1 API.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// APIResource is a resource of the v1 API in the discovery response.
+type APIResource struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	ContentType string `json:"contentType"`
+}
+
+// APIDiscovery is the discovery response of the v1 API.
+type APIDiscovery struct {
+	APIVersion string            `json:"apiVersion"`
+	Resources  []APIResource     `json:"resources"`
+	ServerInfo map[string]string `json:"serverInfo"`
+}
+
+var apiV1Resources = []APIResource{
+	{Name: "crds", Path: agentRegistrationV1Path + "/crds", ContentType: "application/yaml"},
+	{Name: "manifests", Path: agentRegistrationV1Path + "/manifests/{clusterID}", ContentType: "application/yaml"},
+	{Name: "export", Path: agentRegistrationV1Path + "/export/{clusterID}", ContentType: "application/gzip"},
+	{Name: "install", Path: agentRegistrationV1Path + "/install/{clusterID}", ContentType: "text/x-shellscript"},
+	{Name: "refresh", Path: agentRegistrationV1Path + "/refresh/{clusterID}", ContentType: "application/yaml"},
+	{Name: "openapi", Path: agentRegistrationV1Path + "/openapi.json", ContentType: "application/json"},
+}
+
+// writeJSON writes the object as the json response.
+func writeJSON(w http.ResponseWriter, r *http.Request, obj interface{}) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeInternalError, "Failed to encode the response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		klog.Errorf("failed to write the response of %s: %v", r.URL.Path, err)
+	}
+}
+
+// errorCodeForStatus returns the default error code of the http status code.
+func errorCodeForStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthenticated
+	case http.StatusForbidden:
+		return ErrorCodeUnauthorized
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusConflict:
+		return ErrorCodeClusterAlreadyExists
+	case http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	default:
+		return ErrorCodeInternalError
+	}
+}
+
+// writeError writes the error response, it is an Error object for the v1 API and plain text for the
+// unversioned routes.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	if !isV1Request(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(Error{Code: code, Message: message}); err != nil {
+		klog.Errorf("failed to write the error response of %s: %v", r.URL.Path, err)
+	}
+}
+
+// writeStatusError writes the error response with the default error code of the http status code.
+func writeStatusError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	code := errorCodeForStatus(status)
+	if errors.Is(err, errEnrollmentTokenRejected) {
+		code = ErrorCodeEnrollmentTokenRejected
+	}
+	writeError(w, r, status, code, err.Error())
+}
+
+func isV1Request(r *http.Request) bool {
+	return r.URL.Path == agentRegistrationV1Path || strings.HasPrefix(r.URL.Path, agentRegistrationV1Path+"/")
+}
+
+// clusterIDFromPath returns the last segment of the request path as the cluster id.
+func clusterIDFromPath(r *http.Request) string {
+	urlparams := strings.Split(r.URL.Path, "/")
+	return urlparams[len(urlparams)-1]
+}
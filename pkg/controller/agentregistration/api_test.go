@@ -0,0 +1,178 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestAgentRegistrationHandler(t *testing.T) {
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "tokenreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			tr := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+			tr.Status.Authenticated = tr.Spec.Token == "valid"
+			return true, tr, nil
+		})
+	kubeClient.PrependReactor("create", "subjectaccessreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			sar := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+			sar.Status.Allowed = true
+			return true, sar, nil
+		})
+
+	options := NewServerOptions()
+	options.RateLimitQPS = 0
+	handler := newAgentRegistrationHandler(context.TODO(), options, &helpers.ClientHolder{KubeClient: kubeClient}, nil)
+
+	cases := []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+		// expectedCode is the code of the Error object, it is empty if the error is in plain text
+		expectedCode ErrorCode
+		validate     func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "v1 unauthenticated",
+			path:           "/agent-registration/v1/manifests/cluster1",
+			token:          "invalid",
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   ErrorCodeUnauthenticated,
+		},
+		{
+			name:           "unversioned unauthenticated",
+			path:           "/agent-registration/manifests/cluster1",
+			token:          "invalid",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "v1 invalid request",
+			path:           "/agent-registration/v1/manifests/cluster1?mode=Detached",
+			token:          "valid",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   ErrorCodeInvalidRequest,
+		},
+		{
+			name:           "v1 missing cluster id",
+			path:           "/agent-registration/v1/export/",
+			token:          "valid",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   ErrorCodeInvalidRequest,
+		},
+		{
+			name:           "v1 installer in the hosted mode",
+			path:           "/agent-registration/v1/install/cluster1?mode=Hosted",
+			token:          "valid",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   ErrorCodeInvalidRequest,
+		},
+		{
+			name:           "unversioned invalid request",
+			path:           "/agent-registration/export/cluster1?mode=Detached",
+			token:          "valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "v1 not found",
+			path:           "/agent-registration/v1/clusters",
+			token:          "valid",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   ErrorCodeNotFound,
+		},
+		{
+			name:           "v1 discovery",
+			path:           "/agent-registration/v1",
+			token:          "valid",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, body []byte) {
+				discovery := &APIDiscovery{}
+				if err := json.Unmarshal(body, discovery); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if discovery.APIVersion != "v1" || len(discovery.Resources) != len(apiV1Resources) {
+					t.Errorf("unexpected discovery %v", discovery)
+				}
+			},
+		},
+		{
+			name:           "unversioned discovery",
+			path:           "/agent-registration",
+			token:          "valid",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, body []byte) {
+				if !strings.Contains(string(body), `"paths"`) {
+					t.Errorf("unexpected discovery %s", string(body))
+				}
+			},
+		},
+		{
+			name:           "openapi without a credential",
+			path:           "/agent-registration/v1/openapi.json",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, body []byte) {
+				document := struct {
+					Paths map[string]interface{} `json:"paths"`
+				}{}
+				if err := json.Unmarshal(body, &document); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for _, resource := range apiV1Resources {
+					path := strings.TrimPrefix(resource.Path, agentRegistrationV1Path)
+					if _, ok := document.Paths[path]; !ok {
+						t.Errorf("expected the path %s in the openapi document", path)
+					}
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.path, nil)
+			if len(c.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != c.expectedStatus {
+				t.Fatalf("expected status %d, but got %d: %s", c.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if c.validate != nil {
+				c.validate(t, rec.Body.Bytes())
+			}
+			if c.expectedStatus == http.StatusOK {
+				return
+			}
+
+			apiErr := &Error{}
+			err := json.Unmarshal(rec.Body.Bytes(), apiErr)
+			if len(c.expectedCode) == 0 {
+				if err == nil {
+					t.Errorf("expected the error in plain text, but got %s", rec.Body.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if apiErr.Code != c.expectedCode || len(apiErr.Message) == 0 {
+				t.Errorf("expected error code %s, but got %v", c.expectedCode, apiErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+)
+
+// clientRateLimiterTTL is how long the rate limiter of an idle client is kept.
+const clientRateLimiterTTL = 10 * time.Minute
+
+// authResult is the result of the authentication and authorization of a request.
+type authResult struct {
+	status  int
+	message string
+	// reason is the label of the rejected requests metric if the request is rejected
+	reason string
+	code   ErrorCode
+	// user is the authenticated user if the request is allowed
+	user authenticationv1.UserInfo
+}
+
+// authCache caches the auth results keyed by the hash of the credential and the request path, so the
+// TokenReview and SubjectAccessReview are not requested for every request. The rejections are cached with a
+// shorter TTL, and the results of the failed reviews are not cached.
+type authCache struct {
+	cache       *utilcache.LRUExpireCache
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// newAuthCache returns an auth cache, it returns nil if the size is not positive and the cache is disabled.
+func newAuthCache(size int, ttl, negativeTTL time.Duration) *authCache {
+	if size <= 0 {
+		return nil
+	}
+	return &authCache{
+		cache:       utilcache.NewLRUExpireCache(size),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+func authCacheKey(credential []byte, path string) string {
+	return credentialHash(credential) + path
+}
+
+// credentialHash returns the hash of the credential, so the credential is not kept in memory.
+func credentialHash(credential []byte) string {
+	hash := sha256.Sum256(credential)
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *authCache) get(key string) (authResult, bool) {
+	if c == nil {
+		return authResult{}, false
+	}
+
+	value, ok := c.cache.Get(key)
+	if !ok {
+		authCacheRequestsTotal.WithLabelValues("miss").Inc()
+		return authResult{}, false
+	}
+	authCacheRequestsTotal.WithLabelValues("hit").Inc()
+	return value.(authResult), true
+}
+
+func (c *authCache) add(key string, result authResult) {
+	if c == nil {
+		return
+	}
+
+	switch {
+	case result.status == http.StatusOK && c.ttl > 0:
+		c.cache.Add(key, result, c.ttl)
+	case result.status == http.StatusUnauthorized || result.status == http.StatusForbidden:
+		if c.negativeTTL > 0 {
+			c.cache.Add(key, result, c.negativeTTL)
+		}
+	}
+}
+
+// clientRateLimiter limits the requests of each client by a key of the client, e.g. its address or the hash of
+// its credential.
+type clientRateLimiter struct {
+	qps   rate.Limit
+	burst int
+
+	lock     sync.Mutex
+	limiters *utilcache.LRUExpireCache
+}
+
+// newClientRateLimiter returns a client rate limiter, it returns nil if the qps is not positive and the rate
+// limiting is disabled.
+func newClientRateLimiter(qps float64, burst int) *clientRateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &clientRateLimiter{
+		qps:      rate.Limit(qps),
+		burst:    max(burst, 1),
+		limiters: utilcache.NewLRUExpireCache(10000),
+	}
+}
+
+// allow returns true if the request of the client is allowed, otherwise it returns the seconds after which the
+// client can retry.
+func (l *clientRateLimiter) allow(client string) (bool, int) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.lock.Lock()
+	var limiter *rate.Limiter
+	if value, ok := l.limiters.Get(client); ok {
+		limiter = value.(*rate.Limiter)
+	} else {
+		limiter = rate.NewLimiter(l.qps, l.burst)
+	}
+	// refresh the ttl of the limiter of an active client
+	l.limiters.Add(client, limiter, clientRateLimiterTTL)
+	l.lock.Unlock()
+
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.Cancel()
+	return false, int(math.Ceil(delay.Seconds()))
+}
+
+// clientAddress returns the address of the client of the request. If the request is from a trusted proxy, the
+// client address is the rightmost address of the X-Forwarded-For header that is not a trusted proxy, because each
+// proxy appends the address of its peer to the header and the addresses on the left can be forged by the client.
+func clientAddress(r *http.Request, trustedProxies []net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trustedProxies) {
+		return host
+	}
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			break
+		}
+		host = forwarded[i]
+		if !isTrustedProxy(host, trustedProxies) {
+			break
+		}
+	}
+	return host
+}
+
+func isTrustedProxy(addr string, trustedProxies []net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRateLimitedError writes the error of the request that exceeds the rate limit.
+func writeRateLimitedError(w http.ResponseWriter, r *http.Request, retryAfter int) {
+	rejectedRequestsTotal.WithLabelValues("rate_limited").Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeError(w, r, http.StatusTooManyRequests, ErrorCodeRateLimited, "Too many requests")
+}
@@ -0,0 +1,250 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestAuthMiddlewareCache(t *testing.T) {
+	cases := []struct {
+		name                string
+		authenticated       bool
+		allowed             bool
+		reviewErr           error
+		cacheSize           int
+		expectedStatus      int
+		expectedReviewCalls int
+	}{
+		{
+			name:                "allowed requests are cached",
+			authenticated:       true,
+			allowed:             true,
+			cacheSize:           10,
+			expectedStatus:      http.StatusOK,
+			expectedReviewCalls: 1,
+		},
+		{
+			name:                "unauthenticated requests are cached",
+			cacheSize:           10,
+			expectedStatus:      http.StatusUnauthorized,
+			expectedReviewCalls: 1,
+		},
+		{
+			name:                "unauthorized requests are cached",
+			authenticated:       true,
+			cacheSize:           10,
+			expectedStatus:      http.StatusUnauthorized,
+			expectedReviewCalls: 1,
+		},
+		{
+			name:                "failed reviews are not cached",
+			reviewErr:           fmt.Errorf("internal error"),
+			cacheSize:           10,
+			expectedStatus:      http.StatusInternalServerError,
+			expectedReviewCalls: 3,
+		},
+		{
+			name:                "cache disabled",
+			authenticated:       true,
+			allowed:             true,
+			expectedStatus:      http.StatusOK,
+			expectedReviewCalls: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reviewCalls := 0
+			kubeClient := kubefake.NewSimpleClientset()
+			kubeClient.PrependReactor("create", "tokenreviews",
+				func(action clienttesting.Action) (bool, runtime.Object, error) {
+					reviewCalls++
+					if c.reviewErr != nil {
+						return true, nil, c.reviewErr
+					}
+					tr := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+					tr.Status.Authenticated = c.authenticated
+					tr.Status.User = authenticationv1.UserInfo{Username: "system:serviceaccount:edge:registration"}
+					return true, tr, nil
+				})
+			kubeClient.PrependReactor("create", "subjectaccessreviews",
+				func(action clienttesting.Action) (bool, runtime.Object, error) {
+					sar := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+					sar.Status.Allowed = c.allowed
+					return true, sar, nil
+				})
+
+			options := NewServerOptions()
+			options.AuthCacheSize = c.cacheSize
+			options.RateLimitQPS = 0
+			handler := newAuthMiddleware(&helpers.ClientHolder{KubeClient: kubeClient}, options)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/agent-registration/manifests/cluster1", nil)
+				req.Header.Set("Authorization", "Bearer token")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != c.expectedStatus {
+					t.Errorf("expected status %d, but got %d: %s", c.expectedStatus, rec.Code, rec.Body.String())
+				}
+			}
+			if reviewCalls != c.expectedReviewCalls {
+				t.Errorf("expected %d token reviews, but got %d", c.expectedReviewCalls, reviewCalls)
+			}
+		})
+	}
+}
+
+func TestAuthCacheKey(t *testing.T) {
+	if authCacheKey([]byte("token"), "/a") == authCacheKey([]byte("token"), "/b") {
+		t.Errorf("expected the keys of different paths are different")
+	}
+	if authCacheKey([]byte("token1"), "/a") == authCacheKey([]byte("token2"), "/a") {
+		t.Errorf("expected the keys of different tokens are different")
+	}
+}
+
+func TestAuthCacheExpiration(t *testing.T) {
+	cache := newAuthCache(10, 50*time.Millisecond, 10*time.Millisecond)
+	cache.add("allowed", authResult{status: http.StatusOK})
+	cache.add("rejected", authResult{status: http.StatusUnauthorized})
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := cache.get("allowed"); !ok {
+		t.Errorf("expected the allowed result is cached")
+	}
+	if _, ok := cache.get("rejected"); ok {
+		t.Errorf("expected the rejected result is expired")
+	}
+}
+
+func TestAuthMiddlewareRateLimit(t *testing.T) {
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "tokenreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, action.(clienttesting.CreateAction).GetObject(), nil
+		})
+	options := NewServerOptions()
+	options.AuthCacheSize = 0
+	options.RateLimitQPS = 0.1
+	options.RateLimitBurst = 2
+	options.ClientAddressRateLimitQPS = 0.1
+	options.ClientAddressRateLimitBurst = 4
+	handler := newAuthMiddleware(&helpers.ClientHolder{KubeClient: kubeClient}, options)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/agent-registration/manifests/cluster1", nil)
+		req.RemoteAddr = remoteAddr
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the requests of a credential are limited by the credential limits
+	for i := 0; i < 2; i++ {
+		if rec := serve("10.0.0.1:1234", "token1"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d, but got %d", http.StatusUnauthorized, rec.Code)
+		}
+	}
+	rec := serve("10.0.0.1:5678", "token1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, but got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Errorf("expected retry after 10 seconds, but got %q", retryAfter)
+	}
+
+	// the other credentials from the same address are limited by the looser address limits
+	if rec := serve("10.0.0.1:1234", "token2"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, but got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if rec := serve("10.0.0.1:1234", "token3"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, but got %d", http.StatusTooManyRequests, rec.Code)
+	}
+
+	// the other clients are not limited
+	if rec := serve("10.0.0.2:1234", "token4"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, but got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.128.0.0/14")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trustedProxies := []net.IPNet{*proxies}
+
+	cases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expectedAddr string
+	}{
+		{
+			name:         "direct client",
+			remoteAddr:   "192.0.2.1:1234",
+			expectedAddr: "192.0.2.1",
+		},
+		{
+			name:         "forwarded for header from an untrusted client",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			expectedAddr: "192.0.2.1",
+		},
+		{
+			name:         "trusted proxy",
+			remoteAddr:   "10.128.0.5:1234",
+			forwardedFor: []string{"198.51.100.1, 192.0.2.1"},
+			expectedAddr: "192.0.2.1",
+		},
+		{
+			name:         "trusted proxies chain",
+			remoteAddr:   "10.128.0.5:1234",
+			forwardedFor: []string{"192.0.2.1", "10.129.0.7"},
+			expectedAddr: "192.0.2.1",
+		},
+		{
+			name:         "trusted proxy without forwarded for header",
+			remoteAddr:   "10.128.0.5:1234",
+			expectedAddr: "10.128.0.5",
+		},
+		{
+			name:         "invalid forwarded for header",
+			remoteAddr:   "10.128.0.5:1234",
+			forwardedFor: []string{"unknown"},
+			expectedAddr: "10.128.0.5",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/agent-registration/manifests/cluster1", nil)
+			req.RemoteAddr = c.remoteAddr
+			for _, value := range c.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if addr := clientAddress(req, trustedProxies); addr != c.expectedAddr {
+				t.Errorf("expected client address %s, but got %s", c.expectedAddr, addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// An enrollment token is a secret in the pod namespace that authorizes the agent-registration requests without
+// a kubernetes token. The token is in the format of <id>.<secret> as the kubeadm bootstrap token, and it is
+// stored in the secret enrollment-token-<id> with the type EnrollmentTokenSecretType.
+const (
+	EnrollmentTokenSecretType   corev1.SecretType = "import.open-cluster-management.io/enrollment-token"
+	EnrollmentTokenSecretPrefix                   = "enrollment-token-"
+
+	// EnrollmentTokenSecretKey is the secret part of the token, required.
+	EnrollmentTokenSecretKey = "token-secret"
+	// EnrollmentTokenExpirationKey is the expiration of the token in RFC3339 format, the token does not expire
+	// if it is not set.
+	EnrollmentTokenExpirationKey = "expiration"
+	// EnrollmentTokenMaxUsesKey is the number of the clusters that can be enrolled with the token, the default
+	// is 1.
+	EnrollmentTokenMaxUsesKey = "max-uses"
+	// EnrollmentTokenClusterIDPatternKey is the shell pattern that the enrolled cluster IDs must match.
+	EnrollmentTokenClusterIDPatternKey = "cluster-id-pattern"
+	// EnrollmentTokenKlusterletConfigKey is the KlusterletConfig that is used to render the manifests, the
+	// klusterletconfig query parameter must be empty or the same.
+	EnrollmentTokenKlusterletConfigKey = "klusterletconfig"
+	// EnrollmentTokenEnrollmentsKey is the audit of the clusters enrolled with the token, it is maintained by
+	// the server.
+	EnrollmentTokenEnrollmentsKey = "enrollments"
+)
+
+var enrollmentTokenRegexp = regexp.MustCompile(`^([a-z0-9]{6})\.([a-z0-9]{16})$`)
+
+// errEnrollmentTokenRejected is returned if the enrollment token is not allowed to enroll the cluster.
+var errEnrollmentTokenRejected = errors.New("enrollment token rejected")
+
+// enrollment is the audit record of a cluster enrolled with an enrollment token.
+type enrollment struct {
+	ClusterID  string `json:"clusterID"`
+	Time       string `json:"time"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+}
+
+type enrollmentToken struct {
+	id               string
+	expiration       *time.Time
+	maxUses          int
+	clusterIDPattern string
+	klusterletConfig string
+	enrollments      []enrollment
+}
+
+// parseEnrollmentToken returns the id and the secret of the token, ok is false if the token is not in the
+// format of an enrollment token.
+func parseEnrollmentToken(token string) (id, secret string, ok bool) {
+	matches := enrollmentTokenRegexp.FindStringSubmatch(token)
+	if len(matches) != 3 {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// getEnrollmentToken gets the enrollment token secret of the token and verifies the secret part. A nil secret
+// is returned if the token is not found or does not match.
+func getEnrollmentToken(ctx context.Context, kubeClient kubernetes.Interface, ns, token string) (*corev1.Secret, error) {
+	id, tokenSecret, ok := parseEnrollmentToken(token)
+	if !ok {
+		return nil, nil
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets(ns).Get(ctx, EnrollmentTokenSecretPrefix+id, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if secret.Type != EnrollmentTokenSecretType {
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare(secret.Data[EnrollmentTokenSecretKey], []byte(tokenSecret)) != 1 {
+		return nil, nil
+	}
+	return secret, nil
+}
+
+func newEnrollmentToken(secret *corev1.Secret) (*enrollmentToken, error) {
+	t := &enrollmentToken{
+		id:               secret.Name[len(EnrollmentTokenSecretPrefix):],
+		maxUses:          1,
+		clusterIDPattern: string(secret.Data[EnrollmentTokenClusterIDPatternKey]),
+		klusterletConfig: string(secret.Data[EnrollmentTokenKlusterletConfigKey]),
+	}
+
+	if value := secret.Data[EnrollmentTokenExpirationKey]; len(value) > 0 {
+		expiration, err := time.Parse(time.RFC3339, string(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiration of the enrollment token %s: %v", t.id, err)
+		}
+		t.expiration = &expiration
+	}
+
+	if value := secret.Data[EnrollmentTokenMaxUsesKey]; len(value) > 0 {
+		maxUses, err := strconv.Atoi(string(value))
+		if err != nil || maxUses < 1 {
+			return nil, fmt.Errorf("invalid max uses of the enrollment token %s: %q", t.id, string(value))
+		}
+		t.maxUses = maxUses
+	}
+
+	if len(t.clusterIDPattern) > 0 {
+		if _, err := path.Match(t.clusterIDPattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid cluster id pattern of the enrollment token %s: %v", t.id, err)
+		}
+	}
+
+	if value := secret.Data[EnrollmentTokenEnrollmentsKey]; len(value) > 0 {
+		if err := json.Unmarshal(value, &t.enrollments); err != nil {
+			return nil, fmt.Errorf("invalid enrollments of the enrollment token %s: %v", t.id, err)
+		}
+	}
+
+	return t, nil
+}
+
+// validate returns an error if the token is expired or used up. The cluster id is checked against the pattern
+// and the klusterletconfig against the bound one if they are not empty.
+func (t *enrollmentToken) validate(now time.Time, clusterID, klusterletConfig string) error {
+	if t.expiration != nil && !now.Before(*t.expiration) {
+		return fmt.Errorf("%w: the enrollment token %s is expired", errEnrollmentTokenRejected, t.id)
+	}
+	if len(t.enrollments) >= t.maxUses {
+		return fmt.Errorf("%w: the enrollment token %s is used up", errEnrollmentTokenRejected, t.id)
+	}
+	if len(clusterID) > 0 && len(t.clusterIDPattern) > 0 {
+		if matched, _ := path.Match(t.clusterIDPattern, clusterID); !matched {
+			return fmt.Errorf("%w: the cluster %s is not allowed by the enrollment token %s",
+				errEnrollmentTokenRejected, clusterID, t.id)
+		}
+	}
+	if len(klusterletConfig) > 0 && len(t.klusterletConfig) > 0 && klusterletConfig != t.klusterletConfig {
+		return fmt.Errorf("%w: the klusterletconfig %s is not allowed by the enrollment token %s",
+			errEnrollmentTokenRejected, klusterletConfig, t.id)
+	}
+	return nil
+}
+
+// loadEnrollmentToken gets and validates the enrollment token for the cluster and the klusterletconfig.
+func loadEnrollmentToken(ctx context.Context, kubeClient kubernetes.Interface, ns, token, clusterID,
+	klusterletConfig string, now time.Time) (*corev1.Secret, *enrollmentToken, error) {
+	secret, err := getEnrollmentToken(ctx, kubeClient, ns, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret == nil {
+		return nil, nil, fmt.Errorf("%w: the enrollment token is not found", errEnrollmentTokenRejected)
+	}
+
+	t, err := newEnrollmentToken(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := t.validate(now, clusterID, klusterletConfig); err != nil {
+		return nil, nil, err
+	}
+	return secret, t, nil
+}
+
+// checkEnrollmentToken returns the klusterletconfig bound to the token if the token can enroll the cluster, the
+// token is not consumed.
+func checkEnrollmentToken(ctx context.Context, kubeClient kubernetes.Interface, ns, token, clusterID,
+	klusterletConfig string) (string, error) {
+	_, t, err := loadEnrollmentToken(ctx, kubeClient, ns, token, clusterID, klusterletConfig, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return t.klusterletConfig, nil
+}
+
+// consumeEnrollmentToken records the cluster enrollment in the token secret. The secret is updated with its
+// resource version, so the token cannot be used more than its max uses by the concurrent requests. It returns
+// the klusterletconfig bound to the token.
+func consumeEnrollmentToken(ctx context.Context, kubeClient kubernetes.Interface, ns, token, clusterID,
+	klusterletConfig, remoteAddr string) (string, error) {
+	var boundKlusterletConfig string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		now := time.Now()
+		secret, t, err := loadEnrollmentToken(ctx, kubeClient, ns, token, clusterID, klusterletConfig, now)
+		if err != nil {
+			return err
+		}
+
+		enrollments, err := json.Marshal(append(t.enrollments, enrollment{
+			ClusterID:  clusterID,
+			Time:       now.UTC().Format(time.RFC3339),
+			RemoteAddr: remoteAddr,
+		}))
+		if err != nil {
+			return err
+		}
+
+		secret = secret.DeepCopy()
+		secret.Data[EnrollmentTokenEnrollmentsKey] = enrollments
+		if _, err := kubeClient.CoreV1().Secrets(ns).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+
+		boundKlusterletConfig = t.klusterletConfig
+		klog.Infof("The cluster %s is enrolled with the enrollment token %s (%d/%d) from %s",
+			clusterID, t.id, len(t.enrollments)+1, t.maxUses, remoteAddr)
+		return nil
+	})
+	return boundKlusterletConfig, err
+}
@@ -0,0 +1,239 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace       = "multicluster-engine"
+	testEnrollmentToken = "abcdef.0123456789abcdef"
+)
+
+func newEnrollmentTokenSecret(data map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: EnrollmentTokenSecretPrefix + "abcdef", Namespace: testNamespace},
+		Type:       EnrollmentTokenSecretType,
+		Data:       map[string][]byte{EnrollmentTokenSecretKey: []byte("0123456789abcdef")},
+	}
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+	return secret
+}
+
+func TestParseEnrollmentToken(t *testing.T) {
+	cases := []struct {
+		token      string
+		expectedOK bool
+	}{
+		{token: testEnrollmentToken, expectedOK: true},
+		{token: "abcdef0123456789abcdef"},
+		{token: "ABCDEF.0123456789abcdef"},
+		{token: "eyJhbGciOiJSUzI1NiIsImtpZCI6IiJ9.eyJpc3MiOiJrdWJlcm5ldGVzIn0.c2ln"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.token, func(t *testing.T) {
+			if _, _, ok := parseEnrollmentToken(c.token); ok != c.expectedOK {
+				t.Errorf("expected %v, but got %v", c.expectedOK, ok)
+			}
+		})
+	}
+}
+
+func TestConsumeEnrollmentToken(t *testing.T) {
+	cases := []struct {
+		name                  string
+		secret                *corev1.Secret
+		token                 string
+		clusterIDs            []string
+		klusterletConfig      string
+		expectedKlusterletCfg string
+		expectedEnrolled      int
+		expectedRejected      bool
+	}{
+		{
+			name:             "not found",
+			token:            testEnrollmentToken,
+			clusterIDs:       []string{"cluster1"},
+			expectedRejected: true,
+		},
+		{
+			name:             "wrong secret",
+			secret:           newEnrollmentTokenSecret(nil),
+			token:            "abcdef.fedcba9876543210",
+			clusterIDs:       []string{"cluster1"},
+			expectedRejected: true,
+		},
+		{
+			name:             "single use",
+			secret:           newEnrollmentTokenSecret(nil),
+			token:            testEnrollmentToken,
+			clusterIDs:       []string{"cluster1", "cluster2"},
+			expectedEnrolled: 1,
+			expectedRejected: true,
+		},
+		{
+			name:             "max uses",
+			secret:           newEnrollmentTokenSecret(map[string]string{EnrollmentTokenMaxUsesKey: "2"}),
+			token:            testEnrollmentToken,
+			clusterIDs:       []string{"cluster1", "cluster2"},
+			expectedEnrolled: 2,
+		},
+		{
+			name: "expired",
+			secret: newEnrollmentTokenSecret(map[string]string{
+				EnrollmentTokenExpirationKey: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+			}),
+			token:            testEnrollmentToken,
+			clusterIDs:       []string{"cluster1"},
+			expectedRejected: true,
+		},
+		{
+			name:             "cluster id pattern",
+			secret:           newEnrollmentTokenSecret(map[string]string{EnrollmentTokenClusterIDPatternKey: "edge-*"}),
+			token:            testEnrollmentToken,
+			clusterIDs:       []string{"cluster1"},
+			expectedRejected: true,
+		},
+		{
+			name: "bound klusterletconfig",
+			secret: newEnrollmentTokenSecret(map[string]string{
+				EnrollmentTokenClusterIDPatternKey: "edge-*",
+				EnrollmentTokenKlusterletConfigKey: "edge",
+			}),
+			token:                 testEnrollmentToken,
+			clusterIDs:            []string{"edge-1"},
+			expectedKlusterletCfg: "edge",
+			expectedEnrolled:      1,
+		},
+		{
+			name:             "klusterletconfig not allowed",
+			secret:           newEnrollmentTokenSecret(map[string]string{EnrollmentTokenKlusterletConfigKey: "edge"}),
+			token:            testEnrollmentToken,
+			clusterIDs:       []string{"cluster1"},
+			klusterletConfig: "default",
+			expectedRejected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := kubefake.NewSimpleClientset()
+			if c.secret != nil {
+				kubeClient = kubefake.NewSimpleClientset(c.secret)
+			}
+
+			var err error
+			for _, clusterID := range c.clusterIDs {
+				var klusterletConfig string
+				klusterletConfig, err = consumeEnrollmentToken(context.TODO(), kubeClient, testNamespace, c.token,
+					clusterID, c.klusterletConfig, "127.0.0.1:8080")
+				if err != nil {
+					break
+				}
+				if klusterletConfig != c.expectedKlusterletCfg {
+					t.Errorf("expected klusterletconfig %q, but got %q", c.expectedKlusterletCfg, klusterletConfig)
+				}
+			}
+			if errors.Is(err, errEnrollmentTokenRejected) != c.expectedRejected {
+				t.Errorf("expected rejected %v, but got %v", c.expectedRejected, err)
+			}
+
+			if c.secret == nil {
+				return
+			}
+			secret, err := kubeClient.CoreV1().Secrets(testNamespace).Get(context.TODO(), c.secret.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			enrollments := []enrollment{}
+			if data := secret.Data[EnrollmentTokenEnrollmentsKey]; len(data) > 0 {
+				if err := json.Unmarshal(data, &enrollments); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if len(enrollments) != c.expectedEnrolled {
+				t.Errorf("expected %d enrollments, but got %v", c.expectedEnrolled, enrollments)
+			}
+			for i, e := range enrollments {
+				if e.ClusterID != c.clusterIDs[i] {
+					t.Errorf("expected cluster %s enrolled, but got %s", c.clusterIDs[i], e.ClusterID)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareEnrollmentToken(t *testing.T) {
+	t.Setenv(constants.PodNamespaceEnvVarName, testNamespace)
+
+	usedUp := newEnrollmentTokenSecret(map[string]string{
+		EnrollmentTokenEnrollmentsKey: `[{"clusterID":"cluster1","time":"2024-01-01T00:00:00Z"}]`,
+	})
+
+	cases := []struct {
+		name           string
+		secret         *corev1.Secret
+		expectedStatus int
+		expectedToken  string
+	}{
+		{
+			name:           "valid token",
+			secret:         newEnrollmentTokenSecret(nil),
+			expectedStatus: http.StatusOK,
+			expectedToken:  testEnrollmentToken,
+		},
+		{
+			name:           "used up token",
+			secret:         usedUp,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			// the token falls back to the TokenReview, which is not authenticated by the fake client
+			name:           "unknown token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := kubefake.NewSimpleClientset()
+			if c.secret != nil {
+				kubeClient = kubefake.NewSimpleClientset(c.secret)
+			}
+
+			var token string
+			handler := newAuthMiddleware(&helpers.ClientHolder{KubeClient: kubeClient}, NewServerOptions())(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					token, _ = r.Context().Value(enrollmentTokenContextKey{}).(string)
+				}))
+
+			req := httptest.NewRequest(http.MethodGet, "/agent-registration/manifests/cluster1", nil)
+			req.Header.Set("Authorization", "Bearer "+testEnrollmentToken)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != c.expectedStatus {
+				t.Errorf("expected status %d, but got %d: %s", c.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if token != c.expectedToken {
+				t.Errorf("expected token %q in the context, but got %q", c.expectedToken, token)
+			}
+		})
+	}
+}
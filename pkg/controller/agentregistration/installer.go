@@ -0,0 +1,61 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/bootstrap"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+)
+
+//go:embed installer.sh
+var installerTemplate []byte
+
+// installerDelimiter is the delimiter of the here-documents that embed the manifests in the installer.
+const installerDelimiter = "KLUSTERLET_MANIFESTS_EOF"
+
+type installerConfig struct {
+	ClusterName         string
+	GeneratedAt         string
+	ExpirationTimestamp string
+	Delimiter           string
+	CRDs                string
+	Manifests           string
+	KlusterletName      string
+	KlusterletNamespace string
+	NoOperator          bool
+}
+
+// generateInstaller renders a POSIX shell installer of the klusterlet, it applies the CRDs first and waits for
+// them to be established, then applies the manifests and waits for the klusterlet to be available.
+func generateInstaller(ctx context.Context, clientHolder *helpers.ClientHolder,
+	config *bootstrap.KlusterletManifestsConfig, credential *bootstrap.BootstrapCredential) ([]byte, error) {
+	manifests, crds, _, err := config.Generate(ctx, clientHolder)
+	if err != nil {
+		return nil, err
+	}
+
+	delimiter := []byte(installerDelimiter)
+	if bytes.Contains(manifests, delimiter) || bytes.Contains(crds, delimiter) {
+		return nil, fmt.Errorf("the manifests of the cluster %s contain the installer delimiter %s",
+			credential.ClusterName, installerDelimiter)
+	}
+
+	klusterletName, klusterletNamespace := config.KlusterletNamespaceName()
+	return helpers.CreateAssetFromTemplate("installer", installerTemplate, installerConfig{
+		ClusterName:         credential.ClusterName,
+		GeneratedAt:         time.Now().UTC().Format(time.RFC3339),
+		ExpirationTimestamp: credential.ExpirationTimestamp,
+		Delimiter:           installerDelimiter,
+		CRDs:                string(bytes.TrimRight(crds, "\n")),
+		Manifests:           string(bytes.TrimRight(manifests, "\n")),
+		KlusterletName:      klusterletName,
+		KlusterletNamespace: klusterletNamespace,
+		NoOperator:          config.NoOperator(),
+	})
+}
@@ -0,0 +1,76 @@
+#!/bin/sh
+# The klusterlet installer of the cluster {{ .ClusterName }}, it is generated by the agent-registration server at
+# {{ .GeneratedAt }}.
+{{- if .ExpirationTimestamp }}
+# The bootstrap token in the installer expires at {{ .ExpirationTimestamp }}.
+{{- end }}
+#
+# Environment variables:
+#   KUBECTL          the kubectl command, the default is kubectl
+#   TIMEOUT_SECONDS  the timeout to wait for the CRDs and the klusterlet, the default is 600
+set -eu
+
+KUBECTL="${KUBECTL:-kubectl}"
+TIMEOUT_SECONDS="${TIMEOUT_SECONDS:-600}"
+POLL_INTERVAL_SECONDS=5
+
+CLUSTER_NAME={{ .ClusterName | shellQuote }}
+KLUSTERLET_NAME={{ .KlusterletName | shellQuote }}
+KLUSTERLET_NAMESPACE={{ .KlusterletNamespace | shellQuote }}
+
+log() {
+  echo "[klusterlet-installer] $*" >&2
+}
+
+fail() {
+  log "ERROR: $*"
+  exit 1
+}
+
+command -v "${KUBECTL}" >/dev/null 2>&1 || fail "${KUBECTL} is not found"
+
+workdir="$(mktemp -d)"
+trap 'rm -rf "${workdir}"' EXIT
+
+cat > "${workdir}/crds.yaml" <<'{{ .Delimiter }}'
+{{ .CRDs }}
+{{ .Delimiter }}
+
+cat > "${workdir}/import.yaml" <<'{{ .Delimiter }}'
+{{ .Manifests }}
+{{ .Delimiter }}
+
+log "Applying the klusterlet CRDs"
+"${KUBECTL}" apply -f "${workdir}/crds.yaml" || fail "failed to apply the klusterlet CRDs"
+
+log "Waiting for the klusterlet CRDs to be established"
+"${KUBECTL}" wait --for condition=established --timeout="${TIMEOUT_SECONDS}s" -f "${workdir}/crds.yaml" ||
+  fail "the klusterlet CRDs are not established in ${TIMEOUT_SECONDS}s"
+
+log "Applying the klusterlet manifests of the cluster ${CLUSTER_NAME}"
+"${KUBECTL}" apply -f "${workdir}/import.yaml" || fail "failed to apply the klusterlet manifests"
+
+{{- if .NoOperator }}
+
+log "Waiting for the klusterlet agents in the namespace ${KLUSTERLET_NAMESPACE} to be available"
+"${KUBECTL}" -n "${KLUSTERLET_NAMESPACE}" wait --for condition=available --timeout="${TIMEOUT_SECONDS}s" deployment --all ||
+  fail "the klusterlet agents are not available in ${TIMEOUT_SECONDS}s"
+{{- else }}
+
+log "Waiting for the klusterlet ${KLUSTERLET_NAME} to be available"
+elapsed=0
+while :; do
+  available="$("${KUBECTL}" get klusterlet "${KLUSTERLET_NAME}" \
+    -o jsonpath='{.status.conditions[?(@.type=="Available")].status}' 2>/dev/null || true)"
+  if [ "${available}" = "True" ]; then
+    break
+  fi
+  if [ "${elapsed}" -ge "${TIMEOUT_SECONDS}" ]; then
+    fail "the klusterlet ${KLUSTERLET_NAME} is not available in ${TIMEOUT_SECONDS}s"
+  fi
+  sleep "${POLL_INTERVAL_SECONDS}"
+  elapsed=$((elapsed + POLL_INTERVAL_SECONDS))
+done
+{{- end }}
+
+log "The klusterlet of the cluster ${CLUSTER_NAME} is installed"
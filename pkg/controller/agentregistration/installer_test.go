@@ -0,0 +1,103 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+)
+
+func TestInstallerTemplate(t *testing.T) {
+	cases := []struct {
+		name             string
+		config           installerConfig
+		expectedContents []string
+	}{
+		{
+			name: "default mode",
+			config: installerConfig{
+				ClusterName:         "cluster1",
+				GeneratedAt:         "2026-01-01T00:00:00Z",
+				ExpirationTimestamp: "2026-01-02T00:00:00Z",
+				Delimiter:           installerDelimiter,
+				CRDs:                "kind: CustomResourceDefinition",
+				Manifests:           "kind: Klusterlet",
+				KlusterletName:      "klusterlet",
+				KlusterletNamespace: "open-cluster-management-agent",
+			},
+			expectedContents: []string{
+				"# The bootstrap token in the installer expires at 2026-01-02T00:00:00Z.",
+				"CLUSTER_NAME='cluster1'",
+				"KLUSTERLET_NAME='klusterlet'",
+				`get klusterlet "${KLUSTERLET_NAME}"`,
+			},
+		},
+		{
+			name: "no operator",
+			config: installerConfig{
+				ClusterName:         "cluster1",
+				GeneratedAt:         "2026-01-01T00:00:00Z",
+				Delimiter:           installerDelimiter,
+				CRDs:                "kind: CustomResourceDefinition",
+				Manifests:           "kind: Deployment",
+				KlusterletName:      "klusterlet-cluster1",
+				KlusterletNamespace: "open-cluster-management-cluster1",
+				NoOperator:          true,
+			},
+			expectedContents: []string{
+				"KLUSTERLET_NAMESPACE='open-cluster-management-cluster1'",
+				`-n "${KLUSTERLET_NAMESPACE}" wait --for condition=available`,
+			},
+		},
+		{
+			name: "quoted values",
+			config: installerConfig{
+				ClusterName:         "cluster1",
+				GeneratedAt:         "2026-01-01T00:00:00Z",
+				Delimiter:           installerDelimiter,
+				CRDs:                "kind: CustomResourceDefinition",
+				Manifests:           "kind: Klusterlet",
+				KlusterletName:      `klusterlet"; touch /tmp/installed; echo "`,
+				KlusterletNamespace: "open-cluster-management-agent'$(id)'",
+			},
+			expectedContents: []string{
+				`KLUSTERLET_NAME='klusterlet"; touch /tmp/installed; echo "'`,
+				`KLUSTERLET_NAMESPACE='open-cluster-management-agent'\''$(id)'\'''`,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			installer, err := helpers.CreateAssetFromTemplate("installer", installerTemplate, c.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			script := string(installer)
+
+			crds := strings.Index(script, c.config.CRDs)
+			manifests := strings.Index(script, c.config.Manifests)
+			if crds < 0 || manifests < 0 || crds > manifests {
+				t.Errorf("expected the CRDs are written before the manifests:\n%s", script)
+			}
+			for _, content := range c.expectedContents {
+				if !strings.Contains(script, content) {
+					t.Errorf("expected %q in the installer:\n%s", content, script)
+				}
+			}
+			if len(c.config.ExpirationTimestamp) == 0 && strings.Contains(script, "expires at") {
+				t.Errorf("unexpected expiration in the installer:\n%s", script)
+			}
+
+			if _, err := exec.LookPath("sh"); err != nil {
+				return
+			}
+			if output, err := exec.Command("sh", "-n", "-c", script).CombinedOutput(); err != nil {
+				t.Errorf("invalid installer: %v: %s", err, string(output))
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+// The query parameters to customize how the klusterlet is installed, they have the same meaning as the
+// annotations of a managed cluster imported by the importconfig controller. The options other than the defaults
+// are persisted as the annotations of the pre-created ManagedCluster, so the import controller renders the
+// klusterlet in the same way after the cluster joins.
+const (
+	// installModeQueryParam is the install mode of the klusterlet: Default, Singleton, Hosted or SingletonHosted.
+	// The Default mode is used if it is not set.
+	installModeQueryParam = "mode"
+	// klusterletNamespaceQueryParam is the namespace to deploy the agent on the managed cluster, it must have a
+	// prefix of "open-cluster-management-".
+	klusterletNamespaceQueryParam = "klusterletNamespace"
+	// hostingClusterQueryParam is the managed cluster that hosts the klusterlet, it is required in the Hosted
+	// modes.
+	hostingClusterQueryParam = "hostingCluster"
+	// priorityClassQueryParam is the priority class of the klusterlet. By default, the klusterlet-critical is
+	// used in the Hosted modes and none is used in the others. Only the klusterlet-critical can be requested,
+	// because the import controller does not preserve the others.
+	priorityClassQueryParam = "priorityClass"
+)
+
+const klusterletNamespacePrefix = "open-cluster-management-"
+
+// installOptions is the klusterlet install options of an agent-registration request.
+type installOptions struct {
+	mode                operatorv1.InstallMode
+	klusterletNamespace string
+	hostingClusterName  string
+	priorityClassName   string
+}
+
+// parseInstallMode parses and validates the install mode from the query parameters of a request.
+func parseInstallMode(query url.Values) (operatorv1.InstallMode, error) {
+	mode := operatorv1.InstallModeDefault
+	if value := query.Get(installModeQueryParam); len(value) > 0 {
+		// determine the mode in the same way as the managed cluster annotation
+		mode = helpers.DetermineKlusterletMode(&clusterv1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{constants.KlusterletDeployModeAnnotation: value},
+			},
+		})
+		if mode == "Unknown" {
+			return "", fmt.Errorf("klusterlet deploy mode %s not supported", value)
+		}
+	}
+	if err := helpers.ValidateKlusterletMode(mode); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// parseInstallOptions parses and validates the install options from the query parameters of a request.
+func parseInstallOptions(query url.Values) (*installOptions, error) {
+	mode, err := parseInstallMode(query)
+	if err != nil {
+		return nil, err
+	}
+	options := &installOptions{
+		mode:                mode,
+		klusterletNamespace: query.Get(klusterletNamespaceQueryParam),
+		hostingClusterName:  query.Get(hostingClusterQueryParam),
+		priorityClassName:   query.Get(priorityClassQueryParam),
+	}
+
+	if len(options.klusterletNamespace) > 0 {
+		if !strings.HasPrefix(options.klusterletNamespace, klusterletNamespacePrefix) {
+			return nil, fmt.Errorf("invalid klusterlet namespace %q: it must have a prefix of %q",
+				options.klusterletNamespace, klusterletNamespacePrefix)
+		}
+		if errs := validation.IsDNS1123Label(options.klusterletNamespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid klusterlet namespace %q: %s",
+				options.klusterletNamespace, strings.Join(errs, ", "))
+		}
+	}
+
+	switch {
+	case options.isHosted() && len(options.hostingClusterName) == 0:
+		return nil, fmt.Errorf("the %s is required in the %s mode", hostingClusterQueryParam, options.mode)
+	case !options.isHosted() && len(options.hostingClusterName) > 0:
+		return nil, fmt.Errorf("the %s is only supported in the Hosted modes", hostingClusterQueryParam)
+	case len(options.hostingClusterName) > 0:
+		if errs := validation.IsDNS1123Label(options.hostingClusterName); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid hosting cluster %q: %s",
+				options.hostingClusterName, strings.Join(errs, ", "))
+		}
+	}
+
+	if len(options.priorityClassName) > 0 {
+		if options.priorityClassName != constants.DefaultKlusterletPriorityClassName {
+			return nil, fmt.Errorf("invalid priority class %q: only %s is supported",
+				options.priorityClassName, constants.DefaultKlusterletPriorityClassName)
+		}
+	} else if options.isHosted() {
+		// the hosting cluster should support PriorityClass API and have already had the default PriorityClass
+		options.priorityClassName = constants.DefaultKlusterletPriorityClassName
+	}
+
+	return options, nil
+}
+
+func (o *installOptions) isHosted() bool {
+	return o.mode == operatorv1.InstallModeHosted || o.mode == operatorv1.InstallModeSingletonHosted
+}
+
+// annotations returns the annotations of the ManagedCluster that persist the install options other than the
+// defaults, it is empty if all of the options are the defaults.
+func (o *installOptions) annotations() map[string]string {
+	annotations := map[string]string{}
+	if o.mode != operatorv1.InstallModeDefault {
+		annotations[constants.KlusterletDeployModeAnnotation] = string(o.mode)
+	}
+	if len(o.klusterletNamespace) > 0 {
+		annotations[constants.KlusterletNamespaceAnnotation] = o.klusterletNamespace
+	}
+	if len(o.hostingClusterName) > 0 {
+		annotations[constants.HostingClusterNameAnnotation] = o.hostingClusterName
+	}
+	return annotations
+}
+
+// managedCluster returns a managed cluster with the annotations of the install options, it is only used to
+// render the manifests.
+func (o *installOptions) managedCluster(clusterName string) *clusterv1.ManagedCluster {
+	annotations := o.annotations()
+	annotations[constants.KlusterletDeployModeAnnotation] = string(o.mode)
+	return &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        clusterName,
+			Annotations: annotations,
+		},
+	}
+}
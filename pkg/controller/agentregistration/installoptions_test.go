@@ -0,0 +1,130 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+func TestParseInstallOptions(t *testing.T) {
+	cases := []struct {
+		name                   string
+		query                  string
+		expectedMode           operatorv1.InstallMode
+		expectedNamespace      string
+		expectedHostingCluster string
+		expectedPriorityClass  string
+		expectedErr            bool
+	}{
+		{
+			name:         "default",
+			query:        "",
+			expectedMode: operatorv1.InstallModeDefault,
+		},
+		{
+			name:              "singleton",
+			query:             "mode=singleton&klusterletNamespace=open-cluster-management-edge",
+			expectedMode:      operatorv1.InstallModeSingleton,
+			expectedNamespace: "open-cluster-management-edge",
+		},
+		{
+			name:                   "hosted",
+			query:                  "mode=Hosted&hostingCluster=local-cluster",
+			expectedMode:           operatorv1.InstallModeHosted,
+			expectedHostingCluster: "local-cluster",
+			expectedPriorityClass:  constants.DefaultKlusterletPriorityClassName,
+		},
+		{
+			name:                  "singleton with priority class",
+			query:                 "mode=Singleton&priorityClass=klusterlet-critical",
+			expectedMode:          operatorv1.InstallModeSingleton,
+			expectedPriorityClass: constants.DefaultKlusterletPriorityClassName,
+		},
+		{
+			name:        "hosted without hosting cluster",
+			query:       "mode=SingletonHosted",
+			expectedErr: true,
+		},
+		{
+			name:        "hosting cluster in default mode",
+			query:       "hostingCluster=local-cluster",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid hosting cluster",
+			query:       "mode=Hosted&hostingCluster=Local_Cluster",
+			expectedErr: true,
+		},
+		{
+			name:        "priority class not preserved",
+			query:       "mode=Hosted&hostingCluster=local-cluster&priorityClass=system-cluster-critical",
+			expectedErr: true,
+		},
+		{
+			name:        "unknown mode",
+			query:       "mode=Detached",
+			expectedErr: true,
+		},
+		{
+			name:        "namespace without prefix",
+			query:       "klusterletNamespace=agent",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid namespace",
+			query:       "klusterletNamespace=open-cluster-management-Edge",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query, err := url.ParseQuery(c.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			options, err := parseInstallOptions(query)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if options.mode != c.expectedMode {
+				t.Errorf("expected mode %s, but got %s", c.expectedMode, options.mode)
+			}
+			if options.klusterletNamespace != c.expectedNamespace {
+				t.Errorf("expected namespace %q, but got %q", c.expectedNamespace, options.klusterletNamespace)
+			}
+			if options.priorityClassName != c.expectedPriorityClass {
+				t.Errorf("expected priority class %q, but got %q", c.expectedPriorityClass, options.priorityClassName)
+			}
+
+			if options.hostingClusterName != c.expectedHostingCluster {
+				t.Errorf("expected hosting cluster %q, but got %q", c.expectedHostingCluster, options.hostingClusterName)
+			}
+
+			annotations := options.annotations()
+			if _, ok := annotations[constants.KlusterletDeployModeAnnotation]; ok != (c.expectedMode != operatorv1.InstallModeDefault) {
+				t.Errorf("unexpected install annotations %v", annotations)
+			}
+			if annotations[constants.HostingClusterNameAnnotation] != c.expectedHostingCluster {
+				t.Errorf("unexpected install annotations %v", annotations)
+			}
+
+			cluster := options.managedCluster("cluster1")
+			if cluster.Annotations[constants.KlusterletDeployModeAnnotation] != string(c.expectedMode) {
+				t.Errorf("unexpected cluster annotations %v", cluster.Annotations)
+			}
+			if ns, ok := cluster.Annotations[constants.KlusterletNamespaceAnnotation]; ok != (len(c.expectedNamespace) > 0) ||
+				ns != c.expectedNamespace {
+				t.Errorf("unexpected cluster annotations %v", cluster.Annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// authCacheRequestsTotal is the number of the auth cache lookups by the result (hit or miss).
+	authCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_registration_auth_cache_requests_total",
+		Help: "Total number of the agent-registration auth cache lookups by the result (hit or miss).",
+	}, []string{"result"})
+
+	// rejectedRequestsTotal is the number of the rejected requests by the reason (rate_limited,
+	// unauthenticated, unauthorized or error).
+	rejectedRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_registration_rejected_requests_total",
+		Help: "Total number of the rejected agent-registration requests by the reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(authCacheRequestsTotal, rejectedRequestsTotal)
+}
@@ -0,0 +1,207 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/wait"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+)
+
+const clientCAReloadInterval = 10 * time.Second
+
+// ServerOptions is the serving options of the agent-registration server.
+type ServerOptions struct {
+	Port          int
+	CertFile      string
+	KeyFile       string
+	MinTLSVersion string
+	CipherSuites  []string
+	// ClientCAFile enables the mTLS, the client certificates signed by the CA are accepted as an alternative
+	// to the bearer tokens. The registered agents can refresh their manifests with their hub client
+	// certificates if the CA signs the hub client certificates. The TLS connections must be passed through to
+	// the server, e.g. the passthrough route in deploy/agentregistration-mtls, a proxy that terminates the TLS
+	// drops the client certificates.
+	ClientCAFile string
+
+	// AuthCacheSize is the max number of the cached auth results, the cache is disabled if it is 0.
+	AuthCacheSize        int
+	AuthCacheTTL         time.Duration
+	AuthCacheNegativeTTL time.Duration
+	// RateLimitQPS and RateLimitBurst limit the requests of each client credential, the rate limiting is disabled
+	// if the qps is 0.
+	RateLimitQPS   float64
+	RateLimitBurst int
+	// ClientAddressRateLimitQPS and ClientAddressRateLimitBurst limit the requests of each client address before
+	// they are authenticated, the limits are looser than the ones of the credentials because the clients behind a
+	// NAT or a proxy share the address. The rate limiting is disabled if the qps is 0.
+	ClientAddressRateLimitQPS   float64
+	ClientAddressRateLimitBurst int
+	// TrustedProxies are the CIDRs of the proxies in front of the server, e.g. the router, the client address of
+	// the requests from them is read from the X-Forwarded-For header.
+	TrustedProxies []net.IPNet
+
+	// HubAcceptsClient is the hubAcceptsClient of the ManagedClusters pre-created with the requested metadata.
+	HubAcceptsClient bool
+}
+
+func NewServerOptions() *ServerOptions {
+	return &ServerOptions{
+		Port:          9091,
+		CertFile:      "/server/tls.crt",
+		KeyFile:       "/server/tls.key",
+		MinTLSVersion: "VersionTLS12",
+
+		AuthCacheSize:        4096,
+		AuthCacheTTL:         2 * time.Minute,
+		AuthCacheNegativeTTL: 30 * time.Second,
+		RateLimitQPS:         5,
+		RateLimitBurst:       20,
+
+		ClientAddressRateLimitQPS:   50,
+		ClientAddressRateLimitBurst: 200,
+
+		HubAcceptsClient: true,
+	}
+}
+
+func (o *ServerOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&o.Port, "agent-registration-port", o.Port, "the port of the agent-registration server")
+	fs.StringVar(&o.CertFile, "agent-registration-tls-cert-file", o.CertFile,
+		"the serving certificate of the agent-registration server, it is reloaded when the file changes")
+	fs.StringVar(&o.KeyFile, "agent-registration-tls-key-file", o.KeyFile,
+		"the serving key of the agent-registration server, it is reloaded when the file changes")
+	fs.StringVar(&o.MinTLSVersion, "agent-registration-tls-min-version", o.MinTLSVersion,
+		fmt.Sprintf("the minimum TLS version of the agent-registration server, possible values: %v",
+			cliflag.TLSPossibleVersions()))
+	fs.StringSliceVar(&o.CipherSuites, "agent-registration-tls-cipher-suites", o.CipherSuites,
+		"the cipher suites of the agent-registration server, the default Go cipher suites are used if it is empty")
+	fs.StringVar(&o.ClientCAFile, "agent-registration-client-ca-file", o.ClientCAFile,
+		"if set, the client certificates signed by the CA are accepted by the agent-registration server, "+
+			"the server must be exposed with the TLS passthrough")
+	fs.IntVar(&o.AuthCacheSize, "agent-registration-auth-cache-size", o.AuthCacheSize,
+		"the max number of the cached TokenReview and SubjectAccessReview results, 0 disables the cache")
+	fs.DurationVar(&o.AuthCacheTTL, "agent-registration-auth-cache-ttl", o.AuthCacheTTL,
+		"how long the allowed requests are cached")
+	fs.DurationVar(&o.AuthCacheNegativeTTL, "agent-registration-auth-cache-negative-ttl", o.AuthCacheNegativeTTL,
+		"how long the unauthenticated and unauthorized requests are cached")
+	fs.Float64Var(&o.RateLimitQPS, "agent-registration-rate-limit-qps", o.RateLimitQPS,
+		"the requests per second allowed for each client credential, 0 disables the rate limiting")
+	fs.IntVar(&o.RateLimitBurst, "agent-registration-rate-limit-burst", o.RateLimitBurst,
+		"the burst of the requests allowed for each client credential")
+	fs.Float64Var(&o.ClientAddressRateLimitQPS, "agent-registration-client-address-rate-limit-qps",
+		o.ClientAddressRateLimitQPS,
+		"the requests per second allowed for each client address before the authentication, 0 disables the rate limiting")
+	fs.IntVar(&o.ClientAddressRateLimitBurst, "agent-registration-client-address-rate-limit-burst",
+		o.ClientAddressRateLimitBurst, "the burst of the requests allowed for each client address before the authentication")
+	fs.IPNetSliceVar(&o.TrustedProxies, "agent-registration-trusted-proxies", o.TrustedProxies,
+		"the CIDRs of the proxies in front of the agent-registration server, the client address of the requests "+
+			"from them is read from the X-Forwarded-For header")
+	fs.BoolVar(&o.HubAcceptsClient, "agent-registration-hub-accepts-client", o.HubAcceptsClient,
+		"whether the hub accepts the ManagedClusters pre-created with the requested labels, annotations or clusterset")
+}
+
+// tlsConfig builds the TLS config of the server with the reloaded serving certificate and client CA.
+func (o *ServerOptions) tlsConfig(ctx context.Context) (*tls.Config, error) {
+	minVersion, err := cliflag.TLSVersion(o.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := cliflag.TLSCipherSuites(o.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	certWatcher, err := certwatcher.New(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := certWatcher.Start(ctx); err != nil {
+			klog.Errorf("failed to watch the agent-registration serving certificate: %v", err)
+		}
+	}()
+
+	config := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: certWatcher.GetCertificate,
+	}
+
+	if len(o.ClientCAFile) == 0 {
+		return config, nil
+	}
+
+	caWatcher := &clientCAWatcher{file: o.ClientCAFile}
+	if err := caWatcher.load(); err != nil {
+		return nil, err
+	}
+	go wait.UntilWithContext(ctx, func(_ context.Context) {
+		if err := caWatcher.load(); err != nil {
+			klog.Errorf("failed to reload the agent-registration client CA: %v", err)
+		}
+	}, clientCAReloadInterval)
+
+	// the client certificate is optional, the request without it is authenticated with the bearer token
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		clientConfig := config.Clone()
+		clientConfig.GetConfigForClient = nil
+		clientConfig.ClientCAs = caWatcher.pool()
+		return clientConfig, nil
+	}
+	return config, nil
+}
+
+// clientCAWatcher caches the client CA pool, the pool is rebuilt when the CA file content changes.
+type clientCAWatcher struct {
+	file string
+
+	lock     sync.RWMutex
+	data     []byte
+	certPool *x509.CertPool
+}
+
+func (w *clientCAWatcher) load() error {
+	data, err := os.ReadFile(w.file)
+	if err != nil {
+		return err
+	}
+
+	w.lock.RLock()
+	unchanged := bytes.Equal(data, w.data)
+	w.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no valid certificate is found in the client CA file %s", w.file)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.data = data
+	w.certPool = certPool
+	klog.Infof("The agent-registration client CA %s is loaded", w.file)
+	return nil
+}
+
+func (w *clientCAWatcher) pool() *x509.CertPool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.certPool
+}
@@ -0,0 +1,227 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func writeCertKey(t *testing.T, dir, host string) (string, string) {
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey(host, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certFile := filepath.Join(dir, host+".crt")
+	keyFile := filepath.Join(dir, host+".key")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerOptionsTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertKey(t, dir, "server")
+	caFile, _ := writeCertKey(t, dir, "client-ca")
+
+	cases := []struct {
+		name              string
+		options           *ServerOptions
+		expectedErr       bool
+		expectedMinTLS    uint16
+		expectedClientCAs bool
+	}{
+		{
+			name:           "default",
+			options:        &ServerOptions{CertFile: certFile, KeyFile: keyFile, MinTLSVersion: "VersionTLS12"},
+			expectedMinTLS: tls.VersionTLS12,
+		},
+		{
+			name: "min version and cipher suites",
+			options: &ServerOptions{CertFile: certFile, KeyFile: keyFile, MinTLSVersion: "VersionTLS13",
+				CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			expectedMinTLS: tls.VersionTLS13,
+		},
+		{
+			name:        "invalid min version",
+			options:     &ServerOptions{CertFile: certFile, KeyFile: keyFile, MinTLSVersion: "VersionTLS99"},
+			expectedErr: true,
+		},
+		{
+			name: "invalid cipher suites",
+			options: &ServerOptions{CertFile: certFile, KeyFile: keyFile, MinTLSVersion: "VersionTLS12",
+				CipherSuites: []string{"TLS_UNKNOWN"}},
+			expectedErr: true,
+		},
+		{
+			name:        "missing certificate",
+			options:     &ServerOptions{CertFile: filepath.Join(dir, "none.crt"), KeyFile: keyFile, MinTLSVersion: "VersionTLS12"},
+			expectedErr: true,
+		},
+		{
+			name: "mtls",
+			options: &ServerOptions{CertFile: certFile, KeyFile: keyFile, MinTLSVersion: "VersionTLS12",
+				ClientCAFile: caFile},
+			expectedMinTLS:    tls.VersionTLS12,
+			expectedClientCAs: true,
+		},
+		{
+			name: "invalid client ca",
+			options: &ServerOptions{CertFile: certFile, KeyFile: keyFile, MinTLSVersion: "VersionTLS12",
+				ClientCAFile: keyFile},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			config, err := c.options.tlsConfig(ctx)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if config.MinVersion != c.expectedMinTLS {
+				t.Errorf("expected min version %d, but got %d", c.expectedMinTLS, config.MinVersion)
+			}
+			if cert, err := config.GetCertificate(nil); err != nil || cert == nil {
+				t.Errorf("expected the serving certificate, but got %v", err)
+			}
+
+			if !c.expectedClientCAs {
+				if config.GetConfigForClient != nil {
+					t.Errorf("expected no client CA")
+				}
+				return
+			}
+			if config.ClientAuth != tls.VerifyClientCertIfGiven {
+				t.Errorf("expected the client certificate is optional, but got %v", config.ClientAuth)
+			}
+			clientConfig, err := config.GetConfigForClient(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if clientConfig.ClientCAs == nil {
+				t.Errorf("expected the client CAs")
+			}
+		})
+	}
+}
+
+func TestClientCAWatcher(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _ := writeCertKey(t, dir, "ca1")
+	w := &clientCAWatcher{file: caFile}
+	if err := w.load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool := w.pool()
+
+	// the pool is not rebuilt if the file is not changed
+	if err := w.load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.pool() != pool {
+		t.Errorf("expected the pool is not changed")
+	}
+
+	newCAFile, _ := writeCertKey(t, dir, "ca2")
+	data, err := os.ReadFile(newCAFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(caFile, data, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.pool() == pool {
+		t.Errorf("expected the pool is reloaded")
+	}
+}
+
+func TestAuthMiddlewareClientCertificate(t *testing.T) {
+	cases := []struct {
+		name           string
+		tls            *tls.ConnectionState
+		allowed        bool
+		expectedStatus int
+		expectedUser   string
+	}{
+		{
+			name: "allowed client certificate",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+				Subject: pkix.Name{CommonName: "edge-admin", Organization: []string{"edge"}},
+			}}}},
+			allowed:        true,
+			expectedStatus: http.StatusOK,
+			expectedUser:   "edge-admin",
+		},
+		{
+			name: "denied client certificate",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+				Subject: pkix.Name{CommonName: "edge-admin"},
+			}}}},
+			expectedStatus: http.StatusUnauthorized,
+			expectedUser:   "edge-admin",
+		},
+		{
+			name:           "unverified client certificate",
+			tls:            &tls.ConnectionState{},
+			allowed:        true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var user string
+			kubeClient := kubefake.NewSimpleClientset()
+			kubeClient.PrependReactor("create", "subjectaccessreviews",
+				func(action clienttesting.Action) (bool, runtime.Object, error) {
+					sar := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+					user = sar.Spec.User
+					sar.Status.Allowed = c.allowed
+					return true, sar, nil
+				})
+
+			handler := newAuthMiddleware(&helpers.ClientHolder{KubeClient: kubeClient}, NewServerOptions())(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/agent-registration/manifests/cluster1", nil)
+			req.TLS = c.tls
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != c.expectedStatus {
+				t.Errorf("expected status %d, but got %d: %s", c.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if user != c.expectedUser {
+				t.Errorf("expected user %q, but got %q", c.expectedUser, user)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	apimachineryvalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
+)
+
+// The query parameters to request the metadata of the ManagedCluster, the ManagedCluster is pre-created with the
+// metadata before the agent registers.
+const (
+	// labelQueryParam is a label of the cluster in the format of <key>=<value>, it can be repeated. The requester
+	// must have the create permission of the cluster.
+	labelQueryParam = "label"
+	// annotationQueryParam is an annotation of the cluster in the format of <key>=<value>, it can be repeated.
+	annotationQueryParam = "annotation"
+	// clusterSetQueryParam is the ManagedClusterSet that the cluster joins, the requester must have the join
+	// permission of the clusterset.
+	clusterSetQueryParam = "clusterSet"
+)
+
+// reservedAnnotationPrefix is the prefix of the annotations that configure the import, they cannot be requested.
+const reservedAnnotationPrefix = "import.open-cluster-management.io/"
+
+type userInfoContextKey struct{}
+
+// errClusterAlreadyExists is returned if the requested cluster name is used by another cluster.
+var errClusterAlreadyExists = errors.New("the cluster already exists")
+
+// clusterMetadata is the metadata of the ManagedCluster requested by an agent-registration request.
+type clusterMetadata struct {
+	labels      map[string]string
+	annotations map[string]string
+	clusterSet  string
+}
+
+// parseClusterMetadata parses and validates the requested cluster metadata from the query parameters, it returns
+// nil if no metadata is requested.
+func parseClusterMetadata(query url.Values) (*clusterMetadata, error) {
+	if len(query[labelQueryParam]) == 0 && len(query[annotationQueryParam]) == 0 &&
+		len(query.Get(clusterSetQueryParam)) == 0 {
+		return nil, nil
+	}
+
+	metadata := &clusterMetadata{
+		labels:      map[string]string{},
+		annotations: map[string]string{},
+		clusterSet:  query.Get(clusterSetQueryParam),
+	}
+
+	for _, label := range query[labelQueryParam] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: it must be in the format of <key>=<value>", label)
+		}
+		if key == clusterv1beta2.ClusterSetLabel {
+			return nil, fmt.Errorf("invalid label %q: the clusterset is requested with the %s parameter",
+				label, clusterSetQueryParam)
+		}
+		metadata.labels[key] = value
+	}
+	if errs := metav1validation.ValidateLabels(metadata.labels, field.NewPath(labelQueryParam)); len(errs) > 0 {
+		return nil, errs.ToAggregate()
+	}
+
+	for _, annotation := range query[annotationQueryParam] {
+		key, value, ok := strings.Cut(annotation, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid annotation %q: it must be in the format of <key>=<value>", annotation)
+		}
+		if strings.HasPrefix(key, reservedAnnotationPrefix) || key == constants.CreatedViaAnnotation ||
+			key == apiconstants.AnnotationKlusterletConfig {
+			return nil, fmt.Errorf("invalid annotation %q: the annotation is reserved", annotation)
+		}
+		metadata.annotations[key] = value
+	}
+	if errs := apimachineryvalidation.ValidateAnnotations(
+		metadata.annotations, field.NewPath(annotationQueryParam)); len(errs) > 0 {
+		return nil, errs.ToAggregate()
+	}
+
+	if len(metadata.clusterSet) > 0 {
+		if errs := metav1validation.ValidateLabels(map[string]string{
+			clusterv1beta2.ClusterSetLabel: metadata.clusterSet,
+		}, field.NewPath(clusterSetQueryParam)); len(errs) > 0 {
+			return nil, errs.ToAggregate()
+		}
+		metadata.labels[clusterv1beta2.ClusterSetLabel] = metadata.clusterSet
+	}
+
+	return metadata, nil
+}
+
+// checkPreCreateManagedCluster checks if the ManagedCluster can be pre-created with the requested metadata and the
+// annotations of the install options. The cluster is created by the controller, so the requester must have the
+// join permission of the requested clusterset, and the create permission of the cluster if any label is requested.
+// The cluster name cannot be used by an existing cluster unless it is pre-created by the agent-registration with
+// the same install options and klusterletconfig and has not joined yet. It returns true if the cluster is already
+// pre-created, and the http status code if it cannot be pre-created.
+func checkPreCreateManagedCluster(ctx context.Context, clientHolder *helpers.ClientHolder, user authenticationv1.UserInfo,
+	clusterID string, metadata *clusterMetadata) (bool, int, error) {
+	if len(metadata.clusterSet) > 0 {
+		allowed, err := subjectAccessReview(ctx, clientHolder, user, &authorizationv1.ResourceAttributes{
+			Group:       clusterv1beta2.GroupName,
+			Resource:    "managedclustersets",
+			Subresource: "join",
+			Name:        metadata.clusterSet,
+			Verb:        "create",
+		})
+		if err != nil {
+			return false, http.StatusInternalServerError, err
+		}
+		if !allowed {
+			return false, http.StatusForbidden, fmt.Errorf("the user %s cannot join the clusterset %s",
+				user.Username, metadata.clusterSet)
+		}
+	}
+
+	// the labels may add the cluster to the clustersets that select the clusters by labels
+	if hasRequestedLabels(metadata) {
+		allowed, err := subjectAccessReview(ctx, clientHolder, user, &authorizationv1.ResourceAttributes{
+			Group:    clusterv1.GroupName,
+			Resource: "managedclusters",
+			Name:     clusterID,
+			Verb:     "create",
+		})
+		if err != nil {
+			return false, http.StatusInternalServerError, err
+		}
+		if !allowed {
+			return false, http.StatusForbidden, fmt.Errorf("the user %s cannot create the cluster %s with labels",
+				user.Username, clusterID)
+		}
+	}
+
+	existing := &clusterv1.ManagedCluster{}
+	err := clientHolder.RuntimeClient.Get(ctx, types.NamespacedName{Name: clusterID}, existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, http.StatusOK, nil
+	case err != nil:
+		return false, http.StatusInternalServerError, err
+	case existing.Annotations[constants.CreatedViaAnnotation] == constants.CreatedViaAgentRegistration &&
+		!meta.IsStatusConditionTrue(existing.Status.Conditions, clusterv1.ManagedClusterConditionJoined):
+		// the agent requests the manifests again before it joins, the install options cannot be changed
+		if !equality.Semantic.DeepEqual(installAnnotations(existing.Annotations),
+			installAnnotations(metadata.annotations)) {
+			return false, http.StatusConflict, fmt.Errorf("%w: %s is pre-created with different install options",
+				errClusterAlreadyExists, clusterID)
+		}
+		return true, http.StatusOK, nil
+	default:
+		return false, http.StatusConflict, fmt.Errorf("%w: %s", errClusterAlreadyExists, clusterID)
+	}
+}
+
+// preCreateManagedCluster creates the ManagedCluster with the requested metadata, it is called after the cluster is
+// checked by checkPreCreateManagedCluster and the manifests are rendered, so a failed request does not leave a
+// cluster behind. It returns the http status code if it fails.
+func preCreateManagedCluster(ctx context.Context, clientHolder *helpers.ClientHolder, user authenticationv1.UserInfo,
+	clusterID string, metadata *clusterMetadata, hubAcceptsClient bool) (int, error) {
+	annotations := map[string]string{
+		constants.CreatedViaAnnotation: constants.CreatedViaAgentRegistration,
+	}
+	for k, v := range metadata.annotations {
+		annotations[k] = v
+	}
+	cluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        clusterID,
+			Labels:      metadata.labels,
+			Annotations: annotations,
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			HubAcceptsClient: hubAcceptsClient,
+		},
+	}
+	if err := clientHolder.RuntimeClient.Create(ctx, cluster); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return http.StatusConflict, fmt.Errorf("%w: %s", errClusterAlreadyExists, clusterID)
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	klog.Infof("The managed cluster %s is pre-created by %s", clusterID, user.Username)
+	return http.StatusOK, nil
+}
+
+// deletePreCreatedManagedCluster deletes the ManagedCluster pre-created by a request that fails afterwards, the
+// failure is only logged because the request has already failed.
+func deletePreCreatedManagedCluster(ctx context.Context, clientHolder *helpers.ClientHolder, clusterID string) {
+	err := clientHolder.RuntimeClient.Delete(ctx, &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Errorf("failed to delete the pre-created managed cluster %s: %v", clusterID, err)
+		return
+	}
+	klog.Infof("The pre-created managed cluster %s is deleted because the request failed", clusterID)
+}
+
+// hasRequestedLabels returns true if any label other than the clusterset label is requested.
+func hasRequestedLabels(metadata *clusterMetadata) bool {
+	for key := range metadata.labels {
+		if key != clusterv1beta2.ClusterSetLabel {
+			return true
+		}
+	}
+	return false
+}
+
+// installAnnotations returns the reserved annotations that persist the install options of the cluster and the
+// klusterletconfig annotation.
+func installAnnotations(annotations map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for k, v := range annotations {
+		if strings.HasPrefix(k, reservedAnnotationPrefix) || k == apiconstants.AnnotationKlusterletConfig {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+// subjectAccessReview checks if the user has the permission of the resource attributes with the
+// SubjectAccessReview.
+func subjectAccessReview(ctx context.Context, clientHolder *helpers.ClientHolder, user authenticationv1.UserInfo,
+	resourceAttributes *authorizationv1.ResourceAttributes) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar, err := clientHolder.KubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx,
+		&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:               user.Username,
+				Groups:             user.Groups,
+				UID:                user.UID,
+				Extra:              extra,
+				ResourceAttributes: resourceAttributes,
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return sar.Status.Allowed, nil
+}
@@ -0,0 +1,310 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseClusterMetadata(t *testing.T) {
+	cases := []struct {
+		name                string
+		query               string
+		expectedNil         bool
+		expectedLabels      map[string]string
+		expectedAnnotations map[string]string
+		expectedErr         bool
+	}{
+		{
+			name:        "no metadata",
+			query:       "klusterletconfig=default",
+			expectedNil: true,
+		},
+		{
+			name:  "labels, annotations and clusterset",
+			query: "label=env=edge&label=region=us&annotation=owner=edge-team&clusterSet=edge",
+			expectedLabels: map[string]string{
+				"env":                          "edge",
+				"region":                       "us",
+				clusterv1beta2.ClusterSetLabel: "edge",
+			},
+			expectedAnnotations: map[string]string{"owner": "edge-team"},
+		},
+		{
+			name:        "invalid label format",
+			query:       "label=env",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid label value",
+			query:       "label=env=edge/us",
+			expectedErr: true,
+		},
+		{
+			name:        "clusterset label",
+			query:       "label=cluster.open-cluster-management.io/clusterset=edge",
+			expectedErr: true,
+		},
+		{
+			name:        "reserved annotation",
+			query:       "annotation=import.open-cluster-management.io/klusterlet-deploy-mode=Hosted",
+			expectedErr: true,
+		},
+		{
+			name:        "created-via annotation",
+			query:       "annotation=open-cluster-management/created-via=hive",
+			expectedErr: true,
+		},
+		{
+			name:        "klusterletconfig annotation",
+			query:       "annotation=agent.open-cluster-management.io/klusterlet-config=edge",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid clusterset",
+			query:       "clusterSet=Edge_Set!",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query, err := url.ParseQuery(c.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			metadata, err := parseClusterMetadata(query)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if (metadata == nil) != c.expectedNil {
+				t.Fatalf("expected nil metadata %v, but got %v", c.expectedNil, metadata)
+			}
+			if metadata == nil {
+				return
+			}
+			if !reflect.DeepEqual(metadata.labels, c.expectedLabels) {
+				t.Errorf("expected labels %v, but got %v", c.expectedLabels, metadata.labels)
+			}
+			if !reflect.DeepEqual(metadata.annotations, c.expectedAnnotations) {
+				t.Errorf("expected annotations %v, but got %v", c.expectedAnnotations, metadata.annotations)
+			}
+		})
+	}
+}
+
+func TestPreCreateManagedCluster(t *testing.T) {
+	testscheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(testscheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testscheme.AddKnownTypes(clusterv1.SchemeGroupVersion, &clusterv1.ManagedCluster{})
+
+	cases := []struct {
+		name             string
+		existing         []client.Object
+		metadata         *clusterMetadata
+		canJoin          bool
+		canCreate        bool
+		expectedSARs     []string
+		expectedStatus   int
+		expectedConflict bool
+		expectedCreated  bool
+	}{
+		{
+			name: "create the cluster",
+			metadata: &clusterMetadata{
+				labels:      map[string]string{"env": "edge", clusterv1beta2.ClusterSetLabel: "edge"},
+				annotations: map[string]string{"owner": "edge-team"},
+				clusterSet:  "edge",
+			},
+			canJoin:         true,
+			canCreate:       true,
+			expectedSARs:    []string{"managedclustersets/join:edge", "managedclusters:cluster1"},
+			expectedStatus:  http.StatusOK,
+			expectedCreated: true,
+		},
+		{
+			name: "cannot join the clusterset",
+			metadata: &clusterMetadata{
+				labels:     map[string]string{clusterv1beta2.ClusterSetLabel: "edge"},
+				clusterSet: "edge",
+			},
+			expectedSARs:   []string{"managedclustersets/join:edge"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "cannot create the labeled cluster",
+			metadata:       &clusterMetadata{labels: map[string]string{"env": "edge"}},
+			expectedSARs:   []string{"managedclusters:cluster1"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "cluster name collision",
+			existing: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+			}},
+			metadata:         &clusterMetadata{labels: map[string]string{"env": "edge"}},
+			canCreate:        true,
+			expectedSARs:     []string{"managedclusters:cluster1"},
+			expectedStatus:   http.StatusConflict,
+			expectedConflict: true,
+		},
+		{
+			name: "joined pre-created cluster",
+			existing: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "cluster1",
+					Annotations: map[string]string{constants.CreatedViaAnnotation: constants.CreatedViaAgentRegistration},
+				},
+				Status: clusterv1.ManagedClusterStatus{
+					Conditions: []metav1.Condition{
+						{Type: clusterv1.ManagedClusterConditionJoined, Status: metav1.ConditionTrue},
+					},
+				},
+			}},
+			metadata:         &clusterMetadata{labels: map[string]string{"env": "edge"}},
+			canCreate:        true,
+			expectedSARs:     []string{"managedclusters:cluster1"},
+			expectedStatus:   http.StatusConflict,
+			expectedConflict: true,
+		},
+		{
+			name: "pre-created cluster not joined yet",
+			existing: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "cluster1",
+					Annotations: map[string]string{constants.CreatedViaAnnotation: constants.CreatedViaAgentRegistration},
+				},
+			}},
+			metadata:       &clusterMetadata{labels: map[string]string{"env": "edge"}},
+			canCreate:      true,
+			expectedSARs:   []string{"managedclusters:cluster1"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "pre-created cluster with different install options",
+			existing: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster1",
+					Annotations: map[string]string{
+						constants.CreatedViaAnnotation:           constants.CreatedViaAgentRegistration,
+						constants.KlusterletDeployModeAnnotation: "Singleton",
+					},
+				},
+			}},
+			metadata: &clusterMetadata{annotations: map[string]string{
+				constants.KlusterletNamespaceAnnotation: "open-cluster-management-edge",
+			}},
+			expectedStatus:   http.StatusConflict,
+			expectedConflict: true,
+		},
+		{
+			name: "pre-created cluster with a different klusterletconfig",
+			existing: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster1",
+					Annotations: map[string]string{
+						constants.CreatedViaAnnotation:          constants.CreatedViaAgentRegistration,
+						apiconstants.AnnotationKlusterletConfig: "edge",
+					},
+				},
+			}},
+			metadata:         &clusterMetadata{},
+			expectedStatus:   http.StatusConflict,
+			expectedConflict: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var sars []string
+			kubeClient := kubefake.NewSimpleClientset()
+			kubeClient.PrependReactor("create", "subjectaccessreviews",
+				func(action clienttesting.Action) (bool, runtime.Object, error) {
+					sar := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+					attrs := sar.Spec.ResourceAttributes
+					if sar.Spec.User != "edge-admin" || attrs == nil {
+						t.Errorf("unexpected SubjectAccessReview %v", sar)
+						return true, sar, nil
+					}
+					resource := attrs.Resource
+					if len(attrs.Subresource) > 0 {
+						resource = resource + "/" + attrs.Subresource
+					}
+					sars = append(sars, resource+":"+attrs.Name)
+					switch resource {
+					case "managedclustersets/join":
+						sar.Status.Allowed = c.canJoin
+					case "managedclusters":
+						sar.Status.Allowed = c.canCreate && attrs.Verb == "create"
+					}
+					return true, sar, nil
+				})
+			clientHolder := &helpers.ClientHolder{
+				KubeClient: kubeClient,
+				RuntimeClient: fake.NewClientBuilder().WithScheme(testscheme).
+					WithObjects(c.existing...).WithStatusSubresource(c.existing...).Build(),
+			}
+
+			user := authenticationv1.UserInfo{Username: "edge-admin"}
+			exists, status, err := checkPreCreateManagedCluster(context.TODO(), clientHolder, user, "cluster1", c.metadata)
+			if err == nil && !exists {
+				status, err = preCreateManagedCluster(context.TODO(), clientHolder, user, "cluster1", c.metadata, true)
+			}
+			if status != c.expectedStatus {
+				t.Errorf("expected status %d, but got %d: %v", c.expectedStatus, status, err)
+			}
+			if errors.Is(err, errClusterAlreadyExists) != c.expectedConflict {
+				t.Errorf("expected conflict %v, but got %v", c.expectedConflict, err)
+			}
+
+			if !reflect.DeepEqual(sars, c.expectedSARs) {
+				t.Errorf("expected SubjectAccessReviews %v, but got %v", c.expectedSARs, sars)
+			}
+
+			if !c.expectedCreated {
+				return
+			}
+			cluster := &clusterv1.ManagedCluster{}
+			if err := clientHolder.RuntimeClient.Get(context.TODO(), types.NamespacedName{Name: "cluster1"}, cluster); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cluster.Spec.HubAcceptsClient {
+				t.Errorf("expected the hub accepts the cluster")
+			}
+			if !reflect.DeepEqual(cluster.Labels, c.metadata.labels) {
+				t.Errorf("expected labels %v, but got %v", c.metadata.labels, cluster.Labels)
+			}
+			if cluster.Annotations[constants.CreatedViaAnnotation] != constants.CreatedViaAgentRegistration ||
+				cluster.Annotations["owner"] != "edge-team" {
+				t.Errorf("unexpected annotations %v", cluster.Annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// clusterNameFromClientCertificate returns the name of the managed cluster that the hub client certificate is
+// issued to, it returns an empty string if the certificate is not issued to a registration agent.
+func clusterNameFromClientCertificate(cert *x509.Certificate) string {
+	isManagedCluster := false
+	for _, group := range cert.Subject.Organization {
+		if group == helpers.ManagedClustersGroup {
+			isManagedCluster = true
+			break
+		}
+	}
+	if !isManagedCluster {
+		return ""
+	}
+
+	for _, group := range cert.Subject.Organization {
+		if group == helpers.ManagedClustersGroup || !strings.HasPrefix(group, helpers.ClusterUserPrefix) {
+			continue
+		}
+		clusterName := strings.TrimPrefix(group, helpers.ClusterUserPrefix)
+		if len(clusterName) == 0 || strings.Contains(clusterName, ":") {
+			continue
+		}
+		if strings.HasPrefix(cert.Subject.CommonName, group+":") {
+			return clusterName
+		}
+	}
+	return ""
+}
+
+// newRefreshHandler returns the handler for the registered agents to refresh their manifests. The agent presents
+// its hub client certificate instead of a hub credential, so an agent whose hub connection is broken (e.g. the hub
+// CA is rotated) can fetch its current bootstrap secret and klusterlet with the certificate, it requires the
+// mTLS is enabled with the CA that signs the hub client certificates.
+// The manifests are the import.yaml of the <cluster>-import secret that is maintained by the importconfig
+// controller, so they reflect the current KlusterletConfig and hub CA of the cluster.
+// example: curl --cert tls.crt --key tls.key https://<route address>/agent-registration/v1/refresh/cluster1
+func newRefreshHandler(clientHolder *helpers.ClientHolder, options *ServerOptions) http.Handler {
+	addressLimiter := newClientRateLimiter(options.ClientAddressRateLimitQPS, options.ClientAddressRateLimitBurst)
+	credentialLimiter := newClientRateLimiter(options.RateLimitQPS, options.RateLimitBurst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed, retryAfter := addressLimiter.allow(clientAddress(r, options.TrustedProxies)); !allowed {
+			writeRateLimitedError(w, r, retryAfter)
+			return
+		}
+
+		clusterID := clusterIDFromPath(r)
+		if len(clusterID) == 0 {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeInvalidRequest, "the cluster id is required")
+			return
+		}
+
+		cert := verifiedClientCertificate(r)
+		if cert == nil {
+			rejectedRequestsTotal.WithLabelValues("unauthenticated").Inc()
+			writeError(w, r, http.StatusUnauthorized, ErrorCodeUnauthenticated, "a hub client certificate is required")
+			return
+		}
+		if allowed, retryAfter := credentialLimiter.allow(credentialHash(cert.Raw)); !allowed {
+			writeRateLimitedError(w, r, retryAfter)
+			return
+		}
+		if clusterName := clusterNameFromClientCertificate(cert); clusterName != clusterID {
+			rejectedRequestsTotal.WithLabelValues("unauthorized").Inc()
+			writeError(w, r, http.StatusForbidden, ErrorCodeUnauthorized,
+				fmt.Sprintf("the client certificate %s is not issued to the cluster %s", cert.Subject.CommonName, clusterID))
+			return
+		}
+
+		cluster := &clusterv1.ManagedCluster{}
+		err := clientHolder.RuntimeClient.Get(r.Context(), types.NamespacedName{Name: clusterID}, cluster)
+		if apierrors.IsNotFound(err) {
+			writeError(w, r, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("the cluster %s is not found", clusterID))
+			return
+		}
+		if err != nil {
+			writeStatusError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		// the certificate is still valid after the cluster is denied, so the cluster must be accepted
+		if !cluster.Spec.HubAcceptsClient {
+			rejectedRequestsTotal.WithLabelValues("unauthorized").Inc()
+			writeError(w, r, http.StatusForbidden, ErrorCodeUnauthorized,
+				fmt.Sprintf("the cluster %s is not accepted by the hub", clusterID))
+			return
+		}
+
+		importSecretName := fmt.Sprintf("%s-%s", clusterID, constants.ImportSecretNameSuffix)
+		importSecret, err := clientHolder.KubeClient.CoreV1().Secrets(clusterID).Get(
+			r.Context(), importSecretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			writeError(w, r, http.StatusNotFound, ErrorCodeNotFound,
+				fmt.Sprintf("the import secret of the cluster %s is not found", clusterID))
+			return
+		}
+		if err != nil {
+			writeStatusError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		manifests := importSecret.Data[constants.ImportSecretImportYamlKey]
+		if len(manifests) == 0 {
+			writeError(w, r, http.StatusNotFound, ErrorCodeNotFound,
+				fmt.Sprintf("the manifests of the cluster %s are not generated yet", clusterID))
+			return
+		}
+
+		klog.V(4).Infof("The manifests of the cluster %s are refreshed by %s", clusterID, cert.Subject.CommonName)
+		w.Header().Set("Content-Type", "application/yaml")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(manifests); err != nil {
+			klog.Errorf("failed to write the refreshed manifests of %s: %v", clusterID, err)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newAgentCertificate(commonName string, organization ...string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject: pkix.Name{CommonName: commonName, Organization: organization},
+	}
+}
+
+func TestClusterNameFromClientCertificate(t *testing.T) {
+	cases := []struct {
+		name         string
+		cert         *x509.Certificate
+		expectedName string
+	}{
+		{
+			name: "registration agent",
+			cert: newAgentCertificate("system:open-cluster-management:cluster1:abcde",
+				"system:open-cluster-management:cluster1", helpers.ManagedClustersGroup),
+			expectedName: "cluster1",
+		},
+		{
+			name:         "not a managed cluster",
+			cert:         newAgentCertificate("system:open-cluster-management:cluster1:abcde", "system:open-cluster-management:cluster1"),
+			expectedName: "",
+		},
+		{
+			name: "user of another cluster",
+			cert: newAgentCertificate("system:open-cluster-management:cluster2:abcde",
+				"system:open-cluster-management:cluster1", helpers.ManagedClustersGroup),
+			expectedName: "",
+		},
+		{
+			name: "addon agent",
+			cert: newAgentCertificate("system:open-cluster-management:cluster:cluster1:addon:addon1:agent:abcde",
+				"system:open-cluster-management:cluster:cluster1:addon:addon1", helpers.ManagedClustersGroup),
+			expectedName: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if name := clusterNameFromClientCertificate(c.cert); name != c.expectedName {
+				t.Errorf("expected cluster name %q, but got %q", c.expectedName, name)
+			}
+		})
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	testscheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(testscheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testscheme.AddKnownTypes(clusterv1.SchemeGroupVersion, &clusterv1.ManagedCluster{})
+
+	agentCert := newAgentCertificate("system:open-cluster-management:cluster1:abcde",
+		"system:open-cluster-management:cluster1", helpers.ManagedClustersGroup)
+	importSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster1-import", Namespace: "cluster1"},
+		Data: map[string][]byte{
+			constants.ImportSecretImportYamlKey: []byte("kind: Klusterlet"),
+		},
+	}
+
+	cases := []struct {
+		name           string
+		path           string
+		cert           *x509.Certificate
+		clusters       []client.Object
+		secrets        []runtime.Object
+		expectedStatus int
+		expectedCode   ErrorCode
+	}{
+		{
+			name:           "no client certificate",
+			path:           "/agent-registration/v1/refresh/cluster1",
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   ErrorCodeUnauthenticated,
+		},
+		{
+			name: "certificate of another cluster",
+			path: "/agent-registration/v1/refresh/cluster2",
+			cert: agentCert,
+			clusters: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster2"},
+				Spec:       clusterv1.ManagedClusterSpec{HubAcceptsClient: true},
+			}},
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   ErrorCodeUnauthorized,
+		},
+		{
+			name:           "cluster not found",
+			path:           "/agent-registration/v1/refresh/cluster1",
+			cert:           agentCert,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   ErrorCodeNotFound,
+		},
+		{
+			name: "cluster not accepted",
+			path: "/agent-registration/v1/refresh/cluster1",
+			cert: agentCert,
+			clusters: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+			}},
+			secrets:        []runtime.Object{importSecret},
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   ErrorCodeUnauthorized,
+		},
+		{
+			name: "import secret not found",
+			path: "/agent-registration/v1/refresh/cluster1",
+			cert: agentCert,
+			clusters: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+				Spec:       clusterv1.ManagedClusterSpec{HubAcceptsClient: true},
+			}},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   ErrorCodeNotFound,
+		},
+		{
+			name: "refresh the manifests",
+			path: "/agent-registration/v1/refresh/cluster1",
+			cert: agentCert,
+			clusters: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+				Spec:       clusterv1.ManagedClusterSpec{HubAcceptsClient: true},
+			}},
+			secrets:        []runtime.Object{importSecret},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			options := NewServerOptions()
+			options.RateLimitQPS = 0
+			handler := newRefreshHandler(&helpers.ClientHolder{
+				KubeClient:    kubefake.NewSimpleClientset(c.secrets...),
+				RuntimeClient: fake.NewClientBuilder().WithScheme(testscheme).WithObjects(c.clusters...).Build(),
+			}, options)
+
+			req := httptest.NewRequest(http.MethodGet, c.path, nil)
+			if c.cert != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c.cert}}}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != c.expectedStatus {
+				t.Fatalf("expected status %d, but got %d: %s", c.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if c.expectedStatus == http.StatusOK {
+				if rec.Body.String() != "kind: Klusterlet" {
+					t.Errorf("unexpected manifests %s", rec.Body.String())
+				}
+				return
+			}
+			apiErr := &Error{}
+			if err := json.Unmarshal(rec.Body.Bytes(), apiErr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if apiErr.Code != c.expectedCode {
+				t.Errorf("expected error code %s, but got %v", c.expectedCode, apiErr)
+			}
+		})
+	}
+}
@@ -2,8 +2,8 @@ package agentregistration
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/json"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -16,209 +16,629 @@ import (
 	listerklusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/client/klusterletconfig/listers/klusterletconfig/v1alpha1"
 	"github.com/stolostron/managedcluster-import-controller/pkg/bootstrap"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/features"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/klog/v2"
-	operatorv1 "open-cluster-management.io/api/operator/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
 
 	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
 )
 
-func RunAgentRegistrationServer(ctx context.Context, port int, clientHolder *helpers.ClientHolder,
+func RunAgentRegistrationServer(ctx context.Context, options *ServerOptions, clientHolder *helpers.ClientHolder,
 	klusterletconfigLister listerklusterletconfigv1alpha1.KlusterletConfigLister) error {
-	mux := http.NewServeMux()
+	tlsConfig, err := options.tlsConfig(ctx)
+	if err != nil {
+		return err
+	}
 
-	mux.Handle("/agent-registration", authMiddleware(clientHolder, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		response := map[string]interface{}{
-			"paths": []string{
-				"/crds/v1",
-				"/manifests",
-			},
-			"serverInfo": map[string]string{
-				"serverTime": time.Now().UTC().Format(time.RFC3339),
-			},
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			http.Error(w, "Failed to encode API list", http.StatusInternalServerError)
-		}
-	})))
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Addr:              fmt.Sprintf(":%d", options.Port),
+		TLSConfig:         tlsConfig,
+		Handler:           newAgentRegistrationHandler(ctx, options, clientHolder, klusterletconfigLister),
+	}
 
-	mux.Handle("/agent-registration/crds/v1", authMiddleware(clientHolder, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	klog.Infof("Starting AgentRegistrationServer on port %d", options.Port)
+	// the certificate and key are served by the tls config
+	return server.ListenAndServeTLS("", "")
+}
+
+// newAgentRegistrationHandler returns the handler of the v1 API routes and their unversioned aliases.
+func newAgentRegistrationHandler(ctx context.Context, options *ServerOptions, clientHolder *helpers.ClientHolder,
+	klusterletconfigLister listerklusterletconfigv1alpha1.KlusterletConfigLister) http.Handler {
+	authMiddleware := newAuthMiddleware(clientHolder, options)
+
+	crdsHandler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode, err := parseInstallMode(r.URL.Query())
+		if err != nil {
+			writeStatusError(w, r, http.StatusBadRequest, err)
+			return
+		}
 		config := bootstrap.NewKlusterletManifestsConfig(
-			operatorv1.InstallModeDefault,
+			mode,
 			"dummy",
 			nil)
 		_, crdContent, _, err := config.Generate(ctx, clientHolder)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeStatusError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if _, err := w.Write(crdContent); err != nil {
+			klog.Errorf("failed to write the crds: %v", err)
+		}
+	}))
+
+	// example URl: https://<route address>/agent-registration/v1/manifests/cluster1?klusterletconfig=default&duration=4h
+	// the install mode, klusterlet namespace, hosting cluster and priority class can be customized with the mode,
+	// klusterletNamespace, hostingCluster and priorityClass query parameters, e.g.
+	// ?mode=Singleton&klusterletNamespace=open-cluster-management-edge, they are persisted on the pre-created cluster
+	manifestsHandler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clusterID := clusterIDFromPath(r)
+		if len(clusterID) == 0 {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeInvalidRequest, "the cluster id is required")
+			return
 		}
-		_, err = w.Write(crdContent)
+
+		content, status, err := renderAgentRegistrationManifests(ctx, clientHolder, klusterletconfigLister, clusterID,
+			options.HubAcceptsClient, r, func(ctx context.Context, config *bootstrap.KlusterletManifestsConfig,
+				_ *bootstrap.BootstrapCredential) ([]byte, error) {
+				content, _, _, err := config.Generate(ctx, clientHolder)
+				return content, err
+			})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeStatusError(w, r, status, err)
+			return
 		}
-	})))
 
-	// example URl: https://<route address>/agent-registration/manifests/cluster1?klusterletconfig=default&duration=4h
-	mux.Handle("/agent-registration/manifests/", authMiddleware(clientHolder, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var err error
-		urlparams := strings.Split(r.URL.Path, "/")
-		clusterID := urlparams[len(urlparams)-1]
+		if _, err := w.Write(content); err != nil {
+			klog.Errorf("failed to write the manifests of %s: %v", clusterID, err)
+		}
+	}))
 
-		klusterletconfigName := r.URL.Query().Get("klusterletconfig")
-		durationStr := r.URL.Query().Get("duration")
+	// export the offline import bundle of a cluster that has no access to the hub registries, the query parameters
+	// are the same as the manifests.
+	// example URl: https://<route address>/agent-registration/v1/export/cluster1?klusterletconfig=default&duration=24h
+	exportHandler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clusterID := clusterIDFromPath(r)
+		if len(clusterID) == 0 {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeInvalidRequest, "the cluster id is required")
+			return
+		}
 
-		// Get the merged KlusterletConfig, it merges the user assigned KlusterletConfig with the global KlusterletConfig.
-		mergedKlusterletConfig, err := helpers.GetMergedKlusterletConfigWithGlobal(klusterletconfigName, klusterletconfigLister)
+		bundle, status, err := renderAgentRegistrationManifests(ctx, clientHolder, klusterletconfigLister, clusterID,
+			options.HubAcceptsClient, r, func(ctx context.Context, config *bootstrap.KlusterletManifestsConfig,
+				credential *bootstrap.BootstrapCredential) ([]byte, error) {
+				return config.GenerateImportBundle(ctx, clientHolder, *credential)
+			})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeStatusError(w, r, status, err)
 			return
 		}
 
-		// In the agent-registration case, the bootstrap sa is not created in the managed cluster namespace, because managed cluster is not created yet.
-		// Instead, it's in the pod namespace with the name "agent-registration-bootstrap".
-		ns := os.Getenv(constants.PodNamespaceEnvVarName)
-
-		var token []byte
-		if durationStr == "" {
-			token, _, _, err = bootstrap.GetBootstrapToken(ctx, clientHolder.KubeClient, AgentRegistrationDefaultBootstrapSAName, ns,
-				constants.DefaultSecretTokenExpirationSecond)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			duration, err := time.ParseDuration(durationStr)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", clusterID+"-import-bundle.tar.gz"))
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(bundle); err != nil {
+			klog.Errorf("failed to write the import bundle of %s: %v", clusterID, err)
+		}
+	}))
 
-			token, _, _, err = bootstrap.RequestSAToken(ctx, clientHolder.KubeClient, AgentRegistrationDefaultBootstrapSAName, ns, int64(duration.Seconds()))
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	// the shell installer applies the CRDs and the manifests in order and waits for the klusterlet, the query
+	// parameters are the same as the manifests.
+	// example: curl -H "Authorization: Bearer $TOKEN" https://<route address>/agent-registration/v1/install/cluster1 | sh
+	installHandler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clusterID := clusterIDFromPath(r)
+		if len(clusterID) == 0 {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeInvalidRequest, "the cluster id is required")
+			return
 		}
 
-		// get the latest kube apiserver configuration
-		kubeAPIServer, proxyURL, ca, caData, err := bootstrap.GetKubeAPIServerConfig(
-			ctx, clientHolder, ns, mergedKlusterletConfig, false)
+		// the klusterlet in the Hosted modes is not installed on the cluster that runs the installer
+		installOptions, err := parseInstallOptions(r.URL.Query())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeStatusError(w, r, http.StatusBadRequest, err)
 			return
 		}
-		ctxClusterName, err := bootstrap.GetKubeconfigClusterName(ctx, clientHolder.RuntimeClient)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if installOptions.isHosted() {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeInvalidRequest,
+				fmt.Sprintf("the installer does not support the %s mode", installOptions.mode))
 			return
 		}
 
-		bootstrapkubeconfig, err := bootstrap.CreateBootstrapKubeConfig(ctxClusterName, kubeAPIServer, proxyURL, ca, caData, token)
+		installer, status, err := renderAgentRegistrationManifests(ctx, clientHolder, klusterletconfigLister, clusterID,
+			options.HubAcceptsClient, r, func(ctx context.Context, config *bootstrap.KlusterletManifestsConfig,
+				credential *bootstrap.BootstrapCredential) ([]byte, error) {
+				return generateInstaller(ctx, clientHolder, config, credential)
+			})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeStatusError(w, r, status, err)
 			return
 		}
 
-		klusterletClusterAnnotations := map[string]string{
-			"agent.open-cluster-management.io/create-with-default-klusterletaddonconfig": "true",
+		w.Header().Set("Content-Type", "text/x-shellscript; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(installer); err != nil {
+			klog.Errorf("failed to write the installer of %s: %v", clusterID, err)
 		}
-		if klusterletconfigName != "" {
-			// This annotation will finanlly be added on the managedcluster which created by the agent side.
-			// Then the reconciliation of importconfig-controller will render manifests with the same KlusterletConfig
-			klusterletClusterAnnotations[apiconstants.AnnotationKlusterletConfig] = klusterletconfigName
+	}))
+
+	mux := http.NewServeMux()
+
+	discoveryHandler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != agentRegistrationV1Path && r.URL.Path != agentRegistrationV1Path+"/" {
+			writeError(w, r, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("the path %s is not found", r.URL.Path))
+			return
 		}
+		writeJSON(w, r, APIDiscovery{
+			APIVersion: "v1",
+			Resources:  apiV1Resources,
+			ServerInfo: map[string]string{
+				"serverTime": time.Now().UTC().Format(time.RFC3339),
+			},
+		})
+	}))
+	mux.Handle(agentRegistrationV1Path, discoveryHandler)
+	mux.Handle(agentRegistrationV1Path+"/", discoveryHandler)
+	mux.Handle(agentRegistrationV1Path+"/crds", crdsHandler)
+	mux.Handle(agentRegistrationV1Path+"/manifests/", manifestsHandler)
+	mux.Handle(agentRegistrationV1Path+"/export/", exportHandler)
+	mux.Handle(agentRegistrationV1Path+"/install/", installHandler)
+	// the refresh is authenticated by the hub client certificate of the agent, so it is not in the auth middleware
+	mux.Handle(agentRegistrationV1Path+"/refresh/", newRefreshHandler(clientHolder, options))
+	// the OpenAPI document is public, so the tooling can be generated without a credential
+	mux.HandleFunc(agentRegistrationV1Path+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(openAPIDocument); err != nil {
+			klog.Errorf("failed to write the openapi document: %v", err)
+		}
+	})
 
-		content, _, _, err := bootstrap.NewKlusterletManifestsConfig(
-			operatorv1.InstallModeDefault,
-			clusterID,
-			bootstrapkubeconfig).
-			WithKlusterletClusterAnnotations(klusterletClusterAnnotations).
-			WithKlusterletConfig(mergedKlusterletConfig).
-			Generate(r.Context(), clientHolder)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// the unversioned routes are kept as the aliases of the v1 routes
+	mux.Handle(agentRegistrationPath, authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, map[string]interface{}{
+			"paths": []string{
+				"/crds/v1",
+				"/manifests",
+				"/export",
+				"/install",
+			},
+			"serverInfo": map[string]string{
+				"serverTime": time.Now().UTC().Format(time.RFC3339),
+			},
+		})
+	})))
+	mux.Handle(agentRegistrationPath+"/crds/v1", crdsHandler)
+	mux.Handle(agentRegistrationPath+"/manifests/", manifestsHandler)
+	mux.Handle(agentRegistrationPath+"/export/", exportHandler)
+	mux.Handle(agentRegistrationPath+"/install/", installHandler)
+
+	return mux
+}
+
+type enrollmentTokenContextKey struct{}
+
+// renderFunc renders the response of an agent-registration request with the klusterlet manifests config.
+type renderFunc func(ctx context.Context, config *bootstrap.KlusterletManifestsConfig,
+	credential *bootstrap.BootstrapCredential) ([]byte, error)
+
+// renderAgentRegistrationManifests renders the response of an agent-registration request with the klusterletconfig,
+// duration and install options query parameters. If the request is authorized by an enrollment token, the
+// klusterletconfig bound to the token is used, and the token is consumed by the cluster after the response is
+// rendered, so a failed render does not use it up. If the cluster metadata is requested, the ManagedCluster is
+// pre-created. It returns the http status code if it fails.
+func renderAgentRegistrationManifests(ctx context.Context, clientHolder *helpers.ClientHolder,
+	klusterletconfigLister listerklusterletconfigv1alpha1.KlusterletConfigLister, clusterID string,
+	hubAcceptsClient bool, r *http.Request, render renderFunc) ([]byte, int, error) {
+	// the cluster id is the name of the ManagedCluster, and it is templated into the manifests and the installer
+	if errs := validation.IsDNS1123Subdomain(clusterID); len(errs) > 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid cluster id %q: %s", clusterID, strings.Join(errs, ", "))
+	}
+
+	klusterletconfigName := r.URL.Query().Get("klusterletconfig")
+	durationStr := r.URL.Query().Get("duration")
+
+	options, err := parseInstallOptions(r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	metadata, err := parseClusterMetadata(r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	// the cluster is pre-created if the metadata or the install options other than the defaults are requested
+	installAnnotations := options.annotations()
+	preCreate := metadata != nil || len(installAnnotations) > 0
+	if preCreate {
+		if errs := validation.IsDNS1123Label(clusterID); len(errs) > 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid cluster id %q: %s", clusterID, strings.Join(errs, ", "))
+		}
+	}
+
+	token, hasEnrollmentToken := r.Context().Value(enrollmentTokenContextKey{}).(string)
+	if hasEnrollmentToken {
+		// the enrollment token has no user to authorize the requested metadata
+		if metadata != nil {
+			return nil, http.StatusForbidden, fmt.Errorf(
+				"%w: the cluster metadata cannot be requested with an enrollment token", errEnrollmentTokenRejected)
+		}
+
+		boundKlusterletConfig, err := checkEnrollmentToken(r.Context(), clientHolder.KubeClient,
+			os.Getenv(constants.PodNamespaceEnvVarName), token, clusterID, klusterletconfigName)
+		if status, err := enrollmentTokenStatus(err); err != nil {
+			return nil, status, err
 		}
+		if len(boundKlusterletConfig) > 0 {
+			klusterletconfigName = boundKlusterletConfig
+		}
+	}
 
-		_, err = w.Write(content)
+	// the cluster is checked before the manifests are rendered, but it is only created after they are rendered,
+	// so a failed request does not leave a cluster behind
+	user, _ := r.Context().Value(userInfoContextKey{}).(authenticationv1.UserInfo)
+	preCreated := false
+	if preCreate {
+		if metadata == nil {
+			metadata = &clusterMetadata{labels: map[string]string{}, annotations: map[string]string{}}
+		}
+		for k, v := range installAnnotations {
+			metadata.annotations[k] = v
+		}
+		if len(klusterletconfigName) > 0 {
+			metadata.annotations[apiconstants.AnnotationKlusterletConfig] = klusterletconfigName
+		}
+		exists, status, err := checkPreCreateManagedCluster(ctx, clientHolder, user, clusterID, metadata)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, status, err
 		}
-	})))
+		preCreated = exists
+	}
 
-	server := &http.Server{
-		ReadHeaderTimeout: 10 * time.Second,
-		Addr:              fmt.Sprintf(":%d", port),
-		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
-		Handler:           mux,
+	config, credential, status, err := newKlusterletManifestsConfig(ctx, clientHolder, klusterletconfigLister, clusterID,
+		klusterletconfigName, durationStr, options)
+	if err != nil {
+		return nil, status, err
+	}
+
+	content, err := render(r.Context(), config, credential)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if preCreate && !preCreated {
+		if status, err := preCreateManagedCluster(ctx, clientHolder, user, clusterID, metadata, hubAcceptsClient); err != nil {
+			return nil, status, err
+		}
 	}
 
-	klog.Infof("Starting AgentRegistrationServer on port %d", port)
-	return server.ListenAndServeTLS("/server/tls.crt", "/server/tls.key")
+	if hasEnrollmentToken {
+		// the token is validated again when it is consumed, the rendered content is discarded if the token is
+		// used up by the concurrent requests in the meantime
+		_, err := consumeEnrollmentToken(r.Context(), clientHolder.KubeClient,
+			os.Getenv(constants.PodNamespaceEnvVarName), token, clusterID, klusterletconfigName, r.RemoteAddr)
+		if status, err := enrollmentTokenStatus(err); err != nil {
+			if preCreate && !preCreated {
+				deletePreCreatedManagedCluster(ctx, clientHolder, clusterID)
+			}
+			return nil, status, err
+		}
+	}
+
+	return content, http.StatusOK, nil
+}
+
+// enrollmentTokenStatus returns the http status code of the error of an enrollment token.
+func enrollmentTokenStatus(err error) (int, error) {
+	if errors.Is(err, errEnrollmentTokenRejected) {
+		return http.StatusForbidden, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
 }
 
-func authMiddleware(clientHolder *helpers.ClientHolder, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the Authorization header value
-		authHeader := r.Header.Get("Authorization")
+// newKlusterletManifestsConfig builds the klusterlet manifests config for an agent-registration request. It
+// returns the http status code if it fails.
+func newKlusterletManifestsConfig(ctx context.Context, clientHolder *helpers.ClientHolder,
+	klusterletconfigLister listerklusterletconfigv1alpha1.KlusterletConfigLister, clusterID, klusterletconfigName,
+	durationStr string, options *installOptions) (*bootstrap.KlusterletManifestsConfig, *bootstrap.BootstrapCredential, int, error) {
+	var err error
 
-		// Check if the header value starts with "Bearer "
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Invalid Authorization header", http.StatusUnauthorized)
-			return
+	// Get the merged KlusterletConfig, it merges the user assigned KlusterletConfig with the global KlusterletConfig.
+	mergedKlusterletConfig, err := helpers.GetMergedKlusterletConfigWithGlobal(klusterletconfigName, klusterletconfigLister)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	// In the agent-registration case, the bootstrap sa is not created in the managed cluster namespace, because managed cluster is not created yet.
+	// Instead, it's in the pod namespace with the name "agent-registration-bootstrap".
+	ns := os.Getenv(constants.PodNamespaceEnvVarName)
+
+	saName := AgentRegistrationDefaultBootstrapSAName
+	if features.DefaultMutableFeatureGate.Enabled(features.AgentRegistrationClusterIdentity) {
+		saName, err = applyClusterBootstrapIdentity(ctx, clientHolder, clusterID, ns)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, err
 		}
+	}
 
-		// Extract the token from the header value
-		token := strings.TrimPrefix(authHeader, "Bearer ")
+	var token, creation, expiration []byte
+	if durationStr == "" {
+		token, creation, expiration, err = bootstrap.GetBootstrapToken(ctx, clientHolder.KubeClient, saName, ns,
+			constants.DefaultSecretTokenExpirationSecond)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, err
+		}
+	} else {
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
 
-		// Authentication
-		trresult, err := clientHolder.KubeClient.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
-			Spec: authenticationv1.TokenReviewSpec{
-				Token: token,
-			},
-		}, metav1.CreateOptions{})
+		token, creation, expiration, err = bootstrap.RequestSAToken(ctx, clientHolder.KubeClient, saName, ns, int64(duration.Seconds()))
 		if err != nil {
-			http.Error(w, fmt.Sprintf("create TR failed %v", err.Error()), http.StatusInternalServerError)
-			return
+			return nil, nil, http.StatusInternalServerError, err
 		}
-		if !trresult.Status.Authenticated {
-			http.Error(w, fmt.Sprintf("authentication failed, response:%v, error:%v", trresult.Status, trresult.Status.Error), http.StatusUnauthorized)
-			return
+	}
+
+	// get the latest kube apiserver configuration
+	kubeAPIServer, proxyURL, ca, caData, err := bootstrap.GetKubeAPIServerConfig(
+		ctx, clientHolder, ns, mergedKlusterletConfig, false)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+	ctxClusterName, err := bootstrap.GetKubeconfigClusterName(ctx, clientHolder.RuntimeClient)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	bootstrapkubeconfig, err := bootstrap.CreateBootstrapKubeConfig(ctxClusterName, kubeAPIServer, proxyURL, ca, caData, token)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	klusterletClusterAnnotations := map[string]string{
+		"agent.open-cluster-management.io/create-with-default-klusterletaddonconfig": "true",
+	}
+	if klusterletconfigName != "" {
+		// This annotation will finanlly be added on the managedcluster which created by the agent side.
+		// Then the reconciliation of importconfig-controller will render manifests with the same KlusterletConfig
+		klusterletClusterAnnotations[apiconstants.AnnotationKlusterletConfig] = klusterletconfigName
+	}
+
+	config := bootstrap.NewKlusterletManifestsConfig(
+		options.mode,
+		clusterID,
+		bootstrapkubeconfig).
+		WithManagedCluster(options.managedCluster(clusterID)).
+		WithKlusterletClusterAnnotations(klusterletClusterAnnotations).
+		WithKlusterletConfig(mergedKlusterletConfig).
+		WithPriorityClassName(options.priorityClassName)
+	if options.isHosted() {
+		config = config.WithoutImagePullSecretGenerate()
+	}
+
+	credential := &bootstrap.BootstrapCredential{
+		ClusterName:         clusterID,
+		ServiceAccount:      saName,
+		Namespace:           ns,
+		CreationTimestamp:   string(creation),
+		ExpirationTimestamp: string(expiration),
+	}
+
+	return config, credential, http.StatusOK, nil
+}
+
+// applyClusterBootstrapIdentity applies the per-cluster bootstrap service account of the cluster and returns its
+// name, the csr of the cluster is only approved if it is requested by this service account. The service account
+// is owned by the ManagedCluster if the cluster exists, and it is deleted by the managedcluster controller once
+// the cluster joins or is deleted.
+func applyClusterBootstrapIdentity(ctx context.Context, clientHolder *helpers.ClientHolder,
+	clusterID, ns string) (string, error) {
+	if errs := validation.IsDNS1123Label(clusterID); len(errs) > 0 {
+		return "", fmt.Errorf("invalid cluster id %q: %s", clusterID, strings.Join(errs, ", "))
+	}
+
+	objects, err := bootstrap.GenerateAgentRegistrationBootstrapRBACObjects(clusterID, ns)
+	if err != nil {
+		return "", err
+	}
+
+	var owner *clusterv1.ManagedCluster
+	cluster := &clusterv1.ManagedCluster{}
+	err = clientHolder.RuntimeClient.Get(ctx, types.NamespacedName{Name: clusterID}, cluster)
+	switch {
+	case err == nil:
+		owner = cluster
+	case !apierrors.IsNotFound(err):
+		return "", err
+	}
+
+	if _, err := helpers.ApplyResources(clientHolder, helpers.NewEventRecorder(clientHolder.KubeClient, "agent-registration"),
+		clientHolder.RuntimeClient.Scheme(), owner, objects...); err != nil {
+		return "", err
+	}
+
+	return helpers.GetAgentRegistrationBootstrapSAName(clusterID), nil
+}
+
+// newAuthMiddleware returns the middleware to authenticate and authorize the requests. The requests are rate
+// limited by the client address before they are authenticated and then by the credential, and the review results
+// are cached by the credential and the request path.
+func newAuthMiddleware(clientHolder *helpers.ClientHolder, options *ServerOptions) func(next http.Handler) http.Handler {
+	cache := newAuthCache(options.AuthCacheSize, options.AuthCacheTTL, options.AuthCacheNegativeTTL)
+	addressLimiter := newClientRateLimiter(options.ClientAddressRateLimitQPS, options.ClientAddressRateLimitBurst)
+	credentialLimiter := newClientRateLimiter(options.RateLimitQPS, options.RateLimitBurst)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowed, retryAfter := addressLimiter.allow(clientAddress(r, options.TrustedProxies)); !allowed {
+				writeRateLimitedError(w, r, retryAfter)
+				return
+			}
+
+			var credential []byte
+			var review func(ctx context.Context) authResult
+			if cert := verifiedClientCertificate(r); cert != nil {
+				// The client certificate is verified with the client CA in the TLS handshake, it is authorized as
+				// the user of its common name and the groups of its organizations.
+				credential = cert.Raw
+				if allowed, retryAfter := credentialLimiter.allow(credentialHash(credential)); !allowed {
+					writeRateLimitedError(w, r, retryAfter)
+					return
+				}
+				review = func(ctx context.Context) authResult {
+					return authorize(ctx, clientHolder, authenticationv1.UserInfo{
+						Username: cert.Subject.CommonName,
+						Groups:   cert.Subject.Organization,
+					})
+				}
+			} else {
+				// Get the Authorization header value
+				authHeader := r.Header.Get("Authorization")
+
+				// Check if the header value starts with "Bearer "
+				if !strings.HasPrefix(authHeader, "Bearer ") {
+					rejectedRequestsTotal.WithLabelValues("unauthenticated").Inc()
+					writeError(w, r, http.StatusUnauthorized, ErrorCodeUnauthenticated, "Invalid Authorization header")
+					return
+				}
+
+				// Extract the token from the header value
+				token := strings.TrimPrefix(authHeader, "Bearer ")
+				if allowed, retryAfter := credentialLimiter.allow(credentialHash([]byte(token))); !allowed {
+					writeRateLimitedError(w, r, retryAfter)
+					return
+				}
+
+				// The enrollment token is verified without the TokenReview, it is consumed when the manifests are
+				// requested, so it is not cached.
+				if _, _, ok := parseEnrollmentToken(token); ok {
+					secret, err := getEnrollmentToken(r.Context(), clientHolder.KubeClient, os.Getenv(constants.PodNamespaceEnvVarName), token)
+					if err != nil {
+						rejectedRequestsTotal.WithLabelValues("error").Inc()
+						writeError(w, r, http.StatusInternalServerError, ErrorCodeInternalError,
+							fmt.Sprintf("get enrollment token failed %v", err.Error()))
+						return
+					}
+					if secret != nil {
+						t, err := newEnrollmentToken(secret)
+						if err == nil {
+							err = t.validate(time.Now(), "", "")
+						}
+						if err != nil {
+							rejectedRequestsTotal.WithLabelValues("unauthenticated").Inc()
+							writeError(w, r, http.StatusUnauthorized, ErrorCodeEnrollmentTokenRejected, err.Error())
+							return
+						}
+						next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), enrollmentTokenContextKey{}, token)))
+						return
+					}
+				}
+
+				credential = []byte(token)
+				review = func(ctx context.Context) authResult {
+					return reviewToken(ctx, clientHolder, token)
+				}
+			}
+
+			key := authCacheKey(credential, r.URL.Path)
+			result, ok := cache.get(key)
+			if !ok {
+				result = review(r.Context())
+				cache.add(key, result)
+			}
+			if result.status != http.StatusOK {
+				rejectedRequestsTotal.WithLabelValues(result.reason).Inc()
+				writeError(w, r, result.status, result.code, result.message)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userInfoContextKey{}, result.user)))
+		})
+	}
+}
+
+// reviewToken authenticates the token with the TokenReview and authorizes its user.
+func reviewToken(ctx context.Context, clientHolder *helpers.ClientHolder, token string) authResult {
+	trresult, err := clientHolder.KubeClient.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authResult{
+			status:  http.StatusInternalServerError,
+			message: fmt.Sprintf("create TR failed %v", err.Error()),
+			reason:  "error",
+			code:    ErrorCodeInternalError,
+		}
+	}
+	if !trresult.Status.Authenticated {
+		return authResult{
+			status:  http.StatusUnauthorized,
+			message: fmt.Sprintf("authentication failed, response:%v, error:%v", trresult.Status, trresult.Status.Error),
+			reason:  "unauthenticated",
+			code:    ErrorCodeUnauthenticated,
 		}
+	}
+
+	return authorize(ctx, clientHolder, trresult.Status.User)
+}
 
-		// Authorization
-		userInfo := trresult.Status.User
-		extra := make(map[string]authorizationv1.ExtraValue)
-		for k, v := range userInfo.Extra {
-			extra[k] = authorizationv1.ExtraValue(v)
-		}
-		sarrequest := &authorizationv1.SubjectAccessReview{
-			Spec: authorizationv1.SubjectAccessReviewSpec{
-				User:   userInfo.Username,
-				Groups: userInfo.Groups,
-				UID:    userInfo.UID,
-				Extra:  extra,
-				NonResourceAttributes: &authorizationv1.NonResourceAttributes{
-					Path: "/agent-registration/*",
-					Verb: "get",
-				},
+// authorize authorizes the user to access the agent-registration paths with the SubjectAccessReview.
+func authorize(ctx context.Context, clientHolder *helpers.ClientHolder, userInfo authenticationv1.UserInfo) authResult {
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for k, v := range userInfo.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sarrequest := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   userInfo.Username,
+			Groups: userInfo.Groups,
+			UID:    userInfo.UID,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: "/agent-registration/*",
+				Verb: "get",
 			},
+		},
+	}
+	sarresult, err := clientHolder.KubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, sarrequest, metav1.CreateOptions{})
+	if err != nil {
+		return authResult{
+			status:  http.StatusInternalServerError,
+			message: fmt.Sprintf("create SAR failed %v, user: %v", err.Error(), userInfo),
+			reason:  "error",
+			code:    ErrorCodeInternalError,
 		}
-		sarresult, err := clientHolder.KubeClient.AuthorizationV1().SubjectAccessReviews().Create(r.Context(), sarrequest, metav1.CreateOptions{})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("create SAR failed %v, user: %v", err.Error(), userInfo), http.StatusInternalServerError)
-			return
-		}
-		if !sarresult.Status.Allowed {
-			http.Error(w, fmt.Sprintf("authorization failed, response:%v, user:%v", sarresult.Status, userInfo), http.StatusUnauthorized)
-			return
+	}
+	if !sarresult.Status.Allowed {
+		return authResult{
+			status:  http.StatusUnauthorized,
+			message: fmt.Sprintf("authorization failed, response:%v, user:%v", sarresult.Status, userInfo),
+			reason:  "unauthorized",
+			code:    ErrorCodeUnauthorized,
 		}
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	return authResult{status: http.StatusOK, user: userInfo}
+}
+
+// verifiedClientCertificate returns the client certificate of the request if it is verified, the certificate is
+// only requested by the server when the mTLS is enabled.
+func verifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
 }
 
 const (
-	AgentRegistrationDefaultBootstrapSAName = "agent-registration-bootstrap"
+	AgentRegistrationDefaultBootstrapSAName = helpers.AgentRegistrationBootstrapSAName
 )
@@ -0,0 +1,305 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package agentregistration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	listerklusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/client/klusterletconfig/listers/klusterletconfig/v1alpha1"
+	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/bootstrap"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestRenderClients returns the clients and the klusterletconfig lister to render the manifests of an
+// agent-registration request, the hub kube apiserver is set in the klusterletconfig "edge".
+func newTestRenderClients(t *testing.T, objs ...runtime.Object) (*helpers.ClientHolder,
+	listerklusterletconfigv1alpha1.KlusterletConfigLister) {
+	t.Setenv(constants.PodNamespaceEnvVarName, testNamespace)
+
+	objs = append(objs, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: AgentRegistrationDefaultBootstrapSAName + "-token-abcde", Namespace: testNamespace},
+		Type:       corev1.SecretTypeServiceAccountToken,
+		Data:       map[string][]byte{"token": []byte("sa-token")},
+	})
+	kubeClient := kubefake.NewSimpleClientset(objs...)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(&klusterletconfigv1alpha1.KlusterletConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+		Spec: klusterletconfigv1alpha1.KlusterletConfigSpec{
+			HubKubeAPIServerConfig: &klusterletconfigv1alpha1.KubeAPIServerConfig{
+				URL:                        "https://api.hub.example.com:6443",
+				ServerVerificationStrategy: klusterletconfigv1alpha1.ServerVerificationStrategyUseSystemTruststore,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testscheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(testscheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testscheme.AddKnownTypes(clusterv1.SchemeGroupVersion, &clusterv1.ManagedCluster{})
+
+	runtimeClient := fake.NewClientBuilder().WithScheme(testscheme).Build()
+	return &helpers.ClientHolder{
+		KubeClient:       kubeClient,
+		RuntimeClient:    runtimeClient,
+		RuntimeAPIReader: runtimeClient,
+	}, listerklusterletconfigv1alpha1.NewKlusterletConfigLister(indexer)
+}
+
+func TestRenderAgentRegistrationManifestsEnrollmentToken(t *testing.T) {
+	helpers.DeployOnOCP = false
+	defer func() { helpers.DeployOnOCP = true }()
+
+	cases := []struct {
+		name             string
+		renderErr        error
+		expectedStatus   int
+		expectedEnrolled int
+	}{
+		{
+			name:             "rendered",
+			expectedStatus:   http.StatusOK,
+			expectedEnrolled: 1,
+		},
+		{
+			name:           "render failed",
+			renderErr:      errors.New("render failed"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientHolder, lister := newTestRenderClients(t, newEnrollmentTokenSecret(map[string]string{
+				EnrollmentTokenKlusterletConfigKey: "edge",
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/agent-registration/v1/manifests/cluster1", nil)
+			req = req.WithContext(context.WithValue(req.Context(), enrollmentTokenContextKey{}, testEnrollmentToken))
+
+			// the hub kube apiserver is only found in the klusterletconfig bound to the token
+			_, status, err := renderAgentRegistrationManifests(context.TODO(), clientHolder, lister, "cluster1", true, req,
+				func(_ context.Context, _ *bootstrap.KlusterletManifestsConfig,
+					_ *bootstrap.BootstrapCredential) ([]byte, error) {
+					return []byte("manifests"), c.renderErr
+				})
+			if status != c.expectedStatus {
+				t.Errorf("expected status %d, but got %d: %v", c.expectedStatus, status, err)
+			}
+
+			secret, err := clientHolder.KubeClient.CoreV1().Secrets(testNamespace).Get(context.TODO(),
+				EnrollmentTokenSecretPrefix+"abcdef", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			enrollments := []enrollment{}
+			if data := secret.Data[EnrollmentTokenEnrollmentsKey]; len(data) > 0 {
+				if err := json.Unmarshal(data, &enrollments); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if len(enrollments) != c.expectedEnrolled {
+				t.Errorf("expected %d enrollments, but got %v", c.expectedEnrolled, enrollments)
+			}
+		})
+	}
+}
+
+func TestRenderAgentRegistrationManifestsInstallOptions(t *testing.T) {
+	helpers.DeployOnOCP = false
+	defer func() { helpers.DeployOnOCP = true }()
+
+	cases := []struct {
+		name                string
+		query               string
+		renderErr           error
+		expectedStatus      int
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:           "default install options",
+			query:          "klusterletconfig=edge",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "render failed",
+			query:          "klusterletconfig=edge&mode=Singleton",
+			renderErr:      errors.New("render failed"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "singleton in a custom namespace",
+			query:          "klusterletconfig=edge&mode=Singleton&klusterletNamespace=open-cluster-management-edge",
+			expectedStatus: http.StatusOK,
+			expectedAnnotations: map[string]string{
+				constants.KlusterletDeployModeAnnotation: "Singleton",
+				constants.KlusterletNamespaceAnnotation:  "open-cluster-management-edge",
+			},
+		},
+		{
+			name:           "hosted",
+			query:          "klusterletconfig=edge&mode=Hosted&hostingCluster=local-cluster",
+			expectedStatus: http.StatusOK,
+			expectedAnnotations: map[string]string{
+				constants.KlusterletDeployModeAnnotation: "Hosted",
+				constants.HostingClusterNameAnnotation:   "local-cluster",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientHolder, lister := newTestRenderClients(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/agent-registration/v1/manifests/cluster1?"+c.query, nil)
+			_, status, err := renderAgentRegistrationManifests(context.TODO(), clientHolder, lister, "cluster1", true, req,
+				func(_ context.Context, _ *bootstrap.KlusterletManifestsConfig,
+					_ *bootstrap.BootstrapCredential) ([]byte, error) {
+					return []byte("manifests"), c.renderErr
+				})
+			if status != c.expectedStatus {
+				t.Fatalf("expected status %d, but got %d: %v", c.expectedStatus, status, err)
+			}
+
+			// the cluster is not pre-created if the manifests are not rendered
+			cluster := &clusterv1.ManagedCluster{}
+			err = clientHolder.RuntimeClient.Get(context.TODO(), types.NamespacedName{Name: "cluster1"}, cluster)
+			if len(c.expectedAnnotations) == 0 {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("expected the cluster is not pre-created, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for k, v := range c.expectedAnnotations {
+				if cluster.Annotations[k] != v {
+					t.Errorf("expected annotation %s=%s, but got %v", k, v, cluster.Annotations)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderAgentRegistrationManifestsInvalidClusterID(t *testing.T) {
+	clientHolder, lister := newTestRenderClients(t)
+
+	for _, clusterID := range []string{"cluster1;id", "Cluster1", "cluster1$(id)"} {
+		req := httptest.NewRequest(http.MethodGet, "/agent-registration/v1/install/cluster1", nil)
+		_, status, err := renderAgentRegistrationManifests(context.TODO(), clientHolder, lister, clusterID, true, req,
+			func(_ context.Context, _ *bootstrap.KlusterletManifestsConfig,
+				_ *bootstrap.BootstrapCredential) ([]byte, error) {
+				t.Errorf("unexpected render of the cluster %q", clusterID)
+				return nil, nil
+			})
+		if status != http.StatusBadRequest {
+			t.Errorf("expected status %d for the cluster %q, but got %d: %v", http.StatusBadRequest, clusterID, status, err)
+		}
+	}
+}
+
+func TestApplyClusterBootstrapIdentity(t *testing.T) {
+	testscheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(testscheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testscheme.AddKnownTypes(clusterv1.SchemeGroupVersion, &clusterv1.ManagedCluster{})
+
+	cases := []struct {
+		name           string
+		clusterID      string
+		existing       []client.Object
+		expectedSAName string
+		expectedOwned  bool
+		expectedErr    bool
+	}{
+		{
+			name:           "valid cluster id",
+			clusterID:      "cluster1",
+			expectedSAName: "agent-registration-bootstrap-cluster1",
+		},
+		{
+			name:      "owned by the existing cluster",
+			clusterID: "cluster1",
+			existing: []client.Object{&clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1", UID: "cluster1-uid"},
+			}},
+			expectedSAName: "agent-registration-bootstrap-cluster1",
+			expectedOwned:  true,
+		},
+		{
+			name:        "invalid cluster id",
+			clusterID:   "Cluster_1",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := kubefake.NewSimpleClientset()
+			saName, err := applyClusterBootstrapIdentity(context.TODO(), &helpers.ClientHolder{
+				KubeClient:    kubeClient,
+				RuntimeClient: fake.NewClientBuilder().WithScheme(testscheme).WithObjects(c.existing...).Build(),
+			}, c.clusterID, testNamespace)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if saName != c.expectedSAName {
+				t.Errorf("expected sa %s, but got %s", c.expectedSAName, saName)
+			}
+
+			sa, err := kubeClient.CoreV1().ServiceAccounts(testNamespace).Get(context.TODO(), saName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sa.Labels[constants.AgentRegistrationClusterLabel] != c.clusterID {
+				t.Errorf("expected the cluster label %s, but got %v", c.clusterID, sa.Labels)
+			}
+
+			crb, err := kubeClient.RbacV1().ClusterRoleBindings().Get(context.TODO(),
+				"system:open-cluster-management:managedcluster:bootstrap:agent-registration:"+c.clusterID, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(crb.Subjects) != 1 || crb.Subjects[0].Name != saName || crb.Subjects[0].Namespace != testNamespace {
+				t.Errorf("unexpected subjects %v", crb.Subjects)
+			}
+			if crb.RoleRef.Name != "system:open-cluster-management:managedcluster:bootstrap:agent-registration" {
+				t.Errorf("unexpected role %v", crb.RoleRef)
+			}
+
+			for _, owners := range [][]metav1.OwnerReference{sa.OwnerReferences, crb.OwnerReferences} {
+				owned := len(owners) == 1 && owners[0].Kind == "ManagedCluster" && owners[0].UID == "cluster1-uid"
+				if owned != c.expectedOwned {
+					t.Errorf("expected owned by the cluster %v, but got %v", c.expectedOwned, owners)
+				}
+			}
+		})
+	}
+}
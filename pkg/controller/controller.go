@@ -39,6 +39,7 @@ func AddToManager(ctx context.Context,
 	informerHolder *source.InformerHolder,
 	componentNamespace string,
 	flightctlManager *flightctl.FlightCtlManager,
+	csrApprovalWebhookOptions *csr.WebhookOptions,
 	mcRecorder kevents.EventRecorder) error {
 
 	extraCSRApprovalConditions := []func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error){
@@ -54,12 +55,16 @@ func AddToManager(ctx context.Context,
 		{
 			csr.ControllerName,
 			func() error {
-				return csr.Add(ctx, manager, clientHolder, extraCSRApprovalConditions)
+				return csr.Add(ctx, manager, clientHolder, informerHolder, componentNamespace, csrApprovalWebhookOptions,
+					mcRecorder, extraCSRApprovalConditions)
 			},
 		},
 		{
 			managedcluster.ControllerName,
-			func() error { return managedcluster.Add(ctx, manager, clientHolder, mcRecorder) },
+			func() error {
+				return managedcluster.Add(ctx, manager, clientHolder, informerHolder, mcRecorder,
+					componentNamespace)
+			},
 		},
 		{
 			importconfig.ControllerName,
@@ -113,7 +118,14 @@ func AddToManager(ctx context.Context,
 		{
 			flightctl.ManagedClusterControllerName,
 			func() error {
-				return flightctl.AddManagedClusterController(ctx, manager, flightctlManager, clientHolder)
+				return flightctl.AddManagedClusterController(ctx, manager, flightctlManager, clientHolder,
+					informerHolder, componentNamespace)
+			},
+		},
+		{
+			flightctl.SyncControllerName,
+			func() error {
+				return flightctl.AddSyncController(ctx, manager, flightctlManager, clientHolder, informerHolder)
 			},
 		},
 	}
@@ -0,0 +1,214 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+)
+
+// ApprovalAction is the action of an approval rule when its expression is evaluated to true.
+type ApprovalAction string
+
+const (
+	ApprovalActionApprove ApprovalAction = "Approve"
+	ApprovalActionDeny    ApprovalAction = "Deny"
+)
+
+// ApprovalPolicy is the CSR approval policy in the csrApprovalPolicy key of the import-controller-config
+// ConfigMap. The rules are evaluated in order in addition to the built-in approval conditions, the first rule
+// whose expression is true decides the CSR. If no rule matches, the built-in approval conditions are used.
+//
+// example:
+//
+//	rules:
+//	- name: deny-test-clusters
+//	  action: Deny
+//	  expression: clusterName.startsWith("test-")
+//	- name: enrollment-service
+//	  action: Approve
+//	  expression: username == "system:serviceaccount:edge:enrollment" && "client auth" in usages
+type ApprovalPolicy struct {
+	Rules []ApprovalRule `json:"rules"`
+}
+
+// ApprovalRule is a CEL expression over the CSR, the expression must be evaluated to a bool with the variables:
+//   - clusterName: the cluster name label of the CSR
+//   - username: the username of the CSR requester
+//   - groups: the groups of the CSR requester
+//   - labels: the labels of the CSR
+//   - subject: the subject of the certificate request, a map with the commonName and organizations
+//   - usages: the requested key usages
+//   - signerName: the signer name of the CSR
+type ApprovalRule struct {
+	Name       string         `json:"name"`
+	Action     ApprovalAction `json:"action"`
+	Expression string         `json:"expression"`
+}
+
+type compiledApprovalRule struct {
+	ApprovalRule
+	program cel.Program
+}
+
+// approvalPolicyEvaluator compiles the approval policy and evaluates it against the CSRs, the compiled rules are
+// cached until the policy is changed.
+type approvalPolicyEvaluator struct {
+	env *cel.Env
+
+	mutex  sync.Mutex
+	policy string
+	rules  []compiledApprovalRule
+	err    error
+}
+
+func newApprovalPolicyEvaluator() (*approvalPolicyEvaluator, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("clusterName", cel.StringType),
+		cel.Variable("username", cel.StringType),
+		cel.Variable("groups", cel.ListType(cel.StringType)),
+		cel.Variable("labels", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("subject", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("usages", cel.ListType(cel.StringType)),
+		cel.Variable("signerName", cel.StringType),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &approvalPolicyEvaluator{env: env}, nil
+}
+
+// compile compiles the rules of the policy, the result is cached by the policy content.
+func (e *approvalPolicyEvaluator) compile(policy string) ([]compiledApprovalRule, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if policy == e.policy {
+		return e.rules, e.err
+	}
+
+	e.policy = policy
+	e.rules, e.err = e.compileRules(policy)
+	return e.rules, e.err
+}
+
+func (e *approvalPolicyEvaluator) compileRules(policy string) ([]compiledApprovalRule, error) {
+	if len(policy) == 0 {
+		return nil, nil
+	}
+
+	approvalPolicy := &ApprovalPolicy{}
+	if err := yaml.UnmarshalStrict([]byte(policy), approvalPolicy); err != nil {
+		return nil, fmt.Errorf("failed to parse the CSR approval policy: %v", err)
+	}
+
+	names := map[string]bool{}
+	rules := make([]compiledApprovalRule, 0, len(approvalPolicy.Rules))
+	for _, rule := range approvalPolicy.Rules {
+		if len(rule.Name) == 0 {
+			return nil, fmt.Errorf("the name of the CSR approval rule is required")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("the CSR approval rule %q is duplicated", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Action != ApprovalActionApprove && rule.Action != ApprovalActionDeny {
+			return nil, fmt.Errorf("the action %q of the CSR approval rule %q is invalid, it must be %s or %s",
+				rule.Action, rule.Name, ApprovalActionApprove, ApprovalActionDeny)
+		}
+
+		ast, issues := e.env.Compile(rule.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("failed to compile the CSR approval rule %q: %v", rule.Name, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("the expression of the CSR approval rule %q must be evaluated to a bool, but got %v",
+				rule.Name, ast.OutputType())
+		}
+		program, err := e.env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build the CSR approval rule %q: %v", rule.Name, err)
+		}
+		rules = append(rules, compiledApprovalRule{ApprovalRule: rule, program: program})
+	}
+	return rules, nil
+}
+
+// evaluate returns the first rule of the policy that matches the CSR, it returns nil if no rule matches. A rule
+// that fails to be evaluated is skipped.
+func (e *approvalPolicyEvaluator) evaluate(policy string,
+	csr *certificatesv1.CertificateSigningRequest) (*ApprovalRule, error) {
+	rules, err := e.compile(policy)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	variables := approvalPolicyVariables(csr)
+	for _, rule := range rules {
+		out, _, err := rule.program.Eval(variables)
+		if err != nil {
+			log.Info("Failed to evaluate the CSR approval rule", "rule", rule.Name, "csr", csr.Name, "error", err.Error())
+			continue
+		}
+		if matched, ok := out.Value().(bool); ok && matched {
+			matchedRule := rule.ApprovalRule
+			return &matchedRule, nil
+		}
+	}
+	return nil, nil
+}
+
+// approvalPolicyVariables returns the variables of the CSR to evaluate the approval rules.
+func approvalPolicyVariables(csr *certificatesv1.CertificateSigningRequest) map[string]interface{} {
+	groups := append([]string{}, csr.Spec.Groups...)
+	labels := map[string]string{}
+	for k, v := range csr.Labels {
+		labels[k] = v
+	}
+	usages := []string{}
+	for _, usage := range csr.Spec.Usages {
+		usages = append(usages, string(usage))
+	}
+
+	commonName, organizations := certificateRequestSubject(csr)
+	subject := map[string]interface{}{
+		"commonName":    commonName,
+		"organizations": organizations,
+	}
+
+	return map[string]interface{}{
+		"clusterName": helpers.GetClusterName(csr),
+		"username":    csr.Spec.Username,
+		"groups":      groups,
+		"labels":      labels,
+		"subject":     subject,
+		"usages":      usages,
+		"signerName":  csr.Spec.SignerName,
+	}
+}
+
+// certificateRequestSubject returns the common name and the organizations of the certificate request of the CSR,
+// they are empty if the request cannot be parsed.
+func certificateRequestSubject(csr *certificatesv1.CertificateSigningRequest) (string, []string) {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil {
+		return "", []string{}
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", []string{}
+	}
+	return request.Subject.CommonName, append([]string{}, request.Subject.Organization...)
+}
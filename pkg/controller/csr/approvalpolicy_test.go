@@ -0,0 +1,295 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events/eventstesting"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const testApprovalPolicy = `
+rules:
+- name: deny-test-clusters
+  action: Deny
+  expression: clusterName.startsWith("test-")
+- name: enrollment-service
+  action: Approve
+  expression: >-
+    username == "system:serviceaccount:edge:enrollment" &&
+    "client auth" in usages &&
+    subject.commonName.startsWith("system:open-cluster-management:" + clusterName + ":")
+`
+
+func newTestCSR(t *testing.T, clusterName, username string) *certificatesv1.CertificateSigningRequest {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   "system:open-cluster-management:" + clusterName + ":agent1",
+			Organization: []string{"system:open-cluster-management:" + clusterName},
+		},
+	}, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   csrNameReconcile,
+			Labels: map[string]string{constants.CSRClusterNameLabel: clusterName},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Username:   username,
+			Groups:     []string{"system:serviceaccounts", "system:authenticated"},
+			SignerName: certificatesv1.KubeAPIServerClientSignerName,
+			Usages:     []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageClientAuth},
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+		},
+	}
+}
+
+func TestApprovalPolicyEvaluator(t *testing.T) {
+	cases := []struct {
+		name         string
+		policy       string
+		csr          *certificatesv1.CertificateSigningRequest
+		expectedRule string
+		expectedErr  bool
+	}{
+		{
+			name: "no policy",
+			csr:  newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+		},
+		{
+			name:         "approved by the rule",
+			policy:       testApprovalPolicy,
+			csr:          newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedRule: "enrollment-service",
+		},
+		{
+			name:         "denied by the rule",
+			policy:       testApprovalPolicy,
+			csr:          newTestCSR(t, "test-cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedRule: "deny-test-clusters",
+		},
+		{
+			name:   "no rule matches",
+			policy: testApprovalPolicy,
+			csr:    newTestCSR(t, "cluster1", "system:serviceaccount:edge:other"),
+		},
+		{
+			name:   "subject of an invalid request",
+			policy: testApprovalPolicy,
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment")
+				csr.Spec.Request = []byte("invalid")
+				return csr
+			}(),
+		},
+		{
+			name:        "invalid yaml",
+			policy:      "rules: [",
+			csr:         newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedErr: true,
+		},
+		{
+			name: "invalid action",
+			policy: `
+rules:
+- name: rule1
+  action: Ignore
+  expression: "true"
+`,
+			csr:         newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedErr: true,
+		},
+		{
+			name: "duplicated rules",
+			policy: `
+rules:
+- name: rule1
+  action: Approve
+  expression: "true"
+- name: rule1
+  action: Deny
+  expression: "true"
+`,
+			csr:         newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedErr: true,
+		},
+		{
+			name: "undeclared variable",
+			policy: `
+rules:
+- name: rule1
+  action: Approve
+  expression: namespace == "edge"
+`,
+			csr:         newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedErr: true,
+		},
+		{
+			name: "non-bool expression",
+			policy: `
+rules:
+- name: rule1
+  action: Approve
+  expression: username
+`,
+			csr:         newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			evaluator, err := newApprovalPolicyEvaluator()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			rule, err := evaluator.evaluate(c.policy, c.csr)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			ruleName := ""
+			if rule != nil {
+				ruleName = rule.Name
+			}
+			if ruleName != c.expectedRule {
+				t.Errorf("expected rule %q, but got %q", c.expectedRule, ruleName)
+			}
+		})
+	}
+}
+
+func TestReconcileCSRWithApprovalPolicy(t *testing.T) {
+	cases := []struct {
+		name              string
+		csr               *certificatesv1.CertificateSigningRequest
+		builtinApproved   bool
+		expectedCondition certificatesv1.RequestConditionType
+		expectedReason    string
+	}{
+		{
+			name:              "approved by the policy",
+			csr:               newTestCSR(t, "cluster1", "system:serviceaccount:edge:enrollment"),
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRApprovalPolicy",
+		},
+		{
+			name:              "denied by the policy before the built-in conditions",
+			csr:               newTestCSR(t, "test-cluster1", "system:serviceaccount:edge:enrollment"),
+			builtinApproved:   true,
+			expectedCondition: certificatesv1.CertificateDenied,
+			expectedReason:    "DeniedByCSRApprovalPolicy",
+		},
+		{
+			name:              "approved by the built-in conditions",
+			csr:               newTestCSR(t, "cluster1", "system:serviceaccount:cluster1:cluster1-bootstrap-sa"),
+			builtinApproved:   true,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name: "not approved",
+			csr:  newTestCSR(t, "cluster1", "system:serviceaccount:edge:other"),
+		},
+		{
+			name: "addon csr is not denied by the policy",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "test-cluster1", "system:serviceaccount:edge:enrollment")
+				csr.Labels[addonv1alpha1.AddonLabelKey] = "addon1"
+				return csr
+			}(),
+		},
+		{
+			name: "csr of another signer is not denied by the policy",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "test-cluster1", "system:serviceaccount:edge:enrollment")
+				csr.Spec.SignerName = "open-cluster-management.io/addon1"
+				return csr
+			}(),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			controllerConfig := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      constants.ControllerConfigConfigMapName,
+					Namespace: "test",
+				},
+				Data: map[string]string{
+					constants.CSRApprovalPolicyKey: testApprovalPolicy,
+				},
+			}
+			kubeClient := fakeclientset.NewSimpleClientset(c.csr)
+			kubeInformerFactory := informers.NewSharedInformerFactory(kubeClient, 10*time.Minute)
+			if err := kubeInformerFactory.Core().V1().ConfigMaps().Informer().GetStore().Add(controllerConfig); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			evaluator, err := newApprovalPolicyEvaluator()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			r := &ReconcileCSR{
+				clientHolder: &helpers.ClientHolder{KubeClient: kubeClient},
+				recorder:     eventstesting.NewTestingEventRecorder(t),
+				approvalConditions: []func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error){
+					func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error) {
+						return c.builtinApproved, nil
+					},
+				},
+				importControllerConfig: helpers.NewImportControllerConfig("test",
+					kubeInformerFactory.Core().V1().ConfigMaps().Lister(), log),
+				approvalPolicy: evaluator,
+			}
+
+			if _, err := r.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: csrNameReconcile},
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			csr, err := kubeClient.CertificatesV1().CertificateSigningRequests().Get(
+				context.TODO(), csrNameReconcile, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.expectedCondition) == 0 {
+				if len(csr.Status.Conditions) != 0 {
+					t.Errorf("expected the csr is not approved, but got %v", csr.Status.Conditions)
+				}
+				return
+			}
+			if len(csr.Status.Conditions) != 1 || csr.Status.Conditions[0].Type != c.expectedCondition ||
+				csr.Status.Conditions[0].Reason != c.expectedReason {
+				t.Errorf("expected condition %s with reason %s, but got %v",
+					c.expectedCondition, c.expectedReason, csr.Status.Conditions)
+			}
+		})
+	}
+}
@@ -6,8 +6,11 @@ package csr
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/features"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 
 	certificatesv1 "k8s.io/api/certificates/v1"
@@ -15,6 +18,8 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	kevents "k8s.io/client-go/tools/events"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -23,6 +28,9 @@ import (
 
 const (
 	userNameSignature = "system:serviceaccount:%s:%s"
+
+	reasonApprovedByCSRApprovalPolicy = "AutoApprovedByCSRApprovalPolicy"
+	reasonApprovedByApprovalWebhook   = "AutoApprovedByApprovalWebhook"
 )
 
 var log = logf.Log.WithName("controller_csr")
@@ -39,9 +47,21 @@ func getApprovalType(csr *certificatesv1.CertificateSigningRequest) string {
 	return ""
 }
 
+// validUsername checks if the CSR is requested by the bootstrap identity of the cluster: the bootstrap service
+// account in the cluster namespace, the grpc server, or the per-cluster bootstrap service account of the
+// agent-registration cluster in the pod namespace.
 func validUsername(csr *certificatesv1.CertificateSigningRequest, clusterName string) bool {
 	return csr.Spec.Username == fmt.Sprintf(userNameSignature, clusterName, helpers.GetBootstrapSAName(clusterName)) ||
-		csr.Spec.Username == fmt.Sprintf(userNameSignature, helpers.HubNamespace, helpers.GRPCSAName)
+		csr.Spec.Username == fmt.Sprintf(userNameSignature, helpers.HubNamespace, helpers.GRPCSAName) ||
+		csr.Spec.Username == fmt.Sprintf(userNameSignature, os.Getenv(constants.PodNamespaceEnvVarName),
+			helpers.GetAgentRegistrationBootstrapSAName(clusterName))
+}
+
+// sharedAgentRegistrationBootstrapUsername checks if the CSR is requested by the shared bootstrap service account
+// of the agent-registration clusters in the pod namespace.
+func sharedAgentRegistrationBootstrapUsername(csr *certificatesv1.CertificateSigningRequest) bool {
+	return csr.Spec.Username == fmt.Sprintf(userNameSignature, os.Getenv(constants.PodNamespaceEnvVarName),
+		helpers.AgentRegistrationBootstrapSAName)
 }
 
 // isValidUnapprovedBootstrapCSR checks if the CSR:
@@ -53,6 +73,18 @@ func isValidUnapprovedBootstrapCSR(csr *certificatesv1.CertificateSigningRequest
 		getApprovalType(csr) == ""
 }
 
+// isRegistrationBootstrapCSR checks if the CSR is a hub client certificate request of a registration agent: it is
+// signed by the kube-apiserver-client signer and it is not requested by an addon agent. The CSRs of the addon
+// agents are labeled with the cluster name too, so the approval policy and the approval webhook only decide the
+// registration CSRs.
+func isRegistrationBootstrapCSR(csr *certificatesv1.CertificateSigningRequest) bool {
+	if csr.Spec.SignerName != certificatesv1.KubeAPIServerClientSignerName {
+		return false
+	}
+	_, isAddon := csr.Labels[addonv1alpha1.AddonLabelKey]
+	return !isAddon
+}
+
 // approveExistingManagedClusterCSR checks if the CSR is from an existing managed cluster
 func approveExistingManagedClusterCSR(ctx context.Context, csr *certificatesv1.CertificateSigningRequest,
 	clientHolder *helpers.ClientHolder) (bool, error) {
@@ -76,6 +108,25 @@ type ReconcileCSR struct {
 	clientHolder       *helpers.ClientHolder
 	recorder           events.Recorder
 	approvalConditions []func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error)
+
+	// importControllerConfig provides the CSR approval policy, the policy is not evaluated if it is nil
+	importControllerConfig *helpers.ImportControllerConfig
+	approvalPolicy         *approvalPolicyEvaluator
+	// approvalWebhook is the external approval source, it is not requested if it is nil
+	approvalWebhook *approvalWebhook
+
+	// rateTracker tracks the CSR requests of the managed clusters if the CSR rate limit is specified, the managed
+	// clusters that exceed the limit are reported by mcRecorder
+	rateTracker *csrRateTracker
+	mcRecorder  kevents.EventRecorder
+}
+
+// approvalDecision is the decision of a CSR with the reason and message of its approval condition.
+type approvalDecision struct {
+	conditionType certificatesv1.RequestConditionType
+	reason        string
+	message       string
+	eventMessage  string
 }
 
 // blank assignment to verify that ReconcileCSR implements reconcile.Reconciler
@@ -102,39 +153,296 @@ func (r *ReconcileCSR) Reconcile(ctx context.Context, request reconcile.Request)
 		return reconcile.Result{}, nil
 	}
 
-	// Check if any approval condition matches
-	shouldApprove := false
+	clusterName := helpers.GetClusterName(csr)
+	decision, err := r.decide(ctx, csr)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if decision == nil {
+		return reconcile.Result{}, nil
+	}
+
+	// The certificate request is validated before it is approved, an invalid request is denied instead of being
+	// left pending
+	if decision.conditionType == certificatesv1.CertificateApproved {
+		if err := validateCSR(csr); err != nil {
+			decision = &approvalDecision{
+				conditionType: certificatesv1.CertificateDenied,
+				reason:        "InvalidCertificateRequest",
+				message:       fmt.Sprintf("The managedcluster-import-controller denied this CSR: %v", err),
+				eventMessage:  fmt.Sprintf("managed cluster csr %q is denied: %v", csr.Name, err),
+			}
+		}
+	}
+
+	// The approvals of a cluster that exceeds the CSR rate limit are throttled or denied. Only the CSRs that would be
+	// approved for the cluster identity are recorded, so the CSRs labeled with the cluster name by other requesters
+	// cannot exhaust the limit of the cluster.
+	var rateLimit *RateLimit
+	var rateLimitResult rateLimitResult
+	if decision.conditionType == certificatesv1.CertificateApproved && isRateLimitedRequester(csr, decision) {
+		rateLimit, rateLimitResult = r.recordCSRRequest(csr)
+		if rateLimitResult.firstExceeded {
+			r.reportRateLimitExceeded(ctx, clusterName, rateLimit, rateLimitResult)
+		}
+	}
+	if decision.conditionType == certificatesv1.CertificateApproved && rateLimitResult.exceeded {
+		if rateLimit.Action == RateLimitActionThrottle {
+			reqLogger.Info("The CSR is throttled by the CSR rate limit", "cluster", clusterName,
+				"requests", rateLimitResult.requests, "retryAfter", rateLimitResult.retryAfter)
+			decisionsTotal.WithLabelValues(clusterName, "throttled").Inc()
+			return reconcile.Result{RequeueAfter: rateLimitResult.retryAfter}, nil
+		}
+
+		decision = &approvalDecision{
+			conditionType: certificatesv1.CertificateDenied,
+			reason:        "CSRRateLimitExceeded",
+			message: fmt.Sprintf("The managedcluster-import-controller denied this CSR because the cluster requested "+
+				"%d CSRs in %v, more than the limit %d", rateLimitResult.requests, rateLimit.Window.Duration,
+				rateLimit.MaxRequests),
+			eventMessage: fmt.Sprintf("managed cluster csr %q is denied because the cluster %s exceeds the CSR rate limit",
+				csr.Name, clusterName),
+		}
+	}
+
+	reqLogger.V(5).Info("Reconciling CSR", "decision", decision.conditionType, "reason", decision.reason)
+
+	if err := r.updateApproval(ctx, csr, decision.conditionType, decision.reason, decision.message); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if decision.conditionType == certificatesv1.CertificateDenied {
+		decisionsTotal.WithLabelValues(clusterName, "denied").Inc()
+		r.recorder.Warning("ManagedClusterCSRDenied", decision.eventMessage)
+		return reconcile.Result{}, nil
+	}
+	decisionsTotal.WithLabelValues(clusterName, "approved").Inc()
+	r.recorder.Event("ManagedClusterCSRAutoApproved", decision.eventMessage)
+	return reconcile.Result{}, nil
+}
+
+// isRateLimitedRequester checks if the CSR counts toward the CSR rate limit of the cluster: it is requested by the
+// bootstrap identity of the cluster, or it is approved by the approval policy or the approval webhook.
+func isRateLimitedRequester(csr *certificatesv1.CertificateSigningRequest, decision *approvalDecision) bool {
+	return validUsername(csr, helpers.GetClusterName(csr)) ||
+		decision.reason == reasonApprovedByCSRApprovalPolicy || decision.reason == reasonApprovedByApprovalWebhook
+}
+
+// recordCSRRequest records the CSR request of the cluster if the CSR rate limit is specified, it returns the rate
+// limit and the requests of the cluster in the window. An invalid rate limit is ignored.
+func (r *ReconcileCSR) recordCSRRequest(csr *certificatesv1.CertificateSigningRequest) (*RateLimit, rateLimitResult) {
+	if r.importControllerConfig == nil || r.rateTracker == nil {
+		return nil, rateLimitResult{}
+	}
+
+	data, err := r.importControllerConfig.GetCSRRateLimit()
+	if err != nil {
+		log.Error(err, "failed to get the CSR rate limit")
+		return nil, rateLimitResult{}
+	}
+	rateLimit, err := parseRateLimit(data)
+	if err != nil {
+		log.Error(err, "the CSR rate limit is ignored")
+		return nil, rateLimitResult{}
+	}
+	if rateLimit == nil {
+		return nil, rateLimitResult{}
+	}
+
+	return rateLimit, r.rateTracker.record(helpers.GetClusterName(csr), csr.Name,
+		csr.CreationTimestamp.Time, rateLimit)
+}
+
+// reportRateLimitExceeded posts a warning event on the managed cluster when it starts to exceed the CSR rate limit.
+func (r *ReconcileCSR) reportRateLimitExceeded(ctx context.Context, clusterName string,
+	rateLimit *RateLimit, result rateLimitResult) {
+	rateLimitExceededTotal.WithLabelValues(clusterName).Inc()
+	log.Info("The managed cluster exceeds the CSR rate limit", "cluster", clusterName,
+		"requests", result.requests, "window", rateLimit.Window.Duration, "maxRequests", rateLimit.MaxRequests)
+
+	if r.mcRecorder == nil {
+		return
+	}
+	cluster := &clusterv1.ManagedCluster{}
+	if err := r.clientHolder.RuntimeClient.Get(ctx, types.NamespacedName{Name: clusterName}, cluster); err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "failed to get the managed cluster", "cluster", clusterName)
+		}
+		return
+	}
+
+	mc := cluster.DeepCopy()
+	mc.SetNamespace(mc.Name)
+	r.mcRecorder.Eventf(mc, nil, corev1.EventTypeWarning,
+		constants.EventReasonManagedClusterCSRRateLimitExceeded, constants.EventReasonManagedClusterCSRRateLimitExceeded,
+		"The %s requested %d CSRs in %v, more than the limit %d, its CSR approvals are %s",
+		mc.Name, result.requests, rateLimit.Window.Duration, rateLimit.MaxRequests, rateLimitActionVerb(rateLimit.Action))
+}
+
+func rateLimitActionVerb(action RateLimitAction) string {
+	if action == RateLimitActionDeny {
+		return "denied"
+	}
+	return "throttled"
+}
+
+// decide returns the decision of the CSR, it returns nil if the CSR is left pending. The approval policy is
+// evaluated first, so its rules take precedence over the approval webhook and the built-in conditions, then the
+// approval webhook denies the CSR or allows it if any built-in condition matches too, at last the CSR is approved
+// if any built-in condition matches. The approval policy and the approval webhook only decide the registration
+// bootstrap CSRs, the other CSRs of the cluster, e.g. the addon CSRs, are left to the built-in conditions.
+// If the AgentRegistrationClusterIdentity feature is enabled, the CSR requested by the shared agent-registration
+// bootstrap service account is denied before all of them, so a cluster can only join with its own identity.
+func (r *ReconcileCSR) decide(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (*approvalDecision, error) {
+	if features.DefaultMutableFeatureGate.Enabled(features.AgentRegistrationClusterIdentity) &&
+		sharedAgentRegistrationBootstrapUsername(csr) {
+		return &approvalDecision{
+			conditionType: certificatesv1.CertificateDenied,
+			reason:        "SharedBootstrapIdentityDenied",
+			message: "The managedcluster-import-controller denied this CSR because it is requested by the shared " +
+				"agent-registration bootstrap identity",
+			eventMessage: fmt.Sprintf("managed cluster csr %q is denied because it is requested by the shared "+
+				"agent-registration bootstrap identity", csr.Name),
+		}, nil
+	}
+
+	if !isRegistrationBootstrapCSR(csr) {
+		return r.decideByApprovalConditions(ctx, csr)
+	}
+
+	if rule := r.evaluateApprovalPolicy(csr); rule != nil {
+		if rule.Action == ApprovalActionDeny {
+			return &approvalDecision{
+				conditionType: certificatesv1.CertificateDenied,
+				reason:        "DeniedByCSRApprovalPolicy",
+				message: fmt.Sprintf("The managedcluster-import-controller denied this CSR by the approval policy rule %q",
+					rule.Name),
+				eventMessage: fmt.Sprintf("managed cluster csr %q is denied by the approval policy rule %q",
+					csr.Name, rule.Name),
+			}, nil
+		}
+		return &approvalDecision{
+			conditionType: certificatesv1.CertificateApproved,
+			reason:        reasonApprovedByCSRApprovalPolicy,
+			message: fmt.Sprintf(
+				"The managedcluster-import-controller auto approval approved this CSR by the approval policy rule %q",
+				rule.Name),
+			eventMessage: fmt.Sprintf("managed cluster csr %q is auto approved by the approval policy rule %q",
+				csr.Name, rule.Name),
+		}, nil
+	}
+
+	if r.approvalWebhook != nil {
+		response, err := r.approvalWebhook.review(ctx, csr)
+		if err != nil {
+			if r.approvalWebhook.failurePolicy == WebhookFailurePolicyFail {
+				return nil, err
+			}
+			log.Error(err, "the approval webhook abstains because it failed", "csr", csr.Name)
+			response = &WebhookReviewResponse{Decision: WebhookDecisionAbstain}
+		}
+
+		switch response.Decision {
+		case WebhookDecisionDeny:
+			return &approvalDecision{
+				conditionType: certificatesv1.CertificateDenied,
+				reason:        "DeniedByApprovalWebhook",
+				message: fmt.Sprintf("The managedcluster-import-controller denied this CSR by the approval webhook: %s",
+					response.Reason),
+				eventMessage: fmt.Sprintf("managed cluster csr %q is denied by the approval webhook: %s",
+					csr.Name, response.Reason),
+			}, nil
+		case WebhookDecisionAllow:
+			// The webhook cannot approve the CSR of an unknown identity or a cluster that does not exist, the
+			// built-in conditions are required as well
+			matched, err := r.matchApprovalConditions(ctx, csr)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				log.Info("The CSR allowed by the approval webhook is left pending because no built-in condition matches",
+					"csr", csr.Name)
+				return nil, nil
+			}
+			return &approvalDecision{
+				conditionType: certificatesv1.CertificateApproved,
+				reason:        reasonApprovedByApprovalWebhook,
+				message: fmt.Sprintf(
+					"The managedcluster-import-controller auto approval approved this CSR by the approval webhook: %s",
+					response.Reason),
+				eventMessage: fmt.Sprintf("managed cluster csr %q is auto approved by the approval webhook", csr.Name),
+			}, nil
+		}
+	}
+
+	return r.decideByApprovalConditions(ctx, csr)
+}
+
+// decideByApprovalConditions approves the CSR if any built-in condition matches, otherwise the CSR is left pending.
+func (r *ReconcileCSR) decideByApprovalConditions(ctx context.Context,
+	csr *certificatesv1.CertificateSigningRequest) (*approvalDecision, error) {
+	matched, err := r.matchApprovalConditions(ctx, csr)
+	if err != nil || !matched {
+		return nil, err
+	}
+	return &approvalDecision{
+		conditionType: certificatesv1.CertificateApproved,
+		reason:        "AutoApprovedByCSRController",
+		message:       "The managedcluster-import-controller auto approval automatically approved this CSR",
+		eventMessage:  fmt.Sprintf("managed cluster csr %q is auto approved by import controller", csr.Name),
+	}, nil
+}
+
+// matchApprovalConditions checks if any built-in approval condition matches the CSR.
+func (r *ReconcileCSR) matchApprovalConditions(ctx context.Context,
+	csr *certificatesv1.CertificateSigningRequest) (bool, error) {
 	for _, condition := range r.approvalConditions {
 		matched, err := condition(ctx, csr)
 		if err != nil {
-			return reconcile.Result{}, err
+			return false, err
 		}
 		if matched {
-			shouldApprove = true
-			break
+			return true, nil
 		}
 	}
+	return false, nil
+}
+
+// evaluateApprovalPolicy returns the approval policy rule that matches the CSR. An invalid policy is ignored, so
+// the built-in conditions still work.
+func (r *ReconcileCSR) evaluateApprovalPolicy(csr *certificatesv1.CertificateSigningRequest) *ApprovalRule {
+	if r.importControllerConfig == nil || r.approvalPolicy == nil {
+		return nil
+	}
 
-	if !shouldApprove {
-		return reconcile.Result{}, nil
+	policy, err := r.importControllerConfig.GetCSRApprovalPolicy()
+	if err != nil {
+		log.Error(err, "failed to get the CSR approval policy")
+		return nil
 	}
 
-	reqLogger.V(5).Info("Reconciling CSR")
+	rule, err := r.approvalPolicy.evaluate(policy, csr)
+	if err != nil {
+		log.Error(err, "the CSR approval policy is ignored")
+		return nil
+	}
+	return rule
+}
 
+// updateApproval approves or denies the CSR with the reason and message.
+func (r *ReconcileCSR) updateApproval(ctx context.Context, csr *certificatesv1.CertificateSigningRequest,
+	conditionType certificatesv1.RequestConditionType, reason, message string) error {
 	csr = csr.DeepCopy()
 	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
-		Type:           certificatesv1.CertificateApproved,
+		Type:           conditionType,
 		Status:         corev1.ConditionTrue,
-		Reason:         "AutoApprovedByCSRController",
-		Message:        "The managedcluster-import-controller auto approval automatically approved this CSR",
+		Reason:         reason,
+		Message:        message,
 		LastUpdateTime: metav1.Now(),
 	})
-	if _, err := csrReq.UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{}); err != nil {
-		return reconcile.Result{}, err
-	}
-
-	r.recorder.Eventf("ManagedClusterCSRAutoApproved", "managed cluster csr %q is auto approved by import controller", csr.Name)
-	return reconcile.Result{}, nil
+	_, err := r.clientHolder.KubeClient.CertificatesV1().CertificateSigningRequests().UpdateApproval(
+		ctx, csr.Name, csr, metav1.UpdateOptions{})
+	return err
 }
 
 // check whether a CSR is in terminal state
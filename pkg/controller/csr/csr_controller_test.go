@@ -11,6 +11,7 @@ import (
 
 	"github.com/openshift/library-go/pkg/operator/events/eventstesting"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/features"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 
@@ -34,29 +35,13 @@ const (
 
 func TestReconcileCSR_Reconcile(t *testing.T) {
 
-	testCSR := &certificatesv1.CertificateSigningRequest{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: csrNameReconcile,
-			Labels: map[string]string{
-				constants.CSRClusterNameLabel: clusterName,
-			},
-		},
-		Spec: certificatesv1.CertificateSigningRequestSpec{
-			Username: fmt.Sprintf(userNameSignature, clusterName, helpers.GetBootstrapSAName(clusterName)),
-		},
-	}
+	testCSR := newTestCSR(t, clusterName, fmt.Sprintf(userNameSignature, clusterName, helpers.GetBootstrapSAName(clusterName)))
 
-	testSpecialClusterCSR := &certificatesv1.CertificateSigningRequest{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: csrNameReconcile,
-			Labels: map[string]string{
-				constants.CSRClusterNameLabel: "specialCluster",
-			},
-		},
-		Spec: certificatesv1.CertificateSigningRequestSpec{
-			Username: fmt.Sprintf(userNameSignature, "specialCluster", helpers.GetBootstrapSAName("specialCluster")),
-		},
-	}
+	testSpecialClusterCSR := newTestCSR(t, "specialCluster",
+		fmt.Sprintf(userNameSignature, "specialCluster", helpers.GetBootstrapSAName("specialCluster")))
+
+	testInvalidCSR := newTestCSR(t, clusterName, fmt.Sprintf(userNameSignature, clusterName, helpers.GetBootstrapSAName(clusterName)))
+	testInvalidCSR.Spec.Usages = append(testInvalidCSR.Spec.Usages, certificatesv1.UsageServerAuth)
 
 	testManagedCluster := &clusterv1.ManagedCluster{
 		ObjectMeta: metav1.ObjectMeta{
@@ -135,6 +120,21 @@ func TestReconcileCSR_Reconcile(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "testInvalidCSR",
+			fields: fields{
+				client:     fake.NewClientBuilder().WithScheme(testscheme).WithObjects(testManagedCluster, testInvalidCSR).Build(),
+				kubeClient: fakeclientset.NewSimpleClientset(testInvalidCSR),
+				scheme:     testscheme,
+			},
+			args: args{
+				request: req,
+			},
+			want: reconcile.Result{
+				Requeue: false,
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -187,6 +187,10 @@ func TestReconcileCSR_Reconcile(t *testing.T) {
 					if csr.Status.Conditions[0].Type != certificatesv1.CertificateApproved {
 						t.Error("CSR not approved")
 					}
+				case "testInvalidCSR":
+					if csr.Status.Conditions[0].Type != certificatesv1.CertificateDenied {
+						t.Error("CSR not denied")
+					}
 				case "testCSRClusterNotFound":
 					if len(csr.Status.Conditions) != 0 {
 						t.Error("CSR should not have been approved")
@@ -200,6 +204,82 @@ func TestReconcileCSR_Reconcile(t *testing.T) {
 
 }
 
+func TestReconcileCSRWithClusterIdentity(t *testing.T) {
+	t.Setenv(constants.PodNamespaceEnvVarName, "open-cluster-management")
+
+	cases := []struct {
+		name              string
+		clusterIdentity   bool
+		username          string
+		expectedCondition certificatesv1.RequestConditionType
+		expectedReason    string
+	}{
+		{
+			name:              "shared identity without the cluster identity feature",
+			username:          "system:serviceaccount:open-cluster-management:agent-registration-bootstrap",
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name:              "shared identity with the cluster identity feature",
+			clusterIdentity:   true,
+			username:          "system:serviceaccount:open-cluster-management:agent-registration-bootstrap",
+			expectedCondition: certificatesv1.CertificateDenied,
+			expectedReason:    "SharedBootstrapIdentityDenied",
+		},
+		{
+			name:              "cluster identity with the cluster identity feature",
+			clusterIdentity:   true,
+			username:          "system:serviceaccount:open-cluster-management:agent-registration-bootstrap-cluster1",
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{
+				string(features.AgentRegistrationClusterIdentity): c.clusterIdentity,
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer func() {
+				_ = features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{
+					string(features.AgentRegistrationClusterIdentity): false,
+				})
+			}()
+
+			kubeClient := fakeclientset.NewSimpleClientset(newTestCSR(t, "cluster1", c.username))
+			r := &ReconcileCSR{
+				clientHolder: &helpers.ClientHolder{KubeClient: kubeClient},
+				recorder:     eventstesting.NewTestingEventRecorder(t),
+				approvalConditions: []func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error){
+					func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error) {
+						return true, nil
+					},
+				},
+			}
+
+			if _, err := r.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: csrNameReconcile},
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			csr, err := kubeClient.CertificatesV1().CertificateSigningRequests().Get(
+				context.TODO(), csrNameReconcile, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(csr.Status.Conditions) != 1 || csr.Status.Conditions[0].Type != c.expectedCondition ||
+				csr.Status.Conditions[0].Reason != c.expectedReason {
+				t.Errorf("expected condition %s with reason %s, but got %v",
+					c.expectedCondition, c.expectedReason, csr.Status.Conditions)
+			}
+		})
+	}
+}
+
 func Test_getApproval(t *testing.T) {
 	testCSRNoApproval := &certificatesv1.CertificateSigningRequest{
 		ObjectMeta: metav1.ObjectMeta{
@@ -311,6 +391,25 @@ func Test_validUsername(t *testing.T) {
 		},
 	}
 
+	testCSRAgentRegistration := &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: csrNameReconcile,
+			Labels: map[string]string{
+				constants.CSRClusterNameLabel: clusterName,
+			},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Username: fmt.Sprintf(userNameSignature, "multicluster-engine",
+				helpers.GetAgentRegistrationBootstrapSAName(clusterName)),
+		},
+	}
+
+	testCSRAgentRegistrationOtherCluster := testCSRAgentRegistration.DeepCopy()
+	testCSRAgentRegistrationOtherCluster.Spec.Username = fmt.Sprintf(userNameSignature, "multicluster-engine",
+		helpers.GetAgentRegistrationBootstrapSAName("other-cluster"))
+
+	t.Setenv(constants.PodNamespaceEnvVarName, "multicluster-engine")
+
 	type args struct {
 		csr         *certificatesv1.CertificateSigningRequest
 		clusterName string
@@ -328,6 +427,22 @@ func Test_validUsername(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "testCSRAgentRegistration",
+			args: args{
+				csr:         testCSRAgentRegistration,
+				clusterName: clusterName,
+			},
+			want: true,
+		},
+		{
+			name: "testCSRAgentRegistrationOtherCluster",
+			args: args{
+				csr:         testCSRAgentRegistrationOtherCluster,
+				clusterName: clusterName,
+			},
+			want: false,
+		},
 		{
 			name: "testCSRBadUsername",
 			args: args{
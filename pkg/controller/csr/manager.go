@@ -6,7 +6,10 @@ import (
 	"context"
 
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"github.com/stolostron/managedcluster-import-controller/pkg/source"
 	certificatesv1 "k8s.io/api/certificates/v1"
+	kevents "k8s.io/client-go/tools/events"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -25,9 +28,21 @@ const (
 func Add(ctx context.Context,
 	mgr manager.Manager,
 	clientHolder *helpers.ClientHolder,
+	informerHolder *source.InformerHolder,
+	componentNamespace string,
+	webhookOptions *WebhookOptions,
+	mcRecorder kevents.EventRecorder,
 	extraApprovalConditions []func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error)) error {
+	approvalPolicy, err := newApprovalPolicyEvaluator()
+	if err != nil {
+		return err
+	}
+	approvalWebhook, err := newApprovalWebhook(webhookOptions)
+	if err != nil {
+		return err
+	}
 
-	err := ctrl.NewControllerManagedBy(mgr).Named(ControllerName).
+	err = ctrl.NewControllerManagedBy(mgr).Named(ControllerName).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: helpers.GetMaxConcurrentReconciles(),
 		}).
@@ -53,6 +68,12 @@ func Add(ctx context.Context,
 					return approveExistingManagedClusterCSR(ctx, csr, clientHolder)
 				},
 			}, extraApprovalConditions...),
+			importControllerConfig: helpers.NewImportControllerConfig(componentNamespace,
+				informerHolder.ControllerConfigLister, log),
+			approvalPolicy:  approvalPolicy,
+			approvalWebhook: approvalWebhook,
+			rateTracker:     newCSRRateTracker(clock.RealClock{}),
+			mcRecorder:      mcRecorder,
 		})
 
 	return err
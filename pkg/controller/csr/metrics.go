@@ -0,0 +1,27 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// decisionsTotal is the number of the decisions on the CSRs of each managed cluster by the decision (approved,
+	// denied or throttled).
+	decisionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "csr_controller_decisions_total",
+		Help: "Total number of the decisions on the managed cluster CSRs by the cluster and the decision.",
+	}, []string{"cluster", "decision"})
+
+	// rateLimitExceededTotal is the number of the times that each managed cluster exceeds the CSR rate limit.
+	rateLimitExceededTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "csr_controller_rate_limit_exceeded_total",
+		Help: "Total number of the times that a managed cluster exceeds the CSR rate limit.",
+	}, []string{"cluster"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(decisionsTotal, rateLimitExceededTotal)
+}
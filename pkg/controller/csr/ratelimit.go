@@ -0,0 +1,149 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/yaml"
+)
+
+// RateLimitAction is the action on the CSRs of a managed cluster that exceeds the CSR rate limit.
+type RateLimitAction string
+
+const (
+	// RateLimitActionThrottle leaves the CSRs pending until the requests of the cluster are under the limit.
+	RateLimitActionThrottle RateLimitAction = "Throttle"
+	// RateLimitActionDeny denies the CSRs.
+	RateLimitActionDeny RateLimitAction = "Deny"
+)
+
+// minRateLimitRetryInterval is the minimum interval to requeue a throttled CSR.
+const minRateLimitRetryInterval = time.Second
+
+// RateLimit is the CSR rate limit in the csrRateLimit key of the import-controller-config ConfigMap. A managed
+// cluster exceeds the limit if it requests more than maxRequests CSRs in the window, the CSRs that would be
+// approved are throttled or denied until the requests of the cluster in the window are under the limit.
+//
+// example:
+//
+//	maxRequests: 10
+//	window: 1h
+//	action: Throttle
+type RateLimit struct {
+	MaxRequests int             `json:"maxRequests"`
+	Window      metav1.Duration `json:"window"`
+	Action      RateLimitAction `json:"action,omitempty"`
+}
+
+// parseRateLimit parses the CSR rate limit, it returns nil if the rate limit is not specified.
+func parseRateLimit(data string) (*RateLimit, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	limit := &RateLimit{}
+	if err := yaml.UnmarshalStrict([]byte(data), limit); err != nil {
+		return nil, fmt.Errorf("failed to parse the CSR rate limit: %v", err)
+	}
+	if limit.MaxRequests <= 0 {
+		return nil, fmt.Errorf("the maxRequests %d of the CSR rate limit must be positive", limit.MaxRequests)
+	}
+	if limit.Window.Duration <= 0 {
+		return nil, fmt.Errorf("the window %v of the CSR rate limit must be positive", limit.Window.Duration)
+	}
+	switch limit.Action {
+	case "":
+		limit.Action = RateLimitActionThrottle
+	case RateLimitActionThrottle, RateLimitActionDeny:
+	default:
+		return nil, fmt.Errorf("the action %q of the CSR rate limit is invalid, it must be %s or %s",
+			limit.Action, RateLimitActionThrottle, RateLimitActionDeny)
+	}
+	return limit, nil
+}
+
+// rateLimitResult is the CSR requests of a managed cluster in the window of the rate limit.
+type rateLimitResult struct {
+	// requests is the number of the CSRs that are requested by the cluster in the window
+	requests int
+	exceeded bool
+	// firstExceeded is true if the cluster was under the limit before this CSR
+	firstExceeded bool
+	// retryAfter is the duration after which the requests of the cluster are under the limit
+	retryAfter time.Duration
+}
+
+// csrRateTracker tracks the creation time of the CSRs of each managed cluster in the window of the rate limit. The
+// CSRs are tracked by name, so a CSR that is reconciled repeatedly is counted once. The tracker is in memory, after
+// the controller restarts only the pending CSRs are counted.
+type csrRateTracker struct {
+	clock clock.PassiveClock
+
+	mutex    sync.Mutex
+	clusters map[string]*clusterCSRRequests
+}
+
+type clusterCSRRequests struct {
+	requests map[string]time.Time
+	exceeded bool
+}
+
+func newCSRRateTracker(clock clock.PassiveClock) *csrRateTracker {
+	return &csrRateTracker{
+		clock:    clock,
+		clusters: map[string]*clusterCSRRequests{},
+	}
+}
+
+// record records the CSR of the cluster and returns the requests of the cluster in the window of the limit. A CSR
+// that is created before the window is not counted.
+func (t *csrRateTracker) record(clusterName, csrName string, created time.Time, limit *RateLimit) rateLimitResult {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.clock.Now()
+	windowStart := now.Add(-limit.Window.Duration)
+
+	cluster, ok := t.clusters[clusterName]
+	if !ok {
+		cluster = &clusterCSRRequests{requests: map[string]time.Time{}}
+		t.clusters[clusterName] = cluster
+	}
+	for name, requested := range cluster.requests {
+		if requested.Before(windowStart) {
+			delete(cluster.requests, name)
+		}
+	}
+	if !created.Before(windowStart) {
+		cluster.requests[csrName] = created
+	}
+
+	result := rateLimitResult{requests: len(cluster.requests)}
+	if result.requests <= limit.MaxRequests {
+		cluster.exceeded = false
+		if result.requests == 0 {
+			delete(t.clusters, clusterName)
+		}
+		return result
+	}
+
+	result.exceeded = true
+	result.firstExceeded = !cluster.exceeded
+	cluster.exceeded = true
+
+	// the requests are under the limit after the oldest (requests - maxRequests) CSRs are out of the window
+	requested := make([]time.Time, 0, len(cluster.requests))
+	for _, created := range cluster.requests {
+		requested = append(requested, created)
+	}
+	sort.Slice(requested, func(i, j int) bool { return requested[i].Before(requested[j]) })
+	result.retryAfter = max(requested[result.requests-limit.MaxRequests-1].Sub(windowStart),
+		minRateLimitRetryInterval)
+	return result
+}
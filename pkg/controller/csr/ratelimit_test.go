@@ -0,0 +1,305 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events/eventstesting"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	kevents "k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type fakePassiveClock struct {
+	now time.Time
+}
+
+func (c *fakePassiveClock) Now() time.Time                   { return c.now }
+func (c *fakePassiveClock) Since(ts time.Time) time.Duration { return c.now.Sub(ts) }
+
+func TestParseRateLimit(t *testing.T) {
+	cases := []struct {
+		name          string
+		data          string
+		expectedLimit *RateLimit
+		expectedErr   bool
+	}{
+		{
+			name: "not specified",
+		},
+		{
+			name: "default action",
+			data: "maxRequests: 10\nwindow: 1h",
+			expectedLimit: &RateLimit{
+				MaxRequests: 10, Window: metav1.Duration{Duration: time.Hour}, Action: RateLimitActionThrottle},
+		},
+		{
+			name: "deny",
+			data: "maxRequests: 3\nwindow: 10m\naction: Deny",
+			expectedLimit: &RateLimit{
+				MaxRequests: 3, Window: metav1.Duration{Duration: 10 * time.Minute}, Action: RateLimitActionDeny},
+		},
+		{
+			name:        "no max requests",
+			data:        "window: 1h",
+			expectedErr: true,
+		},
+		{
+			name:        "no window",
+			data:        "maxRequests: 10",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid action",
+			data:        "maxRequests: 10\nwindow: 1h\naction: Drop",
+			expectedErr: true,
+		},
+		{
+			name:        "unknown field",
+			data:        "maxRequests: 10\nwindow: 1h\nburst: 5",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			limit, err := parseRateLimit(c.data)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if c.expectedLimit == nil {
+				if limit != nil {
+					t.Errorf("expected no rate limit, but got %v", limit)
+				}
+				return
+			}
+			if limit == nil || *limit != *c.expectedLimit {
+				t.Errorf("expected rate limit %v, but got %v", c.expectedLimit, limit)
+			}
+		})
+	}
+}
+
+func TestCSRRateTracker(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := &fakePassiveClock{now: now}
+	tracker := newCSRRateTracker(fakeClock)
+	limit := &RateLimit{MaxRequests: 2, Window: metav1.Duration{Duration: time.Hour}}
+
+	// a CSR that is created before the window is not counted
+	if result := tracker.record("cluster1", "csr0", now.Add(-2*time.Hour), limit); result.requests != 0 {
+		t.Errorf("expected no request, but got %v", result)
+	}
+
+	if result := tracker.record("cluster1", "csr1", now.Add(-30*time.Minute), limit); result.exceeded {
+		t.Errorf("expected the limit is not exceeded, but got %v", result)
+	}
+	if result := tracker.record("cluster1", "csr2", now.Add(-20*time.Minute), limit); result.exceeded {
+		t.Errorf("expected the limit is not exceeded, but got %v", result)
+	}
+	// a CSR is counted once
+	if result := tracker.record("cluster1", "csr2", now.Add(-20*time.Minute), limit); result.requests != 2 {
+		t.Errorf("expected 2 requests, but got %v", result)
+	}
+	// the requests of other clusters are not counted
+	if result := tracker.record("cluster2", "csr1", now, limit); result.requests != 1 {
+		t.Errorf("expected 1 request, but got %v", result)
+	}
+
+	result := tracker.record("cluster1", "csr3", now, limit)
+	if !result.exceeded || !result.firstExceeded || result.requests != 3 || result.retryAfter != 30*time.Minute {
+		t.Errorf("expected the limit is exceeded for the first time and retry after 30m, but got %v", result)
+	}
+	result = tracker.record("cluster1", "csr4", now, limit)
+	if !result.exceeded || result.firstExceeded || result.requests != 4 || result.retryAfter != 40*time.Minute {
+		t.Errorf("expected the limit is still exceeded and retry after 40m, but got %v", result)
+	}
+
+	// the oldest requests are out of the window
+	fakeClock.now = now.Add(45 * time.Minute)
+	if result := tracker.record("cluster1", "csr4", now, limit); result.exceeded || result.requests != 2 {
+		t.Errorf("expected the limit is not exceeded, but got %v", result)
+	}
+	result = tracker.record("cluster1", "csr5", fakeClock.now, limit)
+	if !result.exceeded || !result.firstExceeded {
+		t.Errorf("expected the limit is exceeded for the first time again, but got %v", result)
+	}
+}
+
+func TestReconcileCSRWithRateLimit(t *testing.T) {
+	now := time.Now()
+	testscheme := scheme.Scheme
+	testscheme.AddKnownTypes(clusterv1.SchemeGroupVersion, &clusterv1.ManagedCluster{})
+
+	cases := []struct {
+		name              string
+		rateLimit         string
+		username          string
+		previousRequests  int
+		expectedRequests  int
+		expectedRequeue   bool
+		expectedCondition certificatesv1.RequestConditionType
+		expectedReason    string
+		expectedEvent     bool
+	}{
+		{
+			name:              "no rate limit",
+			previousRequests:  10,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name:              "invalid rate limit is ignored",
+			rateLimit:         "maxRequests: 0",
+			previousRequests:  10,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name:              "under the limit",
+			rateLimit:         "maxRequests: 3\nwindow: 1h",
+			previousRequests:  2,
+			expectedRequests:  3,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name:              "CSR of another requester is not recorded",
+			rateLimit:         "maxRequests: 3\nwindow: 1h",
+			username:          "system:serviceaccount:default:other",
+			previousRequests:  3,
+			expectedRequests:  3,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name:             "throttled",
+			rateLimit:        "maxRequests: 3\nwindow: 1h",
+			previousRequests: 3,
+			expectedRequeue:  true,
+			expectedEvent:    true,
+		},
+		{
+			name:              "denied",
+			rateLimit:         "maxRequests: 3\nwindow: 1h\naction: Deny",
+			previousRequests:  3,
+			expectedCondition: certificatesv1.CertificateDenied,
+			expectedReason:    "CSRRateLimitExceeded",
+			expectedEvent:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			username := c.username
+			if len(username) == 0 {
+				username = "system:serviceaccount:cluster1:cluster1-bootstrap-sa"
+			}
+			csr := newTestCSR(t, "cluster1", username)
+			csr.CreationTimestamp = metav1.NewTime(now)
+			controllerConfig := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      constants.ControllerConfigConfigMapName,
+					Namespace: "test",
+				},
+				Data: map[string]string{},
+			}
+			if len(c.rateLimit) > 0 {
+				controllerConfig.Data[constants.CSRRateLimitKey] = c.rateLimit
+			}
+
+			kubeClient := fakeclientset.NewSimpleClientset(csr)
+			kubeInformerFactory := informers.NewSharedInformerFactory(kubeClient, 10*time.Minute)
+			if err := kubeInformerFactory.Core().V1().ConfigMaps().Informer().GetStore().Add(controllerConfig); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tracker := newCSRRateTracker(&fakePassiveClock{now: now})
+			previousLimit := &RateLimit{MaxRequests: 100, Window: metav1.Duration{Duration: time.Hour}}
+			for i := 0; i < c.previousRequests; i++ {
+				tracker.record("cluster1", fmt.Sprintf("previous-%d", i), now.Add(-time.Minute), previousLimit)
+			}
+
+			mcRecorder := kevents.NewFakeRecorder(10)
+			r := &ReconcileCSR{
+				clientHolder: &helpers.ClientHolder{
+					KubeClient: kubeClient,
+					RuntimeClient: fake.NewClientBuilder().WithScheme(testscheme).WithObjects(&clusterv1.ManagedCluster{
+						ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+					}).Build(),
+				},
+				recorder: eventstesting.NewTestingEventRecorder(t),
+				approvalConditions: []func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error){
+					func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error) {
+						return true, nil
+					},
+				},
+				importControllerConfig: helpers.NewImportControllerConfig("test",
+					kubeInformerFactory.Core().V1().ConfigMaps().Lister(), log),
+				rateTracker: tracker,
+				mcRecorder:  mcRecorder,
+			}
+
+			result, err := r.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: csrNameReconcile},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (result.RequeueAfter > 0) != c.expectedRequeue {
+				t.Errorf("expected requeue %v, but got %v", c.expectedRequeue, result)
+			}
+
+			if c.expectedRequests > 0 {
+				if requests := len(tracker.clusters["cluster1"].requests); requests != c.expectedRequests {
+					t.Errorf("expected %d recorded requests, but got %d", c.expectedRequests, requests)
+				}
+			}
+
+			select {
+			case event := <-mcRecorder.Events:
+				if !c.expectedEvent {
+					t.Errorf("unexpected event %s", event)
+				} else if !strings.Contains(event, constants.EventReasonManagedClusterCSRRateLimitExceeded) {
+					t.Errorf("unexpected event %s", event)
+				}
+			default:
+				if c.expectedEvent {
+					t.Errorf("expected an event on the managed cluster, but got none")
+				}
+			}
+
+			actual, err := kubeClient.CertificatesV1().CertificateSigningRequests().Get(
+				context.TODO(), csrNameReconcile, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.expectedCondition) == 0 {
+				if len(actual.Status.Conditions) != 0 {
+					t.Errorf("expected the csr is pending, but got %v", actual.Status.Conditions)
+				}
+				return
+			}
+			if len(actual.Status.Conditions) != 1 || actual.Status.Conditions[0].Type != c.expectedCondition ||
+				actual.Status.Conditions[0].Reason != c.expectedReason {
+				t.Errorf("expected condition %s with reason %s, but got %v",
+					c.expectedCondition, c.expectedReason, actual.Status.Conditions)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// minRSAKeySize is the minimum size in bits of the RSA keys of the certificate requests.
+	minRSAKeySize = 2048
+	// minECDSAKeySize is the minimum curve size in bits of the ECDSA keys of the certificate requests.
+	minECDSAKeySize = 256
+)
+
+// allowedUsages are the key usages that a registration agent can request, the client auth is required.
+var allowedUsages = sets.New[certificatesv1.KeyUsage](
+	certificatesv1.UsageDigitalSignature,
+	certificatesv1.UsageKeyEncipherment,
+	certificatesv1.UsageClientAuth,
+)
+
+// validateCSR checks the x509 certificate request of the CSR is a hub client certificate request of a registration
+// agent of the cluster, it returns the reason why the CSR is invalid:
+//  1. the signer is the kube-apiserver-client signer
+//  2. the key usages are limited to the client auth
+//  3. the common name is system:open-cluster-management:<cluster name>:<agent name>
+//  4. the organizations are system:open-cluster-management:<cluster name> and optionally the managed clusters group
+//  5. the key is not smaller than the minimum size
+func validateCSR(csr *certificatesv1.CertificateSigningRequest) error {
+	if csr.Spec.SignerName != certificatesv1.KubeAPIServerClientSignerName {
+		return fmt.Errorf("the signer %q is not %q", csr.Spec.SignerName, certificatesv1.KubeAPIServerClientSignerName)
+	}
+
+	usages := sets.New[certificatesv1.KeyUsage](csr.Spec.Usages...)
+	if !usages.Has(certificatesv1.UsageClientAuth) {
+		return fmt.Errorf("the key usage %q is not requested", certificatesv1.UsageClientAuth)
+	}
+	if extra := usages.Difference(allowedUsages); extra.Len() > 0 {
+		return fmt.Errorf("the key usages %v are not allowed", sets.List(extra))
+	}
+
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return fmt.Errorf("the request is not a PEM encoded certificate request")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse the certificate request: %v", err)
+	}
+	if err := request.CheckSignature(); err != nil {
+		return fmt.Errorf("the signature of the certificate request is invalid: %v", err)
+	}
+
+	clusterGroup := helpers.GetClusterGroup(helpers.GetClusterName(csr))
+	agentName := strings.TrimPrefix(request.Subject.CommonName, clusterGroup+":")
+	if agentName == request.Subject.CommonName || len(agentName) == 0 || strings.Contains(agentName, ":") {
+		return fmt.Errorf("the common name %q is not in the format of %s:<agent name>",
+			request.Subject.CommonName, clusterGroup)
+	}
+
+	organizations := sets.New[string](request.Subject.Organization...)
+	if !organizations.Has(clusterGroup) {
+		return fmt.Errorf("the organization %q is not requested", clusterGroup)
+	}
+	if extra := organizations.Difference(sets.New[string](clusterGroup, helpers.ManagedClustersGroup)); extra.Len() > 0 {
+		return fmt.Errorf("the organizations %v are not allowed", sets.List(extra))
+	}
+
+	switch key := request.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeySize {
+			return fmt.Errorf("the RSA key size %d is smaller than %d", key.N.BitLen(), minRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize < minECDSAKeySize {
+			return fmt.Errorf("the ECDSA key size %d is smaller than %d", key.Curve.Params().BitSize, minECDSAKeySize)
+		}
+	case ed25519.PublicKey:
+	default:
+		return fmt.Errorf("the public key type %T is not supported", key)
+	}
+
+	return nil
+}
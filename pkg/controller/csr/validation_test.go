@@ -0,0 +1,132 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	certificatesv1 "k8s.io/api/certificates/v1"
+)
+
+func TestValidateCSR(t *testing.T) {
+	cases := []struct {
+		name        string
+		csr         func() *certificatesv1.CertificateSigningRequest
+		expectedErr bool
+	}{
+		{
+			name: "valid",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				return newTestCSR(t, "cluster1", "user1")
+			},
+		},
+		{
+			name: "invalid signer",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "user1")
+				csr.Spec.SignerName = certificatesv1.KubeletServingSignerName
+				return csr
+			},
+			expectedErr: true,
+		},
+		{
+			name: "no client auth",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "user1")
+				csr.Spec.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature}
+				return csr
+			},
+			expectedErr: true,
+		},
+		{
+			name: "server auth",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "user1")
+				csr.Spec.Usages = append(csr.Spec.Usages, certificatesv1.UsageServerAuth)
+				return csr
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid request",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "user1")
+				csr.Spec.Request = []byte("invalid")
+				return csr
+			},
+			expectedErr: true,
+		},
+		{
+			name: "common name of another cluster",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster2", "user1")
+				csr.Labels[constants.CSRClusterNameLabel] = "cluster1"
+				return csr
+			},
+			expectedErr: true,
+		},
+		{
+			name: "extra organization",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "user1")
+				csr.Spec.Request = newTestCertificateRequest(t, 2048, pkix.Name{
+					CommonName:   "system:open-cluster-management:cluster1:agent1",
+					Organization: []string{"system:open-cluster-management:cluster1", "system:masters"},
+				})
+				return csr
+			},
+			expectedErr: true,
+		},
+		{
+			name: "managed clusters group",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "user1")
+				csr.Spec.Request = newTestCertificateRequest(t, 2048, pkix.Name{
+					CommonName: "system:open-cluster-management:cluster1:agent1",
+					Organization: []string{"system:open-cluster-management:cluster1",
+						"system:open-cluster-management:managed-clusters"},
+				})
+				return csr
+			},
+		},
+		{
+			name: "small RSA key",
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newTestCSR(t, "cluster1", "user1")
+				csr.Spec.Request = newTestCertificateRequest(t, 1024, pkix.Name{
+					CommonName:   "system:open-cluster-management:cluster1:agent1",
+					Organization: []string{"system:open-cluster-management:cluster1"},
+				})
+				return csr
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateCSR(c.csr())
+			if (err != nil) != c.expectedErr {
+				t.Errorf("expected error %v, but got %v", c.expectedErr, err)
+			}
+		})
+	}
+}
+
+func newTestCertificateRequest(t *testing.T, bits int, subject pkix.Name) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject}, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request})
+}
@@ -0,0 +1,243 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+)
+
+// WebhookDecision is the decision of the approval webhook on a CSR.
+type WebhookDecision string
+
+const (
+	// WebhookDecisionAllow approves the CSR if the certificate request is valid and a built-in approval condition
+	// matches, e.g. the CSR is requested by the bootstrap identity of an existing cluster.
+	WebhookDecisionAllow WebhookDecision = "Allow"
+	// WebhookDecisionDeny denies the CSR.
+	WebhookDecisionDeny WebhookDecision = "Deny"
+	// WebhookDecisionAbstain leaves the CSR to the built-in approval conditions.
+	WebhookDecisionAbstain WebhookDecision = "Abstain"
+)
+
+const (
+	// WebhookFailurePolicyIgnore abstains if the approval webhook fails.
+	WebhookFailurePolicyIgnore = "Ignore"
+	// WebhookFailurePolicyFail leaves the CSR pending and retries if the approval webhook fails.
+	WebhookFailurePolicyFail = "Fail"
+)
+
+const (
+	webhookCacheSize       = 1024
+	webhookMaxResponseSize = 1 << 20
+)
+
+// WebhookReviewRequest is the summary of a CSR that is posted to the approval webhook.
+type WebhookReviewRequest struct {
+	UID         string            `json:"uid"`
+	Name        string            `json:"name"`
+	ClusterName string            `json:"clusterName"`
+	Username    string            `json:"username"`
+	Groups      []string          `json:"groups"`
+	Labels      map[string]string `json:"labels"`
+	SignerName  string            `json:"signerName"`
+	Usages      []string          `json:"usages"`
+	Subject     WebhookSubject    `json:"subject"`
+}
+
+// WebhookSubject is the subject of the certificate request of a CSR.
+type WebhookSubject struct {
+	CommonName    string   `json:"commonName"`
+	Organizations []string `json:"organizations"`
+}
+
+// WebhookReviewResponse is the response of the approval webhook, the reason is recorded in the condition of the
+// CSR if it is allowed or denied.
+type WebhookReviewResponse struct {
+	Decision WebhookDecision `json:"decision"`
+	Reason   string          `json:"reason,omitempty"`
+}
+
+// WebhookOptions is the options of the CSR approval webhook, the webhook is disabled if the URL is empty.
+type WebhookOptions struct {
+	URL string
+	// CAFile is the CA bundle to verify the webhook server, the system roots are used if it is empty.
+	CAFile  string
+	Timeout time.Duration
+	// CacheTTL is how long the decision on a CSR is cached, the cache is disabled if it is 0.
+	CacheTTL      time.Duration
+	FailurePolicy string
+}
+
+func NewWebhookOptions() *WebhookOptions {
+	return &WebhookOptions{
+		Timeout:       10 * time.Second,
+		CacheTTL:      5 * time.Minute,
+		FailurePolicy: WebhookFailurePolicyIgnore,
+	}
+}
+
+func (o *WebhookOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.URL, "csr-approval-webhook-url", o.URL,
+		"the https URL of the webhook that allows, denies or abstains on the managed cluster CSRs, "+
+			"the webhook is disabled if it is empty")
+	fs.StringVar(&o.CAFile, "csr-approval-webhook-ca-file", o.CAFile,
+		"the CA bundle to verify the CSR approval webhook, the system roots are used if it is empty")
+	fs.DurationVar(&o.Timeout, "csr-approval-webhook-timeout", o.Timeout,
+		"the timeout of the requests to the CSR approval webhook")
+	fs.DurationVar(&o.CacheTTL, "csr-approval-webhook-cache-ttl", o.CacheTTL,
+		"how long the decision of the CSR approval webhook on a CSR is cached, 0 disables the cache")
+	fs.StringVar(&o.FailurePolicy, "csr-approval-webhook-failure-policy", o.FailurePolicy,
+		"Ignore abstains if the CSR approval webhook fails, Fail leaves the CSR pending and retries")
+}
+
+// approvalWebhook posts the CSR summaries to the approval webhook, the decisions are cached by the CSR.
+type approvalWebhook struct {
+	url           string
+	client        *http.Client
+	failurePolicy string
+	cache         *cache.LRUExpireCache
+	cacheTTL      time.Duration
+}
+
+// newApprovalWebhook returns the approval webhook of the options, it returns nil if the webhook is disabled.
+func newApprovalWebhook(o *WebhookOptions) (*approvalWebhook, error) {
+	if o == nil || len(o.URL) == 0 {
+		return nil, nil
+	}
+
+	webhookURL, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSR approval webhook URL %q: %v", o.URL, err)
+	}
+	if webhookURL.Scheme != "https" || len(webhookURL.Host) == 0 {
+		return nil, fmt.Errorf("invalid CSR approval webhook URL %q: it must be an https URL", o.URL)
+	}
+	if o.FailurePolicy != WebhookFailurePolicyIgnore && o.FailurePolicy != WebhookFailurePolicyFail {
+		return nil, fmt.Errorf("invalid CSR approval webhook failure policy %q: it must be %s or %s",
+			o.FailurePolicy, WebhookFailurePolicyIgnore, WebhookFailurePolicyFail)
+	}
+	if o.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid CSR approval webhook timeout %v: it must be positive", o.Timeout)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(o.CAFile) > 0 {
+		caData, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CSR approval webhook CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificate is found in the CSR approval webhook CA file %s", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	webhook := &approvalWebhook{
+		url: o.URL,
+		client: &http.Client{
+			Timeout:   o.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+		failurePolicy: o.FailurePolicy,
+		cacheTTL:      o.CacheTTL,
+	}
+	if o.CacheTTL > 0 {
+		webhook.cache = cache.NewLRUExpireCache(webhookCacheSize)
+	}
+	return webhook, nil
+}
+
+// review posts the CSR summary to the approval webhook and returns its decision, the failures are not cached.
+func (w *approvalWebhook) review(ctx context.Context,
+	csr *certificatesv1.CertificateSigningRequest) (*WebhookReviewResponse, error) {
+	// the spec of a CSR is immutable, so the decision is cached by the CSR uid
+	key := string(csr.UID)
+	if len(key) == 0 {
+		key = csr.Name
+	}
+	if w.cache != nil {
+		if response, ok := w.cache.Get(key); ok {
+			return response.(*WebhookReviewResponse), nil
+		}
+	}
+
+	body, err := json.Marshal(newWebhookReviewRequest(csr))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request the CSR approval webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response of the CSR approval webhook: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the CSR approval webhook responds %d: %s", resp.StatusCode, string(data))
+	}
+
+	response := &WebhookReviewResponse{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, fmt.Errorf("failed to decode the response of the CSR approval webhook: %v", err)
+	}
+	switch response.Decision {
+	case WebhookDecisionAllow, WebhookDecisionDeny, WebhookDecisionAbstain:
+	default:
+		return nil, fmt.Errorf("the CSR approval webhook responds an invalid decision %q", response.Decision)
+	}
+
+	if w.cache != nil {
+		w.cache.Add(key, response, w.cacheTTL)
+	}
+	return response, nil
+}
+
+func newWebhookReviewRequest(csr *certificatesv1.CertificateSigningRequest) *WebhookReviewRequest {
+	usages := []string{}
+	for _, usage := range csr.Spec.Usages {
+		usages = append(usages, string(usage))
+	}
+	commonName, organizations := certificateRequestSubject(csr)
+
+	return &WebhookReviewRequest{
+		UID:         string(csr.UID),
+		Name:        csr.Name,
+		ClusterName: helpers.GetClusterName(csr),
+		Username:    csr.Spec.Username,
+		Groups:      csr.Spec.Groups,
+		Labels:      csr.Labels,
+		SignerName:  csr.Spec.SignerName,
+		Usages:      usages,
+		Subject: WebhookSubject{
+			CommonName:    commonName,
+			Organizations: organizations,
+		},
+	}
+}
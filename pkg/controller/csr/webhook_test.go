@@ -0,0 +1,309 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package csr
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events/eventstesting"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestWebhookServer starts a webhook server that responds the decision of the cluster of the request, and
+// returns the options to request it and the count of the requests.
+func newTestWebhookServer(t *testing.T, delay time.Duration) (*WebhookOptions, *int32) {
+	requests := new(int32)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		review := &WebhookReviewRequest{}
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if review.Subject.CommonName != "system:open-cluster-management:"+review.ClusterName+":agent1" {
+			http.Error(w, "unexpected subject", http.StatusBadRequest)
+			return
+		}
+
+		time.Sleep(delay)
+		switch review.ClusterName {
+		case "allowed":
+			_ = json.NewEncoder(w).Encode(WebhookReviewResponse{Decision: WebhookDecisionAllow, Reason: "in the CMDB"})
+		case "denied":
+			_ = json.NewEncoder(w).Encode(WebhookReviewResponse{Decision: WebhookDecisionDeny, Reason: "decommissioned"})
+		case "abstained":
+			_ = json.NewEncoder(w).Encode(WebhookReviewResponse{Decision: WebhookDecisionAbstain})
+		case "invalid":
+			_ = json.NewEncoder(w).Encode(WebhookReviewResponse{Decision: "Maybe"})
+		default:
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	options := NewWebhookOptions()
+	options.URL = server.URL
+	options.CAFile = caFile
+	return options, requests
+}
+
+func TestNewApprovalWebhook(t *testing.T) {
+	cases := []struct {
+		name        string
+		options     func(o *WebhookOptions)
+		expectedNil bool
+		expectedErr bool
+	}{
+		{
+			name:        "disabled",
+			options:     func(o *WebhookOptions) {},
+			expectedNil: true,
+		},
+		{
+			name:    "enabled",
+			options: func(o *WebhookOptions) { o.URL = "https://cmdb.example.com/csr" },
+		},
+		{
+			name:        "http url",
+			options:     func(o *WebhookOptions) { o.URL = "http://cmdb.example.com/csr" },
+			expectedErr: true,
+		},
+		{
+			name: "invalid failure policy",
+			options: func(o *WebhookOptions) {
+				o.URL = "https://cmdb.example.com/csr"
+				o.FailurePolicy = "Retry"
+			},
+			expectedErr: true,
+		},
+		{
+			name: "CA file not found",
+			options: func(o *WebhookOptions) {
+				o.URL = "https://cmdb.example.com/csr"
+				o.CAFile = filepath.Join(t.TempDir(), "ca.crt")
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			options := NewWebhookOptions()
+			c.options(options)
+			webhook, err := newApprovalWebhook(options)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if err == nil && (webhook == nil) != c.expectedNil {
+				t.Errorf("expected nil webhook %v, but got %v", c.expectedNil, webhook)
+			}
+		})
+	}
+}
+
+func TestApprovalWebhookReview(t *testing.T) {
+	cases := []struct {
+		name             string
+		clusterName      string
+		delay            time.Duration
+		expectedDecision WebhookDecision
+		expectedErr      bool
+	}{
+		{
+			name:             "allow",
+			clusterName:      "allowed",
+			expectedDecision: WebhookDecisionAllow,
+		},
+		{
+			name:             "deny",
+			clusterName:      "denied",
+			expectedDecision: WebhookDecisionDeny,
+		},
+		{
+			name:             "abstain",
+			clusterName:      "abstained",
+			expectedDecision: WebhookDecisionAbstain,
+		},
+		{
+			name:        "invalid decision",
+			clusterName: "invalid",
+			expectedErr: true,
+		},
+		{
+			name:        "server error",
+			clusterName: "cluster1",
+			expectedErr: true,
+		},
+		{
+			name:        "timeout",
+			clusterName: "allowed",
+			delay:       500 * time.Millisecond,
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			options, requests := newTestWebhookServer(t, c.delay)
+			options.Timeout = 200 * time.Millisecond
+			webhook, err := newApprovalWebhook(options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			csr := newTestCSR(t, c.clusterName, "user1")
+			csr.UID = types.UID(c.name)
+			for i := 0; i < 2; i++ {
+				response, err := webhook.review(context.TODO(), csr)
+				if (err != nil) != c.expectedErr {
+					t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+				}
+				if err == nil && response.Decision != c.expectedDecision {
+					t.Errorf("expected decision %s, but got %s", c.expectedDecision, response.Decision)
+				}
+			}
+
+			// the decisions are cached, the failures are not
+			expectedRequests := int32(1)
+			if c.expectedErr {
+				expectedRequests = 2
+			}
+			if got := atomic.LoadInt32(requests); got != expectedRequests {
+				t.Errorf("expected %d requests, but got %d", expectedRequests, got)
+			}
+		})
+	}
+}
+
+func TestReconcileCSRWithApprovalWebhook(t *testing.T) {
+	cases := []struct {
+		name              string
+		clusterName       string
+		failurePolicy     string
+		builtinApproved   bool
+		addon             bool
+		expectedErr       bool
+		expectedCondition certificatesv1.RequestConditionType
+		expectedReason    string
+	}{
+		{
+			name:              "allowed by the webhook",
+			clusterName:       "allowed",
+			builtinApproved:   true,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByApprovalWebhook",
+		},
+		{
+			name:        "allowed by the webhook without the built-in conditions",
+			clusterName: "allowed",
+		},
+		{
+			name:        "addon csr is not denied by the webhook",
+			clusterName: "denied",
+			addon:       true,
+		},
+		{
+			name:              "denied by the webhook before the built-in conditions",
+			clusterName:       "denied",
+			builtinApproved:   true,
+			expectedCondition: certificatesv1.CertificateDenied,
+			expectedReason:    "DeniedByApprovalWebhook",
+		},
+		{
+			name:              "abstained by the webhook",
+			clusterName:       "abstained",
+			builtinApproved:   true,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name:              "webhook failure is ignored",
+			clusterName:       "cluster1",
+			failurePolicy:     WebhookFailurePolicyIgnore,
+			builtinApproved:   true,
+			expectedCondition: certificatesv1.CertificateApproved,
+			expectedReason:    "AutoApprovedByCSRController",
+		},
+		{
+			name:            "webhook failure fails the reconcile",
+			clusterName:     "cluster1",
+			failurePolicy:   WebhookFailurePolicyFail,
+			builtinApproved: true,
+			expectedErr:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			options, _ := newTestWebhookServer(t, 0)
+			if len(c.failurePolicy) > 0 {
+				options.FailurePolicy = c.failurePolicy
+			}
+			webhook, err := newApprovalWebhook(options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			testCSR := newTestCSR(t, c.clusterName, "user1")
+			if c.addon {
+				testCSR.Labels[addonv1alpha1.AddonLabelKey] = "addon1"
+			}
+			kubeClient := fakeclientset.NewSimpleClientset(testCSR)
+			r := &ReconcileCSR{
+				clientHolder: &helpers.ClientHolder{KubeClient: kubeClient},
+				recorder:     eventstesting.NewTestingEventRecorder(t),
+				approvalConditions: []func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error){
+					func(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (bool, error) {
+						return c.builtinApproved, nil
+					},
+				},
+				approvalWebhook: webhook,
+			}
+
+			_, err = r.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: csrNameReconcile},
+			})
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+
+			csr, err := kubeClient.CertificatesV1().CertificateSigningRequests().Get(
+				context.TODO(), csrNameReconcile, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.expectedCondition) == 0 {
+				if len(csr.Status.Conditions) != 0 {
+					t.Errorf("expected the csr is pending, but got %v", csr.Status.Conditions)
+				}
+				return
+			}
+			if len(csr.Status.Conditions) != 1 || csr.Status.Conditions[0].Type != c.expectedCondition ||
+				csr.Status.Conditions[0].Reason != c.expectedReason {
+				t.Errorf("expected condition %s with reason %s, but got %v",
+					c.expectedCondition, c.expectedReason, csr.Status.Conditions)
+			}
+		})
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"crypto/x509"
 	"embed"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	flightctlclient "github.com/flightctl/flightctl/lib/api/client"
@@ -17,14 +20,36 @@ import (
 	"github.com/openshift/library-go/pkg/operator/events"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/utils/ptr"
 )
 
 const (
 	FlightCtlDiscoveryConfigMap = "flightctl-discovery"
+
+	// AgentRegistrationServiceAccount is the service account whose token is delivered to the flightctl-agent by
+	// the Repository.
+	AgentRegistrationServiceAccount = "flightctl-agent-registration"
+
+	repositoryName             = "acm-registration"
+	repositoryValidationSuffix = "/agent-registration"
+
+	// repositoryTokenRefreshInterval is the interval to refresh the agent registration token in the Repository, the
+	// token expires in 10 days.
+	repositoryTokenRefreshInterval = 24 * time.Hour
+
+	// serverChangeGracePeriod is the period after the flightctl server is changed that a device not found in the
+	// server is treated as unknown, so the managed clusters of the devices that are not registered to the new
+	// server yet are not removed.
+	serverChangeGracePeriod = 30 * time.Minute
+)
+
+var (
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
 )
 
 //go:embed manifests
@@ -37,12 +62,16 @@ var files = []string{
 	"manifests/serviceaccount.yml",
 }
 
-func NewFlightCtlManager(clientHolder *helpers.ClientHolder,
+func NewFlightCtlManager(clientHolder *helpers.ClientHolder, discoveryConfigMapLister corev1listers.ConfigMapLister,
 	clusterIngressDomain string) *FlightCtlManager {
 	fcm := &FlightCtlManager{
-		agentRegistrationServer: "https://agent-registration-multicluster-engine." + clusterIngressDomain,
-		clientHolder:            clientHolder,
-		recorder:                helpers.NewEventRecorder(clientHolder.KubeClient, "FlightCtl"),
+		agentRegistrationServer:  "https://agent-registration-multicluster-engine." + clusterIngressDomain,
+		clientHolder:             clientHolder,
+		discoveryConfigMapLister: discoveryConfigMapLister,
+		recorder:                 helpers.NewEventRecorder(clientHolder.KubeClient, "FlightCtl"),
+		newFlightCtlClient: func(server string) flightctlClient {
+			return &flightctlClientImpl{flightctlServer: server}
+		},
 	}
 	return fcm
 }
@@ -50,79 +79,26 @@ func NewFlightCtlManager(clientHolder *helpers.ClientHolder,
 type FlightCtlManager struct {
 	clientHolder *helpers.ClientHolder
 	recorder     events.Recorder
+	// discoveryConfigMapLister only lists the flightctl-discovery ConfigMap
+	discoveryConfigMapLister corev1listers.ConfigMapLister
 
+	mutex           sync.RWMutex
 	flightctlClient flightctlClient
 	flightctlServer string
+	// flightctlServerChangedAt is the time when the flightctl server is set or changed
+	flightctlServerChangedAt time.Time
+	// repositoryAppliedAt is the time when the Repository is applied with a new agent registration token
+	repositoryAppliedAt time.Time
 
-	agentRegistrationServer string
-}
-
-// StartReconcileFlightCtlResources starts a loop to reconcile FlightCtl resources
-// 1. ensure the flightctl-api service is running on the hub side.
-// 2. apply the kubernetes resources.
-// 3. apply the Repository resources.
-// 4. keep reconcile the Repository resource every day to keep agent registration token fresh.
-func (f *FlightCtlManager) StartReconcileFlightCtlResources(ctx context.Context) {
-	// Helper function to apply resources and record errors
-	applyFunc := func(ctx context.Context) (bool, error) {
-		// First, check if flightctl is enabled and healthy, if not, skip the reconciliation.
-		if err := f.isFlightCtlEnabledAndHealthy(); err != nil {
-			f.recorder.Event("FlightCtlDegraded",
-				fmt.Sprintf("FlightCtl is not enabled or healthy, skipping resource reconciliation: %v", err))
-			return false, nil
-		}
-
-		if err := f.ensureFlightCtlServer(); err != nil {
-			f.recorder.Event("FlightCtlServerFailed",
-				fmt.Sprintf("Failed to ensure FlightCtl server: %v", err))
-			return false, nil
-		}
-
-		// Apply kubernetes resources
-		if err := f.applyKuberentesResources(ctx); err != nil {
-			f.recorder.Event("KubernetesResourcesFailed",
-				fmt.Sprintf("Failed to apply Kubernetes resources: %v", err))
-			return false, nil
-		}
-
-		// Create Repository resources
-		if err := f.applyRepository(ctx); err != nil {
-			f.recorder.Event("RepositoryFailed",
-				fmt.Sprintf("Failed to apply Repository resources: %v", err))
-			return false, nil
-		}
-
-		// Record successful sync
-		f.recorder.Event("ResourcesSynced", "Successfully synced FlightCtl resources")
-		return true, nil
-	}
-
-	// Poll every 5 minutes until success
-	if err := wait.PollUntilContextCancel(ctx, 5*time.Minute, true, applyFunc); err != nil {
-		f.recorder.Event("ResourcesSynced", "Failed to sync FlightCtl resources")
-	}
+	newFlightCtlClient func(server string) flightctlClient
 
-	// Keep reconcile the Repository resource every day to keep agent registration token fresh.
-	wait.Until(func() {
-		if err := f.applyRepository(ctx); err != nil {
-			f.recorder.Event("RepositoryFailed", fmt.Sprintf("Failed to reconcile Repository resources: %v", err))
-		}
-	}, 24*time.Hour, ctx.Done())
+	agentRegistrationServer string
 }
 
-// ensureFlightCtlServer sets the flightctl server address if not already set.
-// It gets the apiEndpoint from the flightctl-discovery ConfigMap.
+// ensureFlightCtlServer sets the flightctl server address, it gets the apiEndpoint from the flightctl-discovery
+// ConfigMap, so the client follows the changes of the apiEndpoint.
 func (f *FlightCtlManager) ensureFlightCtlServer() error {
-	if f.flightctlServer != "" {
-		return nil
-	}
-
-	namespace := os.Getenv("POD_NAMESPACE")
-	cm, err := f.clientHolder.KubeClient.CoreV1().ConfigMaps(namespace).Get(
-		context.Background(),
-		FlightCtlDiscoveryConfigMap,
-		metav1.GetOptions{},
-	)
+	cm, err := f.getDiscoveryConfigMap()
 	if err != nil {
 		return fmt.Errorf("failed to get %s configmap: %v", FlightCtlDiscoveryConfigMap, err)
 	}
@@ -132,11 +108,39 @@ func (f *FlightCtlManager) ensureFlightCtlServer() error {
 		return fmt.Errorf("apiEndpoint not found or empty in %s configmap", FlightCtlDiscoveryConfigMap)
 	}
 
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.flightctlServer == apiEndpoint {
+		return nil
+	}
+
 	f.flightctlServer = apiEndpoint
-	f.flightctlClient = &flightctlClientImpl{flightctlServer: f.flightctlServer}
+	f.flightctlServerChangedAt = time.Now()
+	f.flightctlClient = f.newFlightCtlClient(apiEndpoint)
+	// the Repository is applied to the new server at the next sync
+	f.repositoryAppliedAt = time.Time{}
 	return nil
 }
 
+// getDiscoveryConfigMap returns the flightctl-discovery ConfigMap in the pod namespace from the informer cache.
+func (f *FlightCtlManager) getDiscoveryConfigMap() (*corev1.ConfigMap, error) {
+	return f.discoveryConfigMapLister.ConfigMaps(os.Getenv("POD_NAMESPACE")).Get(FlightCtlDiscoveryConfigMap)
+}
+
+// isFlightCtlServerJustChanged returns true if the flightctl server is set or changed in the grace period.
+func (f *FlightCtlManager) isFlightCtlServerJustChanged() bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return time.Since(f.flightctlServerChangedAt) < serverChangeGracePeriod
+}
+
+// getFlightCtlClient returns the client of the current flightctl server, it returns nil if the server is unknown.
+func (f *FlightCtlManager) getFlightCtlClient() flightctlClient {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.flightctlClient
+}
+
 func (f *FlightCtlManager) applyKuberentesResources(_ context.Context) error {
 	var err error
 
@@ -157,73 +161,204 @@ func (f *FlightCtlManager) applyKuberentesResources(_ context.Context) error {
 	return nil
 }
 
-func (f *FlightCtlManager) applyRepository(ctx context.Context) error {
+// syncRepository applies the Repository if it drifts from the expected one, e.g. it is deleted or modified out of
+// band, or its agent registration token needs to be refreshed. It returns true if the Repository is applied.
+func (f *FlightCtlManager) syncRepository(ctx context.Context) (bool, error) {
+	client := f.getFlightCtlClient()
+	if client == nil {
+		return false, fmt.Errorf("the flightctl server is unknown")
+	}
+
 	flightctlClientToken, err := f.getFlightCtlClientToken()
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	ca, err := f.getAgentRegistrationCA()
+	if err != nil {
+		return false, err
+	}
+
+	f.mutex.RLock()
+	appliedAt := f.repositoryAppliedAt
+	f.mutex.RUnlock()
+
+	drifted, err := f.isRepositoryDrifted(ctx, client, flightctlClientToken, ca)
+	if err != nil {
+		return false, err
+	}
+	if !drifted && time.Since(appliedAt) < repositoryTokenRefreshInterval {
+		return false, nil
 	}
 
 	agentRegistrationToken, err := f.getFlightCtlAgentRegistrationServiceAccountToken(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	ca, err := f.getAgentRegistrationCA()
+	expectedRepository, err := f.newRepository(agentRegistrationToken, ca)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if err := client.ApplyRepository(ctx, flightctlClientToken, expectedRepository); err != nil {
+		return false, err
+	}
+
+	f.mutex.Lock()
+	f.repositoryAppliedAt = time.Now()
+	f.mutex.Unlock()
+	return true, nil
+}
+
+// isRepositoryDrifted returns true if the Repository is not found or its http repo spec is not the expected one, the
+// token is not compared because it is refreshed by the token refresh interval.
+func (f *FlightCtlManager) isRepositoryDrifted(ctx context.Context, client flightctlClient, token, ca string) (bool, error) {
+	response, err := client.GetRepository(ctx, token, repositoryName)
+	if err != nil {
+		return false, err
+	}
+	if response.HTTPResponse.StatusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if response.HTTPResponse.StatusCode != http.StatusOK || response.JSON200 == nil {
+		return false, fmt.Errorf("failed to get repository %s, status code: %d", repositoryName,
+			response.HTTPResponse.StatusCode)
+	}
+
+	spec, err := response.JSON200.Spec.GetHttpRepoSpec()
+	if err != nil {
+		// the Repository is not an http repository
+		return true, nil
 	}
+	return spec.Type != flightctlapiv1.Http ||
+		spec.Url != f.agentRegistrationServer ||
+		ptr.Deref(spec.ValidationSuffix, "") != repositoryValidationSuffix ||
+		ptr.Deref(spec.HttpConfig.CaCrt, "") != ca ||
+		ptr.Deref(spec.HttpConfig.Token, "") == "", nil
+}
 
+func (f *FlightCtlManager) newRepository(agentRegistrationToken, ca string) (*flightctlapiv1.Repository, error) {
 	expectedRepository := &flightctlapiv1.Repository{
 		ApiVersion: "v1alpha1",
 		Kind:       "Repository",
 		Metadata: flightctlapiv1.ObjectMeta{
 			// Note: In the fleets' `httpRef.repository` field, the name is `acm-registration`.
 			// See details in: https://github.com/flightctl/flightctl/blob/main/docs/user/registering-microshift-devices-acm.md
-			Name: ptr.To("acm-registration"),
+			Name: ptr.To(repositoryName),
 		},
 		Spec: flightctlapiv1.RepositorySpec{},
 	}
-	err = expectedRepository.Spec.MergeHttpRepoSpec(flightctlapiv1.HttpRepoSpec{
+	err := expectedRepository.Spec.MergeHttpRepoSpec(flightctlapiv1.HttpRepoSpec{
 		Type: flightctlapiv1.Http,
 		Url:  f.agentRegistrationServer,
 		HttpConfig: flightctlapiv1.HttpConfig{
 			Token: &agentRegistrationToken,
 			CaCrt: &ca,
 		},
-		ValidationSuffix: ptr.To("/agent-registration"),
+		ValidationSuffix: ptr.To(repositoryValidationSuffix),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return f.flightctlClient.ApplyRepository(ctx, flightctlClientToken, expectedRepository)
+	return expectedRepository, nil
 }
 
+// IsManagedClusterAFlightctlDevice returns true if the managed cluster is an active FlightCtl device, a device that
+// is being deleted or decommissioned is not an active device.
 func (f *FlightCtlManager) IsManagedClusterAFlightctlDevice(ctx context.Context, managedClusterName string) (bool, error) {
+	device, err := f.getDevice(ctx, managedClusterName)
+	if err != nil {
+		return false, err
+	}
+	return device.state == deviceStateActive, nil
+}
+
+// deviceState is the lifecycle state of a FlightCtl device.
+type deviceState string
+
+const (
+	// deviceStateUnknown means FlightCtl is not enabled or healthy, so the device state is unknown.
+	deviceStateUnknown        deviceState = "Unknown"
+	deviceStateNotFound       deviceState = "NotFound"
+	deviceStateDecommissioned deviceState = "Decommissioned"
+	deviceStateActive         deviceState = "Active"
+)
+
+// flightctlDevice is the lifecycle state, the labels and the fleet of a FlightCtl device.
+type flightctlDevice struct {
+	state  deviceState
+	labels map[string]string
+	fleet  string
+}
+
+// deviceLifecycle is the decommission fields of a device, they are decoded from the response body because they are
+// not in the FlightCtl API types that are used by the client.
+type deviceLifecycle struct {
+	Spec *struct {
+		Decommissioning *json.RawMessage `json:"decommissioning,omitempty"`
+	} `json:"spec,omitempty"`
+	Status *struct {
+		Lifecycle *struct {
+			Status string `json:"status"`
+		} `json:"lifecycle,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+// getDevice returns the FlightCtl device of the managed cluster, the state is unknown if FlightCtl is not enabled
+// or healthy, or the device is not found right after the flightctl server is changed.
+func (f *FlightCtlManager) getDevice(ctx context.Context, managedClusterName string) (*flightctlDevice, error) {
 	// First, check if flightctl is enabled and healthy.
 	if err := f.isFlightCtlEnabledAndHealthy(); err != nil {
-		return false, nil
+		return &flightctlDevice{state: deviceStateUnknown}, nil
+	}
+
+	if err := f.ensureFlightCtlServer(); err != nil {
+		return nil, err
 	}
 
 	flightctlClientToken, err := f.getFlightCtlClientToken()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	response, err := f.flightctlClient.GetDevice(ctx, flightctlClientToken, managedClusterName)
+	response, err := f.getFlightCtlClient().GetDevice(ctx, flightctlClientToken, managedClusterName)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	if response.HTTPResponse.StatusCode == http.StatusNotFound {
-		return false, nil
+		if f.isFlightCtlServerJustChanged() {
+			return &flightctlDevice{state: deviceStateUnknown}, nil
+		}
+		return &flightctlDevice{state: deviceStateNotFound}, nil
 	}
 
-	if response.HTTPResponse.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("failed to get device %s, status code: %d", managedClusterName, response.HTTPResponse.StatusCode)
+	if response.HTTPResponse.StatusCode != http.StatusOK || response.JSON200 == nil {
+		return nil, fmt.Errorf("failed to get device %s, status code: %d", managedClusterName, response.HTTPResponse.StatusCode)
 	}
 
-	return true, nil
+	device := &flightctlDevice{
+		state:  deviceStateActive,
+		labels: ptr.Deref(response.JSON200.Metadata.Labels, map[string]string{}),
+	}
+	// the owner of a device that is managed by a fleet is Fleet/<fleet name>
+	if owner := ptr.Deref(response.JSON200.Metadata.Owner, ""); strings.HasPrefix(owner, "Fleet/") {
+		device.fleet = strings.TrimPrefix(owner, "Fleet/")
+	}
+
+	if response.JSON200.Metadata.DeletionTimestamp != nil {
+		device.state = deviceStateDecommissioned
+		return device, nil
+	}
+	lifecycle := &deviceLifecycle{}
+	if err := json.Unmarshal(response.Body, lifecycle); err == nil {
+		if (lifecycle.Spec != nil && lifecycle.Spec.Decommissioning != nil) ||
+			(lifecycle.Status != nil && lifecycle.Status.Lifecycle != nil &&
+				strings.HasPrefix(lifecycle.Status.Lifecycle.Status, "Decommission")) {
+			device.state = deviceStateDecommissioned
+		}
+	}
+	return device, nil
 }
 
 // isFlightCtlEnabledAndHealthy checks if FlightCtl is enabled and healthy by:
@@ -231,12 +366,7 @@ func (f *FlightCtlManager) IsManagedClusterAFlightctlDevice(ctx context.Context,
 // 2. Performing a health check against the healthEndpoint specified in the ConfigMap.
 // Returns nil if FlightCtl is enabled and healthy, otherwise returns an error.
 func (f *FlightCtlManager) isFlightCtlEnabledAndHealthy() error {
-	namespace := os.Getenv("POD_NAMESPACE")
-	cm, err := f.clientHolder.KubeClient.CoreV1().ConfigMaps(namespace).Get(
-		context.Background(),
-		FlightCtlDiscoveryConfigMap,
-		metav1.GetOptions{},
-	)
+	cm, err := f.getDiscoveryConfigMap()
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return fmt.Errorf("flightctl is not enabled: %s configmap not found", FlightCtlDiscoveryConfigMap)
@@ -317,7 +447,7 @@ func (f *FlightCtlManager) getFlightCtlAgentRegistrationServiceAccountToken(ctx
 
 	// Get the token using TokenRequest API
 	tokenResponse, err := f.clientHolder.KubeClient.CoreV1().ServiceAccounts(os.Getenv("POD_NAMESPACE")).
-		CreateToken(ctx, "flightctl-agent-registration", tokenRequest, metav1.CreateOptions{})
+		CreateToken(ctx, AgentRegistrationServiceAccount, tokenRequest, metav1.CreateOptions{})
 	if err != nil {
 		return "", fmt.Errorf("failed to create token: %v", err)
 	}
@@ -327,7 +457,7 @@ func (f *FlightCtlManager) getFlightCtlAgentRegistrationServiceAccountToken(ctx
 
 // The token is mounted from the service account in the pod.
 func (f *FlightCtlManager) getFlightCtlClientToken() (string, error) {
-	tokenData, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+	tokenData, err := os.ReadFile(serviceAccountTokenFile)
 	if err != nil {
 		return "", fmt.Errorf("failed to read service account token: %v", err)
 	}
@@ -337,7 +467,7 @@ func (f *FlightCtlManager) getFlightCtlClientToken() (string, error) {
 // TODO: @xuezhaojun need to consider cases like: https proxy in the middle, route using system CA cert instead of OCP self-signed cert, etc.
 // Note: the CA cert will also rotate, but the expiration time is long enough.
 func (f *FlightCtlManager) getAgentRegistrationCA() (string, error) {
-	caData, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+	caData, err := os.ReadFile(serviceAccountCAFile)
 	if err != nil {
 		return "", fmt.Errorf("failed to read service account CA: %v", err)
 	}
@@ -347,6 +477,7 @@ func (f *FlightCtlManager) getAgentRegistrationCA() (string, error) {
 
 type flightctlClient interface {
 	ApplyRepository(ctx context.Context, token string, expectedRepository *flightctlapiv1.Repository) error
+	GetRepository(ctx context.Context, token string, name string) (*flightctlclient.ReadRepositoryResponse, error)
 	GetDevice(ctx context.Context, token string, managedClusterName string) (*flightctlclient.ReadDeviceResponse, error)
 }
 
@@ -360,6 +491,10 @@ func (f *flightctlClientImpl) ApplyRepository(ctx context.Context, token string,
 	return flightctlcli.ApplyRepository(ctx, token, f.flightctlServer, expectedRepository)
 }
 
+func (f *flightctlClientImpl) GetRepository(ctx context.Context, token string, name string) (*flightctlclient.ReadRepositoryResponse, error) {
+	return flightctlcli.GetRepository(ctx, token, f.flightctlServer, name)
+}
+
 func (f *flightctlClientImpl) GetDevice(ctx context.Context, token string, managedClusterName string) (*flightctlclient.ReadDeviceResponse, error) {
 	return flightctlcli.GetDevice(ctx, token, f.flightctlServer, managedClusterName)
 }
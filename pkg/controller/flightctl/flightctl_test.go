@@ -0,0 +1,97 @@
+package flightctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"github.com/stretchr/testify/assert"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetDevice(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "open-cluster-management")
+
+	serviceAccountTokenFile = filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(serviceAccountTokenFile, []byte("client-token"), 0600))
+
+	tests := []struct {
+		name           string
+		unhealthy      bool
+		serverChanged  bool
+		deviceBody     string
+		expectedDevice *flightctlDevice
+	}{
+		{
+			name:           "flightctl is not healthy",
+			unhealthy:      true,
+			expectedDevice: &flightctlDevice{state: deviceStateUnknown},
+		},
+		{
+			name:           "device is not found",
+			expectedDevice: &flightctlDevice{state: deviceStateNotFound},
+		},
+		{
+			name:           "device is not found right after the flightctl server is changed",
+			serverChanged:  true,
+			expectedDevice: &flightctlDevice{state: deviceStateUnknown},
+		},
+		{
+			name: "device is active",
+			deviceBody: `{"apiVersion":"v1alpha1","kind":"Device","metadata":{"name":"test-cluster",` +
+				`"labels":{"site":"store-1"},"owner":"Fleet/stores"}}`,
+			expectedDevice: &flightctlDevice{
+				state:  deviceStateActive,
+				labels: map[string]string{"site": "store-1"},
+				fleet:  "stores",
+			},
+		},
+		{
+			name: "device is being deleted",
+			deviceBody: `{"apiVersion":"v1alpha1","kind":"Device","metadata":{"name":"test-cluster",` +
+				`"deletionTimestamp":"2026-01-01T00:00:00Z"}}`,
+			expectedDevice: &flightctlDevice{state: deviceStateDecommissioned, labels: map[string]string{}},
+		},
+		{
+			name: "device is decommissioning",
+			deviceBody: `{"apiVersion":"v1alpha1","kind":"Device","metadata":{"name":"test-cluster"},` +
+				`"spec":{"decommissioning":{"target":"Unenroll"}}}`,
+			expectedDevice: &flightctlDevice{state: deviceStateDecommissioned, labels: map[string]string{}},
+		},
+		{
+			name: "device is decommissioned",
+			deviceBody: `{"apiVersion":"v1alpha1","kind":"Device","metadata":{"name":"test-cluster"},` +
+				`"status":{"lifecycle":{"status":"Decommissioned"}}}`,
+			expectedDevice: &flightctlDevice{state: deviceStateDecommissioned, labels: map[string]string{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discovery, caSecret := newTestFlightCtlService(t, !tt.unhealthy)
+			fakeClient := &fakeFlightCtlClient{deviceBody: tt.deviceBody}
+			flightctlManager := &FlightCtlManager{
+				clientHolder: &helpers.ClientHolder{
+					KubeClient: kubefake.NewSimpleClientset(caSecret),
+				},
+				discoveryConfigMapLister: newTestDiscoveryConfigMapLister(t, discovery),
+				flightctlClient:          fakeClient,
+				flightctlServer:          testFlightCtlServer,
+				newFlightCtlClient:       func(server string) flightctlClient { return fakeClient },
+			}
+			if tt.serverChanged {
+				flightctlManager.flightctlServer = "https://old.flightctl.example.com"
+			}
+
+			device, err := flightctlManager.getDevice(context.TODO(), "test-cluster")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedDevice, device)
+
+			isDevice, err := flightctlManager.IsManagedClusterAFlightctlDevice(context.TODO(), "test-cluster")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedDevice.state == deviceStateActive, isDevice)
+		})
+	}
+}
@@ -2,27 +2,66 @@ package flightctl
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/openshift/library-go/pkg/operator/events"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 const ManagedClusterControllerName = "flightctl-managedcluster-controller"
 
-// ManagedClusterReconciler is responsible to set hubAcceptsClient to true if the managed cluster is a flightctl device.
+const (
+	// FlightCtlDeviceLabel is added to the managed clusters that are accepted as FlightCtl devices, the lifecycle of
+	// their devices is mirrored onto them.
+	FlightCtlDeviceLabel = "import.open-cluster-management.io/flightctl-device"
+	// FlightCtlFleetLabel is the fleet that the FlightCtl device of the managed cluster belongs to.
+	FlightCtlFleetLabel = "import.open-cluster-management.io/flightctl-fleet"
+	// FlightCtlDeviceUnavailableTaint is added to the managed clusters whose FlightCtl devices are deleted or
+	// decommissioned, the value is the state of the device.
+	FlightCtlDeviceUnavailableTaint = "import.open-cluster-management.io/flightctl-device-unavailable"
+)
+
+const (
+	// DeviceRemovalActionMarkUnavailable denies and taints the managed cluster whose device is removed, the cluster
+	// is accepted again if the device comes back.
+	DeviceRemovalActionMarkUnavailable = "MarkUnavailable"
+	// DeviceRemovalActionDetach deletes the managed cluster whose device stays removed for a grace period, the
+	// cluster is tainted during the grace period.
+	DeviceRemovalActionDetach = "Detach"
+)
+
+const (
+	// deviceResyncInterval is the interval to reconcile the state of the FlightCtl devices.
+	deviceResyncInterval = 10 * time.Minute
+	// deviceDetachGracePeriod is the period that a device must stay deleted or decommissioned before its managed
+	// cluster is detached, so a transient not found response of FlightCtl does not delete the cluster.
+	deviceDetachGracePeriod = 2 * deviceResyncInterval
+)
+
+// ManagedClusterReconciler is responsible to set hubAcceptsClient to true if the managed cluster is a flightctl device,
+// and then mirrors the lifecycle, the selected labels and the fleet of the device onto the managed cluster.
 type ManagedClusterReconciler struct {
-	clientHolder                     *helpers.ClientHolder
-	recorder                         events.Recorder
-	isManagedClusterAFlightctlDevice func(ctx context.Context, managedClusterName string) (bool, error)
+	clientHolder           *helpers.ClientHolder
+	recorder               events.Recorder
+	importControllerConfig *helpers.ImportControllerConfig
+	getDevice              func(ctx context.Context, managedClusterName string) (*flightctlDevice, error)
 }
 
 var _ reconcile.Reconciler = &ManagedClusterReconciler{}
 
 func (r *ManagedClusterReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	cluster := &clusterv1.ManagedCluster{}
-	if err := r.clientHolder.RuntimeClient.Get(ctx, request.NamespacedName, cluster); err != nil {
+	err := r.clientHolder.RuntimeClient.Get(ctx, request.NamespacedName, cluster)
+	if errors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
 		return reconcile.Result{}, err
 	}
 
@@ -30,22 +69,171 @@ func (r *ManagedClusterReconciler) Reconcile(ctx context.Context, request reconc
 		return reconcile.Result{}, nil
 	}
 
-	if cluster.Spec.HubAcceptsClient {
+	// an accepted cluster that is not accepted by this controller is not a flightctl device
+	isDevice := cluster.Labels[FlightCtlDeviceLabel] == "true"
+	if cluster.Spec.HubAcceptsClient && !isDevice {
 		return reconcile.Result{}, nil
 	}
 
-	isDevice, err := r.isManagedClusterAFlightctlDevice(ctx, cluster.Name)
+	device, err := r.getDevice(ctx, cluster.Name)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
-	if !isDevice {
-		return reconcile.Result{}, nil
+
+	switch device.state {
+	case deviceStateActive:
+		return reconcile.Result{RequeueAfter: deviceResyncInterval}, r.syncDevice(ctx, cluster, device)
+	case deviceStateNotFound, deviceStateDecommissioned:
+		if !isDevice {
+			return reconcile.Result{}, nil
+		}
+		return r.removeDevice(ctx, cluster, device)
+	}
+
+	// the flightctl is not available, the device is checked again later
+	if isDevice {
+		return reconcile.Result{RequeueAfter: deviceResyncInterval}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+// syncDevice accepts the managed cluster of an active device and marks it with the device label, and syncs the
+// selected labels and the fleet of the device to the cluster.
+func (r *ManagedClusterReconciler) syncDevice(ctx context.Context, cluster *clusterv1.ManagedCluster,
+	device *flightctlDevice) error {
+	labelKeys, err := r.importControllerConfig.GetFlightCtlDeviceLabels()
+	if err != nil {
+		return err
+	}
+
+	modified := cluster.DeepCopy()
+	modified.Spec.HubAcceptsClient = true
+	if modified.Labels == nil {
+		modified.Labels = map[string]string{}
+	}
+	modified.Labels[FlightCtlDeviceLabel] = "true"
+	for _, key := range labelKeys {
+		if value, ok := device.labels[key]; ok {
+			modified.Labels[key] = value
+		} else {
+			delete(modified.Labels, key)
+		}
+	}
+	if len(device.fleet) > 0 {
+		modified.Labels[FlightCtlFleetLabel] = device.fleet
+	} else {
+		delete(modified.Labels, FlightCtlFleetLabel)
+	}
+	modified.Spec.Taints = removeDeviceUnavailableTaint(modified.Spec.Taints)
+
+	if equality.Semantic.DeepEqual(cluster.Spec, modified.Spec) &&
+		equality.Semantic.DeepEqual(cluster.Labels, modified.Labels) {
+		return nil
+	}
+
+	if err := r.clientHolder.RuntimeClient.Update(ctx, modified); err != nil {
+		return err
+	}
+
+	if !cluster.Spec.HubAcceptsClient {
+		r.recorder.Eventf("FlightCtlDeviceAccepted", "The managed cluster %s is accepted as a FlightCtl device",
+			cluster.Name)
+	}
+	return nil
+}
+
+// removeDevice handles the managed cluster whose device is deleted or decommissioned by the device removal action.
+func (r *ManagedClusterReconciler) removeDevice(ctx context.Context, cluster *clusterv1.ManagedCluster,
+	device *flightctlDevice) (reconcile.Result, error) {
+	action, err := r.importControllerConfig.GetFlightCtlDeviceRemovalAction()
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if action == DeviceRemovalActionDetach {
+		return r.detachDevice(ctx, cluster, device)
+	}
+
+	if len(action) > 0 && action != DeviceRemovalActionMarkUnavailable {
+		return reconcile.Result{}, fmt.Errorf("the FlightCtl device removal action %q is invalid, it must be %s or %s",
+			action, DeviceRemovalActionMarkUnavailable, DeviceRemovalActionDetach)
 	}
 
-	cluster.Spec.HubAcceptsClient = true
-	if err := r.clientHolder.RuntimeClient.Update(ctx, cluster); err != nil {
+	if existing := findDeviceUnavailableTaint(cluster.Spec.Taints); existing != nil &&
+		existing.Value == string(device.state) && !cluster.Spec.HubAcceptsClient {
+		// the device is still unavailable, it is checked again in case it comes back
+		return reconcile.Result{RequeueAfter: deviceResyncInterval}, nil
+	}
+
+	modified := cluster.DeepCopy()
+	modified.Spec.HubAcceptsClient = false
+	modified.Spec.Taints = append(removeDeviceUnavailableTaint(modified.Spec.Taints), clusterv1.Taint{
+		Key:       FlightCtlDeviceUnavailableTaint,
+		Value:     string(device.state),
+		Effect:    clusterv1.TaintEffectNoSelect,
+		TimeAdded: metav1.Now(),
+	})
+	if err := r.clientHolder.RuntimeClient.Update(ctx, modified); err != nil {
 		return reconcile.Result{}, err
 	}
+	r.recorder.Warningf("FlightCtlDeviceRemoved",
+		"The managed cluster %s is marked unavailable because its FlightCtl device is %s", cluster.Name, device.state)
+	return reconcile.Result{RequeueAfter: deviceResyncInterval}, nil
+}
 
+// detachDevice deletes the managed cluster whose device stays deleted or decommissioned for the grace period. The
+// device unavailable taint is added when the removal is observed first, and its added time starts the grace period.
+func (r *ManagedClusterReconciler) detachDevice(ctx context.Context, cluster *clusterv1.ManagedCluster,
+	device *flightctlDevice) (reconcile.Result, error) {
+	existing := findDeviceUnavailableTaint(cluster.Spec.Taints)
+	if existing == nil {
+		modified := cluster.DeepCopy()
+		modified.Spec.Taints = append(modified.Spec.Taints, clusterv1.Taint{
+			Key:       FlightCtlDeviceUnavailableTaint,
+			Value:     string(device.state),
+			Effect:    clusterv1.TaintEffectNoSelect,
+			TimeAdded: metav1.Now(),
+		})
+		if err := r.clientHolder.RuntimeClient.Update(ctx, modified); err != nil {
+			return reconcile.Result{}, err
+		}
+		r.recorder.Warningf("FlightCtlDeviceRemoved",
+			"The managed cluster %s will be detached in %v because its FlightCtl device is %s",
+			cluster.Name, deviceDetachGracePeriod, device.state)
+		return reconcile.Result{RequeueAfter: deviceDetachGracePeriod}, nil
+	}
+
+	if remaining := deviceDetachGracePeriod - time.Since(existing.TimeAdded.Time); remaining > 0 {
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
+
+	if err := r.clientHolder.RuntimeClient.Delete(ctx, cluster); err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	r.recorder.Warningf("FlightCtlDeviceRemoved",
+		"The managed cluster %s is detached because its FlightCtl device is %s", cluster.Name, device.state)
 	return reconcile.Result{}, nil
 }
+
+func findDeviceUnavailableTaint(taints []clusterv1.Taint) *clusterv1.Taint {
+	for i := range taints {
+		if taints[i].Key == FlightCtlDeviceUnavailableTaint {
+			return &taints[i]
+		}
+	}
+	return nil
+}
+
+func removeDeviceUnavailableTaint(taints []clusterv1.Taint) []clusterv1.Taint {
+	if findDeviceUnavailableTaint(taints) == nil {
+		return taints
+	}
+
+	remaining := []clusterv1.Taint{}
+	for _, taint := range taints {
+		if taint.Key != FlightCtlDeviceUnavailableTaint {
+			remaining = append(remaining, taint)
+		}
+	}
+	return remaining
+}
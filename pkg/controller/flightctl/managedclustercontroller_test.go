@@ -3,18 +3,39 @@ package flightctl
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
+	"github.com/openshift/library-go/pkg/operator/events/eventstesting"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+func newTestManagedCluster(accepted bool, labels map[string]string, taints ...clusterv1.Taint) *clusterv1.ManagedCluster {
+	return &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-cluster",
+			Labels: labels,
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			HubAcceptsClient: accepted,
+			Taints:           taints,
+		},
+	}
+}
+
 func TestManagedClusterReconciler(t *testing.T) {
 	s := scheme.Scheme
 	err := clusterv1.Install(s)
@@ -22,79 +43,151 @@ func TestManagedClusterReconciler(t *testing.T) {
 		t.Fatalf("Failed to install cluster scheme: %v", err)
 	}
 
+	deviceLabels := map[string]string{FlightCtlDeviceLabel: "true"}
+	unavailableTaint := clusterv1.Taint{
+		Key:    FlightCtlDeviceUnavailableTaint,
+		Value:  string(deviceStateNotFound),
+		Effect: clusterv1.TaintEffectNoSelect,
+	}
+
+	labeledCluster := newTestManagedCluster(true, map[string]string{
+		FlightCtlDeviceLabel: "true", "site": "old", "region": "us"})
+
 	tests := []struct {
 		name               string
 		existingObjects    []runtime.Object
-		isFlightCtlDevice  bool
+		controllerConfig   map[string]string
+		device             *flightctlDevice
 		flightCtlError     error
 		expectedAcceptance bool
+		expectedLabels     map[string]string
+		expectedTaint      string
+		expectedDeleted    bool
+		expectedRequeue    time.Duration
 		expectError        bool
 	}{
 		{
-			name: "cluster is flightctl device",
-			existingObjects: []runtime.Object{
-				&clusterv1.ManagedCluster{
-					ObjectMeta: metav1.ObjectMeta{
-						Name: "test-cluster",
-					},
-					Spec: clusterv1.ManagedClusterSpec{
-						HubAcceptsClient: false,
-					},
-				},
-			},
-			isFlightCtlDevice:  true,
+			name:               "cluster is flightctl device",
+			existingObjects:    []runtime.Object{newTestManagedCluster(false, nil)},
+			device:             &flightctlDevice{state: deviceStateActive},
 			expectedAcceptance: true,
+			expectedLabels:     deviceLabels,
+			expectedRequeue:    deviceResyncInterval,
 			expectError:        false,
 		},
 		{
-			name: "cluster is not flightctl device",
-			existingObjects: []runtime.Object{
-				&clusterv1.ManagedCluster{
-					ObjectMeta: metav1.ObjectMeta{
-						Name: "test-cluster",
-					},
-					Spec: clusterv1.ManagedClusterSpec{
-						HubAcceptsClient: false,
-					},
-				},
-			},
-			isFlightCtlDevice:  false,
+			name:               "cluster is not flightctl device",
+			existingObjects:    []runtime.Object{newTestManagedCluster(false, nil)},
+			device:             &flightctlDevice{state: deviceStateNotFound},
 			expectedAcceptance: false,
 			expectError:        false,
 		},
 		{
-			name: "cluster already accepted",
-			existingObjects: []runtime.Object{
-				&clusterv1.ManagedCluster{
-					ObjectMeta: metav1.ObjectMeta{
-						Name: "test-cluster",
-					},
-					Spec: clusterv1.ManagedClusterSpec{
-						HubAcceptsClient: true,
-					},
-				},
-			},
-			isFlightCtlDevice:  true,
+			// the device of a cluster accepted by others is not probed, so the error of flightctl is not returned
+			name:               "accepted cluster is not probed",
+			existingObjects:    []runtime.Object{newTestManagedCluster(true, nil)},
+			flightCtlError:     assert.AnError,
 			expectedAcceptance: true,
 			expectError:        false,
 		},
 		{
-			name: "flightctl error",
-			existingObjects: []runtime.Object{
-				&clusterv1.ManagedCluster{
-					ObjectMeta: metav1.ObjectMeta{
-						Name: "test-cluster",
-					},
-					Spec: clusterv1.ManagedClusterSpec{
-						HubAcceptsClient: false,
-					},
-				},
-			},
-			isFlightCtlDevice:  false,
+			name:               "flightctl error",
+			existingObjects:    []runtime.Object{newTestManagedCluster(false, nil)},
 			flightCtlError:     assert.AnError,
 			expectedAcceptance: false,
 			expectError:        true,
 		},
+		{
+			name:               "flightctl is not available",
+			existingObjects:    []runtime.Object{newTestManagedCluster(true, deviceLabels)},
+			device:             &flightctlDevice{state: deviceStateUnknown},
+			expectedAcceptance: true,
+			expectedLabels:     deviceLabels,
+			expectedRequeue:    deviceResyncInterval,
+		},
+		{
+			name:            "device labels and fleet are synced",
+			existingObjects: []runtime.Object{labeledCluster},
+			controllerConfig: map[string]string{
+				constants.FlightCtlDeviceLabelsKey: "site, region",
+			},
+			device: &flightctlDevice{
+				state:  deviceStateActive,
+				labels: map[string]string{"site": "store-1", "owner": "retail"},
+				fleet:  "stores",
+			},
+			expectedAcceptance: true,
+			expectedLabels: map[string]string{
+				FlightCtlDeviceLabel: "true",
+				FlightCtlFleetLabel:  "stores",
+				"site":               "store-1",
+			},
+			expectedRequeue: deviceResyncInterval,
+		},
+		{
+			name:               "device is deleted",
+			existingObjects:    []runtime.Object{newTestManagedCluster(true, deviceLabels)},
+			device:             &flightctlDevice{state: deviceStateNotFound},
+			expectedAcceptance: false,
+			expectedLabels:     deviceLabels,
+			expectedTaint:      string(deviceStateNotFound),
+			expectedRequeue:    deviceResyncInterval,
+		},
+		{
+			name:            "device is decommissioned",
+			existingObjects: []runtime.Object{newTestManagedCluster(true, deviceLabels)},
+			controllerConfig: map[string]string{
+				constants.FlightCtlDeviceRemovalActionKey: DeviceRemovalActionMarkUnavailable,
+			},
+			device:             &flightctlDevice{state: deviceStateDecommissioned},
+			expectedAcceptance: false,
+			expectedLabels:     deviceLabels,
+			expectedTaint:      string(deviceStateDecommissioned),
+			expectedRequeue:    deviceResyncInterval,
+		},
+		{
+			name:               "device comes back",
+			existingObjects:    []runtime.Object{newTestManagedCluster(false, deviceLabels, unavailableTaint)},
+			device:             &flightctlDevice{state: deviceStateActive},
+			expectedAcceptance: true,
+			expectedLabels:     deviceLabels,
+			expectedRequeue:    deviceResyncInterval,
+		},
+		{
+			name:            "cluster is to be detached",
+			existingObjects: []runtime.Object{newTestManagedCluster(true, deviceLabels)},
+			controllerConfig: map[string]string{
+				constants.FlightCtlDeviceRemovalActionKey: DeviceRemovalActionDetach,
+			},
+			device:             &flightctlDevice{state: deviceStateDecommissioned},
+			expectedAcceptance: true,
+			expectedLabels:     deviceLabels,
+			expectedTaint:      string(deviceStateDecommissioned),
+			expectedRequeue:    deviceDetachGracePeriod,
+		},
+		{
+			name: "cluster is detached after the grace period",
+			existingObjects: []runtime.Object{newTestManagedCluster(true, deviceLabels, clusterv1.Taint{
+				Key:       FlightCtlDeviceUnavailableTaint,
+				Value:     string(deviceStateNotFound),
+				Effect:    clusterv1.TaintEffectNoSelect,
+				TimeAdded: metav1.NewTime(time.Now().Add(-deviceDetachGracePeriod)),
+			})},
+			controllerConfig: map[string]string{
+				constants.FlightCtlDeviceRemovalActionKey: DeviceRemovalActionDetach,
+			},
+			device:          &flightctlDevice{state: deviceStateNotFound},
+			expectedDeleted: true,
+		},
+		{
+			name:            "invalid removal action",
+			existingObjects: []runtime.Object{newTestManagedCluster(true, deviceLabels)},
+			controllerConfig: map[string]string{
+				constants.FlightCtlDeviceRemovalActionKey: "Ignore",
+			},
+			device:      &flightctlDevice{state: deviceStateNotFound},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -109,15 +202,29 @@ func TestManagedClusterReconciler(t *testing.T) {
 				RuntimeClient: fakeClient,
 			}
 
+			kubeInformerFactory := informers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 10*time.Minute)
+			if tt.controllerConfig != nil {
+				assert.NoError(t, kubeInformerFactory.Core().V1().ConfigMaps().Informer().GetStore().Add(&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      constants.ControllerConfigConfigMapName,
+						Namespace: "test",
+					},
+					Data: tt.controllerConfig,
+				}))
+			}
+
 			reconciler := &ManagedClusterReconciler{
 				clientHolder: clientHolder,
-				isManagedClusterAFlightctlDevice: func(ctx context.Context, managedClusterName string) (bool, error) {
-					return tt.isFlightCtlDevice, tt.flightCtlError
+				recorder:     eventstesting.NewTestingEventRecorder(t),
+				importControllerConfig: helpers.NewImportControllerConfig("test",
+					kubeInformerFactory.Core().V1().ConfigMaps().Lister(), logr.Discard()),
+				getDevice: func(ctx context.Context, managedClusterName string) (*flightctlDevice, error) {
+					return tt.device, tt.flightCtlError
 				},
 			}
 
 			// Reconcile
-			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
 				NamespacedName: types.NamespacedName{
 					Name: "test-cluster",
 				},
@@ -128,12 +235,32 @@ func TestManagedClusterReconciler(t *testing.T) {
 				return
 			}
 			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedRequeue, result.RequeueAfter)
 
 			// Get the cluster and check its status
 			cluster := &clusterv1.ManagedCluster{}
 			err = fakeClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, cluster)
+			if tt.expectedDeleted {
+				assert.True(t, errors.IsNotFound(err))
+				return
+			}
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedAcceptance, cluster.Spec.HubAcceptsClient)
+			if tt.expectedLabels != nil {
+				assert.Equal(t, tt.expectedLabels, cluster.Labels)
+			} else {
+				assert.NotContains(t, cluster.Labels, FlightCtlDeviceLabel)
+			}
+
+			taint := findDeviceUnavailableTaint(cluster.Spec.Taints)
+			if len(tt.expectedTaint) == 0 {
+				assert.Nil(t, taint)
+				return
+			}
+			if assert.NotNil(t, taint) {
+				assert.Equal(t, tt.expectedTaint, taint.Value)
+				assert.Equal(t, clusterv1.TaintEffectNoSelect, taint.Effect)
+			}
 		})
 	}
 }
@@ -1,19 +1,25 @@
+// Copyright Contributors to the Open Cluster Management project
+
 package flightctl
 
 import (
 	"context"
 
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"github.com/stolostron/managedcluster-import-controller/pkg/source"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-func AddManagedClusterController(ctx context.Context, mgr manager.Manager, flightctlManager *FlightCtlManager, clientHolder *helpers.ClientHolder) error {
+func AddManagedClusterController(ctx context.Context, mgr manager.Manager, flightctlManager *FlightCtlManager,
+	clientHolder *helpers.ClientHolder, informerHolder *source.InformerHolder, componentNamespace string) error {
 	return ctrl.NewControllerManagedBy(mgr).Named(ManagedClusterControllerName).
 		Watches(
 			&clusterv1.ManagedCluster{},
@@ -22,15 +28,54 @@ func AddManagedClusterController(ctx context.Context, mgr manager.Manager, fligh
 				GenericFunc: func(e event.GenericEvent) bool { return false },
 				DeleteFunc:  func(e event.DeleteEvent) bool { return false },
 				UpdateFunc: func(e event.UpdateEvent) bool {
-					return !e.ObjectNew.(*clusterv1.ManagedCluster).Spec.HubAcceptsClient
+					return isDeviceCandidate(e.ObjectNew.(*clusterv1.ManagedCluster))
 				},
 				CreateFunc: func(e event.CreateEvent) bool {
-					return !e.Object.(*clusterv1.ManagedCluster).Spec.HubAcceptsClient
+					return isDeviceCandidate(e.Object.(*clusterv1.ManagedCluster))
 				},
 			})).
 		Complete(&ManagedClusterReconciler{
-			clientHolder:                     clientHolder,
-			isManagedClusterAFlightctlDevice: flightctlManager.IsManagedClusterAFlightctlDevice,
-			recorder:                         helpers.NewEventRecorder(clientHolder.KubeClient, ManagedClusterControllerName),
+			clientHolder: clientHolder,
+			recorder:     helpers.NewEventRecorder(clientHolder.KubeClient, ManagedClusterControllerName),
+			importControllerConfig: helpers.NewImportControllerConfig(componentNamespace,
+				informerHolder.ControllerConfigLister, ctrl.Log.WithName(ManagedClusterControllerName)),
+			getDevice: flightctlManager.getDevice,
+		})
+}
+
+// isDeviceCandidate returns true if the managed cluster is not accepted yet or it is accepted as a flightctl device.
+func isDeviceCandidate(cluster *clusterv1.ManagedCluster) bool {
+	return !cluster.Spec.HubAcceptsClient || cluster.Labels[FlightCtlDeviceLabel] == "true"
+}
+
+// AddSyncController adds the controller that synchronizes the FlightCtl resources. It watches the
+// flightctl-discovery ConfigMap and the agent registration ServiceAccount and ClusterRoleBinding, whose token and
+// permissions are delivered to the flightctl-agent by the Repository. The informers of the watched resources only
+// list the resources by their names.
+func AddSyncController(ctx context.Context, mgr manager.Manager, flightctlManager *FlightCtlManager,
+	clientHolder *helpers.ClientHolder, informerHolder *source.InformerHolder) error {
+	enqueueSyncRequest := &source.ManagedClusterResourceEventHandler{
+		MapFunc: func(o client.Object) reconcile.Request {
+			return syncRequest
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).Named(SyncControllerName).
+		WatchesRawSource(
+			source.NewFlightCtlDiscoveryConfigMapSource(informerHolder.FlightCtlDiscoveryConfigMapInformer,
+				enqueueSyncRequest),
+		).
+		WatchesRawSource(
+			source.NewFlightCtlServiceAccountSource(informerHolder.FlightCtlServiceAccountInformer,
+				enqueueSyncRequest),
+		).
+		WatchesRawSource(
+			source.NewFlightCtlClusterRoleBindingSource(informerHolder.FlightCtlClusterRoleBindingInformer,
+				enqueueSyncRequest),
+		).
+		Complete(&SyncReconciler{
+			clientHolder:     clientHolder,
+			recorder:         flightctlManager.recorder,
+			flightctlManager: flightctlManager,
 		})
 }
@@ -0,0 +1,252 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package flightctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	SyncControllerName = "flightctl-sync-controller"
+
+	// ClusterManagerName is the name of the ClusterManager whose status reports the FlightCtl synchronization.
+	ClusterManagerName = "cluster-manager"
+
+	// ConditionTypeFlightCtlSynced is the condition of the ClusterManager that reports whether the FlightCtl
+	// resources are synced.
+	ConditionTypeFlightCtlSynced = "FlightCtlSynced"
+
+	// The reasons of the FlightCtlSynced condition and the events that report the FlightCtl synchronization, an
+	// event is recorded once the result of the synchronization is changed.
+	EventReasonFlightCtlUnhealthy       = "FlightCtlUnhealthy"
+	EventReasonKubernetesResourcesError = "KubernetesResourcesFailed"
+	EventReasonRepositoryError          = "RepositoryFailed"
+	EventReasonRepositorySynced         = "RepositorySynced"
+)
+
+const (
+	// driftCheckInterval is the interval to check the Repository is not drifted.
+	driftCheckInterval = 5 * time.Minute
+	// unhealthyRetryInterval is the interval to check an unhealthy FlightCtl service again, the health of the
+	// service is not notified by the discovery ConfigMap.
+	unhealthyRetryInterval = time.Minute
+)
+
+// syncRequest is the only request of the sync controller, all the watched resources are mapped to it.
+var syncRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: FlightCtlDiscoveryConfigMap}}
+
+// SyncReconciler synchronizes the FlightCtl resources: the kubernetes resources of the agent registration and the
+// Repository in FlightCtl. It is triggered by the changes of the flightctl-discovery ConfigMap and the agent
+// registration resources, and it checks the Repository periodically to recover it from the out-of-band changes.
+// The result is reported by the FlightCtlSynced condition of the ClusterManager and the events of the controller.
+type SyncReconciler struct {
+	clientHolder     *helpers.ClientHolder
+	recorder         events.Recorder
+	flightctlManager *FlightCtlManager
+
+	// lastResult is the last reported result of the synchronization, it is nil if FlightCtl is not enabled.
+	lastResult *syncResult
+}
+
+// syncResult is the result of a synchronization.
+type syncResult struct {
+	synced  bool
+	reason  string
+	message string
+}
+
+var _ reconcile.Reconciler = &SyncReconciler{}
+
+func (r *SyncReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	_, err := r.flightctlManager.getDiscoveryConfigMap()
+	if apierrors.IsNotFound(err) {
+		// FlightCtl is not enabled, the controller is triggered again once the discovery ConfigMap is created
+		r.lastResult = nil
+		return reconcile.Result{}, r.removeCondition(ctx)
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.flightctlManager.isFlightCtlEnabledAndHealthy(); err != nil {
+		if reportErr := r.report(ctx, false, EventReasonFlightCtlUnhealthy,
+			fmt.Sprintf("FlightCtl is not healthy: %v", err)); reportErr != nil {
+			return reconcile.Result{}, reportErr
+		}
+		return reconcile.Result{RequeueAfter: unhealthyRetryInterval}, nil
+	}
+
+	if err := r.flightctlManager.ensureFlightCtlServer(); err != nil {
+		if reportErr := r.report(ctx, false, EventReasonFlightCtlUnhealthy,
+			fmt.Sprintf("Failed to ensure FlightCtl server: %v", err)); reportErr != nil {
+			return reconcile.Result{}, reportErr
+		}
+		return reconcile.Result{RequeueAfter: unhealthyRetryInterval}, nil
+	}
+
+	if err := r.flightctlManager.applyKuberentesResources(ctx); err != nil {
+		if reportErr := r.report(ctx, false, EventReasonKubernetesResourcesError,
+			fmt.Sprintf("Failed to apply Kubernetes resources: %v", err)); reportErr != nil {
+			klog.Errorf("Failed to report the FlightCtl sync status: %v", reportErr)
+		}
+		return reconcile.Result{}, err
+	}
+
+	applied, err := r.flightctlManager.syncRepository(ctx)
+	if err != nil {
+		if reportErr := r.report(ctx, false, EventReasonRepositoryError,
+			fmt.Sprintf("Failed to sync Repository %s: %v", repositoryName, err)); reportErr != nil {
+			klog.Errorf("Failed to report the FlightCtl sync status: %v", reportErr)
+		}
+		return reconcile.Result{}, err
+	}
+	if applied {
+		klog.Infof("The FlightCtl Repository %s is applied", repositoryName)
+	}
+
+	if err := r.report(ctx, true, EventReasonRepositorySynced, "Successfully synced FlightCtl resources"); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: driftCheckInterval}, nil
+}
+
+// report sets the FlightCtlSynced condition of the ClusterManager to the result of the synchronization, and records
+// an event if the result is changed, so the periodic checks do not flood the events.
+func (r *SyncReconciler) report(ctx context.Context, synced bool, reason, message string) error {
+	status := metav1.ConditionFalse
+	if synced {
+		status = metav1.ConditionTrue
+	}
+	if err := r.updateCondition(ctx, status, reason, message); err != nil {
+		return err
+	}
+
+	result := &syncResult{synced: synced, reason: reason, message: message}
+	if r.lastResult != nil && *r.lastResult == *result {
+		return nil
+	}
+	r.lastResult = result
+
+	if synced {
+		r.recorder.Event(reason, message)
+		return nil
+	}
+	r.recorder.Warning(reason, message)
+	return nil
+}
+
+// updateCondition sets the FlightCtlSynced condition of the ClusterManager. The ClusterManager is owned by the
+// registration-operator, so only the condition is patched and the other conditions are kept. The status is not
+// reported if the ClusterManager is not found.
+func (r *SyncReconciler) updateCondition(ctx context.Context, status metav1.ConditionStatus, reason, message string) error {
+	clusterManager, err := r.clientHolder.OperatorClient.OperatorV1().ClusterManagers().Get(
+		ctx, ClusterManagerName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.V(4).Infof("The ClusterManager %s is not found, the FlightCtl sync status is not reported: %s",
+			ClusterManagerName, message)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeFlightCtlSynced,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	conditions := clusterManager.Status.Conditions
+	var patch []jsonPatchOperation
+	switch index := conditionIndex(conditions, ConditionTypeFlightCtlSynced); {
+	case index >= 0:
+		existing := conditions[index]
+		if existing.Status == status && existing.Reason == reason && existing.Message == message {
+			return nil
+		}
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		path := fmt.Sprintf("/status/conditions/%d", index)
+		patch = []jsonPatchOperation{
+			{Op: "test", Path: path + "/type", Value: ConditionTypeFlightCtlSynced},
+			{Op: "replace", Path: path, Value: condition},
+		}
+	case len(conditions) > 0:
+		patch = []jsonPatchOperation{{Op: "add", Path: "/status/conditions/-", Value: condition}}
+	default:
+		// the conditions may be added by the registration-operator after the ClusterManager is read
+		patch = []jsonPatchOperation{
+			{Op: "test", Path: "/metadata/resourceVersion", Value: clusterManager.ResourceVersion},
+			{Op: "add", Path: "/status/conditions", Value: []metav1.Condition{condition}},
+		}
+	}
+	return r.patchClusterManagerStatus(ctx, patch)
+}
+
+// removeCondition removes the FlightCtlSynced condition of the ClusterManager.
+func (r *SyncReconciler) removeCondition(ctx context.Context) error {
+	clusterManager, err := r.clientHolder.OperatorClient.OperatorV1().ClusterManagers().Get(
+		ctx, ClusterManagerName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	index := conditionIndex(clusterManager.Status.Conditions, ConditionTypeFlightCtlSynced)
+	if index < 0 {
+		return nil
+	}
+	path := fmt.Sprintf("/status/conditions/%d", index)
+	return r.patchClusterManagerStatus(ctx, []jsonPatchOperation{
+		{Op: "test", Path: path + "/type", Value: ConditionTypeFlightCtlSynced},
+		{Op: "remove", Path: path},
+	})
+}
+
+// jsonPatchOperation is an operation of a JSON patch.
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// patchClusterManagerStatus patches the status of the ClusterManager with the JSON patch, the test operations make
+// the patch fail if the conditions are changed after they are read, and the reconcile is retried.
+func (r *SyncReconciler) patchClusterManagerStatus(ctx context.Context, patch []jsonPatchOperation) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = r.clientHolder.OperatorClient.OperatorV1().ClusterManagers().Patch(ctx, ClusterManagerName,
+		types.JSONPatchType, data, metav1.PatchOptions{}, "status")
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// conditionIndex returns the index of the condition type in the conditions, or -1 if it is not found.
+func conditionIndex(conditions []metav1.Condition, conditionType string) int {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,351 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package flightctl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	flightctlclient "github.com/flightctl/flightctl/lib/api/client"
+	flightctlapiv1 "github.com/flightctl/flightctl/lib/apipublic/v1alpha1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/events/eventstesting"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+	operatorfake "open-cluster-management.io/api/client/operator/clientset/versioned/fake"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+const (
+	testAgentRegistrationServer = "https://agent-registration-multicluster-engine.apps.example.com"
+	testFlightCtlServer         = "https://api.flightctl.example.com"
+)
+
+type fakeFlightCtlClient struct {
+	repository *flightctlapiv1.Repository
+	applyError error
+	applied    int
+	// deviceBody is the response body of the device, the device is not found if it is empty
+	deviceBody string
+}
+
+func (c *fakeFlightCtlClient) ApplyRepository(ctx context.Context, token string,
+	expectedRepository *flightctlapiv1.Repository) error {
+	if c.applyError != nil {
+		return c.applyError
+	}
+	c.applied++
+	c.repository = expectedRepository
+	return nil
+}
+
+func (c *fakeFlightCtlClient) GetRepository(ctx context.Context, token string,
+	name string) (*flightctlclient.ReadRepositoryResponse, error) {
+	if c.repository == nil {
+		return &flightctlclient.ReadRepositoryResponse{
+			HTTPResponse: &http.Response{StatusCode: http.StatusNotFound},
+		}, nil
+	}
+	return &flightctlclient.ReadRepositoryResponse{
+		HTTPResponse: &http.Response{StatusCode: http.StatusOK},
+		JSON200:      c.repository,
+	}, nil
+}
+
+func (c *fakeFlightCtlClient) GetDevice(ctx context.Context, token string,
+	managedClusterName string) (*flightctlclient.ReadDeviceResponse, error) {
+	if len(c.deviceBody) == 0 {
+		return &flightctlclient.ReadDeviceResponse{HTTPResponse: &http.Response{StatusCode: http.StatusNotFound}}, nil
+	}
+	device := &flightctlapiv1.Device{}
+	if err := json.Unmarshal([]byte(c.deviceBody), device); err != nil {
+		return nil, err
+	}
+	return &flightctlclient.ReadDeviceResponse{
+		Body:         []byte(c.deviceBody),
+		HTTPResponse: &http.Response{StatusCode: http.StatusOK},
+		JSON200:      device,
+	}, nil
+}
+
+// newTestFlightCtlService starts a FlightCtl health endpoint, and returns the discovery ConfigMap and the CA secret
+// of the service.
+func newTestFlightCtlService(t *testing.T, healthy bool) (*corev1.ConfigMap, *corev1.Secret) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: FlightCtlDiscoveryConfigMap, Namespace: "open-cluster-management"},
+		Data: map[string]string{
+			"apiEndpoint":    testFlightCtlServer,
+			"healthEndpoint": server.URL,
+			"namespace":      "flightctl",
+			"caSecretName":   "flightctl-ca",
+		},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "flightctl-ca", Namespace: "flightctl"},
+		Data: map[string][]byte{
+			"ca-bundle.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+		},
+	}
+}
+
+// newTestDiscoveryConfigMapLister returns the lister of the discovery ConfigMap, the lister is empty if the
+// ConfigMap is nil.
+func newTestDiscoveryConfigMapLister(t *testing.T, discovery *corev1.ConfigMap) corev1listers.ConfigMapLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if discovery != nil {
+		assert.NoError(t, indexer.Add(discovery))
+	}
+	return corev1listers.NewConfigMapLister(indexer)
+}
+
+func newTestRepository(t *testing.T, url string) *flightctlapiv1.Repository {
+	f := &FlightCtlManager{agentRegistrationServer: url}
+	repository, err := f.newRepository("agent-registration-token", base64.StdEncoding.EncodeToString([]byte("ca")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return repository
+}
+
+func TestSyncReconciler(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "open-cluster-management")
+
+	tokenDir := t.TempDir()
+	serviceAccountTokenFile = filepath.Join(tokenDir, "token")
+	serviceAccountCAFile = filepath.Join(tokenDir, "ca.crt")
+	assert.NoError(t, os.WriteFile(serviceAccountTokenFile, []byte("client-token"), 0600))
+	assert.NoError(t, os.WriteFile(serviceAccountCAFile, []byte("ca"), 0600))
+
+	tests := []struct {
+		name                string
+		noDiscovery         bool
+		unhealthy           bool
+		lastResult          *syncResult
+		repository          *flightctlapiv1.Repository
+		repositoryAppliedAt time.Time
+		flightctlServer     string
+		applyError          error
+		noClusterManager    bool
+		conditions          []metav1.Condition
+		expectError         bool
+		expectedRequeue     time.Duration
+		expectedApplied     int
+		expectedEvent       string
+		// expectedCondition is the status of the FlightCtlSynced condition, the condition is removed if it is empty
+		expectedCondition metav1.ConditionStatus
+	}{
+		{
+			name:        "flightctl is not enabled",
+			noDiscovery: true,
+			lastResult:  &syncResult{synced: true, reason: EventReasonRepositorySynced},
+			conditions: []metav1.Condition{
+				{Type: ConditionTypeFlightCtlSynced, Status: metav1.ConditionTrue, Reason: EventReasonRepositorySynced},
+			},
+		},
+		{
+			name:              "flightctl is not healthy",
+			unhealthy:         true,
+			expectedRequeue:   unhealthyRetryInterval,
+			expectedEvent:     EventReasonFlightCtlUnhealthy,
+			expectedCondition: metav1.ConditionFalse,
+		},
+		{
+			name:              "repository is created",
+			expectedRequeue:   driftCheckInterval,
+			expectedApplied:   1,
+			expectedEvent:     EventReasonRepositorySynced,
+			expectedCondition: metav1.ConditionTrue,
+		},
+		{
+			name:             "cluster manager is not found",
+			noClusterManager: true,
+			expectedRequeue:  driftCheckInterval,
+			expectedApplied:  1,
+			expectedEvent:    EventReasonRepositorySynced,
+		},
+		{
+			name:                "repository is in sync",
+			repository:          newTestRepository(t, testAgentRegistrationServer),
+			repositoryAppliedAt: time.Now().Add(-time.Hour),
+			flightctlServer:     testFlightCtlServer,
+			conditions: []metav1.Condition{
+				{Type: ConditionTypeFlightCtlSynced, Status: metav1.ConditionFalse, Reason: EventReasonRepositoryError},
+			},
+			expectedRequeue:   driftCheckInterval,
+			expectedEvent:     EventReasonRepositorySynced,
+			expectedCondition: metav1.ConditionTrue,
+		},
+		{
+			name:                "repository is drifted",
+			repository:          newTestRepository(t, "https://other.example.com"),
+			repositoryAppliedAt: time.Now().Add(-time.Hour),
+			flightctlServer:     testFlightCtlServer,
+			expectedRequeue:     driftCheckInterval,
+			expectedApplied:     1,
+			expectedEvent:       EventReasonRepositorySynced,
+			expectedCondition:   metav1.ConditionTrue,
+		},
+		{
+			name:                "agent registration token is refreshed",
+			repository:          newTestRepository(t, testAgentRegistrationServer),
+			repositoryAppliedAt: time.Now().Add(-2 * repositoryTokenRefreshInterval),
+			flightctlServer:     testFlightCtlServer,
+			expectedRequeue:     driftCheckInterval,
+			expectedApplied:     1,
+			expectedEvent:       EventReasonRepositorySynced,
+			expectedCondition:   metav1.ConditionTrue,
+		},
+		{
+			name:                "flightctl server is changed",
+			repository:          newTestRepository(t, testAgentRegistrationServer),
+			repositoryAppliedAt: time.Now().Add(-time.Hour),
+			flightctlServer:     "https://old.flightctl.example.com",
+			expectedRequeue:     driftCheckInterval,
+			expectedApplied:     1,
+			expectedEvent:       EventReasonRepositorySynced,
+			expectedCondition:   metav1.ConditionTrue,
+		},
+		{
+			name:              "failed to apply repository",
+			applyError:        assert.AnError,
+			expectError:       true,
+			expectedEvent:     EventReasonRepositoryError,
+			expectedCondition: metav1.ConditionFalse,
+		},
+		{
+			name:                "repository is still in sync",
+			repository:          newTestRepository(t, testAgentRegistrationServer),
+			repositoryAppliedAt: time.Now().Add(-time.Hour),
+			flightctlServer:     testFlightCtlServer,
+			lastResult: &syncResult{synced: true, reason: EventReasonRepositorySynced,
+				message: "Successfully synced FlightCtl resources"},
+			conditions: []metav1.Condition{
+				{Type: ConditionTypeFlightCtlSynced, Status: metav1.ConditionTrue, Reason: EventReasonRepositorySynced,
+					Message: "Successfully synced FlightCtl resources"},
+			},
+			expectedRequeue:   driftCheckInterval,
+			expectedCondition: metav1.ConditionTrue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discovery, caSecret := newTestFlightCtlService(t, !tt.unhealthy)
+			if tt.noDiscovery {
+				discovery = nil
+			}
+			kubeClient := kubefake.NewSimpleClientset(caSecret)
+			kubeClient.PrependReactor("create", "serviceaccounts",
+				func(action clienttesting.Action) (bool, runtime.Object, error) {
+					if action.GetSubresource() != "token" {
+						return false, nil, nil
+					}
+					return true, &authenticationv1.TokenRequest{
+						Status: authenticationv1.TokenRequestStatus{Token: "agent-registration-token"},
+					}, nil
+				})
+
+			// the conditions of the registration-operator are kept
+			operatorObjects := []runtime.Object{}
+			if !tt.noClusterManager {
+				operatorObjects = append(operatorObjects, &operatorv1.ClusterManager{
+					ObjectMeta: metav1.ObjectMeta{Name: ClusterManagerName},
+					Status: operatorv1.ClusterManagerStatus{
+						Conditions: append([]metav1.Condition{
+							{Type: "Applied", Status: metav1.ConditionTrue, Reason: "ClusterManagerApplied"},
+						}, tt.conditions...),
+					},
+				})
+			}
+			operatorClient := operatorfake.NewSimpleClientset(operatorObjects...)
+
+			recorder := eventstesting.NewTestingEventRecorder(t)
+			fakeClient := &fakeFlightCtlClient{repository: tt.repository, applyError: tt.applyError}
+			flightctlManager := &FlightCtlManager{
+				clientHolder:             &helpers.ClientHolder{KubeClient: kubeClient, OperatorClient: operatorClient},
+				discoveryConfigMapLister: newTestDiscoveryConfigMapLister(t, discovery),
+				recorder:                 recorder,
+				agentRegistrationServer:  testAgentRegistrationServer,
+				flightctlServer:          tt.flightctlServer,
+				repositoryAppliedAt:      tt.repositoryAppliedAt,
+				newFlightCtlClient:       func(server string) flightctlClient { return fakeClient },
+			}
+			if len(tt.flightctlServer) > 0 {
+				flightctlManager.flightctlClient = fakeClient
+			}
+
+			syncRecorder := events.NewInMemoryRecorder(SyncControllerName, clock.RealClock{})
+			reconciler := &SyncReconciler{
+				clientHolder:     flightctlManager.clientHolder,
+				recorder:         syncRecorder,
+				flightctlManager: flightctlManager,
+				lastResult:       tt.lastResult,
+			}
+			result, err := reconciler.Reconcile(context.TODO(), syncRequest)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedRequeue, result.RequeueAfter)
+			}
+			assert.Equal(t, tt.expectedApplied, fakeClient.applied)
+			if tt.expectedApplied > 0 {
+				spec, err := fakeClient.repository.Spec.GetHttpRepoSpec()
+				assert.NoError(t, err)
+				assert.Equal(t, testAgentRegistrationServer, spec.Url)
+				assert.Equal(t, "agent-registration-token", ptr.Deref(spec.HttpConfig.Token, ""))
+			}
+
+			recorded := syncRecorder.Events()
+			if len(tt.expectedEvent) == 0 {
+				assert.Empty(t, recorded)
+			} else if assert.Len(t, recorded, 1) {
+				assert.Equal(t, tt.expectedEvent, recorded[0].Reason)
+			}
+			if tt.noDiscovery {
+				assert.Nil(t, reconciler.lastResult)
+			}
+
+			if tt.noClusterManager {
+				return
+			}
+			clusterManager, err := operatorClient.OperatorV1().ClusterManagers().Get(
+				context.TODO(), ClusterManagerName, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.NotNil(t, meta.FindStatusCondition(clusterManager.Status.Conditions, "Applied"))
+			condition := meta.FindStatusCondition(clusterManager.Status.Conditions, ConditionTypeFlightCtlSynced)
+			if len(tt.expectedCondition) == 0 {
+				assert.Nil(t, condition)
+			} else if assert.NotNil(t, condition) {
+				assert.Equal(t, tt.expectedCondition, condition.Status)
+			}
+		})
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"k8s.io/klog/v2"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	operatorv1 "open-cluster-management.io/api/operator/v1"
+	"sigs.k8s.io/yaml"
 )
 
 func getImportSecret(ctx context.Context, clientHolder *helpers.ClientHolder, clusterName string) (*corev1.Secret, error) {
@@ -29,6 +30,17 @@ func getImportSecret(ctx context.Context, clientHolder *helpers.ClientHolder, cl
 }
 
 func extractBootstrapKubeConfigDataFromImportSecret(importSecret *corev1.Secret) []byte {
+	kubeconfigs := extractBootstrapKubeConfigsFromImportSecret(importSecret)
+	if len(kubeconfigs) == 0 {
+		return nil
+	}
+	return kubeconfigs[0]
+}
+
+// extractBootstrapKubeConfigsFromImportSecret returns the bootstrap kubeconfigs in the import.yaml. If the
+// bootstrap kubeconfig secret of the hub kube apiserver endpoints are found, the kubeconfigs of them are
+// returned in order, otherwise the kubeconfig of the default bootstrap kubeconfig secret is returned.
+func extractBootstrapKubeConfigsFromImportSecret(importSecret *corev1.Secret) [][]byte {
 	if importSecret == nil {
 		return nil
 	}
@@ -38,15 +50,32 @@ func extractBootstrapKubeConfigDataFromImportSecret(importSecret *corev1.Secret)
 		return nil
 	}
 
+	kubeconfigs := map[string][]byte{}
 	for _, yaml := range helpers.SplitYamls(importYaml) {
 		obj := helpers.MustCreateObject(yaml)
 		if secret, ok := obj.(*corev1.Secret); ok {
-			if secret.Name == constants.DefaultBootstrapHubKubeConfigSecretName {
-				return secret.Data["kubeconfig"]
+			if strings.HasPrefix(secret.Name, constants.DefaultBootstrapHubKubeConfigSecretName) {
+				kubeconfigs[secret.Name] = secret.Data["kubeconfig"]
 			}
 		}
 	}
 
+	var endpointKubeconfigs [][]byte
+	for i := 0; ; i++ {
+		kubeconfig, ok := kubeconfigs[bootstrap.GetEndpointBootstrapKubeConfigSecretName(i)]
+		if !ok {
+			break
+		}
+		endpointKubeconfigs = append(endpointKubeconfigs, kubeconfig)
+	}
+	if len(endpointKubeconfigs) > 0 {
+		return endpointKubeconfigs
+	}
+
+	if kubeconfig, ok := kubeconfigs[constants.DefaultBootstrapHubKubeConfigSecretName]; ok {
+		return [][]byte{kubeconfig}
+	}
+
 	return nil
 }
 
@@ -138,10 +167,13 @@ func validateTokenExpiration(token string, creation, expiration []byte) bool {
 	return lifetime > refreshThreshold
 }
 
+// buildBootstrapKubeconfigData returns the bootstrap kubeconfigs for the hub kube apiserver endpoints in order,
+// and the creation and expiration of the token in the kubeconfigs.
 func buildBootstrapKubeconfigData(ctx context.Context, clientHolder *helpers.ClientHolder,
 	managedCluster *clusterv1.ManagedCluster,
-	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) ([]byte, []byte, []byte, error) {
-	var bootstrapKubeconfigData, tokenData, tokenCreation, tokenExpiration []byte
+	klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig) ([][]byte, []byte, []byte, error) {
+	var bootstrapKubeconfigs [][]byte
+	var tokenData, tokenCreation, tokenExpiration []byte
 
 	// get the import secret
 	importSecret, err := getImportSecret(ctx, clientHolder, managedCluster.Name)
@@ -150,7 +182,7 @@ func buildBootstrapKubeconfigData(ctx context.Context, clientHolder *helpers.Cli
 	}
 
 	// get the latest kube apiserver configuration
-	requiredKubeAPIServer, requiredProxyURL, requiredCA, requiredCAData, err := bootstrap.GetKubeAPIServerConfig(
+	requiredEndpoints, err := bootstrap.GetKubeAPIServerEndpoints(
 		ctx, clientHolder, managedCluster.Name, klusterletConfig, isSelfManaged(managedCluster))
 	if err != nil {
 		return nil, nil, nil, err
@@ -166,9 +198,9 @@ func buildBootstrapKubeconfigData(ctx context.Context, clientHolder *helpers.Cli
 		klog.Infof("the import secret is missing for the managed cluster %s", managedCluster.Name)
 	}
 
-	// check if the bootstrap kubeconfig and token in the import secret are still valid
-	if kubeconfigData := extractBootstrapKubeConfigDataFromImportSecret(importSecret); len(kubeconfigData) > 0 {
-		kubeAPIServer, proxyURL, ca, caData, tokenString, ctxClusterName, err := helpers.ParseKubeConfigData(kubeconfigData)
+	// check if the bootstrap kubeconfigs and token in the import secret are still valid
+	if kubeconfigs := extractBootstrapKubeConfigsFromImportSecret(importSecret); len(kubeconfigs) > 0 {
+		endpoints, tokenString, ctxClusterName, err := parseBootstrapKubeconfigs(kubeconfigs)
 		if err != nil {
 			klog.Infof("failed to parse the bootstrap hub kubeconfig in the import.yaml. Recreation is required: %v", err)
 		} else {
@@ -195,11 +227,10 @@ func buildBootstrapKubeconfigData(ctx context.Context, clientHolder *helpers.Cli
 					managedCluster.Name, string(creation), string(expiration))
 			}
 
-			// use the kubeconfig if it is still valid
+			// use the kubeconfigs if they are still valid
 			if valid := bootstrap.ValidateBootstrapKubeconfig(managedCluster.Name,
-				kubeAPIServer, proxyURL, ca, caData, ctxClusterName,
-				requiredKubeAPIServer, requiredProxyURL, requiredCA, requiredCAData, requiredCtxClusterName); valid {
-				bootstrapKubeconfigData = kubeconfigData
+				endpoints, ctxClusterName, requiredEndpoints, requiredCtxClusterName); valid {
+				bootstrapKubeconfigs = kubeconfigs
 			}
 		}
 	}
@@ -214,21 +245,53 @@ func buildBootstrapKubeconfigData(ctx context.Context, clientHolder *helpers.Cli
 			return nil, nil, nil, err
 		}
 
-		// reset the bootstrap kubeconfig to trigger the regeneration since the token is updated
-		bootstrapKubeconfigData = nil
+		// reset the bootstrap kubeconfigs to trigger the regeneration since the token is updated
+		bootstrapKubeconfigs = nil
 	}
 
-	// create a new bootstrap kubeconfig if it is invalid or missing
-	if len(bootstrapKubeconfigData) == 0 {
+	// create new bootstrap kubeconfigs if they are invalid or missing
+	if len(bootstrapKubeconfigs) == 0 {
 		klog.Infof("create a new bootstrap kubeconfig for the managed cluster %s", managedCluster.Name)
-		bootstrapKubeconfigData, err = bootstrap.CreateBootstrapKubeConfig(requiredCtxClusterName,
-			requiredKubeAPIServer, requiredProxyURL, requiredCA, requiredCAData, tokenData)
+		for _, endpoint := range requiredEndpoints {
+			bootstrapKubeconfigData, err := bootstrap.CreateBootstrapKubeConfig(requiredCtxClusterName,
+				endpoint.URL, endpoint.ProxyURL, endpoint.CA, endpoint.CAData, tokenData)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			bootstrapKubeconfigs = append(bootstrapKubeconfigs, bootstrapKubeconfigData)
+		}
+	}
+
+	return bootstrapKubeconfigs, tokenCreation, tokenExpiration, nil
+}
+
+// parseBootstrapKubeconfigs parses the kube apiserver endpoints from the bootstrap kubeconfigs, and returns
+// them with the token and the context cluster name of the first kubeconfig. The kubeconfigs are considered
+// invalid if they do not share the same token and context cluster name.
+func parseBootstrapKubeconfigs(kubeconfigs [][]byte) ([]bootstrap.KubeAPIServerEndpoint, string, string, error) {
+	var endpoints []bootstrap.KubeAPIServerEndpoint
+	var token, ctxClusterName string
+	for i, kubeconfigData := range kubeconfigs {
+		kubeAPIServer, proxyURL, ca, caData, tokenString, clusterName, err := helpers.ParseKubeConfigData(kubeconfigData)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, "", "", err
+		}
+
+		if i == 0 {
+			token, ctxClusterName = tokenString, clusterName
+		} else if tokenString != token || clusterName != ctxClusterName {
+			return nil, "", "", fmt.Errorf("the bootstrap kubeconfigs have different tokens or context clusters")
 		}
+
+		endpoints = append(endpoints, bootstrap.KubeAPIServerEndpoint{
+			URL:      kubeAPIServer,
+			ProxyURL: proxyURL,
+			CA:       ca,
+			CAData:   caData,
+		})
 	}
 
-	return bootstrapKubeconfigData, tokenCreation, tokenExpiration, nil
+	return endpoints, token, ctxClusterName, nil
 }
 
 func isSelfManaged(managedCluster *clusterv1.ManagedCluster) bool {
@@ -243,10 +306,27 @@ func isSelfManaged(managedCluster *clusterv1.ManagedCluster) bool {
 
 func buildImportSecret(ctx context.Context, clientHolder *helpers.ClientHolder, managedCluster *clusterv1.ManagedCluster,
 	mode operatorv1.InstallMode, klusterletConfig *klusterletconfigv1alpha1.KlusterletConfig,
-	bootstrapKubeconfigData, tokenCreation, tokenExpiration []byte) (*corev1.Secret, *corev1.Secret, error) {
+	bootstrapKubeconfigs [][]byte, tokenCreation, tokenExpiration []byte) (*corev1.Secret, *corev1.Secret, error) {
 	var yamlcontent, crdsYAML, valuesYAML []byte
 	var secretAnnotations map[string]string
 	var err error
+
+	if len(bootstrapKubeconfigs) == 0 {
+		return nil, nil, fmt.Errorf("the bootstrap kubeconfig of the managed cluster %s is missing", managedCluster.Name)
+	}
+	bootstrapKubeconfigData := bootstrapKubeconfigs[0]
+
+	// the klusterlet fails over among the hub kube apiserver endpoints with one bootstrap kubeconfig secret
+	// for each endpoint
+	var bootstrapKubeConfigSecrets []bootstrap.BootstrapKubeConfigSecret
+	if len(bootstrapKubeconfigs) > 1 {
+		for i, kubeconfig := range bootstrapKubeconfigs {
+			bootstrapKubeConfigSecrets = append(bootstrapKubeConfigSecrets, bootstrap.BootstrapKubeConfigSecret{
+				Name:       bootstrap.GetEndpointBootstrapKubeConfigSecretName(i),
+				KubeConfig: string(kubeconfig),
+			})
+		}
+	}
 	switch mode {
 	case operatorv1.InstallModeDefault, operatorv1.InstallModeSingleton:
 		supportPriorityClass, err := helpers.SupportPriorityClass(managedCluster)
@@ -263,6 +343,7 @@ func buildImportSecret(ctx context.Context, clientHolder *helpers.ClientHolder,
 			bootstrapKubeconfigData).
 			WithManagedCluster(managedCluster).
 			WithKlusterletConfig(klusterletConfig).
+			WithBootstrapKubeConfigSecrets(bootstrapKubeConfigSecrets...).
 			WithPriorityClassName(priorityClassName)
 		yamlcontent, crdsYAML, valuesYAML, err = config.Generate(ctx, clientHolder)
 		if err != nil {
@@ -280,6 +361,7 @@ func buildImportSecret(ctx context.Context, clientHolder *helpers.ClientHolder,
 			// already had the default PriorityClass
 			WithPriorityClassName(constants.DefaultKlusterletPriorityClassName).
 			WithKlusterletConfig(klusterletConfig).
+			WithBootstrapKubeConfigSecrets(bootstrapKubeConfigSecrets...).
 			Generate(ctx, clientHolder)
 		if err != nil {
 			return nil, nil, err
@@ -332,3 +414,46 @@ func buildImportSecret(ctx context.Context, clientHolder *helpers.ClientHolder,
 
 	return importSecret, valuesSecret, nil
 }
+
+// buildEffectiveKlusterletConfigMap builds a configmap with the merged klusterletconfig spec and annotations of the
+// managed cluster and the sources of each field in the spec and of each annotation.
+func buildEffectiveKlusterletConfigMap(clusterName, klusterletconfigName string,
+	mergedKlusterletConfig *klusterletconfigv1alpha1.KlusterletConfig,
+	sources map[string][]string) (*corev1.ConfigMap, error) {
+	spec := klusterletconfigv1alpha1.KlusterletConfigSpec{}
+	annotations := map[string]string{}
+	if mergedKlusterletConfig != nil {
+		spec = mergedKlusterletConfig.Spec
+		for key, value := range mergedKlusterletConfig.Annotations {
+			annotations[key] = value
+		}
+	}
+
+	specYAML, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the merged klusterletconfig spec: %w", err)
+	}
+
+	annotationsYAML, err := yaml.Marshal(annotations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the merged klusterletconfig annotations: %w", err)
+	}
+
+	sourcesYAML, err := yaml.Marshal(sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the merged klusterletconfig sources: %w", err)
+	}
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      constants.EffectiveKlusterletConfigConfigMapName,
+			Namespace: clusterName,
+		},
+		Data: map[string]string{
+			constants.EffectiveKlusterletConfigNameKey:        klusterletconfigName,
+			constants.EffectiveKlusterletConfigSpecKey:        string(specYAML),
+			constants.EffectiveKlusterletConfigAnnotationsKey: string(annotationsYAML),
+			constants.EffectiveKlusterletConfigSourcesKey:     string(sourcesYAML),
+		},
+	}, nil
+}
@@ -6,6 +6,7 @@ package importconfig
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -127,6 +128,8 @@ func TestBuildBootstrapKubeconfigData(t *testing.T) {
 		ca        string
 		certData  []byte
 		token     string
+		// the servers of all bootstrap kubeconfigs if there are multiple kube apiserver urls
+		serverURLs []string
 	}
 	tests := []struct {
 		name             string
@@ -255,6 +258,25 @@ func TestBuildBootstrapKubeconfigData(t *testing.T) {
 				token:     "mock-token",
 			},
 		},
+		{
+			name:        "multiple kube apiserver urls",
+			clientObjs:  []client.Object{testInfraConfigDNS, apiserverConfig},
+			runtimeObjs: []runtime.Object{cm, sa, saSecret},
+			klusterletConfig: &klusterletconfigv1alpha1.KlusterletConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						constants.KlusterletConfigHubKubeAPIServerURLsAnnotation: "https://lb1.example.com:6443, " +
+							"https://lb2.example.com:6443,https://lb1.example.com:6443",
+					},
+				},
+			},
+			want: &wantData{
+				serverURL:  "https://lb1.example.com:6443",
+				certData:   certData1,
+				token:      "sa-token",
+				serverURLs: []string{"https://lb1.example.com:6443", "https://lb2.example.com:6443"},
+			},
+		},
 		{
 			name:        "self managed cluster without import secret",
 			selfManaged: true,
@@ -344,25 +366,46 @@ func TestBuildBootstrapKubeconfigData(t *testing.T) {
 				}
 			}
 
-			kubeconfigData, _, _, err := buildBootstrapKubeconfigData(context.Background(), clientHolder, cluster, tt.klusterletConfig) // cluster.Name = testcluster
+			kubeconfigs, _, _, err := buildBootstrapKubeconfigData(context.Background(), clientHolder, cluster, tt.klusterletConfig) // cluster.Name = testcluster
 			if err != nil {
 				t.Errorf("buildBootstrapKubeconfigData() error = %v", err)
 				return
 			}
 
 			if tt.want == nil {
-				if kubeconfigData == nil {
+				if kubeconfigs == nil {
 					return
 				} else {
-					t.Errorf("buildBootstrapKubeconfigData() returns wrong data. want nil, got %v", kubeconfigData)
+					t.Errorf("buildBootstrapKubeconfigData() returns wrong data. want nil, got %v", kubeconfigs)
 					return
 				}
 			}
-			if tt.want != nil && kubeconfigData == nil {
+			if tt.want != nil && kubeconfigs == nil {
 				t.Errorf("buildBootstrapKubeconfigData() returns wrong data. want %v, got nil", tt.want)
 				return
 			}
 
+			if len(tt.want.serverURLs) > 0 {
+				if len(kubeconfigs) != len(tt.want.serverURLs) {
+					t.Errorf("buildBootstrapKubeconfigData() returns %d kubeconfigs, want %d",
+						len(kubeconfigs), len(tt.want.serverURLs))
+					return
+				}
+				for i, kubeconfig := range kubeconfigs {
+					server, _, _, _, _, _, err := helpers.ParseKubeConfigData(kubeconfig)
+					if err != nil {
+						t.Errorf("buildBootstrapKubeconfigData() failed to parse kubeconfig: %v", err)
+						return
+					}
+					if server != tt.want.serverURLs[i] {
+						t.Errorf("buildBootstrapKubeconfigData() returns wrong server. want %v, got %v",
+							tt.want.serverURLs[i], server)
+					}
+				}
+			}
+
+			kubeconfigData := kubeconfigs[0]
+
 			bootstrapConfig := &clientcmdapi.Config{}
 			if err := runtime.DecodeInto(clientcmdlatest.Codec, kubeconfigData, bootstrapConfig); err != nil {
 				t.Errorf("buildBootstrapKubeconfigData() failed to decode return data")
@@ -785,3 +828,43 @@ func TestValidateLegacyServiceAccountToken(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildEffectiveKlusterletConfigMap(t *testing.T) {
+	merged := &klusterletconfigv1alpha1.KlusterletConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				constants.KlusterletConfigHubKubeAPIServerURLsAnnotation: "https://test:6443",
+			},
+		},
+		Spec: klusterletconfigv1alpha1.KlusterletConfigSpec{
+			HubKubeAPIServerURL: "https://test:6443",
+		},
+	}
+	sources := map[string][]string{
+		"hubKubeAPIServerURL": {"test"},
+		"metadata.annotations." + constants.KlusterletConfigHubKubeAPIServerURLsAnnotation: {"test"},
+	}
+
+	cm, err := buildEffectiveKlusterletConfigMap("cluster1", "test", merged, sources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cm.Namespace != "cluster1" || cm.Name != constants.EffectiveKlusterletConfigConfigMapName {
+		t.Errorf("unexpected configmap %s/%s", cm.Namespace, cm.Name)
+	}
+	if cm.Data[constants.EffectiveKlusterletConfigNameKey] != "test" {
+		t.Errorf("unexpected klusterletconfig name %q", cm.Data[constants.EffectiveKlusterletConfigNameKey])
+	}
+	if !strings.Contains(cm.Data[constants.EffectiveKlusterletConfigSpecKey], "hubKubeAPIServerURL: https://test:6443") {
+		t.Errorf("unexpected spec %q", cm.Data[constants.EffectiveKlusterletConfigSpecKey])
+	}
+	if cm.Data[constants.EffectiveKlusterletConfigAnnotationsKey] !=
+		"import.open-cluster-management.io/hub-kube-apiserver-urls: https://test:6443\n" {
+		t.Errorf("unexpected annotations %q", cm.Data[constants.EffectiveKlusterletConfigAnnotationsKey])
+	}
+	if cm.Data[constants.EffectiveKlusterletConfigSourcesKey] != "hubKubeAPIServerURL:\n- test\n"+
+		"metadata.annotations.import.open-cluster-management.io/hub-kube-apiserver-urls:\n- test\n" {
+		t.Errorf("unexpected sources %q", cm.Data[constants.EffectiveKlusterletConfigSourcesKey])
+	}
+}
@@ -7,20 +7,23 @@ import (
 	"context"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/openshift/library-go/pkg/operator/events"
+	clusterlisterv1beta2 "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta2"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 
 	"github.com/stolostron/managedcluster-import-controller/pkg/bootstrap"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 
 	listerklusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/client/klusterletconfig/listers/klusterletconfig/v1alpha1"
-
-	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
 )
 
 var log = logf.Log.WithName(ControllerName)
@@ -29,9 +32,14 @@ var log = logf.Log.WithName(ControllerName)
 type ReconcileImportConfig struct {
 	clientHolder           *helpers.ClientHolder
 	klusterletconfigLister listerklusterletconfigv1alpha1.KlusterletConfigLister
-	scheme                 *runtime.Scheme
-	recorder               events.Recorder
-	importControllerConfig *helpers.ImportControllerConfig
+	// klusterletconfigIndexer indexes the klusterletconfigs by the selectors
+	klusterletconfigIndexer cache.Indexer
+	clusterSetLister        clusterlisterv1beta2.ManagedClusterSetLister
+	// effectiveKlusterletConfigLister lists the effective klusterletconfig configmaps
+	effectiveKlusterletConfigLister corev1listers.ConfigMapLister
+	scheme                          *runtime.Scheme
+	recorder                        events.Recorder
+	importControllerConfig          *helpers.ImportControllerConfig
 }
 
 // blank assignment to verify that ReconcileImportConfig implements reconcile.Reconciler
@@ -71,16 +79,23 @@ func (r *ReconcileImportConfig) Reconcile(ctx context.Context, request reconcile
 		return reconcile.Result{}, err
 	}
 
-	klusterletconfigName := managedCluster.GetAnnotations()[apiconstants.AnnotationKlusterletConfig]
+	// Get the KlusterletConfig of the cluster, the one specified by the cluster annotation takes precedence over
+	// the ones selecting the cluster.
+	klusterletconfigName, err := helpers.GetKlusterletConfigNameForCluster(managedCluster, r.klusterletconfigIndexer,
+		r.clusterSetLister)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
 	// Get the merged KlusterletConfig, it merges the user assigned KlusterletConfig with the global KlusterletConfig.
-	mergedKlusterletConfig, err := helpers.GetMergedKlusterletConfigWithGlobal(klusterletconfigName, r.klusterletconfigLister)
+	mergedKlusterletConfig, klusterletConfigSources, err := helpers.GetMergedKlusterletConfigWithSources(
+		klusterletconfigName, r.klusterletconfigLister)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
 
-	// build the bootstrap kubeconfig
-	bootstrapKubeconfigData, tokenCreation, tokenExpiration, err := buildBootstrapKubeconfigData(ctx, r.clientHolder,
+	// build the bootstrap kubeconfigs
+	bootstrapKubeconfigs, tokenCreation, tokenExpiration, err := buildBootstrapKubeconfigData(ctx, r.clientHolder,
 		managedCluster, mergedKlusterletConfig)
 	if err != nil {
 		return reconcile.Result{}, err
@@ -88,7 +103,7 @@ func (r *ReconcileImportConfig) Reconcile(ctx context.Context, request reconcile
 
 	// rebuild the import secret and save it if it is modified
 	importSecret, configSecret, err := buildImportSecret(ctx, r.clientHolder, managedCluster, mode, mergedKlusterletConfig,
-		bootstrapKubeconfigData, tokenCreation, tokenExpiration)
+		bootstrapKubeconfigs, tokenCreation, tokenExpiration)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -98,6 +113,24 @@ func (r *ReconcileImportConfig) Reconcile(ctx context.Context, request reconcile
 		return reconcile.Result{}, err
 	}
 
+	generateEffectiveKlusterletConfig, err := r.importControllerConfig.GenerateEffectiveKlusterletConfig()
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if generateEffectiveKlusterletConfig {
+		effectiveKlusterletConfig, err := buildEffectiveKlusterletConfigMap(managedCluster.Name, klusterletconfigName,
+			mergedKlusterletConfig, klusterletConfigSources)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if _, err := helpers.ApplyResources(
+			r.clientHolder, r.recorder, r.scheme, managedCluster, effectiveKlusterletConfig); err != nil {
+			return reconcile.Result{}, err
+		}
+	} else if err := r.removeEffectiveKlusterletConfigMap(ctx, managedCluster.Name); err != nil {
+		return reconcile.Result{}, err
+	}
+
 	generateConfigSecret, err := r.importControllerConfig.GenerateImportConfig()
 	if err != nil || !generateConfigSecret {
 		return reconcile.Result{}, err
@@ -109,3 +142,23 @@ func (r *ReconcileImportConfig) Reconcile(ctx context.Context, request reconcile
 
 	return reconcile.Result{}, nil
 }
+
+// removeEffectiveKlusterletConfigMap removes the effective klusterletconfig configmap that is generated before the
+// option is disabled, the configmap is only deleted when it is in the cache.
+func (r *ReconcileImportConfig) removeEffectiveKlusterletConfigMap(ctx context.Context, clusterName string) error {
+	_, err := r.effectiveKlusterletConfigLister.ConfigMaps(clusterName).Get(
+		constants.EffectiveKlusterletConfigConfigMapName)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.clientHolder.KubeClient.CoreV1().ConfigMaps(clusterName).Delete(ctx,
+		constants.EffectiveKlusterletConfigConfigMapName, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
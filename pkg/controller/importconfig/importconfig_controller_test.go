@@ -5,6 +5,7 @@ package importconfig
 
 import (
 	"context"
+	clusterlisterv1beta2 "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta2"
 	"os"
 	"testing"
 	"time"
@@ -22,12 +23,14 @@ import (
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers/imageregistry"
 	testinghelpers "github.com/stolostron/managedcluster-import-controller/pkg/helpers/testing"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	operatorv1 "open-cluster-management.io/api/operator/v1"
@@ -147,6 +150,12 @@ func TestReconcile(t *testing.T) {
 						"ca.crt": string(rootCACertData),
 					},
 				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      constants.EffectiveKlusterletConfigConfigMapName,
+						Namespace: "test",
+					},
+				},
 			},
 			request: reconcile.Request{
 				NamespacedName: types.NamespacedName{
@@ -202,6 +211,13 @@ func TestReconcile(t *testing.T) {
 				if data, ok := importConfigSecret.Data[constants.ValuesYamlKey]; !ok || len(data) == 0 {
 					t.Errorf("the %s is required", constants.ValuesYamlKey)
 				}
+
+				// the effective klusterletconfig is not enabled, the stale configmap is removed
+				_, err = kubeClient.CoreV1().ConfigMaps("test").Get(context.TODO(),
+					constants.EffectiveKlusterletConfigConfigMapName, metav1.GetOptions{})
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected the effective klusterletconfig configmap to be deleted, but got %v", err)
+				}
 			},
 		},
 		{
@@ -1019,7 +1035,8 @@ func TestReconcile(t *testing.T) {
 			}
 			fakeklusterletconfigClient := fakeklusterletconfigv1alpha1.NewSimpleClientset(klusterletconfigs...)
 			klusterletconfigInformer := klusterletconfiginformerv1alpha1.NewKlusterletConfigInformer(fakeklusterletconfigClient, time.Second*30, cache.Indexers{
-				cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+				cache.NamespaceIndex:                     cache.MetaNamespaceIndexFunc,
+				helpers.KlusterletConfigSelectorIndexKey: helpers.IndexKlusterletConfigBySelector,
 			})
 			if c.klusterletconfig != nil {
 				klusterletconfigInformer.GetStore().Add(c.klusterletconfig)
@@ -1034,11 +1051,27 @@ func TestReconcile(t *testing.T) {
 
 			importConfigLister := testinghelpers.FakeImportControllerConfigLister("test", "", c.importConfigSecret)
 
+			// setup the effective klusterletconfig configmap cache
+			effectiveKlusterletConfigIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+				cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			})
+			for _, obj := range c.runtimeObjs {
+				if cm, ok := obj.(*corev1.ConfigMap); ok && cm.Name == constants.EffectiveKlusterletConfigConfigMapName {
+					if err := effectiveKlusterletConfigIndexer.Add(cm); err != nil {
+						t.Fatalf("failed to add configmap to indexer: %v", err)
+					}
+				}
+			}
+
 			r := &ReconcileImportConfig{
-				clientHolder:           clientHolder,
-				scheme:                 testscheme,
-				klusterletconfigLister: klusterletconfigLister,
-				recorder:               eventstesting.NewTestingEventRecorder(t),
+				clientHolder:                    clientHolder,
+				scheme:                          testscheme,
+				klusterletconfigLister:          klusterletconfigLister,
+				klusterletconfigIndexer:         klusterletconfigInformer.GetIndexer(),
+				effectiveKlusterletConfigLister: corev1listers.NewConfigMapLister(effectiveKlusterletConfigIndexer),
+				clusterSetLister: clusterlisterv1beta2.NewManagedClusterSetLister(
+					cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+				recorder: eventstesting.NewTestingEventRecorder(t),
 				importControllerConfig: helpers.NewImportControllerConfig("test", importConfigLister,
 					logf.Log.WithName("fake-import-controller-config")),
 			}
@@ -1052,3 +1085,61 @@ func TestReconcile(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoveEffectiveKlusterletConfigMap(t *testing.T) {
+	effectiveKlusterletConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      constants.EffectiveKlusterletConfigConfigMapName,
+			Namespace: "test",
+		},
+	}
+
+	cases := []struct {
+		name           string
+		cached         bool
+		expectedDelete bool
+	}{
+		{
+			name:           "the configmap is not in the cache",
+			cached:         false,
+			expectedDelete: false,
+		},
+		{
+			name:           "the configmap is in the cache",
+			cached:         true,
+			expectedDelete: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := kubefake.NewSimpleClientset(effectiveKlusterletConfig)
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+				cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			})
+			if c.cached {
+				if err := indexer.Add(effectiveKlusterletConfig); err != nil {
+					t.Fatalf("failed to add configmap to indexer: %v", err)
+				}
+			}
+
+			r := &ReconcileImportConfig{
+				clientHolder:                    &helpers.ClientHolder{KubeClient: kubeClient},
+				effectiveKlusterletConfigLister: corev1listers.NewConfigMapLister(indexer),
+			}
+			if err := r.removeEffectiveKlusterletConfigMap(context.TODO(), "test"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			deleted := false
+			for _, action := range kubeClient.Actions() {
+				if action.GetVerb() == "delete" {
+					deleted = true
+				}
+			}
+			if deleted != c.expectedDelete {
+				t.Errorf("expected delete %v, but got %v", c.expectedDelete, deleted)
+			}
+		})
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	clusterlisterv1beta2 "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta2"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 	operatorv1 "open-cluster-management.io/api/operator/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -18,45 +21,55 @@ import (
 	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
 	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 )
 
 var _ handler.EventHandler = &enqueueManagedClusterInKlusterletConfigAnnotation{}
 
+// enqueueManagedClusterInKlusterletConfigAnnotation enqueues the managedclusters using the klusterletconfig, including
+// the managedclusters that reference the klusterletconfig by the annotation and the managedclusters that are selected
+// by the klusterletconfig selectors. On an update, the managedclusters selected by the old and the new selectors are
+// both enqueued, so the managedclusters that are no longer selected are reconciled too.
 type enqueueManagedClusterInKlusterletConfigAnnotation struct {
 	managedclusterIndexer cache.Indexer
+	clusterSetLister      clusterlisterv1beta2.ManagedClusterSetLister
 }
 
 func (e *enqueueManagedClusterInKlusterletConfigAnnotation) Create(ctx context.Context,
 	evt event.TypedCreateEvent[client.Object], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
-	e.enqueue(evt.Object.GetName(), q)
+	e.enqueue(evt.Object, q)
 }
 
 func (e *enqueueManagedClusterInKlusterletConfigAnnotation) Update(ctx context.Context,
 	evt event.TypedUpdateEvent[client.Object], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
-	e.enqueue(evt.ObjectNew.GetName(), q)
+	e.enqueue(evt.ObjectOld, q)
+	e.enqueue(evt.ObjectNew, q)
 }
 
 func (e *enqueueManagedClusterInKlusterletConfigAnnotation) Delete(ctx context.Context,
 	evt event.TypedDeleteEvent[client.Object], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
-	e.enqueue(evt.Object.GetName(), q)
+	e.enqueue(evt.Object, q)
 }
 
 func (e *enqueueManagedClusterInKlusterletConfigAnnotation) Generic(ctx context.Context,
 	evt event.TypedGenericEvent[client.Object], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
-	e.enqueue(evt.Object.GetName(), q)
+	e.enqueue(evt.Object, q)
 }
 
-func (e *enqueueManagedClusterInKlusterletConfigAnnotation) enqueue(klusterletconfigName string,
+func (e *enqueueManagedClusterInKlusterletConfigAnnotation) enqueue(obj client.Object,
 	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
-	objs, err := e.managedclusterIndexer.ByIndex(ManagedClusterKlusterletConfigAnnotationIndexKey, klusterletconfigName)
-	if err != nil {
-		klog.Error(err, "Failed to get managed clusters by klusterletconfig annotation by indexer", "klusterletconfig", klusterletconfigName)
+	if obj == nil {
 		return
 	}
-	for _, obj := range objs {
-		mc := obj.(*clusterv1.ManagedCluster)
+
+	clusterNames := listManagedClustersByKlusterletConfig(e.managedclusterIndexer, obj.GetName())
+	if kc, ok := obj.(*klusterletconfigv1alpha1.KlusterletConfig); ok {
+		clusterNames.Insert(listManagedClustersBySelector(e.managedclusterIndexer, e.clusterSetLister, kc)...)
+	}
+
+	for _, name := range clusterNames.UnsortedList() {
 		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
-			Name: mc.GetName(),
+			Name: name,
 		}})
 	}
 }
@@ -78,6 +91,121 @@ func IndexManagedClusterByKlusterletconfigAnnotation(obj interface{}) ([]string,
 	return klusterletconfigs, nil
 }
 
+// listManagedClustersByKlusterletConfig returns the names of the managedclusters which reference the
+// klusterletconfig by the annotation.
+func listManagedClustersByKlusterletConfig(managedclusterIndexer cache.Indexer, klusterletconfigName string) sets.Set[string] {
+	clusterNames := sets.New[string]()
+	objs, err := managedclusterIndexer.ByIndex(ManagedClusterKlusterletConfigAnnotationIndexKey, klusterletconfigName)
+	if err != nil {
+		klog.Error(err, "Failed to get managed clusters by indexer", "klusterletconfig", klusterletconfigName)
+		return clusterNames
+	}
+	for _, obj := range objs {
+		clusterNames.Insert(obj.(*clusterv1.ManagedCluster).GetName())
+	}
+	return clusterNames
+}
+
+// listManagedClustersBySelector returns the names of the managedclusters matching the klusterletconfig selectors.
+func listManagedClustersBySelector(managedclusterIndexer cache.Indexer,
+	clusterSetLister clusterlisterv1beta2.ManagedClusterSetLister,
+	kc *klusterletconfigv1alpha1.KlusterletConfig) []string {
+	selector, err := helpers.GetKlusterletConfigSelector(kc)
+	if err != nil {
+		klog.Error(err, "Failed to parse klusterletconfig selector", "klusterletconfig", kc.GetName())
+		return nil
+	}
+	if selector == nil {
+		return nil
+	}
+
+	var clusterNames []string
+	for _, obj := range managedclusterIndexer.List() {
+		mc, ok := obj.(*clusterv1.ManagedCluster)
+		if !ok {
+			continue
+		}
+		if selector.Matches(mc, clusterSetLister) {
+			clusterNames = append(clusterNames, mc.GetName())
+		}
+	}
+	return clusterNames
+}
+
+var _ handler.EventHandler = &enqueueManagedClusterByClusterSet{}
+
+// enqueueManagedClusterByClusterSet enqueues the members of the clusterset if any klusterletconfig selects the
+// clusterset. On an update, the members of the old and the new clusterset are both enqueued, so the membership
+// changes of the clusterset are applied.
+type enqueueManagedClusterByClusterSet struct {
+	// index klusterletconfig by the selectors
+	klusterletconfigIndexer cache.Indexer
+
+	managedclusterIndexer cache.Indexer
+}
+
+func (e *enqueueManagedClusterByClusterSet) Create(ctx context.Context,
+	evt event.CreateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	e.enqueue(evt.Object, q)
+}
+
+func (e *enqueueManagedClusterByClusterSet) Update(ctx context.Context,
+	evt event.UpdateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	e.enqueue(evt.ObjectOld, q)
+	e.enqueue(evt.ObjectNew, q)
+}
+
+func (e *enqueueManagedClusterByClusterSet) Delete(ctx context.Context,
+	evt event.DeleteEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	e.enqueue(evt.Object, q)
+}
+
+func (e *enqueueManagedClusterByClusterSet) Generic(ctx context.Context,
+	evt event.GenericEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	e.enqueue(evt.Object, q)
+}
+
+func (e *enqueueManagedClusterByClusterSet) enqueue(obj client.Object,
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	clusterSet, ok := obj.(*clusterv1beta2.ManagedClusterSet)
+	if !ok {
+		return
+	}
+
+	kcs, err := helpers.ListKlusterletConfigsWithSelector(e.klusterletconfigIndexer)
+	if err != nil {
+		klog.Error(err, "Failed to list klusterletconfigs", "clusterset", clusterSet.Name)
+		return
+	}
+	selected := false
+	for _, kc := range kcs {
+		if kc.GetAnnotations()[constants.KlusterletConfigClusterSetAnnotation] == clusterSet.Name {
+			selected = true
+			break
+		}
+	}
+	if !selected {
+		return
+	}
+
+	for _, obj := range e.managedclusterIndexer.List() {
+		mc, ok := obj.(*clusterv1.ManagedCluster)
+		if !ok {
+			continue
+		}
+		inClusterSet, err := helpers.IsClusterInManagedClusterSet(mc, clusterSet)
+		if err != nil {
+			klog.Error(err, "Failed to resolve the clusterset members", "clusterset", clusterSet.Name)
+			return
+		}
+		if inClusterSet {
+			q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+				Name: mc.GetName(),
+			}})
+		}
+	}
+}
+
 const (
 	KlusterletConfigBootstrapKubeConfigSecretsIndexKey = "klusterletconfig-bootstrapkubeconfig-secrets"
 )
@@ -92,6 +220,8 @@ type enqueueManagedClusterByBootstrapKubeConfigSecrets struct {
 
 	// index managedcluster by the annotation
 	managedclusterIndexer cache.Indexer
+
+	clusterSetLister clusterlisterv1beta2.ManagedClusterSetLister
 }
 
 func (e *enqueueManagedClusterByBootstrapKubeConfigSecrets) Create(ctx context.Context,
@@ -122,17 +252,11 @@ func (e *enqueueManagedClusterByBootstrapKubeConfigSecrets) enqueue(secretName s
 	}
 	for _, kcObj := range klusterletconfigObjs {
 		kc := kcObj.(*klusterletconfigv1alpha1.KlusterletConfig)
-		managedclusterObjs, err := e.managedclusterIndexer.ByIndex(
-			ManagedClusterKlusterletConfigAnnotationIndexKey, kc.GetName())
-		if err != nil {
-			klog.Error(err, "Failed to get managedclusters by klusterletconfig annotation by indexer",
-				"klusterletconfig", kc.GetName())
-			return
-		}
-		for _, mcObj := range managedclusterObjs {
-			mc := mcObj.(*clusterv1.ManagedCluster)
+		clusterNames := listManagedClustersByKlusterletConfig(e.managedclusterIndexer, kc.GetName())
+		clusterNames.Insert(listManagedClustersBySelector(e.managedclusterIndexer, e.clusterSetLister, kc)...)
+		for _, name := range clusterNames.UnsortedList() {
 			q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
-				Name: mc.GetName(),
+				Name: name,
 			}})
 		}
 	}
@@ -172,6 +296,8 @@ type enqueueManagedClusterByCustomizedCAConfigmaps struct {
 
 	// index managedcluster by the annotation
 	managedclusterIndexer cache.Indexer
+
+	clusterSetLister clusterlisterv1beta2.ManagedClusterSetLister
 }
 
 func (e *enqueueManagedClusterByCustomizedCAConfigmaps) Create(ctx context.Context,
@@ -205,17 +331,11 @@ func (e *enqueueManagedClusterByCustomizedCAConfigmaps) enqueue(
 	}
 	for _, kcObj := range klusterletconfigObjs {
 		kc := kcObj.(*klusterletconfigv1alpha1.KlusterletConfig)
-		managedclusterObjs, err := e.managedclusterIndexer.ByIndex(
-			ManagedClusterKlusterletConfigAnnotationIndexKey, kc.GetName())
-		if err != nil {
-			klog.Error(err, "Failed to get managedclusters by klusterletconfig annotation by indexer",
-				"klusterletconfig", kc.GetName())
-			return
-		}
-		for _, mcObj := range managedclusterObjs {
-			mc := mcObj.(*clusterv1.ManagedCluster)
+		clusterNames := listManagedClustersByKlusterletConfig(e.managedclusterIndexer, kc.GetName())
+		clusterNames.Insert(listManagedClustersBySelector(e.managedclusterIndexer, e.clusterSetLister, kc)...)
+		for _, name := range clusterNames.UnsortedList() {
 			q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
-				Name: mc.GetName(),
+				Name: name,
 			}})
 		}
 	}
@@ -5,12 +5,16 @@ import (
 	"testing"
 
 	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
+	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
+	clusterlisterv1beta2 "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta2"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 	operatorv1 "open-cluster-management.io/api/operator/v1"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -171,6 +175,163 @@ func TestIndexManagedClusterByKlusterletconfigAnnotation(t *testing.T) {
 	}
 }
 
+func TestEnqueueManagedClusterByKlusterletConfigSelector(t *testing.T) {
+	selectedBefore := &clusterv1.ManagedCluster{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   "test1",
+			Labels: map[string]string{"env": "prod"},
+		},
+	}
+	selectedAfter := &clusterv1.ManagedCluster{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   "test2",
+			Labels: map[string]string{"env": "edge"},
+		},
+	}
+	notSelected := &clusterv1.ManagedCluster{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "test3",
+		},
+	}
+
+	oldKC := &klusterletconfigv1alpha1.KlusterletConfig{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "test-kc",
+			Annotations: map[string]string{
+				constants.KlusterletConfigClusterSelectorAnnotation: `{"matchLabels":{"env":"prod"}}`,
+			},
+		},
+	}
+	newKC := oldKC.DeepCopy()
+	newKC.Annotations[constants.KlusterletConfigClusterSelectorAnnotation] = `{"matchLabels":{"env":"edge"}}`
+
+	clusterSetLister := clusterlisterv1beta2.NewManagedClusterSetLister(
+		cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		ManagedClusterKlusterletConfigAnnotationIndexKey: IndexManagedClusterByKlusterletconfigAnnotation,
+	})
+	for _, mc := range []*clusterv1.ManagedCluster{selectedBefore, selectedAfter, notSelected} {
+		if err := indexer.Add(mc); err != nil {
+			t.Fatalf("Failed to add managed cluster to indexer: %v", err)
+		}
+	}
+
+	kcHandler := &enqueueManagedClusterInKlusterletConfigAnnotation{
+		managedclusterIndexer: indexer,
+		clusterSetLister:      clusterSetLister,
+	}
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+	kcHandler.Update(context.Background(), event.UpdateEvent{ObjectOld: oldKC, ObjectNew: newKC}, queue)
+
+	// both the previously and newly selected clusters should be enqueued
+	if queue.Len() != 2 {
+		t.Fatalf("Expected queue length to be 2, but got %d", queue.Len())
+	}
+	enqueued := map[string]bool{}
+	for queue.Len() > 0 {
+		item, _ := queue.Get()
+		enqueued[item.Name] = true
+		queue.Done(item)
+	}
+	if !enqueued["test1"] || !enqueued["test2"] {
+		t.Errorf("Expected test1 and test2 to be enqueued, but got %v", enqueued)
+	}
+}
+
+func TestEnqueueManagedClusterByClusterSet(t *testing.T) {
+	inLabelSet := &clusterv1.ManagedCluster{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   "test1",
+			Labels: map[string]string{"tier": "edge"},
+		},
+	}
+	inExclusiveSet := &clusterv1.ManagedCluster{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   "test2",
+			Labels: map[string]string{clusterv1beta2.ClusterSetLabel: "edge-set"},
+		},
+	}
+	notInSet := &clusterv1.ManagedCluster{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "test3",
+		},
+	}
+
+	kcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		helpers.KlusterletConfigSelectorIndexKey: helpers.IndexKlusterletConfigBySelector,
+	})
+	for _, kc := range []*klusterletconfigv1alpha1.KlusterletConfig{
+		{
+			ObjectMeta: v1.ObjectMeta{
+				Name: "edge-kc",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterSetAnnotation: "edge-set",
+				},
+			},
+		},
+		{
+			ObjectMeta: v1.ObjectMeta{
+				Name: "other-kc",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterSetAnnotation: "other-set",
+				},
+			},
+		},
+	} {
+		if err := kcIndexer.Add(kc); err != nil {
+			t.Fatalf("Failed to add klusterletconfig to indexer: %v", err)
+		}
+	}
+
+	// the clusterset is changed from ExclusiveClusterSetLabel to LabelSelector
+	clusterSetIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	exclusiveSet := &clusterv1beta2.ManagedClusterSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "edge-set",
+		},
+	}
+	if err := clusterSetIndexer.Add(exclusiveSet); err != nil {
+		t.Fatalf("Failed to add clusterset to indexer: %v", err)
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, mc := range []*clusterv1.ManagedCluster{inLabelSet, inExclusiveSet, notInSet} {
+		if err := indexer.Add(mc); err != nil {
+			t.Fatalf("Failed to add managed cluster to indexer: %v", err)
+		}
+	}
+
+	labelSet := exclusiveSet.DeepCopy()
+	labelSet.Spec.ClusterSelector = clusterv1beta2.ManagedClusterSelector{
+		SelectorType: clusterv1beta2.LabelSelector,
+		LabelSelector: &v1.LabelSelector{
+			MatchLabels: map[string]string{"tier": "edge"},
+		},
+	}
+	if err := clusterSetIndexer.Update(labelSet); err != nil {
+		t.Fatalf("Failed to update clusterset in indexer: %v", err)
+	}
+
+	clusterSetHandler := &enqueueManagedClusterByClusterSet{
+		klusterletconfigIndexer: kcIndexer,
+		managedclusterIndexer:   indexer,
+	}
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+	clusterSetHandler.Update(context.Background(), event.UpdateEvent{ObjectOld: exclusiveSet, ObjectNew: labelSet}, queue)
+
+	// both the previous and the new members of the clusterset should be enqueued
+	enqueued := map[string]bool{}
+	for queue.Len() > 0 {
+		item, _ := queue.Get()
+		enqueued[item.Name] = true
+		queue.Done(item)
+	}
+	if len(enqueued) != 2 || !enqueued["test1"] || !enqueued["test2"] {
+		t.Errorf("Expected test1 and test2 to be enqueued, but got %v", enqueued)
+	}
+}
+
 func TestEnqueueManagedClusterByBootstrapKubeconfigSecret(t *testing.T) {
 	mcs := []*clusterv1.ManagedCluster{
 		{
@@ -12,6 +12,7 @@ import (
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -56,9 +57,11 @@ func Add(ctx context.Context,
 					// handle the labels changes for image registry
 					// handle the annotations changes for node placement and klusterletconfig
 					// handle the claim changes for priority class
+					// handle the labels and cluster claims changes for klusterletconfig selectors
 					return !equality.Semantic.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
 						!equality.Semantic.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()) ||
-						helpers.IsKubeVersionChanged(e.ObjectOld, e.ObjectNew)
+						helpers.IsKubeVersionChanged(e.ObjectOld, e.ObjectNew) ||
+						helpers.IsClusterClaimsChanged(e.ObjectOld, e.ObjectNew)
 				},
 			}),
 		).
@@ -111,6 +114,7 @@ func Add(ctx context.Context,
 			&klusterletconfigv1alpha1.KlusterletConfig{},
 			&enqueueManagedClusterInKlusterletConfigAnnotation{
 				managedclusterIndexer: informerHolder.ManagedClusterInformer.GetIndexer(),
+				clusterSetLister:      informerHolder.ManagedClusterSetLister,
 			},
 			builder.WithPredicates(predicate.Funcs{
 				GenericFunc: func(e event.GenericEvent) bool { return true },
@@ -129,11 +133,29 @@ func Add(ctx context.Context,
 					UpdateFunc:  func(e event.UpdateEvent) bool { return true },
 				})),
 		).
+		WatchesRawSource(
+			source.NewManagedClusterSetSource(informerHolder.ManagedClusterSetInformer,
+				&enqueueManagedClusterByClusterSet{
+					klusterletconfigIndexer: informerHolder.KlusterletConfigInformer.GetIndexer(),
+					managedclusterIndexer:   informerHolder.ManagedClusterInformer.GetIndexer(),
+				},
+				predicate.Predicate(predicate.Funcs{
+					GenericFunc: func(e event.GenericEvent) bool { return false },
+					CreateFunc:  func(e event.CreateEvent) bool { return true },
+					DeleteFunc:  func(e event.DeleteEvent) bool { return true },
+					UpdateFunc: func(e event.UpdateEvent) bool {
+						oldSet, okOld := e.ObjectOld.(*clusterv1beta2.ManagedClusterSet)
+						newSet, okNew := e.ObjectNew.(*clusterv1beta2.ManagedClusterSet)
+						return okOld && okNew && !equality.Semantic.DeepEqual(oldSet.Spec, newSet.Spec)
+					},
+				})),
+		).
 		WatchesMetadata(
 			&corev1.Secret{},
 			&enqueueManagedClusterByBootstrapKubeConfigSecrets{
 				managedclusterIndexer:   informerHolder.ManagedClusterInformer.GetIndexer(),
 				klusterletconfigIndexer: informerHolder.KlusterletConfigInformer.GetIndexer(),
+				clusterSetLister:        informerHolder.ManagedClusterSetLister,
 			},
 			builder.WithPredicates(predicate.Funcs{
 				GenericFunc: func(e event.GenericEvent) bool {
@@ -155,6 +177,7 @@ func Add(ctx context.Context,
 			&enqueueManagedClusterByCustomizedCAConfigmaps{
 				managedclusterIndexer:   informerHolder.ManagedClusterInformer.GetIndexer(),
 				klusterletconfigIndexer: informerHolder.KlusterletConfigInformer.GetIndexer(),
+				clusterSetLister:        informerHolder.ManagedClusterSetLister,
 			},
 			builder.WithPredicates(predicate.Funcs{
 				GenericFunc: func(e event.GenericEvent) bool {
@@ -180,11 +203,14 @@ func Add(ctx context.Context,
 			}),
 		).
 		Complete(&ReconcileImportConfig{
-			clientHolder:           clientHolder,
-			klusterletconfigLister: informerHolder.KlusterletConfigLister,
-			scheme:                 mgr.GetScheme(),
-			recorder:               helpers.NewEventRecorder(clientHolder.KubeClient, ControllerName),
-			importControllerConfig: helpers.NewImportControllerConfig(componentNamespace, informerHolder.ControllerConfigLister, log),
+			clientHolder:                    clientHolder,
+			klusterletconfigLister:          informerHolder.KlusterletConfigLister,
+			klusterletconfigIndexer:         informerHolder.KlusterletConfigInformer.GetIndexer(),
+			clusterSetLister:                informerHolder.ManagedClusterSetLister,
+			effectiveKlusterletConfigLister: informerHolder.EffectiveKlusterletConfigLister,
+			scheme:                          mgr.GetScheme(),
+			recorder:                        helpers.NewEventRecorder(clientHolder.KubeClient, ControllerName),
+			importControllerConfig:          helpers.NewImportControllerConfig(componentNamespace, informerHolder.ControllerConfigLister, log),
 		})
 	return err
 }
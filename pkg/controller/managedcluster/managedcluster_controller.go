@@ -10,10 +10,14 @@ import (
 	"github.com/openshift/library-go/pkg/operator/events"
 	"github.com/openshift/library-go/pkg/operator/resource/resourcemerge"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/features"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
 	kevents "k8s.io/client-go/tools/events"
 	"k8s.io/utils/ptr"
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
@@ -38,6 +42,13 @@ type ReconcileManagedCluster struct {
 	client     client.Client
 	recorder   events.Recorder
 	mcRecorder kevents.EventRecorder
+	// componentNamespace is the namespace of the per-cluster bootstrap service accounts of the
+	// agent-registration clusters
+	componentNamespace string
+	// the listers of the per-cluster bootstrap service accounts and cluster role bindings of the
+	// agent-registration clusters
+	bootstrapSALister  corev1listers.ServiceAccountLister
+	bootstrapCRBLister rbacv1listers.ClusterRoleBindingLister
 }
 
 // NewReconcileManagedCluster creates a new ReconcileManagedCluster
@@ -45,11 +56,17 @@ func NewReconcileManagedCluster(
 	client client.Client,
 	recorder events.Recorder,
 	mcRecorder kevents.EventRecorder,
+	componentNamespace string,
+	bootstrapSALister corev1listers.ServiceAccountLister,
+	bootstrapCRBLister rbacv1listers.ClusterRoleBindingLister,
 ) *ReconcileManagedCluster {
 	return &ReconcileManagedCluster{
-		client:     client,
-		recorder:   recorder,
-		mcRecorder: mcRecorder,
+		client:             client,
+		recorder:           recorder,
+		mcRecorder:         mcRecorder,
+		componentNamespace: componentNamespace,
+		bootstrapSALister:  bootstrapSALister,
+		bootstrapCRBLister: bootstrapCRBLister,
 	}
 }
 
@@ -60,6 +77,8 @@ var _ reconcile.Reconciler = &ReconcileManagedCluster{}
 //   - When a new managed cluster is created, we will add the required meta data to the managed cluster
 //   - When a managed cluster is deleting, we will wait the other components to delete their finalizers, after
 //     there is only the import finalizer on managed cluster, we will delete the managed cluster namespace.
+//   - When a cluster created via the agent-registration joins or is deleted, we will delete its per-cluster
+//     bootstrap identity.
 //
 // Note: The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
@@ -69,8 +88,8 @@ func (r *ReconcileManagedCluster) Reconcile(ctx context.Context, request reconci
 	managedCluster := &clusterv1.ManagedCluster{}
 	err := r.client.Get(ctx, types.NamespacedName{Name: request.Name}, managedCluster)
 	if errors.IsNotFound(err) {
-		// the managed cluster could have been deleted, do nothing
-		return reconcile.Result{}, nil
+		// the managed cluster could have been deleted, only clean up its bootstrap identity
+		return reconcile.Result{}, r.cleanupAgentRegistrationBootstrapIdentity(ctx, request.Name)
 	}
 	if err != nil {
 		return reconcile.Result{}, err
@@ -78,6 +97,15 @@ func (r *ReconcileManagedCluster) Reconcile(ctx context.Context, request reconci
 
 	reqLogger.V(5).Info("Reconciling the managed cluster meta object")
 
+	if managedCluster.Annotations[constants.CreatedViaAnnotation] == constants.CreatedViaAgentRegistration &&
+		(!managedCluster.DeletionTimestamp.IsZero() ||
+			meta.IsStatusConditionTrue(managedCluster.Status.Conditions, clusterv1.ManagedClusterConditionJoined)) {
+		// the bootstrap identity is not needed once the cluster joins
+		if err := r.cleanupAgentRegistrationBootstrapIdentity(ctx, managedCluster.Name); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
 	if !managedCluster.DeletionTimestamp.IsZero() {
 		return reconcile.Result{}, nil
 	}
@@ -116,6 +144,32 @@ func (r *ReconcileManagedCluster) Reconcile(ctx context.Context, request reconci
 	return reconcile.Result{}, nil
 }
 
+// cleanupAgentRegistrationBootstrapIdentity deletes the per-cluster bootstrap service account and its cluster role
+// binding of the agent-registration cluster if the AgentRegistrationClusterIdentity feature is enabled. They are
+// only deleted when they are in the cache.
+func (r *ReconcileManagedCluster) cleanupAgentRegistrationBootstrapIdentity(ctx context.Context, clusterName string) error {
+	if !features.DefaultMutableFeatureGate.Enabled(features.AgentRegistrationClusterIdentity) {
+		return nil
+	}
+
+	_, saErr := r.bootstrapSALister.ServiceAccounts(r.componentNamespace).Get(
+		helpers.GetAgentRegistrationBootstrapSAName(clusterName))
+	_, crbErr := r.bootstrapCRBLister.Get(helpers.GetAgentRegistrationBootstrapClusterRoleBindingName(clusterName))
+	if errors.IsNotFound(saErr) && errors.IsNotFound(crbErr) {
+		return nil
+	}
+
+	deleted, err := helpers.DeleteAgentRegistrationBootstrapIdentity(ctx, r.client, clusterName, r.componentNamespace)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		r.recorder.Eventf("AgentRegistrationBootstrapIdentityDeleted",
+			"The bootstrap identity of the managed cluster %s is deleted", clusterName)
+	}
+	return nil
+}
+
 func (r *ReconcileManagedCluster) ensureManagedClusterMetaObj(ctx context.Context, managedCluster *clusterv1.ManagedCluster) error {
 	patch := client.MergeFrom(managedCluster.DeepCopy())
 	modified := ptr.To(false)
@@ -204,10 +258,11 @@ func ensureCreateViaAnnotation(modified *bool, cluster *clusterv1.ManagedCluster
 
 	// Define a set of valid created-via values
 	validCreatedViaValues := map[string]bool{
-		constants.CreatedViaAI:         true,
-		constants.CreatedViaHive:       true,
-		constants.CreatedViaDiscovery:  true,
-		constants.CreatedViaHypershift: true,
+		constants.CreatedViaAI:                true,
+		constants.CreatedViaHive:              true,
+		constants.CreatedViaDiscovery:         true,
+		constants.CreatedViaHypershift:        true,
+		constants.CreatedViaAgentRegistration: true,
 	}
 
 	// If the annotation value is not in the valid set, set it to the default value (other)
@@ -11,11 +11,16 @@ import (
 	hivev1 "github.com/openshift/hive/apis/hive/v1"
 	"github.com/openshift/library-go/pkg/operator/events/eventstesting"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -23,6 +28,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"github.com/stolostron/managedcluster-import-controller/pkg/features"
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
 )
 
@@ -273,6 +279,9 @@ func TestReconcile(t *testing.T) {
 				runtimeClient,
 				eventstesting.NewTestingEventRecorder(t),
 				helpers.NewManagedClusterEventRecorder(ctx, kubeClient),
+				"open-cluster-management",
+				corev1listers.NewServiceAccountLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+				rbacv1listers.NewClusterRoleBindingLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
 			)
 
 			_, err := r.Reconcile(ctx, c.request)
@@ -284,3 +293,127 @@ func TestReconcile(t *testing.T) {
 		})
 	}
 }
+
+func TestCleanupAgentRegistrationBootstrapIdentity(t *testing.T) {
+	if err := features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{
+		string(features.AgentRegistrationClusterIdentity): true,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{
+			string(features.AgentRegistrationClusterIdentity): false,
+		})
+	}()
+
+	agentRegistrationAnnotations := map[string]string{
+		constants.CreatedViaAnnotation: constants.CreatedViaAgentRegistration,
+	}
+	joinedConditions := []metav1.Condition{
+		{Type: clusterv1.ManagedClusterConditionJoined, Status: metav1.ConditionTrue},
+	}
+
+	cases := []struct {
+		name            string
+		cluster         *clusterv1.ManagedCluster
+		uncached        bool
+		expectedDeleted bool
+	}{
+		{
+			name: "cluster is not joined",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1", Annotations: agentRegistrationAnnotations},
+			},
+		},
+		{
+			name: "cluster is joined",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1", Annotations: agentRegistrationAnnotations},
+				Status:     clusterv1.ManagedClusterStatus{Conditions: joinedConditions},
+			},
+			expectedDeleted: true,
+		},
+		{
+			name: "cluster is joined but not created via agent-registration",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+				Status:     clusterv1.ManagedClusterStatus{Conditions: joinedConditions},
+			},
+		},
+		{
+			name: "bootstrap identity is not cached",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1", Annotations: agentRegistrationAnnotations},
+				Status:     clusterv1.ManagedClusterStatus{Conditions: joinedConditions},
+			},
+			uncached: true,
+		},
+		{
+			name:            "cluster is deleted",
+			expectedDeleted: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.TODO()
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      helpers.GetAgentRegistrationBootstrapSAName("cluster1"),
+					Namespace: "open-cluster-management",
+				},
+			}
+			crb := &rbacv1.ClusterRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: helpers.GetAgentRegistrationBootstrapClusterRoleBindingName("cluster1"),
+				},
+			}
+			objs := []client.Object{sa, crb}
+			if c.cluster != nil {
+				objs = append(objs, c.cluster)
+			}
+			runtimeClient := fake.NewClientBuilder().WithScheme(testscheme).WithObjects(objs...).
+				WithStatusSubresource(objs...).Build()
+
+			saIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			crbIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if !c.uncached {
+				if err := saIndexer.Add(sa); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := crbIndexer.Add(crb); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			r := NewReconcileManagedCluster(
+				runtimeClient,
+				eventstesting.NewTestingEventRecorder(t),
+				helpers.NewManagedClusterEventRecorder(ctx, kubefake.NewSimpleClientset()),
+				"open-cluster-management",
+				corev1listers.NewServiceAccountLister(saIndexer),
+				rbacv1listers.NewClusterRoleBindingLister(crbIndexer),
+			)
+
+			if _, err := r.Reconcile(ctx, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: "cluster1"},
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err := runtimeClient.Get(ctx, types.NamespacedName{
+				Name:      helpers.GetAgentRegistrationBootstrapSAName("cluster1"),
+				Namespace: "open-cluster-management",
+			}, &corev1.ServiceAccount{})
+			if errors.IsNotFound(err) != c.expectedDeleted {
+				t.Errorf("expected the service account deleted %v, but got %v", c.expectedDeleted, err)
+			}
+			err = runtimeClient.Get(ctx, types.NamespacedName{
+				Name: helpers.GetAgentRegistrationBootstrapClusterRoleBindingName("cluster1"),
+			}, &rbacv1.ClusterRoleBinding{})
+			if errors.IsNotFound(err) != c.expectedDeleted {
+				t.Errorf("expected the cluster role binding deleted %v, but got %v", c.expectedDeleted, err)
+			}
+		})
+	}
+}
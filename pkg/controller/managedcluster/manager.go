@@ -8,10 +8,12 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	kevents "k8s.io/client-go/tools/events"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -19,6 +21,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	"github.com/stolostron/managedcluster-import-controller/pkg/helpers"
+	"github.com/stolostron/managedcluster-import-controller/pkg/source"
 )
 
 const ControllerName = "managedcluster-controller"
@@ -28,7 +31,9 @@ const ControllerName = "managedcluster-controller"
 func Add(ctx context.Context,
 	mgr manager.Manager,
 	clientHolder *helpers.ClientHolder,
-	mcRecorder kevents.EventRecorder) error {
+	informerHolder *source.InformerHolder,
+	mcRecorder kevents.EventRecorder,
+	componentNamespace string) error {
 
 	err := ctrl.NewControllerManagedBy(mgr).Named(ControllerName).
 		WithOptions(controller.Options{
@@ -46,10 +51,11 @@ func Add(ctx context.Context,
 					if !e.ObjectNew.GetDeletionTimestamp().IsZero() {
 						return true
 					}
-					// only handle the finalizers/labels/annotations changes
+					// only handle the finalizers/labels/annotations/joined condition changes
 					return !equality.Semantic.DeepEqual(e.ObjectOld.GetFinalizers(), e.ObjectNew.GetFinalizers()) ||
 						!equality.Semantic.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
-						!equality.Semantic.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations())
+						!equality.Semantic.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()) ||
+						joined(e.ObjectOld) != joined(e.ObjectNew)
 				},
 			}),
 		).
@@ -70,7 +76,18 @@ func Add(ctx context.Context,
 			clientHolder.RuntimeClient,
 			helpers.NewEventRecorder(clientHolder.KubeClient, ControllerName),
 			mcRecorder,
+			componentNamespace,
+			informerHolder.AgentRegistrationServiceAccountLister,
+			informerHolder.AgentRegistrationClusterRoleBindingLister,
 		))
 
 	return err
 }
+
+func joined(obj client.Object) bool {
+	cluster, ok := obj.(*clusterv1.ManagedCluster)
+	if !ok {
+		return false
+	}
+	return meta.IsStatusConditionTrue(cluster.Status.Conditions, clusterv1.ManagedClusterConditionJoined)
+}
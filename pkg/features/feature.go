@@ -21,6 +21,14 @@ const (
 
 	// AgentRegistration enables a server to provide an endpoint for clients to get manifests
 	AgentRegistration featuregate.Feature = "AgentRegistration"
+
+	// AgentRegistrationClusterIdentity makes the agent-registration server request the bootstrap token of a
+	// per-cluster service account instead of the shared agent-registration-bootstrap service account, so the
+	// csr of a cluster is only approved if it is requested by the identity of the cluster, and a single cluster
+	// can be revoked by deleting its service account. The service account is deleted once the cluster joins or
+	// is deleted, and the csr requested by the shared service account is denied, so the shared service account
+	// must be removed from the autoApproveUsers of the ClusterManager when this feature is enabled.
+	AgentRegistrationClusterIdentity featuregate.Feature = "AgentRegistrationClusterIdentity"
 )
 
 var (
@@ -40,4 +48,6 @@ func init() {
 var defaultRegistrationFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
 	KlusterletHostedMode: {Default: true, PreRelease: featuregate.Alpha},
 	AgentRegistration:    {Default: true, PreRelease: featuregate.Alpha},
+
+	AgentRegistrationClusterIdentity: {Default: false, PreRelease: featuregate.Alpha},
 }
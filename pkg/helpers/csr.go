@@ -1,10 +1,16 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
 	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var BootstrapSASuffix = "bootstrap-sa"
@@ -12,6 +18,22 @@ var BootstrapSASuffix = "bootstrap-sa"
 const (
 	GRPCSAName   = "grpc-server-sa"
 	HubNamespace = "open-cluster-management-hub"
+
+	// AgentRegistrationBootstrapSAName is the shared bootstrap service account of the agent-registration clusters.
+	AgentRegistrationBootstrapSAName = "agent-registration-bootstrap"
+	// AgentRegistrationBootstrapSAPrefix is the name prefix of the per-cluster bootstrap service account of the
+	// agent-registration clusters.
+	AgentRegistrationBootstrapSAPrefix = AgentRegistrationBootstrapSAName + "-"
+	// AgentRegistrationBootstrapClusterRoleBindingPrefix is the name prefix of the cluster role binding of the
+	// per-cluster bootstrap service account.
+	AgentRegistrationBootstrapClusterRoleBindingPrefix = "system:open-cluster-management:managedcluster:bootstrap:agent-registration:"
+
+	// ClusterUserPrefix is the prefix of the user and the group of the hub client certificates of the registration
+	// agents, the group is system:open-cluster-management:<cluster name> and the user is
+	// system:open-cluster-management:<cluster name>:<agent name>.
+	ClusterUserPrefix = "system:open-cluster-management:"
+	// ManagedClustersGroup is the group of the hub client certificates of all of the managed clusters.
+	ManagedClustersGroup = "system:open-cluster-management:managed-clusters"
 )
 
 func GetClusterName(csr *certificatesv1.CertificateSigningRequest) (clusterName string) {
@@ -30,3 +52,47 @@ func GetBootstrapSAName(clusterName string) string {
 	}
 	return bootstrapSAName
 }
+
+// GetAgentRegistrationBootstrapSAName returns the per-cluster bootstrap service account name of an
+// agent-registration cluster, the cluster name is a DNS-1123 label so the name is not truncated.
+func GetAgentRegistrationBootstrapSAName(clusterName string) string {
+	return AgentRegistrationBootstrapSAPrefix + clusterName
+}
+
+// GetAgentRegistrationBootstrapClusterRoleBindingName returns the name of the cluster role binding of the
+// per-cluster bootstrap service account of an agent-registration cluster.
+func GetAgentRegistrationBootstrapClusterRoleBindingName(clusterName string) string {
+	return AgentRegistrationBootstrapClusterRoleBindingPrefix + clusterName
+}
+
+// DeleteAgentRegistrationBootstrapIdentity deletes the per-cluster bootstrap service account of an
+// agent-registration cluster in the namespace and its cluster role binding. It returns true if any of them
+// is deleted.
+func DeleteAgentRegistrationBootstrapIdentity(ctx context.Context, runtimeClient client.Client,
+	clusterName, namespace string) (bool, error) {
+	deleted := false
+	objs := []client.Object{
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: GetAgentRegistrationBootstrapClusterRoleBindingName(clusterName)},
+		},
+		&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: GetAgentRegistrationBootstrapSAName(clusterName), Namespace: namespace},
+		},
+	}
+	for _, obj := range objs {
+		err := runtimeClient.Delete(ctx, obj)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted = true
+	}
+	return deleted, nil
+}
+
+// GetClusterGroup returns the group of the hub client certificates of the registration agents of the cluster.
+func GetClusterGroup(clusterName string) string {
+	return ClusterUserPrefix + clusterName
+}
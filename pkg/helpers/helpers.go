@@ -31,6 +31,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
@@ -478,15 +479,24 @@ func MustCreateObjectFromTemplate(file string, template []byte, config interface
 // MustCreateAssetFromTemplate render a template with its configuration
 // If it's failed, this function will panic
 func MustCreateAssetFromTemplate(name string, tb []byte, config interface{}) []byte {
-	tmpl, err := template.New(name).Funcs(funcMap()).Parse(string(tb))
+	b, err := CreateAssetFromTemplate(name, tb, config)
 	if err != nil {
 		panic(err)
 	}
+	return b
+}
+
+// CreateAssetFromTemplate render a template with its configuration
+func CreateAssetFromTemplate(name string, tb []byte, config interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(funcMap()).Parse(string(tb))
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, config); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 func funcMap() template.FuncMap {
@@ -499,9 +509,15 @@ func funcMap() template.FuncMap {
 		}
 		return strings.TrimSuffix(string(data), "\n")
 	}
+	f["shellQuote"] = ShellQuote
 	return f
 }
 
+// ShellQuote quotes the value as a single word of the POSIX shell, the value is not expanded by the shell.
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
 // ManifestsEqual if two manifests are equal, return true
 func ManifestsEqual(newManifests, oldManifests []workv1.Manifest) bool {
 	if len(newManifests) != len(oldManifests) {
@@ -516,8 +532,9 @@ func ManifestsEqual(newManifests, oldManifests []workv1.Manifest) bool {
 	return true
 }
 
-// ApplyResources apply resources, includes: serviceaccount, secret, deployment, clusterrole, clusterrolebinding,
-// crdv1, manifestwork and klusterlet
+// ApplyResources apply resources, includes: serviceaccount, secret, configmap, deployment, clusterrole,
+// clusterrolebinding, crdv1, manifestwork and klusterlet. The resources of the other kinds, like the additional
+// manifests in the import.yaml, are applied as unstructured objects with the runtime client.
 func ApplyResources(clientHolder *ClientHolder, recorder events.Recorder,
 	scheme *runtime.Scheme, owner metav1.Object, objs ...runtime.Object) (bool, error) {
 	changed := false
@@ -547,6 +564,11 @@ func ApplyResources(clientHolder *ClientHolder, recorder events.Recorder,
 				clientHolder.KubeClient.CoreV1(), recorder, required)
 			errs = append(errs, err)
 			changed = changed || modified
+		case *corev1.ConfigMap:
+			_, modified, err := resourceapply.ApplyConfigMap(context.TODO(),
+				clientHolder.KubeClient.CoreV1(), recorder, required)
+			errs = append(errs, err)
+			changed = changed || modified
 		case *corev1.Namespace:
 			_, modified, err := resourceapply.ApplyNamespace(context.TODO(),
 				clientHolder.KubeClient.CoreV1(), recorder, required)
@@ -592,13 +614,89 @@ func ApplyResources(clientHolder *ClientHolder, recorder events.Recorder,
 			errs = append(errs, err)
 			changed = changed || modified
 		default:
-			errs = append(errs, fmt.Errorf("unknown type %T", required))
+			modified, err := applyUnstructured(clientHolder.RuntimeClient, recorder, required)
+			errs = append(errs, err)
+			changed = changed || modified
 		}
 	}
 
 	return changed, utilerrors.NewAggregate(errs)
 }
 
+// applyUnstructured applies the object that is not handled by the typed appliers, the fields other than the
+// metadata and the status of the existing object are replaced by the required ones.
+func applyUnstructured(runtimeClient client.Client, recorder events.Recorder, obj runtime.Object) (bool, error) {
+	if runtimeClient == nil {
+		return false, fmt.Errorf("unknown type %T", obj)
+	}
+
+	required, err := toUnstructured(obj)
+	if err != nil {
+		return false, err
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(required.GroupVersionKind())
+	err = runtimeClient.Get(context.TODO(), client.ObjectKeyFromObject(required), existing)
+	if errors.IsNotFound(err) {
+		if err := runtimeClient.Create(context.TODO(), required.DeepCopy()); err != nil {
+			return false, err
+		}
+
+		reportEvent(recorder, required, required.GetKind(), "created")
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	modified := false
+	existing = existing.DeepCopy()
+	if err := resourcemerge.EnsureObjectMetaForUnstructured(&modified, existing, required); err != nil {
+		return false, err
+	}
+	for key, value := range required.Object {
+		switch key {
+		case "apiVersion", "kind", "metadata", "status":
+			continue
+		}
+		if !equality.Semantic.DeepEqual(existing.Object[key], value) {
+			existing.Object[key] = value
+			modified = true
+		}
+	}
+	if !modified {
+		return false, nil
+	}
+
+	if err := runtimeClient.Update(context.TODO(), existing); err != nil {
+		return false, err
+	}
+	reportEvent(recorder, required, required.GetKind(), "updated")
+	return true, nil
+}
+
+// toUnstructured converts the object to an unstructured object with its group version kind.
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u, nil
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	if len(u.GetKind()) == 0 {
+		gvks, _, err := genericScheme.ObjectKinds(obj)
+		if err != nil {
+			return nil, err
+		}
+		u.SetGroupVersionKind(gvks[0])
+	}
+	return u, nil
+}
+
 func applyDeployment(clientHolder *ClientHolder, recorder events.Recorder, required *appsv1.Deployment) (bool, error) {
 	existing, err := clientHolder.KubeClient.AppsV1().Deployments(required.Namespace).Get(
 		context.TODO(), required.Name, metav1.GetOptions{})
@@ -753,12 +851,20 @@ func applyNetworkPolicy(client kubernetes.Interface, recorder events.Recorder,
 	return true, nil
 }
 
-// MustCreateObject translate object from raw bytes to runtime object
+// ValidateApplicableManifest returns an error if the manifest cannot be decoded by MustCreateObject, the manifests
+// in the import.yaml must be decoded before they are applied by ApplyResources.
+func ValidateApplicableManifest(raw []byte) error {
+	_, err := decodeObject(raw)
+	return err
+}
+
+// MustCreateObject translate object from raw bytes to runtime object, the object of a kind that is not registered
+// in the generic scheme is translated to an unstructured object.
 func MustCreateObject(raw []byte) runtime.Object {
 	if len(raw) == 0 {
 		return nil
 	}
-	obj, _, err := genericCodec.Decode(raw, nil, nil)
+	obj, err := decodeObject(raw)
 	if err != nil {
 		panic(err)
 	}
@@ -766,6 +872,23 @@ func MustCreateObject(raw []byte) runtime.Object {
 	return obj
 }
 
+func decodeObject(raw []byte) (runtime.Object, error) {
+	obj, _, err := genericCodec.Decode(raw, nil, nil)
+	if !runtime.IsNotRegisteredError(err) {
+		return obj, err
+	}
+
+	jsonData, err := yaml.YAMLToJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(jsonData); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func reportEvent(recorder events.Recorder, metaObj metav1.Object, objKind, action string) {
 	name := metaObj.GetName()
 	if len(metaObj.GetNamespace()) != 0 {
@@ -1048,6 +1171,18 @@ func IsKubeVersionChanged(objectOld, objectNew runtime.Object) bool {
 	return clusterOld.Status.Version.Kubernetes != clusterNew.Status.Version.Kubernetes
 }
 
+func IsClusterClaimsChanged(objectOld, objectNew runtime.Object) bool {
+	clusterOld, ok := objectOld.(*clusterv1.ManagedCluster)
+	if !ok {
+		return false
+	}
+	clusterNew, ok := objectNew.(*clusterv1.ManagedCluster)
+	if !ok {
+		return false
+	}
+	return !equality.Semantic.DeepEqual(clusterOld.Status.ClusterClaims, clusterNew.Status.ClusterClaims)
+}
+
 func SupportPriorityClass(cluster *clusterv1.ManagedCluster) (bool, error) {
 	if cluster == nil || len(cluster.Status.Version.Kubernetes) == 0 {
 		return false, nil
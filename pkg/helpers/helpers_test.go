@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"reflect"
 	"testing"
 	"time"
@@ -30,7 +31,9 @@ import (
 	apiextensionsfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
@@ -49,6 +52,7 @@ import (
 	operatorv1 "open-cluster-management.io/api/operator/v1"
 	workv1 "open-cluster-management.io/api/work/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -1063,6 +1067,107 @@ func TestApplyResources(t *testing.T) {
 	}
 }
 
+func TestApplyUnstructuredResources(t *testing.T) {
+	limitRange := MustCreateObject([]byte(`apiVersion: v1
+kind: LimitRange
+metadata:
+  name: limits
+  namespace: open-cluster-management-agent
+spec:
+  limits:
+  - type: Container
+    default:
+      memory: 512Mi`))
+	roleBinding := MustCreateObject([]byte(`apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: scc-binding
+  namespace: open-cluster-management-agent
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:openshift:scc:restricted-v2
+subjects:
+- kind: ServiceAccount
+  name: klusterlet
+  namespace: open-cluster-management-agent`))
+
+	existingLimitRange := limitRange.DeepCopyObject().(*corev1.LimitRange)
+	existingLimitRange.Spec.Limits[0].Default[corev1.ResourceMemory] = resource.MustParse("256Mi")
+
+	cases := []struct {
+		name         string
+		existingObjs []client.Object
+		modified     bool
+	}{
+		{
+			name:     "create resources",
+			modified: true,
+		},
+		{
+			name:         "update resources",
+			existingObjs: []client.Object{existingLimitRange, roleBinding.(client.Object)},
+			modified:     true,
+		},
+		{
+			name:         "no changes",
+			existingObjs: []client.Object{limitRange.(client.Object), roleBinding.(client.Object)},
+			modified:     false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			existingObjs := []client.Object{}
+			for _, obj := range c.existingObjs {
+				existingObjs = append(existingObjs, obj.DeepCopyObject().(client.Object))
+			}
+			runtimeClient := fake.NewClientBuilder().WithScheme(testscheme).WithObjects(existingObjs...).Build()
+			clientHolder := &ClientHolder{
+				KubeClient:    kubefake.NewSimpleClientset(),
+				RuntimeClient: runtimeClient,
+			}
+
+			modified, err := ApplyResources(clientHolder, eventstesting.NewTestingEventRecorder(t),
+				testscheme, nil, limitRange.DeepCopyObject(), roleBinding.DeepCopyObject())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if modified != c.modified {
+				t.Errorf("expected modified %v, but got %v", c.modified, modified)
+			}
+
+			actual := &corev1.LimitRange{}
+			if err := runtimeClient.Get(context.TODO(), types.NamespacedName{
+				Namespace: "open-cluster-management-agent", Name: "limits"}, actual); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if memory := actual.Spec.Limits[0].Default[corev1.ResourceMemory]; memory.String() != "512Mi" {
+				t.Errorf("expected memory 512Mi, but got %s", memory.String())
+			}
+			if err := runtimeClient.Get(context.TODO(), types.NamespacedName{
+				Namespace: "open-cluster-management-agent", Name: "scc-binding"}, &rbacv1.RoleBinding{}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMustCreateObjectUnregisteredKind(t *testing.T) {
+	obj := MustCreateObject([]byte(`apiVersion: security.openshift.io/v1
+kind: SecurityContextConstraints
+metadata:
+  name: klusterlet-scc
+allowPrivilegedContainer: false`))
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		t.Fatalf("expected an unstructured object, but got %T", obj)
+	}
+	if u.GetKind() != "SecurityContextConstraints" || u.GetName() != "klusterlet-scc" {
+		t.Errorf("unexpected object %v", u)
+	}
+}
+
 var tb = `
 apiVersion: v1
 kind: ServiceAccount
@@ -2543,3 +2648,20 @@ users:
 		})
 	}
 }
+
+func TestShellQuote(t *testing.T) {
+	values := []string{"", "cluster1", "it's", `"; touch /tmp/installed; echo "`, "$(id) `id` ${HOME}", "a\nb"}
+	for _, value := range values {
+		quoted := ShellQuote(value)
+		if _, err := exec.LookPath("sh"); err != nil {
+			continue
+		}
+		output, err := exec.Command("sh", "-c", "printf %s "+quoted).Output()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(output) != value {
+			t.Errorf("expected %q, but got %q from %s", value, string(output), quoted)
+		}
+	}
+}
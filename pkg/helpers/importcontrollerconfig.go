@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	"strings"
+
 	"github.com/go-logr/logr"
 	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
@@ -62,3 +64,75 @@ func (c *ImportControllerConfig) GenerateImportConfig() (bool, error) {
 	}
 	return false, nil
 }
+
+// GenerateEffectiveKlusterletConfig to check whether to generate the effective klusterletconfig configmap.
+func (c *ImportControllerConfig) GenerateEffectiveKlusterletConfig() (bool, error) {
+	cm, err := c.configMapLister.ConfigMaps(c.componentNamespace).Get(constants.ControllerConfigConfigMapName)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return cm.Data[constants.EffectiveKlusterletConfig] == "true", nil
+}
+
+// GetCSRApprovalPolicy returns the CSR approval policy, it returns an empty string if the policy is not specified.
+func (c *ImportControllerConfig) GetCSRApprovalPolicy() (string, error) {
+	cm, err := c.configMapLister.ConfigMaps(c.componentNamespace).Get(constants.ControllerConfigConfigMapName)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return cm.Data[constants.CSRApprovalPolicyKey], nil
+}
+
+// GetCSRRateLimit returns the CSR rate limit, it returns an empty string if the rate limit is not specified.
+func (c *ImportControllerConfig) GetCSRRateLimit() (string, error) {
+	cm, err := c.configMapLister.ConfigMaps(c.componentNamespace).Get(constants.ControllerConfigConfigMapName)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return cm.Data[constants.CSRRateLimitKey], nil
+}
+
+// GetFlightCtlDeviceLabels returns the keys of the FlightCtl device labels that are synced to the managed clusters.
+func (c *ImportControllerConfig) GetFlightCtlDeviceLabels() ([]string, error) {
+	cm, err := c.configMapLister.ConfigMaps(c.componentNamespace).Get(constants.ControllerConfigConfigMapName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, key := range strings.Split(cm.Data[constants.FlightCtlDeviceLabelsKey], ",") {
+		if key = strings.TrimSpace(key); len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// GetFlightCtlDeviceRemovalAction returns the action on the managed clusters whose FlightCtl devices are deleted or
+// decommissioned, it returns an empty string if the action is not specified.
+func (c *ImportControllerConfig) GetFlightCtlDeviceRemovalAction() (string, error) {
+	cm, err := c.configMapLister.ConfigMaps(c.componentNamespace).Get(constants.ControllerConfigConfigMapName)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return cm.Data[constants.FlightCtlDeviceRemovalActionKey], nil
+}
@@ -1,33 +1,468 @@
 package helpers
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	listerklusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/client/klusterletconfig/listers/klusterletconfig/v1alpha1"
+	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
 	klusterletconfighelper "github.com/stolostron/cluster-lifecycle-api/helpers/klusterletconfig"
 	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	clusterlisterv1beta2 "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta2"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 )
 
 func GetMergedKlusterletConfigWithGlobal(
 	klusterletconfigName string,
 	kcLister listerklusterletconfigv1alpha1.KlusterletConfigLister,
 ) (*klusterletconfigv1alpha1.KlusterletConfig, error) {
+	merged, _, err := GetMergedKlusterletConfigWithSources(klusterletconfigName, kcLister)
+	return merged, err
+}
+
+// GetMergedKlusterletConfigWithSources merges the KlusterletConfig with the global KlusterletConfig like
+// GetMergedKlusterletConfigWithGlobal, and also returns the sources of each field in the merged spec and of
+// each merged annotation. The key of the sources is the json path of the spec field or the annotation key
+// prefixed with "metadata.annotations.", the value is the names of the KlusterletConfigs that the field or
+// the annotation comes from. The fields that are not set in any KlusterletConfig are not included.
+func GetMergedKlusterletConfigWithSources(
+	klusterletconfigName string,
+	kcLister listerklusterletconfigv1alpha1.KlusterletConfigLister,
+) (*klusterletconfigv1alpha1.KlusterletConfig, map[string][]string, error) {
 	var err error
 	var kc *klusterletconfigv1alpha1.KlusterletConfig
 	if klusterletconfigName != "" {
 		kc, err = kcLister.Get(klusterletconfigName)
 		if err != nil && !apierrors.IsNotFound(err) {
-			return nil, fmt.Errorf("failed to get klusterletconfig %s: %v", klusterletconfigName, err)
+			return nil, nil, fmt.Errorf("failed to get klusterletconfig %s: %v", klusterletconfigName, err)
 		}
 	}
 
 	globalKlusterletConfig, err := kcLister.Get(constants.GlobalKlusterletConfigName)
 	if err != nil && !apierrors.IsNotFound(err) {
-		return nil, fmt.Errorf("failed to get global klusterletconfig: %v", err)
+		return nil, nil, fmt.Errorf("failed to get global klusterletconfig: %v", err)
 	}
 
 	// The object get from a lister should be be modified directly.
-	return klusterletconfighelper.MergeKlusterletConfigs(globalKlusterletConfig.DeepCopy(), kc.DeepCopy())
+	merged, err := klusterletconfighelper.MergeKlusterletConfigs(globalKlusterletConfig.DeepCopy(), kc.DeepCopy())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sources := getKlusterletConfigFieldSources(merged, globalKlusterletConfig, kc)
+
+	// The merge only handles the spec, the annotations are merged separately.
+	if merged != nil {
+		annotations, annotationSources := mergeKlusterletConfigAnnotations(globalKlusterletConfig, kc)
+		merged.Annotations = annotations
+		for key, source := range annotationSources {
+			sources[klusterletConfigAnnotationSourcePrefix+key] = []string{source}
+		}
+	}
+
+	return merged, sources, nil
+}
+
+// klusterletConfigAnnotationSourcePrefix is the prefix of the source keys of the merged annotations.
+const klusterletConfigAnnotationSourcePrefix = "metadata.annotations."
+
+// klusterletConfigSelectionAnnotations are the annotations that select the managed clusters of a KlusterletConfig,
+// they are not configurations of the klusterlet and are not merged.
+var klusterletConfigSelectionAnnotations = sets.New[string](
+	constants.KlusterletConfigClusterSelectorAnnotation,
+	constants.KlusterletConfigClusterClaimSelectorAnnotation,
+	constants.KlusterletConfigClusterSetAnnotation,
+	constants.KlusterletConfigPriorityAnnotation,
+)
+
+// mergeKlusterletConfigAnnotations merges the annotations with the KlusterletConfigAnnotationPrefix of the
+// KlusterletConfigs except the selection annotations, the latter KlusterletConfig overrides the former ones. It
+// also returns the name of the KlusterletConfig that each merged annotation comes from.
+func mergeKlusterletConfigAnnotations(
+	kcs ...*klusterletconfigv1alpha1.KlusterletConfig) (map[string]string, map[string]string) {
+	var annotations, sources map[string]string
+	for _, kc := range kcs {
+		if kc == nil {
+			continue
+		}
+		for key, value := range kc.Annotations {
+			if !strings.HasPrefix(key, constants.KlusterletConfigAnnotationPrefix) ||
+				klusterletConfigSelectionAnnotations.Has(key) {
+				continue
+			}
+			if annotations == nil {
+				annotations = map[string]string{}
+				sources = map[string]string{}
+			}
+			annotations[key] = value
+			sources[key] = kc.Name
+		}
+	}
+	return annotations, sources
+}
+
+// getKlusterletConfigFieldSources compares each field of the merged spec with the same field of the
+// KlusterletConfigs to find out where the field comes from. A field that is set in more than one
+// KlusterletConfig and does not equal to any of them is a merge result of these KlusterletConfigs.
+// The nested structs, like hubKubeAPIServerConfig, are merged field by field, so their fields are
+// attributed separately with the dotted json path as the key. A field that is only derived from a
+// deprecated field during the merge is not set in any KlusterletConfig and has no source.
+func getKlusterletConfigFieldSources(merged *klusterletconfigv1alpha1.KlusterletConfig,
+	kcs ...*klusterletconfigv1alpha1.KlusterletConfig) map[string][]string {
+	sources := map[string][]string{}
+	if merged == nil {
+		return sources
+	}
+
+	var names []string
+	var specs []reflect.Value
+	for _, kc := range kcs {
+		if kc == nil {
+			continue
+		}
+		names = append(names, kc.Name)
+		specs = append(specs, reflect.ValueOf(kc.Spec))
+	}
+
+	collectFieldSources(sources, "", reflect.ValueOf(merged.Spec), specs, names)
+	return sources
+}
+
+// collectFieldSources attributes the fields of the merged struct to the named structs of the same type, an
+// invalid value stands for a struct that is not set.
+func collectFieldSources(sources map[string][]string, prefix string, merged reflect.Value,
+	values []reflect.Value, names []string) {
+	structType := merged.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := merged.Field(i)
+		if !structType.Field(i).IsExported() || field.IsZero() {
+			continue
+		}
+
+		jsonName := strings.Split(structType.Field(i).Tag.Get("json"), ",")[0]
+		if len(jsonName) == 0 {
+			jsonName = structType.Field(i).Name
+		}
+		if len(prefix) > 0 {
+			jsonName = prefix + "." + jsonName
+		}
+
+		fieldValues := make([]reflect.Value, len(values))
+		for j, value := range values {
+			if value.IsValid() {
+				fieldValues[j] = value.Field(i)
+			}
+		}
+
+		if isNestedStruct(field.Type()) {
+			for j, value := range fieldValues {
+				fieldValues[j] = derefStruct(value)
+			}
+			collectFieldSources(sources, jsonName, derefStruct(field), fieldValues, names)
+			continue
+		}
+
+		var contributors []string
+		var source string
+		for j, value := range fieldValues {
+			if !value.IsValid() || value.IsZero() {
+				continue
+			}
+			contributors = append(contributors, names[j])
+			if equality.Semantic.DeepEqual(field.Interface(), value.Interface()) {
+				// the latter KlusterletConfig overrides the former ones
+				source = names[j]
+			}
+		}
+
+		switch {
+		case len(contributors) == 0:
+			continue
+		case len(contributors) == 1:
+			sources[jsonName] = contributors
+		case len(source) > 0:
+			sources[jsonName] = []string{source}
+		default:
+			sources[jsonName] = contributors
+		}
+	}
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// isNestedStruct returns true if the type is a struct or a pointer to a struct whose fields are serialized one
+// by one, the types with their own json serialization, like metav1.Duration, are handled as a single value.
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !t.Implements(jsonMarshalerType) &&
+		!reflect.PointerTo(t).Implements(jsonMarshalerType)
+}
+
+func derefStruct(v reflect.Value) reflect.Value {
+	if !v.IsValid() {
+		return v
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		return v.Elem()
+	}
+	return v
+}
+
+// KlusterletConfigSelector is the parsed form of the cluster selector annotations on a KlusterletConfig.
+// A KlusterletConfig selects a managed cluster only if all of the configured selectors match.
+type KlusterletConfigSelector struct {
+	ClusterSelector      labels.Selector
+	ClusterClaimSelector labels.Selector
+	ClusterSet           string
+	Priority             int
+}
+
+// GetKlusterletConfigSelector parses the selector annotations of the KlusterletConfig, it returns nil if
+// the KlusterletConfig does not select any cluster. The global KlusterletConfig is always applied, so
+// it never selects clusters.
+func GetKlusterletConfigSelector(kc *klusterletconfigv1alpha1.KlusterletConfig) (*KlusterletConfigSelector, error) {
+	if kc == nil || kc.Name == constants.GlobalKlusterletConfigName {
+		return nil, nil
+	}
+
+	annotations := kc.GetAnnotations()
+	clusterSelector, hasClusterSelector := annotations[constants.KlusterletConfigClusterSelectorAnnotation]
+	claimSelector, hasClaimSelector := annotations[constants.KlusterletConfigClusterClaimSelectorAnnotation]
+	clusterSet, hasClusterSet := annotations[constants.KlusterletConfigClusterSetAnnotation]
+	if !hasClusterSelector && !hasClaimSelector && !hasClusterSet {
+		return nil, nil
+	}
+
+	selector := &KlusterletConfigSelector{
+		ClusterSelector:      labels.Everything(),
+		ClusterClaimSelector: labels.Everything(),
+		ClusterSet:           clusterSet,
+	}
+
+	var err error
+	if hasClusterSelector {
+		if selector.ClusterSelector, err = parseLabelSelector(clusterSelector); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %v", constants.KlusterletConfigClusterSelectorAnnotation, err)
+		}
+	}
+
+	if hasClaimSelector {
+		if selector.ClusterClaimSelector, err = parseLabelSelector(claimSelector); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %v", constants.KlusterletConfigClusterClaimSelectorAnnotation, err)
+		}
+	}
+
+	if priority, ok := annotations[constants.KlusterletConfigPriorityAnnotation]; ok {
+		if selector.Priority, err = strconv.Atoi(priority); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %v", constants.KlusterletConfigPriorityAnnotation, err)
+		}
+	}
+
+	return selector, nil
+}
+
+// Matches returns true if the managed cluster is selected by the selector. The clusterset membership is
+// resolved by the selector of the ManagedClusterSet, so a cluster never matches a clusterset that does not
+// exist.
+func (s *KlusterletConfigSelector) Matches(cluster *clusterv1.ManagedCluster,
+	clusterSetLister clusterlisterv1beta2.ManagedClusterSetLister) bool {
+	if s == nil || cluster == nil {
+		return false
+	}
+
+	if len(s.ClusterSet) > 0 {
+		inClusterSet, err := IsClusterInClusterSet(cluster, s.ClusterSet, clusterSetLister)
+		if err != nil {
+			klog.Warningf("failed to resolve the clusterset %s of cluster %s: %v", s.ClusterSet, cluster.Name, err)
+			return false
+		}
+		if !inClusterSet {
+			return false
+		}
+	}
+
+	if !s.ClusterSelector.Matches(labels.Set(cluster.Labels)) {
+		return false
+	}
+
+	if s.ClusterClaimSelector.Empty() {
+		return true
+	}
+
+	claims := labels.Set{}
+	for _, claim := range cluster.Status.ClusterClaims {
+		claims[claim.Name] = claim.Value
+	}
+	return s.ClusterClaimSelector.Matches(claims)
+}
+
+// IsClusterInClusterSet returns true if the managed cluster is a member of the ManagedClusterSet. The members
+// of an ExclusiveClusterSetLabel set are the clusters with the clusterset label, and the members of a
+// LabelSelector set, like the global set, are the clusters matching its label selector.
+func IsClusterInClusterSet(cluster *clusterv1.ManagedCluster, clusterSetName string,
+	clusterSetLister clusterlisterv1beta2.ManagedClusterSetLister) (bool, error) {
+	clusterSet, err := clusterSetLister.Get(clusterSetName)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return IsClusterInManagedClusterSet(cluster, clusterSet)
+}
+
+// IsClusterInManagedClusterSet returns true if the managed cluster is a member of the given ManagedClusterSet, it
+// is used to resolve the members of a ManagedClusterSet before and after it is changed.
+func IsClusterInManagedClusterSet(cluster *clusterv1.ManagedCluster,
+	clusterSet *clusterv1beta2.ManagedClusterSet) (bool, error) {
+	switch clusterSet.Spec.ClusterSelector.SelectorType {
+	case "", clusterv1beta2.ExclusiveClusterSetLabel:
+		return cluster.Labels[clusterv1beta2.ClusterSetLabel] == clusterSet.Name, nil
+	case clusterv1beta2.LabelSelector:
+		if clusterSet.Spec.ClusterSelector.LabelSelector == nil {
+			return false, fmt.Errorf("the label selector of clusterset %s is not set", clusterSet.Name)
+		}
+		selector, err := metav1.LabelSelectorAsSelector(clusterSet.Spec.ClusterSelector.LabelSelector)
+		if err != nil {
+			return false, err
+		}
+		return selector.Matches(labels.Set(cluster.Labels)), nil
+	default:
+		return false, fmt.Errorf("the selector type %q of clusterset %s is not supported",
+			clusterSet.Spec.ClusterSelector.SelectorType, clusterSet.Name)
+	}
+}
+
+// ListKlusterletConfigsSelectingCluster returns the names of the KlusterletConfigs whose selectors match the
+// managed cluster. The names are ordered by precedence, the KlusterletConfig with a higher priority comes
+// first, and KlusterletConfigs with the same priority are ordered by name.
+func ListKlusterletConfigsSelectingCluster(cluster *clusterv1.ManagedCluster,
+	kcs []*klusterletconfigv1alpha1.KlusterletConfig,
+	clusterSetLister clusterlisterv1beta2.ManagedClusterSetLister) []string {
+	type candidate struct {
+		name     string
+		priority int
+	}
+
+	candidates := []candidate{}
+	for _, kc := range kcs {
+		selector, err := GetKlusterletConfigSelector(kc)
+		if err != nil {
+			klog.Warningf("ignore the selector of klusterletconfig %s: %v", kc.Name, err)
+			continue
+		}
+		if !selector.Matches(cluster, clusterSetLister) {
+			continue
+		}
+		candidates = append(candidates, candidate{name: kc.Name, priority: selector.Priority})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority > candidates[j].priority
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// KlusterletConfigSelectorIndexKey is the index of the KlusterletConfig informer that has the KlusterletConfigs
+// with the selector annotations, so the KlusterletConfigs selecting a cluster are resolved from the cache without
+// listing all the KlusterletConfigs.
+const KlusterletConfigSelectorIndexKey = "klusterletconfig-selector"
+
+// klusterletConfigSelectorIndexValue is the only value of the KlusterletConfigSelectorIndexKey index.
+const klusterletConfigSelectorIndexValue = "selector"
+
+// IndexKlusterletConfigBySelector indexes the KlusterletConfigs that have any selector annotation, the global
+// KlusterletConfig never selects clusters so it is not indexed.
+func IndexKlusterletConfigBySelector(obj interface{}) ([]string, error) {
+	kc, ok := obj.(*klusterletconfigv1alpha1.KlusterletConfig)
+	if !ok {
+		return nil, fmt.Errorf("not a klusterletconfig object")
+	}
+	if kc.Name == constants.GlobalKlusterletConfigName {
+		return nil, nil
+	}
+
+	for _, key := range []string{
+		constants.KlusterletConfigClusterSelectorAnnotation,
+		constants.KlusterletConfigClusterClaimSelectorAnnotation,
+		constants.KlusterletConfigClusterSetAnnotation,
+	} {
+		if _, ok := kc.GetAnnotations()[key]; ok {
+			return []string{klusterletConfigSelectorIndexValue}, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListKlusterletConfigsWithSelector returns the KlusterletConfigs that have selectors from the KlusterletConfig
+// informer indexer, the indexer must have the KlusterletConfigSelectorIndexKey index.
+func ListKlusterletConfigsWithSelector(kcIndexer cache.Indexer) ([]*klusterletconfigv1alpha1.KlusterletConfig, error) {
+	objs, err := kcIndexer.ByIndex(KlusterletConfigSelectorIndexKey, klusterletConfigSelectorIndexValue)
+	if err != nil {
+		return nil, err
+	}
+
+	kcs := make([]*klusterletconfigv1alpha1.KlusterletConfig, 0, len(objs))
+	for _, obj := range objs {
+		if kc, ok := obj.(*klusterletconfigv1alpha1.KlusterletConfig); ok {
+			kcs = append(kcs, kc)
+		}
+	}
+	return kcs, nil
+}
+
+// GetKlusterletConfigNameForCluster returns the name of the KlusterletConfig that applies to the managed
+// cluster. The KlusterletConfig specified by the cluster annotation takes precedence over the
+// KlusterletConfigs that select the cluster. An empty name is returned if there is no KlusterletConfig
+// for the cluster, in which case only the global KlusterletConfig is used.
+func GetKlusterletConfigNameForCluster(cluster *clusterv1.ManagedCluster,
+	kcIndexer cache.Indexer,
+	clusterSetLister clusterlisterv1beta2.ManagedClusterSetLister) (string, error) {
+	if name := cluster.GetAnnotations()[apiconstants.AnnotationKlusterletConfig]; len(name) > 0 {
+		return name, nil
+	}
+
+	kcs, err := ListKlusterletConfigsWithSelector(kcIndexer)
+	if err != nil {
+		return "", fmt.Errorf("failed to list klusterletconfigs: %v", err)
+	}
+
+	if names := ListKlusterletConfigsSelectingCluster(cluster, kcs, clusterSetLister); len(names) > 0 {
+		return names[0], nil
+	}
+	return "", nil
+}
+
+func parseLabelSelector(data string) (labels.Selector, error) {
+	labelSelector := &metav1.LabelSelector{}
+	if err := json.Unmarshal([]byte(data), labelSelector); err != nil {
+		return nil, err
+	}
+	return metav1.LabelSelectorAsSelector(labelSelector)
 }
@@ -1,13 +1,20 @@
 package helpers
 
 import (
+	"reflect"
 	"testing"
 
+	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
 	klusterletconfigv1alpha1 "github.com/stolostron/cluster-lifecycle-api/klusterletconfig/v1alpha1"
 	"github.com/stolostron/managedcluster-import-controller/pkg/constants"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	labels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	clusterlisterv1beta2 "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta2"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
 )
 
 func TestGetMergedKlusterletConfigWithGlobal(t *testing.T) {
@@ -70,6 +77,337 @@ func TestGetMergedKlusterletConfigWithGlobal(t *testing.T) {
 	}
 }
 
+func TestGetMergedKlusterletConfigWithSources(t *testing.T) {
+	global := &klusterletconfigv1alpha1.KlusterletConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: constants.GlobalKlusterletConfigName},
+		Spec: klusterletconfigv1alpha1.KlusterletConfigSpec{
+			HubKubeAPIServerURL:                    "https://global:6443",
+			AppliedManifestWorkEvictionGracePeriod: "10m",
+			FeatureGates: []operatorv1.FeatureGate{
+				{Feature: "ClusterClaim", Mode: operatorv1.FeatureGateModeTypeEnable},
+			},
+			HubKubeAPIServerConfig: &klusterletconfigv1alpha1.KubeAPIServerConfig{
+				URL:                        "https://global-config:6443",
+				ServerVerificationStrategy: klusterletconfigv1alpha1.ServerVerificationStrategyUseSystemTruststore,
+			},
+		},
+	}
+	kc := &klusterletconfigv1alpha1.KlusterletConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Annotations: map[string]string{
+				constants.KlusterletConfigHubKubeAPIServerURLsAnnotation: "https://test:6443",
+				// the selection annotations are not merged
+				constants.KlusterletConfigClusterSetAnnotation: "test-set",
+				constants.KlusterletConfigPriorityAnnotation:   "10",
+			},
+		},
+		Spec: klusterletconfigv1alpha1.KlusterletConfigSpec{
+			HubKubeAPIServerURL: "https://test:6443",
+			Registries: []klusterletconfigv1alpha1.Registries{
+				{Source: "quay.io", Mirror: "mirror.io"},
+			},
+			FeatureGates: []operatorv1.FeatureGate{
+				{Feature: "AddonManagement", Mode: operatorv1.FeatureGateModeTypeEnable},
+			},
+			HubKubeAPIServerConfig: &klusterletconfigv1alpha1.KubeAPIServerConfig{
+				URL: "https://test-config:6443",
+			},
+		},
+	}
+
+	lister := &mockKlusterletConfigLister{
+		GetFunc: func(name string) (*klusterletconfigv1alpha1.KlusterletConfig, error) {
+			switch name {
+			case constants.GlobalKlusterletConfigName:
+				return global, nil
+			case "test":
+				return kc, nil
+			}
+			return nil, errors.NewNotFound(klusterletconfigv1alpha1.Resource("klusterletconfigs"), name)
+		},
+	}
+
+	merged, sources, err := GetMergedKlusterletConfigWithSources("test", lister)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if merged.Spec.HubKubeAPIServerURL != "https://test:6443" {
+		t.Errorf("unexpected hub kube apiserver url %s", merged.Spec.HubKubeAPIServerURL)
+	}
+	expectedAnnotations := map[string]string{
+		constants.KlusterletConfigHubKubeAPIServerURLsAnnotation: "https://test:6443",
+	}
+	if !reflect.DeepEqual(merged.Annotations, expectedAnnotations) {
+		t.Errorf("expected annotations %v, but got %v", expectedAnnotations, merged.Annotations)
+	}
+
+	expected := map[string][]string{
+		"hubKubeAPIServerURL":                    {"test"},
+		"registries":                             {"test"},
+		"appliedManifestWorkEvictionGracePeriod": {constants.GlobalKlusterletConfigName},
+		"featureGates":                           {constants.GlobalKlusterletConfigName, "test"},
+		// the nested fields are merged and attributed one by one
+		"hubKubeAPIServerConfig.url":                        {"test"},
+		"hubKubeAPIServerConfig.serverVerificationStrategy": {constants.GlobalKlusterletConfigName},
+		// the merged annotations are attributed to the KlusterletConfig they come from
+		"metadata.annotations." + constants.KlusterletConfigHubKubeAPIServerURLsAnnotation: {"test"},
+	}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("expected sources %v, got %v", expected, sources)
+	}
+}
+
+func newTestClusterSetLister(t *testing.T) clusterlisterv1beta2.ManagedClusterSetLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, clusterSet := range []*clusterv1beta2.ManagedClusterSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "east"},
+			Spec: clusterv1beta2.ManagedClusterSetSpec{
+				ClusterSelector: clusterv1beta2.ManagedClusterSelector{
+					SelectorType: clusterv1beta2.ExclusiveClusterSetLabel,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "global"},
+			Spec: clusterv1beta2.ManagedClusterSetSpec{
+				ClusterSelector: clusterv1beta2.ManagedClusterSelector{
+					SelectorType:  clusterv1beta2.LabelSelector,
+					LabelSelector: &metav1.LabelSelector{},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+			Spec: clusterv1beta2.ManagedClusterSetSpec{
+				ClusterSelector: clusterv1beta2.ManagedClusterSelector{
+					SelectorType: clusterv1beta2.LabelSelector,
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"tier": "edge"},
+					},
+				},
+			},
+		},
+	} {
+		if err := indexer.Add(clusterSet); err != nil {
+			t.Fatalf("failed to add clusterset: %v", err)
+		}
+	}
+	return clusterlisterv1beta2.NewManagedClusterSetLister(indexer)
+}
+
+func TestIsClusterInClusterSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		clusterSet string
+		expected   bool
+	}{
+		{
+			name:       "in exclusive clusterset",
+			labels:     map[string]string{clusterv1beta2.ClusterSetLabel: "east"},
+			clusterSet: "east",
+			expected:   true,
+		},
+		{
+			name:       "not in exclusive clusterset",
+			labels:     map[string]string{clusterv1beta2.ClusterSetLabel: "west"},
+			clusterSet: "east",
+			expected:   false,
+		},
+		{
+			name:       "in global clusterset",
+			clusterSet: "global",
+			expected:   true,
+		},
+		{
+			name:       "in label selector clusterset",
+			labels:     map[string]string{"tier": "edge"},
+			clusterSet: "edge",
+			expected:   true,
+		},
+		{
+			name:       "the clusterset label does not select a label selector clusterset",
+			labels:     map[string]string{clusterv1beta2.ClusterSetLabel: "edge"},
+			clusterSet: "edge",
+			expected:   false,
+		},
+		{
+			name:       "clusterset does not exist",
+			labels:     map[string]string{clusterv1beta2.ClusterSetLabel: "missing"},
+			clusterSet: "missing",
+			expected:   false,
+		},
+	}
+
+	clusterSetLister := newTestClusterSetLister(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Labels: tt.labels},
+			}
+			inClusterSet, err := IsClusterInClusterSet(cluster, tt.clusterSet, clusterSetLister)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if inClusterSet != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, inClusterSet)
+			}
+		})
+	}
+}
+
+func TestGetKlusterletConfigNameForCluster(t *testing.T) {
+	kcs := []*klusterletconfigv1alpha1.KlusterletConfig{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: constants.GlobalKlusterletConfigName,
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterSelectorAnnotation: `{}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "edge",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterSelectorAnnotation: `{"matchLabels":{"env":"edge"}}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "edge-priority",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterSelectorAnnotation: `{"matchLabels":{"env":"edge"}}`,
+					constants.KlusterletConfigClusterSetAnnotation:      "east",
+					constants.KlusterletConfigPriorityAnnotation:        "10",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "aws",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterClaimSelectorAnnotation: `{"matchExpressions":[{"key":"platform.open-cluster-management.io","operator":"In","values":["AWS"]}]}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "a-aws",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterClaimSelectorAnnotation: `{"matchLabels":{"platform.open-cluster-management.io":"AWS"}}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "edge-set",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterSetAnnotation: "edge",
+					constants.KlusterletConfigPriorityAnnotation:   "20",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "invalid",
+				Annotations: map[string]string{
+					constants.KlusterletConfigClusterSelectorAnnotation: `{"matchLabels":`,
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		cluster  *clusterv1.ManagedCluster
+		expected string
+	}{
+		{
+			name:     "no klusterletconfig selects the cluster",
+			cluster:  &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+			expected: "",
+		},
+		{
+			name: "annotation takes precedence over selectors",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Labels:      map[string]string{"env": "edge"},
+					Annotations: map[string]string{apiconstants.AnnotationKlusterletConfig: "test"},
+				},
+			},
+			expected: "test",
+		},
+		{
+			name: "selected by labels",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "test",
+					Labels: map[string]string{"env": "edge"},
+				},
+			},
+			expected: "edge",
+		},
+		{
+			name: "higher priority wins",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "test",
+					Labels: map[string]string{"env": "edge", clusterv1beta2.ClusterSetLabel: "east"},
+				},
+			},
+			expected: "edge-priority",
+		},
+		{
+			name: "selected by label selector clusterset",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "test",
+					Labels: map[string]string{"env": "edge", "tier": "edge"},
+				},
+			},
+			expected: "edge-set",
+		},
+		{
+			name: "same priority ordered by name",
+			cluster: &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Status: clusterv1.ManagedClusterStatus{
+					ClusterClaims: []clusterv1.ManagedClusterClaim{
+						{Name: "platform.open-cluster-management.io", Value: "AWS"},
+					},
+				},
+			},
+			expected: "a-aws",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+				KlusterletConfigSelectorIndexKey: IndexKlusterletConfigBySelector,
+			})
+			for _, kc := range kcs {
+				if err := kcIndexer.Add(kc); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			name, err := GetKlusterletConfigNameForCluster(tt.cluster, kcIndexer, newTestClusterSetLister(t))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if name != tt.expected {
+				t.Errorf("expected klusterletconfig %q, got %q", tt.expected, name)
+			}
+		})
+	}
+}
+
 // mockKlusterletConfigLister is a mock implementation of KlusterletConfigLister interface.
 type mockKlusterletConfigLister struct {
 	ListFunc func(selector labels.Selector) ([]*klusterletconfigv1alpha1.KlusterletConfig, error)
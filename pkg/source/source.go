@@ -10,12 +10,16 @@ import (
 
 	klusterletconfigv1alpha1lister "github.com/stolostron/cluster-lifecycle-api/client/klusterletconfig/listers/klusterletconfig/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/types"
 	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	clusterv1beta2lister "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta2"
 	workv1lister "open-cluster-management.io/api/client/work/listers/work/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 	workv1 "open-cluster-management.io/api/work/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -44,7 +48,21 @@ type InformerHolder struct {
 	ControllerConfigInformer cache.SharedIndexInformer
 	ControllerConfigLister   corev1listers.ConfigMapLister
 
+	EffectiveKlusterletConfigInformer cache.SharedIndexInformer
+	EffectiveKlusterletConfigLister   corev1listers.ConfigMapLister
+
 	ManagedClusterInformer cache.SharedIndexInformer
+
+	ManagedClusterSetInformer cache.SharedIndexInformer
+	ManagedClusterSetLister   clusterv1beta2lister.ManagedClusterSetLister
+
+	FlightCtlDiscoveryConfigMapInformer cache.SharedIndexInformer
+
+	FlightCtlServiceAccountInformer     cache.SharedIndexInformer
+	FlightCtlClusterRoleBindingInformer cache.SharedIndexInformer
+
+	AgentRegistrationServiceAccountLister     corev1listers.ServiceAccountLister
+	AgentRegistrationClusterRoleBindingLister rbacv1listers.ClusterRoleBindingLister
 }
 
 // NewImportSecretSource return a source only for import secrets
@@ -103,6 +121,62 @@ func NewHostedWorkSource(workInformer cache.SharedIndexInformer,
 	}
 }
 
+// NewManagedClusterSetSource return a source for managed cluster sets
+func NewManagedClusterSetSource(clusterSetInformer cache.SharedIndexInformer,
+	handler handler.EventHandler,
+	predicates ...predicate.Predicate) *Source {
+	return &Source{
+		informer:     clusterSetInformer,
+		expectedType: reflect.TypeOf(&clusterv1beta2.ManagedClusterSet{}),
+		name:         "managed-cluster-sets",
+
+		handler:    handler,
+		predicates: predicates,
+	}
+}
+
+// NewFlightCtlDiscoveryConfigMapSource return a source only for the flightctl discovery configmap
+func NewFlightCtlDiscoveryConfigMapSource(configMapInformer cache.SharedIndexInformer,
+	handler handler.EventHandler,
+	predicates ...predicate.Predicate) *Source {
+	return &Source{
+		informer:     configMapInformer,
+		expectedType: reflect.TypeOf(&corev1.ConfigMap{}),
+		name:         "flightctl-discovery-configmap",
+
+		handler:    handler,
+		predicates: predicates,
+	}
+}
+
+// NewFlightCtlServiceAccountSource return a source only for the flightctl agent registration service account
+func NewFlightCtlServiceAccountSource(serviceAccountInformer cache.SharedIndexInformer,
+	handler handler.EventHandler,
+	predicates ...predicate.Predicate) *Source {
+	return &Source{
+		informer:     serviceAccountInformer,
+		expectedType: reflect.TypeOf(&corev1.ServiceAccount{}),
+		name:         "flightctl-service-account",
+
+		handler:    handler,
+		predicates: predicates,
+	}
+}
+
+// NewFlightCtlClusterRoleBindingSource return a source only for the flightctl agent registration cluster role binding
+func NewFlightCtlClusterRoleBindingSource(clusterRoleBindingInformer cache.SharedIndexInformer,
+	handler handler.EventHandler,
+	predicates ...predicate.Predicate) *Source {
+	return &Source{
+		informer:     clusterRoleBindingInformer,
+		expectedType: reflect.TypeOf(&rbacv1.ClusterRoleBinding{}),
+		name:         "flightctl-cluster-role-binding",
+
+		handler:    handler,
+		predicates: predicates,
+	}
+}
+
 // Source is the event source of specified objects
 type Source struct {
 	informer     cache.SharedIndexInformer